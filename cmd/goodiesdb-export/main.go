@@ -0,0 +1,53 @@
+// Command goodiesdb-export writes the dataset of a running server as a file
+// of RESP-encoded commands, which goodiesdb-import or redis-cli --pipe load
+// back, for backups and migrations.
+//
+//	goodiesdb-export -addr 127.0.0.1:6379 -file dump.resp
+//
+// Every database is walked with SCAN, so the server keeps serving other
+// clients during the export; keys written meanwhile may or may not be
+// included. TTLs are written as absolute expirations.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/andrelcunha/goodiesdb/internal/dump"
+	"github.com/andrelcunha/goodiesdb/pkg/client"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:6379", "address of the server")
+	file := flag.String("file", "-", "file to write the commands to, - for stdout")
+	flag.Parse()
+
+	out := os.Stdout
+	if *file != "-" {
+		f, err := os.Create(*file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error creating the export file:", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	c, err := client.Dial(*addr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error connecting to the server:", err)
+		os.Exit(1)
+	}
+	defer c.Close()
+
+	keys, err := dump.Export(c, out)
+	if err == nil && out != os.Stdout {
+		err = out.Close()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Export failed:", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d keys\n", keys)
+}
@@ -0,0 +1,53 @@
+// Command goodiesdb-import loads data into a running server from a file of
+// RESP-encoded commands, the format redis-cli --pipe consumes. Inline
+// commands, one per line, are accepted too.
+//
+//	goodiesdb-import -addr 127.0.0.1:6379 -file dump.resp
+//
+// The commands are sent one at a time on a single connection, so a SELECT in
+// the file applies to the commands after it. A command the server rejects is
+// reported and skipped; a malformed file or a lost connection stops the
+// import.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/andrelcunha/goodiesdb/internal/dump"
+	"github.com/andrelcunha/goodiesdb/pkg/client"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:6379", "address of the server")
+	file := flag.String("file", "-", "file of RESP commands to import, - for stdin")
+	flag.Parse()
+
+	in := os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error opening the import file:", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	c, err := client.Dial(*addr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error connecting to the server:", err)
+		os.Exit(1)
+	}
+	defer c.Close()
+
+	applied, failed, err := dump.Import(c, in, os.Stderr)
+	fmt.Printf("Applied %d commands, %d errors\n", applied, failed)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Import stopped:", err)
+	}
+	if err != nil || failed > 0 {
+		os.Exit(1)
+	}
+}
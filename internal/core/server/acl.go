@@ -0,0 +1,283 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/andrelcunha/goodiesdb/internal/protocol"
+	"github.com/andrelcunha/goodiesdb/internal/utils/glob"
+)
+
+// aclUser is a user and its permissions, built from a rule in the Redis ACL
+// syntax, e.g. "USER alice on >secret ~cache:* +get +set"
+type aclUser struct {
+	name        string
+	enabled     bool
+	nopass      bool
+	passwords   []string
+	keyPatterns []string
+	commands    map[string]bool // allowed commands, by upper case name
+	open        bool            // may be used without AUTH, set by newUsers
+}
+
+// parseACLRule parses "USER <name> <op>...". The supported operations are
+// on, off, >password, nopass, ~pattern, allkeys, resetkeys, +command,
+// -command, +@category, -@category, allcommands and nocommands. As in Redis a
+// new user is disabled and can't run anything until the rule allows it.
+func parseACLRule(rule string) (*aclUser, error) {
+	fields := strings.Fields(rule)
+	if len(fields) < 2 || !strings.EqualFold(fields[0], "USER") {
+		return nil, fmt.Errorf("expected 'USER <name> <rules>...', got %q", rule)
+	}
+	user := &aclUser{name: fields[1], commands: make(map[string]bool)}
+	for _, op := range fields[2:] {
+		if err := user.apply(op); err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+// apply applies one ACL operation to the user
+func (u *aclUser) apply(op string) error {
+	switch lower := strings.ToLower(op); {
+	case lower == "on":
+		u.enabled = true
+	case lower == "off":
+		u.enabled = false
+	case lower == "nopass":
+		u.nopass, u.passwords = true, nil
+	case lower == "allkeys":
+		u.keyPatterns = []string{"*"}
+	case lower == "resetkeys":
+		u.keyPatterns = nil
+	case lower == "allcommands":
+		return u.apply("+@all")
+	case lower == "nocommands":
+		return u.apply("-@all")
+	case strings.HasPrefix(op, ">"):
+		u.nopass = false
+		u.passwords = append(u.passwords, op[1:])
+	case strings.HasPrefix(op, "~"):
+		u.keyPatterns = append(u.keyPatterns, op[1:])
+	case strings.HasPrefix(op, "+@"), strings.HasPrefix(op, "-@"):
+		category := lower[2:]
+		if category != "all" && !slices.Contains(aclCategories(), category) {
+			return fmt.Errorf("unknown command category %q", category)
+		}
+		for name, spec := range availableCommands {
+			if category == "all" || spec.hasCategory(category) {
+				u.commands[name] = op[0] == '+'
+			}
+		}
+	case strings.HasPrefix(op, "+"), strings.HasPrefix(op, "-"):
+		name := strings.ToUpper(op[1:])
+		if _, ok := availableCommands[name]; !ok {
+			return fmt.Errorf("unknown command %q", op[1:])
+		}
+		u.commands[name] = op[0] == '+'
+	default:
+		return fmt.Errorf("unknown ACL operation %q", op)
+	}
+	return nil
+}
+
+// checkPassword reports whether the user may log in with password
+func (u *aclUser) checkPassword(password string) bool {
+	if !u.enabled {
+		return false
+	}
+	if u.nopass {
+		return true
+	}
+	for _, p := range u.passwords {
+		if subtle.ConstantTimeCompare([]byte(password), []byte(p)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// canAccessKey reports whether key matches one of the user's key patterns
+func (u *aclUser) canAccessKey(key string) bool {
+	for _, pattern := range u.keyPatterns {
+		if glob.Match(pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// newUsers builds the users of the server: the default user with PASSWORD,
+// the USERS with every permission, then the ACL rules, which may redefine
+// any of them. Invalid rules are reported and skipped.
+func newUsers(config *Config) map[string]*aclUser {
+	users := map[string]*aclUser{"default": fullAccessUser("default", config.Password)}
+	for name, password := range config.Users {
+		users[name] = fullAccessUser(name, password)
+	}
+	for _, rule := range config.ACL {
+		user, err := parseACLRule(rule)
+		if err != nil {
+			fmt.Printf("Ignoring ACL rule: %v\n", err)
+			continue
+		}
+		users[user.name] = user
+	}
+	if user, ok := users["default"]; ok {
+		user.open = user.isOpen()
+	}
+	return users
+}
+
+// isOpen reports whether clients may act as the user without logging in:
+// when it is enabled, has no password and may run everything on every key
+func (u *aclUser) isOpen() bool {
+	if !u.enabled || !slices.Contains(u.keyPatterns, "*") {
+		return false
+	}
+	if !u.nopass && slices.ContainsFunc(u.passwords, func(p string) bool { return p != "" }) {
+		return false
+	}
+	for name := range availableCommands {
+		if !u.commands[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// fullAccessUser returns an enabled user that may run every command on every
+// key. The password is set directly as it may contain spaces.
+func fullAccessUser(name, password string) *aclUser {
+	user := &aclUser{name: name, enabled: true, passwords: []string{password}, commands: make(map[string]bool)}
+	user.apply("allkeys")
+	user.apply("allcommands")
+	return user
+}
+
+// describe returns the user as an ACL rule, as ACL LIST shows it. Passwords
+// are shown as their SHA-256 hashes.
+func (u *aclUser) describe() string {
+	parts := []string{"user", u.name, "off"}
+	if u.enabled {
+		parts[2] = "on"
+	}
+	if u.nopass {
+		parts = append(parts, "nopass")
+	}
+	for _, password := range u.passwords {
+		parts = append(parts, fmt.Sprintf("#%x", sha256.Sum256([]byte(password))))
+	}
+	for _, pattern := range u.keyPatterns {
+		parts = append(parts, "~"+pattern)
+	}
+
+	var allowed []string
+	for name, ok := range u.commands {
+		if ok {
+			allowed = append(allowed, strings.ToLower(name))
+		}
+	}
+	if len(allowed) == len(availableCommands) {
+		return strings.Join(append(parts, "+@all"), " ")
+	}
+	sort.Strings(allowed)
+	parts = append(parts, "-@all")
+	for _, name := range allowed {
+		parts = append(parts, "+"+name)
+	}
+	return strings.Join(parts, " ")
+}
+
+// ACL runs an ACL subcommand
+func (s *Server) ACL(client *Client, args []string) (protocol.RESPValue, error) {
+	switch strings.ToUpper(args[0]) {
+	case "WHOAMI":
+		if len(args) != 1 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'ACL|WHOAMI' command"), nil
+		}
+		return protocol.BulkString(client.user), nil
+
+	case "LIST":
+		if len(args) != 1 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'ACL|LIST' command"), nil
+		}
+		names := make([]string, 0, len(s.users))
+		for name := range s.users {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		rules := make(protocol.Array, len(names))
+		for i, name := range names {
+			rules[i] = protocol.BulkString(s.users[name].describe())
+		}
+		return rules, nil
+
+	case "CAT":
+		switch len(args) {
+		case 1:
+			reply := protocol.Array{}
+			for _, category := range aclCategories() {
+				reply = append(reply, protocol.BulkString(category))
+			}
+			return reply, nil
+		case 2:
+			category := strings.ToLower(args[1])
+			if !slices.Contains(aclCategories(), category) {
+				return protocol.ErrorString("ERR Unknown category '" + args[1] + "'"), nil
+			}
+			var names []string
+			for name, spec := range availableCommands {
+				if spec.hasCategory(category) {
+					names = append(names, strings.ToLower(name))
+				}
+			}
+			sort.Strings(names)
+			reply := make(protocol.Array, len(names))
+			for i, name := range names {
+				reply[i] = protocol.BulkString(name)
+			}
+			return reply, nil
+		default:
+			return protocol.ErrorString("ERR wrong number of arguments for 'ACL|CAT' command"), nil
+		}
+
+	default:
+		return protocol.ErrorString("ERR unknown subcommand '" + args[0] + "'. Try ACL HELP."), nil
+	}
+}
+
+// requiresAuth reports whether client must AUTH before running commands.
+// Clients start as the default user, which they may only act as without
+// logging in when it is open.
+func (s *Server) requiresAuth(client *Client) bool {
+	if client.authenticated {
+		return false
+	}
+	user, ok := s.users["default"]
+	return !ok || !user.open
+}
+
+// checkPermissions returns a NOPERM error when the user of client may not
+// run the command or access one of its keys, and nil otherwise
+func (s *Server) checkPermissions(client *Client, command string, parts []string) protocol.RESPValue {
+	spec, ok := availableCommands[command]
+	if !ok || command == "AUTH" || command == "HELLO" {
+		return nil
+	}
+	user, ok := s.users[client.user]
+	if !ok || !user.commands[command] {
+		return protocol.ErrorString(fmt.Sprintf("NOPERM User %s has no permissions to run the '%s' command", client.user, strings.ToLower(command)))
+	}
+	for _, key := range commandKeys(spec, parts) {
+		if !user.canAccessKey(key) {
+			return protocol.ErrorString("NOPERM No permissions to access a key")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andrelcunha/goodiesdb/internal/protocol"
+)
+
+// Client holds the state of a connection. Replies are queued and written by a
+// dedicated goroutine so that a slow reader never blocks the server, while the
+// number of queued bytes is tracked to enforce the output buffer limits.
+type Client struct {
+	id            int64
+	conn          net.Conn
+	name          string
+	db            int
+	authenticated bool
+	user          string // the user authenticated as, "default" until AUTH
+	channels      map[string]struct{}
+	patterns      map[string]struct{}
+	subscribed    atomic.Int32 // len(channels) + len(patterns), readable by publishers
+	noTouch       bool         // reads don't update the access time of keys
+	noEvict       atomic.Bool  // exempt from the output buffer limits
+	quitting      bool         // set by QUIT, to disconnect once its reply is sent
+
+	mu           sync.Mutex
+	cond         *sync.Cond
+	proto        protocol.Protocol // negotiated with HELLO
+	pending      [][]byte
+	pendingBytes int64         // bytes queued or being written
+	totalBytes   *atomic.Int64 // pending bytes of every client, shared by the server
+	softSince    time.Time     // when the soft limit was first exceeded
+	closing      bool          // stop once the pending replies are written
+	closed       bool          // stop now, dropping the pending replies
+	done         chan struct{}
+}
+
+// newClient creates a client for conn speaking proto and starts its writer
+func newClient(id int64, conn net.Conn, proto protocol.Protocol, totalBytes *atomic.Int64) *Client {
+	c := &Client{
+		id:         id,
+		user:       "default",
+		totalBytes: totalBytes,
+		conn:       conn,
+		proto:      proto,
+		channels:   make(map[string]struct{}),
+		patterns:   make(map[string]struct{}),
+		done:       make(chan struct{}),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	go c.writeLoop()
+	return c
+}
+
+// protocol returns the protocol the client speaks
+func (c *Client) protocol() protocol.Protocol {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.proto
+}
+
+// setProtocol switches the protocol used for the next replies
+func (c *Client) setProtocol(proto protocol.Protocol) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.proto = proto
+}
+
+// isRESP3 reports whether the client negotiated RESP3
+func (c *Client) isRESP3() bool {
+	return c.protocol().Version() == "RESP3"
+}
+
+// subscriptions returns the number of channels and patterns the client is subscribed to
+func (c *Client) subscriptions() int {
+	return int(c.subscribed.Load())
+}
+
+// updateSubscriptions refreshes the count after channels or patterns changed
+func (c *Client) updateSubscriptions() {
+	c.subscribed.Store(int32(len(c.channels) + len(c.patterns)))
+}
+
+// enqueue queues an encoded reply. It returns false when the pending output
+// has grown past limit and the client should be disconnected.
+func (c *Client) enqueue(data []byte, limit OutputBufferLimit) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.closing {
+		return true
+	}
+	c.pending = append(c.pending, data)
+	c.addPending(int64(len(data)))
+	c.cond.Signal()
+	return !c.overLimit(limit)
+}
+
+// overLimit checks the pending output against limit. The caller holds c.mu.
+func (c *Client) overLimit(limit OutputBufferLimit) bool {
+	if limit.Hard > 0 && c.pendingBytes >= limit.Hard {
+		return true
+	}
+	if limit.Soft > 0 && c.pendingBytes >= limit.Soft {
+		if c.softSince.IsZero() {
+			c.softSince = time.Now()
+			return false
+		}
+		return time.Since(c.softSince) >= time.Duration(limit.SoftSeconds)*time.Second
+	}
+	c.softSince = time.Time{}
+	return false
+}
+
+// addPending accounts for n more (or, when negative, fewer) pending bytes.
+// The caller holds c.mu.
+func (c *Client) addPending(n int64) {
+	c.pendingBytes += n
+	c.totalBytes.Add(n)
+}
+
+// outputBufferSize returns the number of bytes waiting to be written
+func (c *Client) outputBufferSize() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pendingBytes
+}
+
+// isClosed reports whether the client has been disconnected
+func (c *Client) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// close closes the connection once the pending replies have been written
+func (c *Client) close() {
+	c.mu.Lock()
+	c.closing = true
+	c.cond.Signal()
+	c.mu.Unlock()
+}
+
+// kill closes the connection right away, dropping the pending replies
+func (c *Client) kill() {
+	c.mu.Lock()
+	c.closed = true
+	for _, data := range c.pending {
+		c.addPending(-int64(len(data)))
+	}
+	c.pending = nil
+	c.cond.Signal()
+	c.mu.Unlock()
+	c.conn.Close()
+}
+
+// writeLoop writes the queued replies until the client is closed
+func (c *Client) writeLoop() {
+	defer close(c.done)
+	defer c.conn.Close()
+	for {
+		c.mu.Lock()
+		for len(c.pending) == 0 && !c.closing && !c.closed {
+			c.cond.Wait()
+		}
+		if c.closed || len(c.pending) == 0 {
+			c.mu.Unlock()
+			return
+		}
+		batch := c.pending
+		c.pending = nil
+		c.mu.Unlock()
+
+		buffers := net.Buffers(batch)
+		written, err := buffers.WriteTo(c.conn)
+
+		c.mu.Lock()
+		c.addPending(-written)
+		c.mu.Unlock()
+		if err != nil {
+			c.kill()
+			return
+		}
+	}
+}
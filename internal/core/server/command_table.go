@@ -0,0 +1,635 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// commandArg describes one argument of a command, as reported by COMMAND DOCS.
+// Arguments of type "oneof" and "block" hold their alternatives or parts in Args.
+type commandArg struct {
+	Name     string
+	Type     string
+	Token    string
+	Optional bool
+	Multiple bool
+	Args     []commandArg
+}
+
+// commandSpec holds the metadata of a command. Arity follows the Redis
+// convention: a positive number is the exact number of parts including the
+// command name, a negative one is the minimum. Deprecated commands set
+// DeprecatedSince and name the command to use instead in ReplacedBy.
+type commandSpec struct {
+	Arity           int
+	Flags           []string
+	Group           string
+	Summary         string
+	Since           string
+	DeprecatedSince string
+	ReplacedBy      string
+	Args            []commandArg
+}
+
+// isWrite reports whether the command may modify the dataset. Write commands
+// are the ones rejected in read-only mode and the only ones that must be
+// logged to the AOF.
+func (spec commandSpec) isWrite() bool {
+	return slices.Contains(spec.Flags, "write")
+}
+
+// isDenyOOM reports whether the command may grow the dataset, so it is
+// rejected while the memory used is over maxmemory
+func (spec commandSpec) isDenyOOM() bool {
+	return slices.Contains(spec.Flags, "denyoom")
+}
+
+// isLoadingAllowed reports whether the command may run while the dataset is
+// loaded in the background
+func (spec commandSpec) isLoadingAllowed() bool {
+	return slices.Contains(spec.Flags, "loading")
+}
+
+// isReadOnly reports whether the command only reads keys
+func (spec commandSpec) isReadOnly() bool {
+	return slices.Contains(spec.Flags, "readonly")
+}
+
+// categories returns the ACL categories of the command: read or write,
+// keyspace for the generic key commands, the data type it works on, and the
+// fast, admin and pubsub flags
+func (spec commandSpec) categories() []string {
+	var categories []string
+	if spec.isReadOnly() {
+		categories = append(categories, "read")
+	}
+	if spec.isWrite() {
+		categories = append(categories, "write")
+	}
+	switch spec.Group {
+	case "generic":
+		categories = append(categories, "keyspace")
+	case "sorted-set":
+		categories = append(categories, "sortedset")
+	case "string", "list", "hash", "set", "connection":
+		categories = append(categories, spec.Group)
+	}
+	for _, flag := range []string{"fast", "admin", "pubsub"} {
+		if slices.Contains(spec.Flags, flag) && !slices.Contains(categories, flag) {
+			categories = append(categories, flag)
+		}
+	}
+	return categories
+}
+
+// hasCategory reports whether the command belongs to an ACL category
+func (spec commandSpec) hasCategory(category string) bool {
+	return slices.Contains(spec.categories(), category)
+}
+
+// aclCategories returns every ACL category of the commands in the table
+func aclCategories() []string {
+	var categories []string
+	for _, spec := range availableCommands {
+		for _, category := range spec.categories() {
+			if !slices.Contains(categories, category) {
+				categories = append(categories, category)
+			}
+		}
+	}
+	sort.Strings(categories)
+	return categories
+}
+
+func keyArg(name string) commandArg { return commandArg{Name: name, Type: "key"} }
+
+func stringArg(name string) commandArg { return commandArg{Name: name, Type: "string"} }
+
+func integerArg(name string) commandArg { return commandArg{Name: name, Type: "integer"} }
+
+func tokenArg(name, token string) commandArg {
+	return commandArg{Name: name, Type: "pure-token", Token: token}
+}
+
+func optionalArg(arg commandArg) commandArg {
+	arg.Optional = true
+	return arg
+}
+
+func multipleArg(arg commandArg) commandArg {
+	arg.Multiple = true
+	return arg
+}
+
+func withToken(arg commandArg, token string) commandArg {
+	arg.Token = token
+	return arg
+}
+
+func oneOfArg(name string, args ...commandArg) commandArg {
+	return commandArg{Name: name, Type: "oneof", Args: args}
+}
+
+// availableCommands is the metadata of every command the server implements
+var availableCommands = map[string]commandSpec{
+	"ACL": {
+		Arity: -2, Flags: []string{"noscript", "loading", "stale"}, Group: "server", Since: "6.0.0",
+		Summary: "A container for Access List Control commands.",
+	},
+	"APPEND": {
+		Arity: 3, Flags: []string{"write", "denyoom", "fast"}, Group: "string", Since: "2.0.0",
+		Summary: "Appends a string to the value of a key. Creates the key if it doesn't exist.",
+		Args:    []commandArg{keyArg("key"), stringArg("value")},
+	},
+	"AUTH": {
+		Arity: -2, Flags: []string{"noscript", "loading", "stale", "fast"}, Group: "connection", Since: "1.0.0",
+		Summary: "Authenticates the connection.",
+		Args:    []commandArg{optionalArg(stringArg("username")), stringArg("password")},
+	},
+	"BGREWRITEAOF": {
+		Arity: 1, Flags: []string{"admin", "noscript", "no_async_loading"}, Group: "server", Since: "1.0.0",
+		Summary: "Asynchronously rewrites the append-only file to disk.",
+	},
+	"CLIENT": {
+		Arity: -2, Flags: []string{"noscript", "loading", "stale"}, Group: "connection", Since: "2.4.0",
+		Summary: "A container for client connection commands.",
+	},
+	"COMMAND": {
+		Arity: -1, Flags: []string{"loading", "stale"}, Group: "server", Since: "2.8.13",
+		Summary: "Returns detailed information about all commands.",
+	},
+	"COPY": {
+		Arity: -3, Flags: []string{"write", "denyoom"}, Group: "generic", Since: "6.2.0",
+		Summary: "Copies the value of a key to a new key.",
+		Args: []commandArg{keyArg("source"), keyArg("destination"),
+			optionalArg(withToken(integerArg("destination-db"), "DB")), optionalArg(tokenArg("replace", "REPLACE"))},
+	},
+	"CONFIG": {
+		Arity: -2, Flags: []string{"admin", "noscript", "loading", "stale"}, Group: "server", Since: "2.0.0",
+		Summary: "A container for server configuration commands.",
+	},
+	"DEBUG": {
+		Arity: -2, Flags: []string{"admin", "noscript", "loading", "stale"}, Group: "server", Since: "1.0.0",
+		Summary: "A container for debugging commands.",
+	},
+	"DECR": {
+		Arity: 2, Flags: []string{"write", "denyoom", "fast"}, Group: "string", Since: "1.0.0",
+		Summary: "Decrements the integer value of a key by one. Uses 0 as initial value if the key doesn't exist.",
+		Args:    []commandArg{keyArg("key")},
+	},
+	"DECRBY": {
+		Arity: 3, Flags: []string{"write", "denyoom", "fast"}, Group: "string", Since: "1.0.0",
+		Summary: "Decrements a number from the integer value of a key. Uses 0 as initial value if the key doesn't exist.",
+		Args:    []commandArg{keyArg("key"), integerArg("decrement")},
+	},
+	"DEL": {
+		Arity: 2, Flags: []string{"write"}, Group: "generic", Since: "1.0.0",
+		Summary: "Deletes a key.",
+		Args:    []commandArg{keyArg("key")},
+	},
+	"DUMP": {
+		Arity: 2, Flags: []string{"readonly"}, Group: "generic", Since: "2.6.0",
+		Summary: "Returns a serialized representation of the value stored at a key.",
+		Args:    []commandArg{keyArg("key")},
+	},
+	"ECHO": {
+		Arity: 2, Flags: []string{"loading", "stale", "fast"}, Group: "connection", Since: "1.0.0",
+		Summary: "Returns the given string.",
+		Args:    []commandArg{stringArg("message")},
+	},
+	"EXISTS": {
+		Arity: -2, Flags: []string{"readonly", "fast"}, Group: "generic", Since: "1.0.0",
+		Summary: "Determines whether one or more keys exist.",
+		Args:    []commandArg{multipleArg(keyArg("key"))},
+	},
+	"EXPIRE": {
+		Arity: 3, Flags: []string{"write", "fast"}, Group: "generic", Since: "1.0.0",
+		Summary: "Sets the expiration time of a key in seconds.",
+		Args:    []commandArg{keyArg("key"), integerArg("seconds")},
+	},
+	"EXPIREAT": {
+		Arity: 3, Flags: []string{"write", "fast"}, Group: "generic", Since: "1.2.0",
+		Summary: "Sets the expiration time of a key to a Unix timestamp.",
+		Args:    []commandArg{keyArg("key"), {Name: "unix-time-seconds", Type: "unix-time"}},
+	},
+	"FLUSHALL": {
+		Arity: 1, Flags: []string{"write"}, Group: "server", Since: "1.0.0",
+		Summary: "Removes all keys from all databases.",
+	},
+	"FLUSHDB": {
+		Arity: 1, Flags: []string{"write"}, Group: "server", Since: "1.0.0",
+		Summary: "Remove all keys from the current database.",
+	},
+	"GET": {
+		Arity: 2, Flags: []string{"readonly", "fast"}, Group: "string", Since: "1.0.0",
+		Summary: "Returns the string value of a key.",
+		Args:    []commandArg{keyArg("key")},
+	},
+	"GETDEL": {
+		Arity: 2, Flags: []string{"write", "fast"}, Group: "string", Since: "6.2.0",
+		Summary: "Returns the string value of a key after deleting the key.",
+		Args:    []commandArg{keyArg("key")},
+	},
+	"GETEX": {
+		Arity: -2, Flags: []string{"write", "fast"}, Group: "string", Since: "6.2.0",
+		Summary: "Returns the string value of a key after setting its expiration time.",
+		Args: []commandArg{keyArg("key"), optionalArg(oneOfArg("expiration",
+			withToken(integerArg("seconds"), "EX"),
+			withToken(integerArg("milliseconds"), "PX"),
+			withToken(commandArg{Name: "unix-time-seconds", Type: "unix-time"}, "EXAT"),
+			withToken(commandArg{Name: "unix-time-milliseconds", Type: "unix-time"}, "PXAT"),
+			tokenArg("persist", "PERSIST"),
+		))},
+	},
+	"GETRANGE": {
+		Arity: 4, Flags: []string{"readonly"}, Group: "string", Since: "2.4.0",
+		Summary: "Returns a substring of the string stored at a key.",
+		Args:    []commandArg{keyArg("key"), integerArg("start"), integerArg("end")},
+	},
+	"GETSET": {
+		Arity: 3, Flags: []string{"write", "denyoom", "fast"}, Group: "string", Since: "1.0.0",
+		DeprecatedSince: "6.2.0", ReplacedBy: "`SET` with the `!GET` argument",
+		Summary: "Returns the previous string value of a key after setting it to a new value.",
+		Args:    []commandArg{keyArg("key"), stringArg("value")},
+	},
+	"HGET": {
+		Arity: 3, Flags: []string{"readonly", "fast"}, Group: "hash", Since: "2.0.0",
+		Summary: "Returns the value of a field in a hash.",
+		Args:    []commandArg{keyArg("key"), stringArg("field")},
+	},
+	"HGETALL": {
+		Arity: 2, Flags: []string{"readonly"}, Group: "hash", Since: "2.0.0",
+		Summary: "Returns all fields and values in a hash.",
+		Args:    []commandArg{keyArg("key")},
+	},
+	"HELLO": {
+		Arity: -1, Flags: []string{"noscript", "loading", "stale", "fast"}, Group: "connection", Since: "6.0.0",
+		Summary: "Handshakes with the server.",
+		Args: []commandArg{optionalArg(commandArg{Name: "arguments", Type: "block", Args: []commandArg{
+			integerArg("protover"),
+			optionalArg(commandArg{Name: "auth", Type: "block", Token: "AUTH", Args: []commandArg{
+				stringArg("username"), stringArg("password"),
+			}}),
+			optionalArg(withToken(stringArg("clientname"), "SETNAME")),
+		}})},
+	},
+	"HRANDFIELD": {
+		Arity: -2, Flags: []string{"readonly"}, Group: "hash", Since: "6.2.0",
+		Summary: "Returns one or more random fields from a hash.",
+		Args: []commandArg{keyArg("key"), optionalArg(commandArg{Name: "options", Type: "block",
+			Args: []commandArg{integerArg("count"), optionalArg(tokenArg("withvalues", "WITHVALUES"))}})},
+	},
+	"HSET": {
+		Arity: -4, Flags: []string{"write", "denyoom", "fast"}, Group: "hash", Since: "2.0.0",
+		Summary: "Creates or modifies the value of a field in a hash.",
+		Args: []commandArg{keyArg("key"), multipleArg(commandArg{Name: "data", Type: "block", Args: []commandArg{
+			stringArg("field"), stringArg("value"),
+		}})},
+	},
+	"HVALS": {
+		Arity: 2, Flags: []string{"readonly"}, Group: "hash", Since: "2.0.0",
+		Summary: "Returns all values in a hash.",
+		Args:    []commandArg{keyArg("key")},
+	},
+	"INCR": {
+		Arity: 2, Flags: []string{"write", "denyoom", "fast"}, Group: "string", Since: "1.0.0",
+		Summary: "Increments the integer value of a key by one. Uses 0 as initial value if the key doesn't exist.",
+		Args:    []commandArg{keyArg("key")},
+	},
+	"INCRBY": {
+		Arity: 3, Flags: []string{"write", "denyoom", "fast"}, Group: "string", Since: "1.0.0",
+		Summary: "Increments the integer value of a key by a number. Uses 0 as initial value if the key doesn't exist.",
+		Args:    []commandArg{keyArg("key"), integerArg("increment")},
+	},
+	"INCRBYFLOAT": {
+		Arity: 3, Flags: []string{"write", "denyoom", "fast"}, Group: "string", Since: "2.6.0",
+		Summary: "Increment the floating point value of a key by a number. Uses 0 as initial value if the key doesn't exist.",
+		Args:    []commandArg{keyArg("key"), {Name: "increment", Type: "double"}},
+	},
+	"INFO": {
+		Arity: -1, Flags: []string{"loading", "stale"}, Group: "server", Since: "1.0.0",
+		Summary: "Returns information and statistics about the server.",
+		Args:    []commandArg{optionalArg(multipleArg(stringArg("section")))},
+	},
+	"KEYS": {
+		Arity: 2, Flags: []string{"readonly"}, Group: "generic", Since: "1.0.0",
+		Summary: "Returns all key names that match a pattern.",
+		Args:    []commandArg{{Name: "pattern", Type: "pattern"}},
+	},
+	"LLEN": {
+		Arity: 2, Flags: []string{"readonly", "fast"}, Group: "list", Since: "1.0.0",
+		Summary: "Returns the length of a list.",
+		Args:    []commandArg{keyArg("key")},
+	},
+	"LPOP": {
+		Arity: -2, Flags: []string{"write", "fast"}, Group: "list", Since: "1.0.0",
+		Summary: "Returns the first elements in a list after removing it. Deletes the list if the last element was popped.",
+		Args:    []commandArg{keyArg("key"), optionalArg(integerArg("count"))},
+	},
+	"LPOS": {
+		Arity: -3, Flags: []string{"readonly"}, Group: "list", Since: "6.0.6",
+		Summary: "Returns the index of matching elements in a list.",
+		Args: []commandArg{keyArg("key"), stringArg("element"),
+			optionalArg(withToken(integerArg("rank"), "RANK")),
+			optionalArg(withToken(integerArg("num-matches"), "COUNT")),
+			optionalArg(withToken(integerArg("len"), "MAXLEN"))},
+	},
+	"LPUSH": {
+		Arity: -3, Flags: []string{"write", "denyoom", "fast"}, Group: "list", Since: "1.0.0",
+		Summary: "Prepends one or more elements to a list. Creates the key if it doesn't exist.",
+		Args:    []commandArg{keyArg("key"), multipleArg(stringArg("element"))},
+	},
+	"LRANGE": {
+		Arity: 4, Flags: []string{"readonly"}, Group: "list", Since: "1.0.0",
+		Summary: "Returns a range of elements from a list.",
+		Args:    []commandArg{keyArg("key"), integerArg("start"), integerArg("stop")},
+	},
+	"LINSERT": {
+		Arity: 5, Flags: []string{"write", "denyoom"}, Group: "list", Since: "2.2.0",
+		Summary: "Inserts an element before or after another element in a list.",
+		Args: []commandArg{keyArg("key"),
+			oneOfArg("where", tokenArg("before", "BEFORE"), tokenArg("after", "AFTER")),
+			stringArg("pivot"), stringArg("element")},
+	},
+	"LMOVE": {
+		Arity: 5, Flags: []string{"write", "denyoom"}, Group: "list", Since: "6.2.0",
+		Summary: "Returns an element after popping it from one list and pushing it to another. Deletes the list if the last element was moved.",
+		Args: []commandArg{keyArg("source"), keyArg("destination"),
+			oneOfArg("wherefrom", tokenArg("left", "LEFT"), tokenArg("right", "RIGHT")),
+			oneOfArg("whereto", tokenArg("left", "LEFT"), tokenArg("right", "RIGHT"))},
+	},
+	"LREM": {
+		Arity: 4, Flags: []string{"write"}, Group: "list", Since: "1.0.0",
+		Summary: "Removes elements from a list. Deletes the list if the last element was removed.",
+		Args:    []commandArg{keyArg("key"), integerArg("count"), stringArg("element")},
+	},
+	"LSET": {
+		Arity: 4, Flags: []string{"write", "denyoom"}, Group: "list", Since: "1.0.0",
+		Summary: "Sets the value of an element in a list by its index.",
+		Args:    []commandArg{keyArg("key"), integerArg("index"), stringArg("element")},
+	},
+	"LTRIM": {
+		Arity: 4, Flags: []string{"write"}, Group: "list", Since: "1.0.0",
+		Summary: "Removes elements from both ends a list. Deletes the list if all elements were trimmed.",
+		Args:    []commandArg{keyArg("key"), integerArg("start"), integerArg("stop")},
+	},
+	"MEMORY": {
+		Arity: -2, Group: "server", Since: "4.0.0",
+		Summary: "A container for memory diagnostics commands.",
+	},
+	"MGET": {
+		Arity: -2, Flags: []string{"readonly", "fast"}, Group: "string", Since: "1.0.0",
+		Summary: "Atomically returns the string values of one or more keys.",
+		Args:    []commandArg{multipleArg(keyArg("key"))},
+	},
+	"MSET": {
+		Arity: -3, Flags: []string{"write", "denyoom"}, Group: "string", Since: "1.0.1",
+		Summary: "Atomically creates or modifies the string values of one or more keys.",
+		Args: []commandArg{multipleArg(commandArg{Name: "data", Type: "block",
+			Args: []commandArg{keyArg("key"), stringArg("value")}})},
+	},
+	"OBJECT": {
+		Arity: -2, Group: "generic", Since: "2.2.3",
+		Summary: "A container for object introspection commands.",
+	},
+	"PERSIST": {
+		Arity: 2, Flags: []string{"write", "fast"}, Group: "generic", Since: "2.2.0",
+		Summary: "Removes the expiration time of a key.",
+		Args:    []commandArg{keyArg("key")},
+	},
+	"PEXPIRE": {
+		Arity: 3, Flags: []string{"write", "fast"}, Group: "generic", Since: "2.6.0",
+		Summary: "Sets the expiration time of a key in milliseconds.",
+		Args:    []commandArg{keyArg("key"), integerArg("milliseconds")},
+	},
+	"PEXPIREAT": {
+		Arity: 3, Flags: []string{"write", "fast"}, Group: "generic", Since: "2.6.0",
+		Summary: "Sets the expiration time of a key to a Unix milliseconds timestamp.",
+		Args:    []commandArg{keyArg("key"), {Name: "unix-time-milliseconds", Type: "unix-time"}},
+	},
+	"PING": {
+		Arity: -1, Flags: []string{"loading", "fast"}, Group: "connection", Since: "1.0.0",
+		Summary: "Returns the server's liveliness response.",
+		Args:    []commandArg{optionalArg(stringArg("message"))},
+	},
+	"PSUBSCRIBE": {
+		Arity: -2, Flags: []string{"pubsub", "noscript", "loading", "stale"}, Group: "pubsub", Since: "2.0.0",
+		Summary: "Listens for messages published to channels that match one or more patterns.",
+		Args:    []commandArg{multipleArg(commandArg{Name: "pattern", Type: "pattern"})},
+	},
+	"PTTL": {
+		Arity: 2, Flags: []string{"readonly", "fast"}, Group: "generic", Since: "2.6.0",
+		Summary: "Returns the expiration time in milliseconds of a key.",
+		Args:    []commandArg{keyArg("key")},
+	},
+	"PUBLISH": {
+		Arity: 3, Flags: []string{"pubsub", "loading", "stale", "fast"}, Group: "pubsub", Since: "2.0.0",
+		Summary: "Posts a message to a channel.",
+		Args:    []commandArg{stringArg("channel"), stringArg("message")},
+	},
+	"PUNSUBSCRIBE": {
+		Arity: -1, Flags: []string{"pubsub", "noscript", "loading", "stale"}, Group: "pubsub", Since: "2.0.0",
+		Summary: "Stops listening to messages published to channels that match one or more patterns.",
+		Args:    []commandArg{optionalArg(multipleArg(commandArg{Name: "pattern", Type: "pattern"}))},
+	},
+	"QUIT": {
+		Arity: -1, Flags: []string{"noscript", "loading", "stale", "fast"}, Group: "connection", Since: "1.0.0",
+		Summary: "Closes the connection.",
+	},
+	"RENAME": {
+		Arity: 3, Flags: []string{"write"}, Group: "generic", Since: "1.0.0",
+		Summary: "Renames a key and overwrites the destination.",
+		Args:    []commandArg{keyArg("key"), keyArg("newkey")},
+	},
+	"RESTORE": {
+		Arity: -4, Flags: []string{"write", "denyoom"}, Group: "generic", Since: "2.6.0",
+		Summary: "Creates a key from the serialized representation of a value.",
+		Args: []commandArg{keyArg("key"), integerArg("ttl"), stringArg("serialized-value"),
+			optionalArg(tokenArg("replace", "REPLACE")), optionalArg(tokenArg("absttl", "ABSTTL"))},
+	},
+	"RPOP": {
+		Arity: -2, Flags: []string{"write", "fast"}, Group: "list", Since: "1.0.0",
+		Summary: "Returns and removes the last elements of a list. Deletes the list if the last element was popped.",
+		Args:    []commandArg{keyArg("key"), optionalArg(integerArg("count"))},
+	},
+	"RPOPLPUSH": {
+		Arity: 3, Flags: []string{"write", "denyoom"}, Group: "list", Since: "1.2.0",
+		DeprecatedSince: "6.2.0", ReplacedBy: "`LMOVE` with the `RIGHT` and `LEFT` arguments",
+		Summary: "Returns the last element of a list after removing and pushing it to another list. Deletes the list if the last element was popped.",
+		Args:    []commandArg{keyArg("source"), keyArg("destination")},
+	},
+	"RPUSH": {
+		Arity: -3, Flags: []string{"write", "denyoom", "fast"}, Group: "list", Since: "1.0.0",
+		Summary: "Appends one or more elements to a list. Creates the key if it doesn't exist.",
+		Args:    []commandArg{keyArg("key"), multipleArg(stringArg("element"))},
+	},
+	"SADD": {
+		Arity: -3, Flags: []string{"write", "denyoom", "fast"}, Group: "set", Since: "1.0.0",
+		Summary: "Adds one or more members to a set. Creates the key if it doesn't exist.",
+		Args:    []commandArg{keyArg("key"), multipleArg(stringArg("member"))},
+	},
+	"SAVE": {
+		Arity: 1, Flags: []string{"admin", "noscript", "no_async_loading", "no_multi"}, Group: "server", Since: "1.0.0",
+		Summary: "Synchronously saves the database(s) to disk.",
+	},
+	"SCARD": {
+		Arity: 2, Flags: []string{"readonly", "fast"}, Group: "set", Since: "1.0.0",
+		Summary: "Returns the number of members in a set.",
+		Args:    []commandArg{keyArg("key")},
+	},
+	"SCAN": {
+		Arity: -2, Flags: []string{"readonly"}, Group: "generic", Since: "2.8.0",
+		Summary: "Iterates over the key names in the database.",
+		Args: []commandArg{integerArg("cursor"),
+			optionalArg(withToken(commandArg{Name: "pattern", Type: "pattern"}, "MATCH")),
+			optionalArg(withToken(integerArg("count"), "COUNT"))},
+	},
+	"SELECT": {
+		Arity: 2, Flags: []string{"loading", "stale", "fast"}, Group: "connection", Since: "1.0.0",
+		Summary: "Changes the selected database.",
+		Args:    []commandArg{integerArg("index")},
+	},
+	"SET": {
+		Arity: -3, Flags: []string{"write", "denyoom"}, Group: "string", Since: "1.0.0",
+		Summary: "Sets the string value of a key, ignoring its type. The key is created if it doesn't exist.",
+		Args: []commandArg{keyArg("key"), stringArg("value"),
+			optionalArg(oneOfArg("condition", tokenArg("nx", "NX"), tokenArg("xx", "XX"))),
+			optionalArg(tokenArg("get", "GET")),
+			optionalArg(oneOfArg("expiration",
+				withToken(integerArg("seconds"), "EX"),
+				withToken(integerArg("milliseconds"), "PX"),
+				withToken(commandArg{Name: "unix-time-seconds", Type: "unix-time"}, "EXAT"),
+				withToken(commandArg{Name: "unix-time-milliseconds", Type: "unix-time"}, "PXAT"),
+				tokenArg("keepttl", "KEEPTTL"),
+			))},
+	},
+	"SETRANGE": {
+		Arity: 4, Flags: []string{"write", "denyoom"}, Group: "string", Since: "2.2.0",
+		Summary: "Overwrites a part of a string value with another by an offset. Creates the key if it doesn't exist.",
+		Args:    []commandArg{keyArg("key"), integerArg("offset"), stringArg("value")},
+	},
+	"SETNX": {
+		Arity: 3, Flags: []string{"write", "denyoom", "fast"}, Group: "string", Since: "1.0.0",
+		Summary: "Set the string value of a key only when the key doesn't exist.",
+		Args:    []commandArg{keyArg("key"), stringArg("value")},
+	},
+	"SISMEMBER": {
+		Arity: 3, Flags: []string{"readonly", "fast"}, Group: "set", Since: "1.0.0",
+		Summary: "Determines whether a member belongs to a set.",
+		Args:    []commandArg{keyArg("key"), stringArg("member")},
+	},
+	"SMEMBERS": {
+		Arity: 2, Flags: []string{"readonly"}, Group: "set", Since: "1.0.0",
+		Summary: "Returns all members of a set.",
+		Args:    []commandArg{keyArg("key")},
+	},
+	"SRANDMEMBER": {
+		Arity: -2, Flags: []string{"readonly"}, Group: "set", Since: "1.0.0",
+		Summary: "Get one or multiple random members from a set",
+		Args:    []commandArg{keyArg("key"), optionalArg(integerArg("count"))},
+	},
+	"SREM": {
+		Arity: -3, Flags: []string{"write", "fast"}, Group: "set", Since: "1.0.0",
+		Summary: "Removes one or more members from a set. Deletes the set if the last member was removed.",
+		Args:    []commandArg{keyArg("key"), multipleArg(stringArg("member"))},
+	},
+	"STRLEN": {
+		Arity: 2, Flags: []string{"readonly", "fast"}, Group: "string", Since: "2.2.0",
+		Summary: "Returns the length of a string value.",
+		Args:    []commandArg{keyArg("key")},
+	},
+	"SUBSCRIBE": {
+		Arity: -2, Flags: []string{"pubsub", "noscript", "loading", "stale"}, Group: "pubsub", Since: "2.0.0",
+		Summary: "Listens for messages published to channels.",
+		Args:    []commandArg{multipleArg(stringArg("channel"))},
+	},
+	"TOUCH": {
+		Arity: -2, Flags: []string{"readonly", "fast"}, Group: "generic", Since: "3.2.1",
+		Summary: "Returns the number of existing keys out of those specified after updating the time they were last accessed.",
+		Args:    []commandArg{multipleArg(keyArg("key"))},
+	},
+	"SUBSTR": {
+		Arity: 4, Flags: []string{"readonly"}, Group: "string", Since: "1.0.0",
+		DeprecatedSince: "2.0.0", ReplacedBy: "`GETRANGE`",
+		Summary: "Returns a substring from a string value.",
+		Args:    []commandArg{keyArg("key"), integerArg("start"), integerArg("end")},
+	},
+	"TTL": {
+		Arity: 2, Flags: []string{"readonly", "fast"}, Group: "generic", Since: "1.0.0",
+		Summary: "Returns the expiration time in seconds of a key.",
+		Args:    []commandArg{keyArg("key")},
+	},
+	"TYPE": {
+		Arity: 2, Flags: []string{"readonly", "fast"}, Group: "generic", Since: "1.0.0",
+		Summary: "Determines the type of value stored at a key.",
+		Args:    []commandArg{keyArg("key")},
+	},
+	"UNSUBSCRIBE": {
+		Arity: -1, Flags: []string{"pubsub", "noscript", "loading", "stale"}, Group: "pubsub", Since: "2.0.0",
+		Summary: "Stops listening to messages posted to channels.",
+		Args:    []commandArg{optionalArg(multipleArg(stringArg("channel")))},
+	},
+	"ZADD": {
+		Arity: -4, Flags: []string{"write", "denyoom", "fast"}, Group: "sorted-set", Since: "1.2.0",
+		Summary: "Adds one or more members to a sorted set, or updates their scores. Creates the key if it doesn't exist.",
+		Args: []commandArg{keyArg("key"),
+			optionalArg(oneOfArg("condition", tokenArg("nx", "NX"), tokenArg("xx", "XX"))),
+			optionalArg(oneOfArg("comparison", tokenArg("gt", "GT"), tokenArg("lt", "LT"))),
+			optionalArg(tokenArg("change", "CH")), optionalArg(tokenArg("increment", "INCR")),
+			multipleArg(commandArg{Name: "data", Type: "block", Args: []commandArg{
+				{Name: "score", Type: "double"}, stringArg("member"),
+			}})},
+	},
+	"ZRANDMEMBER": {
+		Arity: -2, Flags: []string{"readonly"}, Group: "sorted-set", Since: "6.2.0",
+		Summary: "Returns one or more random members from a sorted set.",
+		Args: []commandArg{keyArg("key"), optionalArg(commandArg{Name: "options", Type: "block",
+			Args: []commandArg{integerArg("count"), optionalArg(tokenArg("withscores", "WITHSCORES"))}})},
+	},
+	"ZRANGE": {
+		Arity: -4, Flags: []string{"readonly"}, Group: "sorted-set", Since: "1.2.0",
+		Summary: "Returns members in a sorted set within a range of indexes.",
+		Args: []commandArg{keyArg("key"), integerArg("start"), integerArg("stop"),
+			optionalArg(tokenArg("withscores", "WITHSCORES"))},
+	},
+	"ZSCORE": {
+		Arity: 3, Flags: []string{"readonly", "fast"}, Group: "sorted-set", Since: "1.2.0",
+		Summary: "Returns the score of a member in a sorted set.",
+		Args:    []commandArg{keyArg("key"), stringArg("member")},
+	},
+}
+
+// newCommandNames applies the RENAME_COMMAND rules of config. It maps the
+// names clients must use for renamed commands to the real ones, and the real
+// names of renamed and disabled commands to "", so they are unknown to
+// clients. Commands without a rule keep their name and have no entry.
+func newCommandNames(config *Config) map[string]string {
+	names := make(map[string]string)
+	commands := make([]string, 0, len(config.RenameCommand))
+	for command := range config.RenameCommand {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+	for _, command := range commands {
+		newName := strings.ToUpper(config.RenameCommand[command])
+		if _, ok := availableCommands[command]; !ok {
+			fmt.Printf("Ignoring RENAME_COMMAND rule: unknown command %q\n", command)
+			continue
+		}
+		if _, ok := availableCommands[newName]; ok {
+			fmt.Printf("Ignoring RENAME_COMMAND rule: %q is already a command\n", newName)
+			continue
+		}
+		if _, ok := names[newName]; ok && newName != "" {
+			fmt.Printf("Ignoring RENAME_COMMAND rule: %q is already the new name of a command\n", newName)
+			continue
+		}
+		names[command] = ""
+		if newName != "" {
+			names[newName] = command
+		}
+	}
+	return names
+}
@@ -2,26 +2,294 @@ package server
 
 import (
 	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/andrelcunha/goodiesdb/internal/persistence/rdb"
 	"github.com/andrelcunha/goodiesdb/internal/protocol"
+	"github.com/andrelcunha/goodiesdb/internal/protocol/resp2"
+	"github.com/andrelcunha/goodiesdb/internal/protocol/resp3"
+	"github.com/andrelcunha/goodiesdb/internal/utils/glob"
 )
 
-// Info returns server info
-func (s *Server) Info() protocol.BulkString {
+// Info returns server info. Without sections, or with "default", "all" or
+// "everything", every section is included; otherwise only the named ones.
+func (s *Server) Info(sections ...string) protocol.BulkString {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	//
+
+	wanted := make(map[string]bool, len(sections))
+	for _, section := range sections {
+		wanted[strings.ToLower(section)] = true
+	}
+	everything := len(wanted) == 0 || wanted["default"] || wanted["all"] || wanted["everything"]
+
 	var b strings.Builder
-	b.WriteString("# Server\n")
-	b.WriteString(fmt.Sprintf("version:%s\n", s.config.Version))
-	b.WriteString(fmt.Sprintf("uptime_in_seconds:%d\n", 1000))
-	b.WriteString(fmt.Sprintf("connected_clients:%d\n", 0))
+	for _, section := range s.infoSections() {
+		if !everything && !wanted[strings.ToLower(section.name)] {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("# " + section.name + "\n")
+		section.render(&b)
+	}
 	bytArr := []byte(b.String())
 	fmt.Println("Sending info: ", b.String())
 	return protocol.BulkString(bytArr)
 }
 
+// infoSection is a section of the INFO reply
+type infoSection struct {
+	name   string
+	render func(b *strings.Builder)
+}
+
+// infoSections lists the INFO sections in the order they are rendered.
+// Renderers run with s.mu held.
+func (s *Server) infoSections() []infoSection {
+	return []infoSection{
+		{"Server", s.infoServer},
+		{"Memory", s.infoMemory},
+		{"Persistence", s.infoPersistence},
+		{"Stats", s.infoStats},
+		{"Replication", s.infoReplication},
+	}
+}
+
+func (s *Server) infoServer(b *strings.Builder) {
+	uptime := time.Since(s.startTime)
+	b.WriteString(fmt.Sprintf("server_name:%s\n", s.config.ServerName))
+	b.WriteString(fmt.Sprintf("version:%s\n", s.config.Version))
+	b.WriteString(fmt.Sprintf("process_id:%d\n", os.Getpid()))
+	b.WriteString(fmt.Sprintf("tcp_port:%s\n", s.config.Port))
+	b.WriteString(fmt.Sprintf("uptime_in_seconds:%d\n", int64(uptime.Seconds())))
+	b.WriteString(fmt.Sprintf("uptime_in_days:%d\n", int64(uptime.Hours()/24)))
+	b.WriteString(fmt.Sprintf("connected_clients:%d\n", len(s.clients)))
+}
+
+// infoMemory reports the memory used by the dataset, as estimated by the
+// store, and the memory the process got from the OS
+func (s *Server) infoMemory(b *strings.Builder) {
+	used := s.store.UsedMemory()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	ratio := 0.0
+	if used > 0 {
+		ratio = float64(stats.Sys) / float64(used)
+	}
+	b.WriteString(fmt.Sprintf("used_memory:%d\n", used))
+	b.WriteString(fmt.Sprintf("used_memory_human:%s\n", humanBytes(used)))
+	b.WriteString(fmt.Sprintf("used_memory_rss:%d\n", stats.Sys))
+	b.WriteString(fmt.Sprintf("used_memory_rss_human:%s\n", humanBytes(int64(stats.Sys))))
+	b.WriteString(fmt.Sprintf("maxmemory:%d\n", s.config.MaxMemory))
+	b.WriteString(fmt.Sprintf("maxmemory_human:%s\n", humanBytes(s.config.MaxMemory)))
+	b.WriteString(fmt.Sprintf("maxmemory_policy:%s\n", s.config.MaxMemoryPolicy))
+	b.WriteString(fmt.Sprintf("mem_fragmentation_ratio:%.2f\n", ratio))
+}
+
+// infoPersistence reports the state of the RDB snapshots and of the AOF
+func (s *Server) infoPersistence(b *strings.Builder) {
+	aofStatus := "ok"
+	if s.aofErr != nil {
+		aofStatus = "err"
+	}
+	b.WriteString(fmt.Sprintf("loading:%d\n", boolToInt(s.loading.Load())))
+	b.WriteString(fmt.Sprintf("rdb_changes_since_last_save:%d\n", s.store.Dirty()))
+	b.WriteString(fmt.Sprintf("rdb_bgsave_in_progress:%d\n", boolToInt(s.bgsaveInProgress.Load())))
+	b.WriteString(fmt.Sprintf("rdb_last_save_time:%d\n", s.store.LastSave().Unix()))
+	b.WriteString(fmt.Sprintf("aof_enabled:%d\n", boolToInt(s.config.UseAOF)))
+	b.WriteString(fmt.Sprintf("aof_rewrite_in_progress:%d\n", boolToInt(s.aofRewriting.Load())))
+	b.WriteString(fmt.Sprintf("aof_last_write_status:%s\n", aofStatus))
+}
+
+// infoStats reports the server and keyspace counters
+func (s *Server) infoStats(b *strings.Builder) {
+	keyspace := s.store.Stats()
+	b.WriteString(fmt.Sprintf("total_connections_received:%d\n", s.stats.connectionsReceived.Load()))
+	b.WriteString(fmt.Sprintf("total_commands_processed:%d\n", s.stats.current().commandsProcessed.Load()))
+	b.WriteString(fmt.Sprintf("instantaneous_ops_per_sec:%d\n", s.stats.opsPerSec()))
+	b.WriteString(fmt.Sprintf("expired_keys:%d\n", keyspace.ExpiredKeys))
+	b.WriteString(fmt.Sprintf("keyspace_hits:%d\n", keyspace.Hits))
+	b.WriteString(fmt.Sprintf("keyspace_misses:%d\n", keyspace.Misses))
+}
+
+// infoReplication reports the replication state. Replication isn't
+// implemented yet, so the server is always a master without replicas.
+func (s *Server) infoReplication(b *strings.Builder) {
+	b.WriteString("role:master\n")
+	b.WriteString(fmt.Sprintf("connected_slaves:%d\n", 0))
+	b.WriteString(fmt.Sprintf("master_replid:%s\n", s.replID))
+	b.WriteString(fmt.Sprintf("master_repl_offset:%d\n", 0))
+}
+
+// configParams are the parameters CONFIG GET reports, by their Redis name.
+// dir, bind and port are the values in use, which may differ from the
+// configured ones, e.g. the port actually bound when configured as 0.
+var configParams = map[string]func(s *Server) string{
+	"dir": func(s *Server) string {
+		dir, err := filepath.Abs(s.dataDir)
+		if err != nil {
+			return s.dataDir
+		}
+		return dir
+	},
+	"bind": func(s *Server) string {
+		host, _ := s.listenAddr()
+		return host
+	},
+	"port": func(s *Server) string {
+		_, port := s.listenAddr()
+		return port
+	},
+	"appendonly":                    func(s *Server) string { return yesNo(s.config.UseAOF) },
+	"aof-rewrite-incremental-fsync": func(s *Server) string { return yesNo(s.config.AOFRewriteIncrementalFsync) },
+	"maxmemory":                     func(s *Server) string { return strconv.FormatInt(s.config.MaxMemory, 10) },
+	"maxmemory-policy":              func(s *Server) string { return s.config.MaxMemoryPolicy },
+	"maxmemory-clients":             func(s *Server) string { return strconv.FormatInt(s.config.MaxMemoryClients, 10) },
+	"proto-max-bulk-len":            func(s *Server) string { return strconv.FormatInt(s.config.ProtoMaxBulkLen, 10) },
+	"list-max-listpack-size":        func(s *Server) string { return strconv.Itoa(s.config.ListMaxListpackSize) },
+	"hash-max-listpack-entries":     func(s *Server) string { return strconv.Itoa(s.config.HashMaxListpackEntries) },
+	"hash-max-listpack-value":       func(s *Server) string { return strconv.Itoa(s.config.HashMaxListpackValue) },
+	"zset-max-listpack-entries":     func(s *Server) string { return strconv.Itoa(s.config.ZSetMaxListpackEntries) },
+	"zset-max-listpack-value":       func(s *Server) string { return strconv.Itoa(s.config.ZSetMaxListpackValue) },
+}
+
+// listenAddr returns the host and port the server listens on, or the
+// configured ones before it starts listening
+func (s *Server) listenAddr() (host, port string) {
+	addr := s.Addr()
+	if addr == nil {
+		return s.config.Host, s.config.Port
+	}
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return s.config.Host, s.config.Port
+	}
+	return host, port
+}
+
+// Config runs a CONFIG subcommand
+func (s *Server) Config(client *Client, args []string) (protocol.RESPValue, error) {
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		if len(args) < 2 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'CONFIG|GET' command"), nil
+		}
+		// Each parameter is reported once, even if several patterns match it
+		var names []string
+		for name := range configParams {
+			for _, pattern := range args[1:] {
+				if glob.Match(strings.ToLower(pattern), name) {
+					names = append(names, name)
+					break
+				}
+			}
+		}
+		sort.Strings(names)
+		pairs := make([]string, 0, 2*len(names))
+		for _, name := range names {
+			pairs = append(pairs, name, configParams[name](s))
+		}
+		return pairsReply(client, pairs), nil
+
+	case "RESETSTAT":
+		if len(args) != 1 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'CONFIG|RESETSTAT' command"), nil
+		}
+		s.resetStats()
+		return protocol.SimpleString("OK"), nil
+
+	default:
+		return protocol.ErrorString("ERR unknown subcommand '" + args[0] + "'. Try CONFIG HELP."), nil
+	}
+}
+
+// Hello switches the protocol of the client and returns the server properties
+func (s *Server) Hello(client *Client, args []string) (protocol.RESPValue, error) {
+	proto := client.protocol()
+	if len(args) > 0 {
+		switch args[0] {
+		case "2":
+			proto = &resp2.RESP2Protocol{}
+		case "3":
+			proto = &resp3.RESP3Protocol{}
+		default:
+			if _, err := strconv.Atoi(args[0]); err != nil {
+				return protocol.ErrorString("ERR Protocol version is not an integer or out of range"), nil
+			}
+			return protocol.ErrorString("NOPROTO unsupported protocol version"), nil
+		}
+	}
+
+	name := client.name
+	for i := 1; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "AUTH":
+			if i+2 >= len(args) {
+				return protocol.ErrorString("ERR Syntax error in HELLO option 'auth'"), nil
+			}
+			if !s.authenticate(client, args[i+1], args[i+2]) {
+				return protocol.ErrorString("WRONGPASS invalid username-password pair or user is disabled."), nil
+			}
+			i += 2
+		case "SETNAME":
+			if i+1 >= len(args) {
+				return protocol.ErrorString("ERR Syntax error in HELLO option 'setname'"), nil
+			}
+			name = args[i+1]
+			i++
+		default:
+			return protocol.ErrorString("ERR Syntax error in HELLO option '" + args[i] + "'"), nil
+		}
+	}
+	// Without AUTH among the options HELLO is only for logged in clients
+	if s.requiresAuth(client) {
+		return protocol.ErrorString("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time"), nil
+	}
+	client.name = name
+	client.setProtocol(proto)
+
+	properties := []protocol.RESPValue{
+		protocol.SimpleString("server"), protocol.BulkString("goodiesdb"),
+		protocol.SimpleString("version"), protocol.BulkString(s.config.Version),
+		protocol.SimpleString("proto"), protocol.Integer(3),
+		protocol.SimpleString("id"), protocol.Integer(client.id),
+		protocol.SimpleString("mode"), protocol.BulkString("standalone"),
+		protocol.SimpleString("role"), protocol.BulkString("master"),
+		protocol.SimpleString("modules"), protocol.Array{},
+		protocol.SimpleString("server_name"), protocol.BulkString(s.config.ServerName),
+	}
+	if proto.Version() != "RESP3" {
+		properties[5] = protocol.Integer(2)
+		return protocol.Array(properties), nil
+	}
+	reply := make(protocol.Map, len(properties)/2)
+	for i := 0; i < len(properties); i += 2 {
+		reply[properties[i]] = properties[i+1]
+	}
+	return reply, nil
+}
+
+// authenticate logs client in as username when the password is one of the
+// user's and the user is enabled
+func (s *Server) authenticate(client *Client, username, password string) bool {
+	user, ok := s.users[username]
+	if !ok || !user.checkPassword(password) {
+		return false
+	}
+	client.user = username
+	client.authenticated = true
+	return true
+}
+
 // Ping returns pong
 func (s *Server) Ping() protocol.SimpleString {
 	return "PONG"
@@ -31,3 +299,183 @@ func (s *Server) Ping() protocol.SimpleString {
 func (s *Server) Echo(message string) protocol.SimpleString {
 	return protocol.SimpleString(message)
 }
+
+// Debug runs a DEBUG subcommand
+func (s *Server) Debug(dbIndex int, args []string) (protocol.RESPValue, error) {
+	switch strings.ToUpper(args[0]) {
+	case "OBJECT":
+		if len(args) != 2 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'DEBUG|OBJECT' command"), nil
+		}
+		info, ok := s.store.DescribeSize(dbIndex, args[1])
+		if !ok {
+			return protocol.ErrorString("ERR no such key"), nil
+		}
+		idle := int64(info.Idle / time.Second)
+		reply := fmt.Sprintf("Value refcount:1 encoding:%s serializedlength:%d lru_seconds_idle:%d",
+			info.Encoding, info.Size, idle)
+		// Quicklists also describe their nodes. Nodes are never compressed.
+		if info.Encoding == "quicklist" {
+			entries := 0
+			for _, n := range info.ListNodes {
+				entries += n
+			}
+			reply += fmt.Sprintf(" ql_nodes:%d ql_avg_node:%.2f ql_listpack_max:%d ql_compressed:0",
+				len(info.ListNodes), float64(entries)/float64(len(info.ListNodes)), s.config.ListMaxListpackSize)
+		}
+		return protocol.SimpleString(reply), nil
+
+	case "LISTPACK-ENTRIES":
+		if len(args) != 2 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'DEBUG|LISTPACK-ENTRIES' command"), nil
+		}
+		entries, err := s.store.ListpackEntries(dbIndex, args[1])
+		if err != nil {
+			return errorReply(err), nil
+		}
+		return protocol.Integer(entries), nil
+
+	case "DUMP-KEYSPACE":
+		if len(args) != 1 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'DEBUG|DUMP-KEYSPACE' command"), nil
+		}
+		dump, err := s.store.DumpKeyspace(dbIndex)
+		if err != nil {
+			return errorReply(err), nil
+		}
+		return protocol.BulkString(dump), nil
+
+	case "RELOAD":
+		// Round-trip the dataset through an RDB snapshot
+		rdbFilepath := s.rdbFilepath()
+		if err := rdb.SaveSnapshot(s.store, rdbFilepath); err != nil {
+			return protocol.ErrorString("ERR Error trying to save the DB: " + err.Error()), nil
+		}
+		if err := rdb.LoadSnapshot(s.store, rdbFilepath); err != nil {
+			return protocol.ErrorString("ERR Error trying to load the RDB dump: " + err.Error()), nil
+		}
+		return protocol.SimpleString("OK"), nil
+
+	default:
+		return protocol.ErrorString("ERR unknown subcommand '" + args[0] + "'. Try DEBUG HELP."), nil
+	}
+}
+
+// CommandDocs returns the documentation of the given commands, or of every
+// command when none is given, as the nested name/value arrays redis-cli reads
+func (s *Server) CommandDocs(names []string) protocol.Array {
+	if len(names) == 0 {
+		for name := range availableCommands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	docs := protocol.Array{}
+	for _, name := range names {
+		spec, ok := availableCommands[strings.ToUpper(name)]
+		if !ok {
+			continue
+		}
+		doc := protocol.Array{
+			protocol.BulkString("summary"), protocol.BulkString(spec.Summary),
+			protocol.BulkString("since"), protocol.BulkString(spec.Since),
+			protocol.BulkString("group"), protocol.BulkString(spec.Group),
+		}
+		if spec.DeprecatedSince != "" {
+			doc = append(doc,
+				protocol.BulkString("doc_flags"), protocol.Array{protocol.SimpleString("deprecated")},
+				protocol.BulkString("deprecated_since"), protocol.BulkString(spec.DeprecatedSince),
+				protocol.BulkString("replaced_by"), protocol.BulkString(spec.ReplacedBy),
+			)
+		}
+		if len(spec.Args) > 0 {
+			doc = append(doc, protocol.BulkString("arguments"), commandArgsDocs(spec.Args))
+		}
+		docs = append(docs, protocol.BulkString(strings.ToLower(name)), doc)
+	}
+	return docs
+}
+
+func commandArgsDocs(args []commandArg) protocol.Array {
+	docs := make(protocol.Array, len(args))
+	for i, arg := range args {
+		doc := protocol.Array{
+			protocol.BulkString("name"), protocol.BulkString(arg.Name),
+			protocol.BulkString("type"), protocol.BulkString(arg.Type),
+		}
+		if arg.Token != "" {
+			doc = append(doc, protocol.BulkString("token"), protocol.BulkString(arg.Token))
+		}
+		var flags protocol.Array
+		if arg.Optional {
+			flags = append(flags, protocol.SimpleString("optional"))
+		}
+		if arg.Multiple {
+			flags = append(flags, protocol.SimpleString("multiple"))
+		}
+		if len(flags) > 0 {
+			doc = append(doc, protocol.BulkString("flags"), flags)
+		}
+		if len(arg.Args) > 0 {
+			doc = append(doc, protocol.BulkString("arguments"), commandArgsDocs(arg.Args))
+		}
+		docs[i] = doc
+	}
+	return docs
+}
+
+// ClientCommand runs a CLIENT subcommand for the calling client
+func (s *Server) ClientCommand(client *Client, args []string) (protocol.RESPValue, error) {
+	switch sub := strings.ToUpper(args[0]); sub {
+	case "NO-EVICT", "NO-TOUCH":
+		if len(args) != 2 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'CLIENT|" + strings.ToLower(sub) + "' command"), nil
+		}
+		var on bool
+		switch strings.ToUpper(args[1]) {
+		case "ON":
+			on = true
+		case "OFF":
+			on = false
+		default:
+			return protocol.ErrorString("ERR syntax error"), nil
+		}
+		if sub == "NO-EVICT" {
+			client.noEvict.Store(on)
+		} else {
+			client.noTouch = on
+		}
+		return protocol.SimpleString("OK"), nil
+
+	default:
+		return protocol.ErrorString("ERR unknown subcommand '" + args[0] + "'. Try CLIENT HELP."), nil
+	}
+}
+
+// Object runs an OBJECT subcommand
+func (s *Server) Object(client *Client, args []string) (protocol.RESPValue, error) {
+	switch strings.ToUpper(args[0]) {
+	case "IDLETIME":
+		if len(args) != 2 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'OBJECT|IDLETIME' command"), nil
+		}
+		idle, ok := s.store.IdleTime(client.db, args[1])
+		if !ok {
+			return client.protocol().EncodeNil(), nil
+		}
+		return protocol.Integer(int64(idle / time.Second)), nil
+
+	case "ENCODING":
+		if len(args) != 2 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'OBJECT|ENCODING' command"), nil
+		}
+		encoding, ok := s.store.ObjectEncoding(client.db, args[1])
+		if !ok {
+			return client.protocol().EncodeNil(), nil
+		}
+		return protocol.BulkString(encoding), nil
+
+	default:
+		return protocol.ErrorString("ERR unknown subcommand '" + args[0] + "'. Try OBJECT HELP."), nil
+	}
+}
@@ -1,24 +1,130 @@
 package server
 
-import "os"
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/andrelcunha/goodiesdb/internal/core/store"
+	"github.com/andrelcunha/goodiesdb/internal/protocol"
+)
+
+// OutputBufferLimit bounds the replies queued for a client. The client is
+// disconnected as soon as its pending output reaches Hard bytes, or once it
+// stays above Soft bytes for SoftSeconds. A zero size disables that limit.
+type OutputBufferLimit struct {
+	Hard        int64
+	Soft        int64
+	SoftSeconds int
+}
+
+// MaxMemoryPolicies are the accepted values of Config.MaxMemoryPolicy
+var MaxMemoryPolicies = []string{
+	"noeviction", "allkeys-lru", "allkeys-lfu", "allkeys-random",
+	"volatile-lru", "volatile-lfu", "volatile-random", "volatile-ttl",
+}
+
+// Recovery preferences, choosing which persistence file is loaded at startup
+// when both RDB and AOF are enabled
+const (
+	RecoveryAOFPreferred = "aof-preferred"
+	RecoveryRDBPreferred = "rdb-preferred"
+)
 
 type Config struct {
 	Host     string
 	Port     string
-	Password string
+	Password string // password of the default user, none when empty
 	UseRDB   bool
 	UseAOF   bool
 	Version  string
 	DataDir  string
+	// AOFRewriteIncrementalFsync fsyncs the file written by BGREWRITEAOF as
+	// it goes, rather than all at once at the end
+	AOFRewriteIncrementalFsync bool
+	// ServerName tells instances apart in INFO and HELLO
+	ServerName string
+	// Users maps the names of additional users to their passwords
+	Users map[string]string
+	// ACL holds rules such as "USER alice on >secret ~cache:* +get +set",
+	// applied after Users and able to redefine any user
+	ACL []string
+	// RenameCommand maps command names to the names clients must use
+	// instead. An empty name disables the command.
+	RenameCommand map[string]string
+	// ActiveExpire deletes the expired keys in the background, rather than
+	// only when they are accessed
+	ActiveExpire bool
+	// BackgroundLoading loads the persistence files while accepting
+	// connections, which get a LOADING error for data commands until done
+	BackgroundLoading bool
+	// RecoveryPreference is RecoveryAOFPreferred or RecoveryRDBPreferred. The
+	// other file is only loaded when the preferred one can't be.
+	RecoveryPreference string
+	// ReadOnly rejects the commands flagged as write, as a read-only replica
+	ReadOnly bool
+	// EnableDebug allows the DEBUG command, which is disabled by default
+	EnableDebug bool
+	// Output buffer limits for regular clients and for pub/sub subscribers
+	OutputBufferLimitNormal OutputBufferLimit
+	OutputBufferLimitPubSub OutputBufferLimit
+	// MaxMemory is the memory limit of the dataset in bytes, zero for none,
+	// and MaxMemoryPolicy what happens when it is reached. Keys aren't
+	// evicted yet, so past the limit the commands that may grow the dataset
+	// are rejected whatever the policy.
+	MaxMemory       int64
+	MaxMemoryPolicy string
+	// MaxMemoryClients caps the output buffers of all clients together. When
+	// it is exceeded the clients with the largest buffers are disconnected.
+	// Zero disables the cap.
+	MaxMemoryClients int64
+	// ProtoMaxBulkLen is the largest string APPEND and SETRANGE may build
+	ProtoMaxBulkLen int64
+	// ProtoInlineMax is the longest inline command a client may send; longer
+	// lines close the connection
+	ProtoInlineMax int
+	// Size of each listpack node of a list: entries when positive, -1 to -5
+	// for 4kb to 64kb
+	ListMaxListpackSize int
+	// Sizes up to which hashes and sorted sets keep the compact listpack
+	// encoding
+	HashMaxListpackEntries int
+	HashMaxListpackValue   int
+	ZSetMaxListpackEntries int
+	ZSetMaxListpackValue   int
+	// COUNT used by SCAN when none is given, and the largest COUNT honored
+	ScanDefaultCount int
+	ScanMaxCount     int
 }
 
 func NewConfig() *Config {
 	return &Config{
-		Port:     "6379",
-		Password: "guest",
-		UseRDB:   true,
-		UseAOF:   true,
-		DataDir:  "data",
+		Port:                       "6379",
+		Password:                   "",
+		UseRDB:                     true,
+		UseAOF:                     true,
+		AOFRewriteIncrementalFsync: true,
+		ActiveExpire:               true,
+		DataDir:                    "data",
+		ServerName:                 "goodiesdb",
+		RecoveryPreference:         RecoveryAOFPreferred,
+		ProtoMaxBulkLen:            store.DefaultProtoMaxBulkLen,
+		ProtoInlineMax:             protocol.DefaultInlineMax,
+		ListMaxListpackSize:        store.DefaultEncodingLimits().ListMaxListpackSize,
+		HashMaxListpackEntries:     store.DefaultEncodingLimits().HashMaxListpackEntries,
+		HashMaxListpackValue:       store.DefaultEncodingLimits().HashMaxListpackValue,
+		ZSetMaxListpackEntries:     store.DefaultEncodingLimits().ZSetMaxListpackEntries,
+		ZSetMaxListpackValue:       store.DefaultEncodingLimits().ZSetMaxListpackValue,
+		ScanDefaultCount:           10,
+		ScanMaxCount:               1000,
+		MaxMemoryPolicy:            "noeviction",
+		OutputBufferLimitPubSub: OutputBufferLimit{
+			Hard:        32 * 1024 * 1024,
+			Soft:        8 * 1024 * 1024,
+			SoftSeconds: 60,
+		},
 	}
 }
 
@@ -30,16 +136,247 @@ func (c *Config) LoadFromEnv() {
 	if port := os.Getenv("PORT"); port != "" {
 		c.Port = port
 	}
+	if name := os.Getenv("SERVER_NAME"); name != "" {
+		c.ServerName = name
+	}
 	if password := os.Getenv("PASSWORD"); password != "" {
 		c.Password = password
 	}
+	if users := os.Getenv("USERS"); users != "" {
+		if parsed, err := parseUsers(users); err != nil {
+			fmt.Printf("Ignoring USERS: %v\n", err)
+		} else {
+			c.Users = parsed
+		}
+	}
+	if acl := os.Getenv("ACL"); acl != "" {
+		// Rules are separated by semicolons
+		for _, rule := range strings.Split(acl, ";") {
+			if rule = strings.TrimSpace(rule); rule != "" {
+				c.ACL = append(c.ACL, rule)
+			}
+		}
+	}
+	if rename := os.Getenv("RENAME_COMMAND"); rename != "" {
+		if parsed, err := parseRenameCommand(rename); err != nil {
+			fmt.Printf("Ignoring RENAME_COMMAND: %v\n", err)
+		} else {
+			c.RenameCommand = parsed
+		}
+	}
 	if useRDB := os.Getenv("USE_RDB"); useRDB != "" {
 		c.UseRDB = useRDB == "true"
 	}
 	if useAOF := os.Getenv("USE_AOF"); useAOF != "" {
 		c.UseAOF = useAOF == "true"
 	}
+	if incrementalFsync := os.Getenv("AOF_REWRITE_INCREMENTAL_FSYNC"); incrementalFsync != "" {
+		c.AOFRewriteIncrementalFsync = incrementalFsync == "true"
+	}
 	if dataDir := os.Getenv("DATA_DIR"); dataDir != "" {
 		c.DataDir = dataDir
 	}
+	if preference := os.Getenv("RECOVERY_PREFERENCE"); preference != "" {
+		if preference != RecoveryAOFPreferred && preference != RecoveryRDBPreferred {
+			fmt.Printf("Ignoring RECOVERY_PREFERENCE: invalid value %q\n", preference)
+		} else {
+			c.RecoveryPreference = preference
+		}
+	}
+	if activeExpire := os.Getenv("ACTIVE_EXPIRE"); activeExpire != "" {
+		c.ActiveExpire = activeExpire == "true"
+	}
+	if backgroundLoading := os.Getenv("BACKGROUND_LOADING"); backgroundLoading != "" {
+		c.BackgroundLoading = backgroundLoading == "true"
+	}
+	if readOnly := os.Getenv("READ_ONLY"); readOnly != "" {
+		c.ReadOnly = readOnly == "true"
+	}
+	if enableDebug := os.Getenv("ENABLE_DEBUG"); enableDebug != "" {
+		c.EnableDebug = enableDebug == "true"
+	}
+	if maxBulkLen := os.Getenv("PROTO_MAX_BULK_LEN"); maxBulkLen != "" {
+		if n, err := parseMemory(maxBulkLen); err != nil {
+			fmt.Printf("Ignoring PROTO_MAX_BULK_LEN: %v\n", err)
+		} else {
+			c.ProtoMaxBulkLen = n
+		}
+	}
+	if inlineMax := os.Getenv("PROTO_INLINE_MAX"); inlineMax != "" {
+		if n, err := parseMemory(inlineMax); err != nil {
+			fmt.Printf("Ignoring PROTO_INLINE_MAX: %v\n", err)
+		} else {
+			c.ProtoInlineMax = int(n)
+		}
+	}
+	if size := os.Getenv("LIST_MAX_LISTPACK_SIZE"); size != "" {
+		if n, err := strconv.Atoi(size); err != nil || n == 0 || n < -5 {
+			fmt.Printf("Ignoring LIST_MAX_LISTPACK_SIZE: invalid value %q\n", size)
+		} else {
+			c.ListMaxListpackSize = n
+		}
+	}
+	if entries := os.Getenv("HASH_MAX_LISTPACK_ENTRIES"); entries != "" {
+		if n, err := strconv.Atoi(entries); err != nil || n < 0 {
+			fmt.Printf("Ignoring HASH_MAX_LISTPACK_ENTRIES: invalid value %q\n", entries)
+		} else {
+			c.HashMaxListpackEntries = n
+		}
+	}
+	if value := os.Getenv("HASH_MAX_LISTPACK_VALUE"); value != "" {
+		if n, err := strconv.Atoi(value); err != nil || n < 0 {
+			fmt.Printf("Ignoring HASH_MAX_LISTPACK_VALUE: invalid value %q\n", value)
+		} else {
+			c.HashMaxListpackValue = n
+		}
+	}
+	if entries := os.Getenv("ZSET_MAX_LISTPACK_ENTRIES"); entries != "" {
+		if n, err := strconv.Atoi(entries); err != nil || n < 0 {
+			fmt.Printf("Ignoring ZSET_MAX_LISTPACK_ENTRIES: invalid value %q\n", entries)
+		} else {
+			c.ZSetMaxListpackEntries = n
+		}
+	}
+	if value := os.Getenv("ZSET_MAX_LISTPACK_VALUE"); value != "" {
+		if n, err := strconv.Atoi(value); err != nil || n < 0 {
+			fmt.Printf("Ignoring ZSET_MAX_LISTPACK_VALUE: invalid value %q\n", value)
+		} else {
+			c.ZSetMaxListpackValue = n
+		}
+	}
+	if count := os.Getenv("SCAN_DEFAULT_COUNT"); count != "" {
+		if n, err := strconv.Atoi(count); err != nil || n <= 0 {
+			fmt.Printf("Ignoring SCAN_DEFAULT_COUNT: invalid value %q\n", count)
+		} else {
+			c.ScanDefaultCount = n
+		}
+	}
+	if count := os.Getenv("SCAN_MAX_COUNT"); count != "" {
+		if n, err := strconv.Atoi(count); err != nil || n <= 0 {
+			fmt.Printf("Ignoring SCAN_MAX_COUNT: invalid value %q\n", count)
+		} else {
+			c.ScanMaxCount = n
+		}
+	}
+	if maxMemory := os.Getenv("MAXMEMORY"); maxMemory != "" {
+		if n, err := parseMemory(maxMemory); err != nil {
+			fmt.Printf("Ignoring MAXMEMORY: %v\n", err)
+		} else {
+			c.MaxMemory = n
+		}
+	}
+	if policy := os.Getenv("MAXMEMORY_POLICY"); policy != "" {
+		if !slices.Contains(MaxMemoryPolicies, policy) {
+			fmt.Printf("Ignoring MAXMEMORY_POLICY: invalid value %q\n", policy)
+		} else {
+			c.MaxMemoryPolicy = policy
+		}
+	}
+	if maxMemoryClients := os.Getenv("MAXMEMORY_CLIENTS"); maxMemoryClients != "" {
+		if n, err := parseMemory(maxMemoryClients); err != nil {
+			fmt.Printf("Ignoring MAXMEMORY_CLIENTS: %v\n", err)
+		} else {
+			c.MaxMemoryClients = n
+		}
+	}
+	if limit := os.Getenv("CLIENT_OUTPUT_BUFFER_LIMIT_NORMAL"); limit != "" {
+		if parsed, err := parseOutputBufferLimit(limit); err != nil {
+			fmt.Printf("Ignoring CLIENT_OUTPUT_BUFFER_LIMIT_NORMAL: %v\n", err)
+		} else {
+			c.OutputBufferLimitNormal = parsed
+		}
+	}
+	if limit := os.Getenv("CLIENT_OUTPUT_BUFFER_LIMIT_PUBSUB"); limit != "" {
+		if parsed, err := parseOutputBufferLimit(limit); err != nil {
+			fmt.Printf("Ignoring CLIENT_OUTPUT_BUFFER_LIMIT_PUBSUB: %v\n", err)
+		} else {
+			c.OutputBufferLimitPubSub = parsed
+		}
+	}
+}
+
+// parseUsers parses a comma separated list of "<name>:<password>" pairs
+func parseUsers(s string) (map[string]string, error) {
+	users := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		name, password, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("expected '<name>:<password>', got %q", pair)
+		}
+		if name == "default" {
+			return nil, fmt.Errorf("the password of the default user is PASSWORD")
+		}
+		users[name] = password
+	}
+	return users, nil
+}
+
+// parseRenameCommand parses semicolon separated "<command> <new name>" rules,
+// e.g. `FLUSHALL "";CONFIG secretconfig`. An empty new name, written "",
+// disables the command.
+func parseRenameCommand(s string) (map[string]string, error) {
+	renames := make(map[string]string)
+	for _, rule := range strings.Split(s, ";") {
+		if rule = strings.TrimSpace(rule); rule == "" {
+			continue
+		}
+		fields := strings.Fields(rule)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("expected '<command> <new name>', got %q", rule)
+		}
+		newName := fields[1]
+		if newName == `""` {
+			newName = ""
+		}
+		renames[strings.ToUpper(fields[0])] = newName
+	}
+	return renames, nil
+}
+
+// parseOutputBufferLimit parses "<hard> <soft> <soft seconds>", e.g. "32mb 8mb 60"
+func parseOutputBufferLimit(s string) (OutputBufferLimit, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 3 {
+		return OutputBufferLimit{}, fmt.Errorf("expected '<hard> <soft> <soft seconds>', got %q", s)
+	}
+	hard, err := parseMemory(fields[0])
+	if err != nil {
+		return OutputBufferLimit{}, err
+	}
+	soft, err := parseMemory(fields[1])
+	if err != nil {
+		return OutputBufferLimit{}, err
+	}
+	seconds, err := strconv.Atoi(fields[2])
+	if err != nil || seconds < 0 {
+		return OutputBufferLimit{}, fmt.Errorf("invalid soft seconds %q", fields[2])
+	}
+	return OutputBufferLimit{Hard: hard, Soft: soft, SoftSeconds: seconds}, nil
+}
+
+// parseMemory parses a size such as "100", "1k", "1kb", "32mb" or "1gb".
+// As in Redis, k/m/g are powers of 1000 and kb/mb/gb powers of 1024.
+func parseMemory(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		factor int64
+	}{
+		{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+	lower := strings.ToLower(s)
+	factor := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(lower, unit.suffix) {
+			lower = strings.TrimSuffix(lower, unit.suffix)
+			factor = unit.factor
+			break
+		}
+	}
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid memory size %q", s)
+	}
+	return n * factor, nil
 }
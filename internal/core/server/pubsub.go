@@ -0,0 +1,158 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/andrelcunha/goodiesdb/internal/protocol"
+	"github.com/andrelcunha/goodiesdb/internal/utils/glob"
+)
+
+// pubSub tracks which clients are subscribed to each channel and pattern
+type pubSub struct {
+	mu       sync.RWMutex
+	channels map[string]map[*Client]struct{}
+	patterns map[string]map[*Client]struct{}
+}
+
+func newPubSub() *pubSub {
+	return &pubSub{
+		channels: make(map[string]map[*Client]struct{}),
+		patterns: make(map[string]map[*Client]struct{}),
+	}
+}
+
+func (ps *pubSub) add(subs map[string]map[*Client]struct{}, name string, c *Client) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if subs[name] == nil {
+		subs[name] = make(map[*Client]struct{})
+	}
+	subs[name][c] = struct{}{}
+}
+
+func (ps *pubSub) remove(subs map[string]map[*Client]struct{}, name string, c *Client) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	delete(subs[name], c)
+	if len(subs[name]) == 0 {
+		delete(subs, name)
+	}
+}
+
+// pushReply frames a pub/sub notification: RESP3 clients get a Push so they
+// can tell it apart from command replies, RESP2 clients a plain array
+func pushReply(c *Client, items protocol.Array) protocol.RESPValue {
+	if c.isRESP3() {
+		return protocol.Push(items)
+	}
+	return items
+}
+
+// subscribe handles SUBSCRIBE and PSUBSCRIBE, confirming each name to the client
+func (s *Server) subscribe(c *Client, kind string, names []string) {
+	own, subs := c.channels, s.pubsub.channels
+	if kind == "psubscribe" {
+		own, subs = c.patterns, s.pubsub.patterns
+	}
+	for _, name := range names {
+		if _, ok := own[name]; !ok {
+			own[name] = struct{}{}
+			s.pubsub.add(subs, name, c)
+			c.updateSubscriptions()
+		}
+		s.send(c, pushReply(c, protocol.Array{
+			protocol.BulkString(kind),
+			protocol.BulkString(name),
+			protocol.Integer(c.subscriptions()),
+		}))
+	}
+}
+
+// unsubscribe handles UNSUBSCRIBE and PUNSUBSCRIBE. Without names the client
+// leaves every channel (or pattern) it is subscribed to.
+func (s *Server) unsubscribe(c *Client, kind string, names []string) {
+	own, subs := c.channels, s.pubsub.channels
+	if kind == "punsubscribe" {
+		own, subs = c.patterns, s.pubsub.patterns
+	}
+	if len(names) == 0 {
+		for name := range own {
+			names = append(names, name)
+		}
+		if len(names) == 0 {
+			s.send(c, pushReply(c, protocol.Array{
+				protocol.BulkString(kind),
+				c.protocol().EncodeNil(),
+				protocol.Integer(c.subscriptions()),
+			}))
+			return
+		}
+	}
+	for _, name := range names {
+		if _, ok := own[name]; ok {
+			delete(own, name)
+			s.pubsub.remove(subs, name, c)
+			c.updateSubscriptions()
+		}
+		s.send(c, pushReply(c, protocol.Array{
+			protocol.BulkString(kind),
+			protocol.BulkString(name),
+			protocol.Integer(c.subscriptions()),
+		}))
+	}
+}
+
+// unsubscribeAll drops every subscription of a client that is going away
+func (s *Server) unsubscribeAll(c *Client) {
+	for name := range c.channels {
+		s.pubsub.remove(s.pubsub.channels, name, c)
+	}
+	for pattern := range c.patterns {
+		s.pubsub.remove(s.pubsub.patterns, pattern, c)
+	}
+	c.channels = make(map[string]struct{})
+	c.patterns = make(map[string]struct{})
+	c.updateSubscriptions()
+}
+
+// Publish delivers a message to the subscribers of channel and to the clients
+// whose patterns match it, returning the number of clients that received it
+func (s *Server) Publish(channel, message string) int {
+	s.pubsub.mu.RLock()
+	type delivery struct {
+		client *Client
+		reply  protocol.Array
+	}
+	var deliveries []delivery
+	for c := range s.pubsub.channels[channel] {
+		deliveries = append(deliveries, delivery{c, protocol.Array{
+			protocol.BulkString("message"),
+			protocol.BulkString(channel),
+			protocol.BulkString(message),
+		}})
+	}
+	for pattern, clients := range s.pubsub.patterns {
+		if !glob.Match(pattern, channel) {
+			continue
+		}
+		for c := range clients {
+			deliveries = append(deliveries, delivery{c, protocol.Array{
+				protocol.BulkString("pmessage"),
+				protocol.BulkString(pattern),
+				protocol.BulkString(channel),
+				protocol.BulkString(message),
+			}})
+		}
+	}
+	s.pubsub.mu.RUnlock()
+
+	receivers := 0
+	for _, d := range deliveries {
+		if d.client.isClosed() {
+			continue
+		}
+		s.send(d.client, pushReply(d.client, d.reply))
+		receivers++
+	}
+	return receivers
+}
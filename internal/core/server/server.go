@@ -2,13 +2,18 @@ package server
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net"
 	"os"
-	"path/filepath"
+	"runtime/debug"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/andrelcunha/goodiesdb/internal/core/store"
@@ -20,14 +25,31 @@ import (
 
 // Server represents a TCP server
 type Server struct {
-	store                    *store.Store
-	config                   *Config
-	mu                       sync.Mutex
-	authenticatedConnections map[net.Conn]bool // TODO create a connection abstraction to hold more info
-	connectionDbs            map[net.Conn]int
-	shutdownChan             chan struct{}
-	dataDir                  string
-	Protocol                 protocol.Protocol
+	store        *store.Store
+	config       *Config
+	mu           sync.Mutex
+	clients      map[*Client]struct{}
+	nextClientID int64
+	// clientOutputBytes is the output pending for all clients, for maxmemory-clients
+	clientOutputBytes atomic.Int64
+	pubsub            *pubSub
+	aofErr            error // last AOF error, after which writes are no longer logged
+	replID            string
+	startTime         time.Time
+	users             map[string]*aclUser
+	commandNames      map[string]string // RENAME_COMMAND, see newCommandNames
+	stats             stats
+	listener          net.Listener
+	shutdownChan      chan struct{}
+	shutdownOnce      sync.Once
+	background        sync.WaitGroup // goroutines stopped by Shutdown
+	aofWriter         sync.WaitGroup // done once the AOF writer has flushed and closed the file
+	aofLog            *aof.Writer    // writes the AOF once it is started, guarded by mu
+	aofRewriting      atomic.Bool    // set while BGREWRITEAOF runs
+	loading           atomic.Bool    // set while the dataset is loaded in the background
+	bgsaveInProgress  atomic.Bool    // set while a periodic snapshot is saved
+	dataDir           string
+	Protocol          protocol.Protocol
 }
 
 // NewServer creates a new server
@@ -39,18 +61,36 @@ func NewServer(config *Config) *Server {
 		os.Exit(1)
 	}
 
-	aofChan := make(chan string, 100)
+	// Without AOF there is nobody draining the channel, so don't log at all
+	var aofChan chan string
+	if config.UseAOF {
+		aofChan = make(chan string, 100)
+	}
 	s := store.NewStore(aofChan)
-
-	return &Server{
-		store:                    s,
-		config:                   config,
-		authenticatedConnections: make(map[net.Conn]bool),
-		connectionDbs:            make(map[net.Conn]int),
-		shutdownChan:             make(chan struct{}),
-		dataDir:                  config.DataDir,
-		Protocol:                 &resp2.RESP2Protocol{},
+	s.SetProtoMaxBulkLen(config.ProtoMaxBulkLen)
+	s.SetEncodingLimits(store.EncodingLimits{
+		ListMaxListpackSize:    config.ListMaxListpackSize,
+		HashMaxListpackEntries: config.HashMaxListpackEntries,
+		HashMaxListpackValue:   config.HashMaxListpackValue,
+		ZSetMaxListpackEntries: config.ZSetMaxListpackEntries,
+		ZSetMaxListpackValue:   config.ZSetMaxListpackValue,
+	})
+
+	server := &Server{
+		store:        s,
+		config:       config,
+		clients:      make(map[*Client]struct{}),
+		pubsub:       newPubSub(),
+		replID:       newReplID(),
+		startTime:    time.Now(),
+		users:        newUsers(config),
+		commandNames: newCommandNames(config),
+		shutdownChan: make(chan struct{}),
+		dataDir:      config.DataDir,
+		Protocol:     &resp2.RESP2Protocol{},
 	}
+	server.stats.reset()
+	return server
 }
 
 // Start starts the server
@@ -58,90 +98,204 @@ func (s *Server) Start() error {
 	fmt.Println(s.asciiLogo())
 	fmt.Println("Starting Redis Clone Server...")
 
-	if s.config.UseRDB || s.config.UseAOF {
-		fmt.Println("Found persistence enabled. Recovering data...")
-		s.recoverStore()
-	} else {
+	switch {
+	case !s.config.UseRDB && !s.config.UseAOF:
 		fmt.Println("No persistence enabled. Data will not be persisted.")
-	}
-
-	if s.config.UseRDB {
-		go s.startRDB()
-		fmt.Println("RDB persistence enabled")
-	}
-	if s.config.UseAOF {
-		aofFilepath := filepath.Join(s.dataDir, "appendonly.aof")
-		go aof.AOFWriter(s.store.AOFChannel(), aofFilepath)
-		fmt.Println("AOF persistence enabled")
+	case s.config.BackgroundLoading:
+		fmt.Println("Found persistence enabled. Recovering data in the background...")
+		s.loadInBackground(s.loadData)
+	default:
+		fmt.Println("Found persistence enabled. Recovering data...")
+		s.loadData()
 	}
 
 	// set addr string (host and port) using config
 	addr := fmt.Sprintf("%s:%s", s.config.Host, s.config.Port)
 	ln, err := net.Listen("tcp", addr)
-	fmt.Printf("Redis Clone Server %s started on %s:%s\n", s.config.Version, s.config.Host, s.config.Port)
 	if err != nil {
 		return err
 	}
+	fmt.Printf("Redis Clone Server %s started on %s\n", s.config.Version, ln.Addr())
+	return s.Serve(ln)
+}
+
+// Addr returns the address the server listens on, or nil before Serve
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Bounds of the delay between retries after a temporary accept error
+const (
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
+)
+
+// Serve accepts connections on ln until Shutdown closes it. Start calls it
+// after recovering the store, and tests may call it directly with a listener
+// on an ephemeral port. Temporary accept errors, such as running out of file
+// descriptors, are retried with an exponential backoff; any other error stops
+// the server and is returned.
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	s.listener = ln
+	s.mu.Unlock()
 	defer ln.Close()
 
+	s.startStatsSampler()
+	if s.config.ActiveExpire {
+		s.startExpirationSweeper()
+	}
+
+	var delay time.Duration
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
-			fmt.Println("Error accepting connection:", err)
+			select {
+			case <-s.shutdownChan:
+				return nil
+			default:
+			}
+			var temporary interface{ Temporary() bool }
+			if !errors.As(err, &temporary) || !temporary.Temporary() {
+				return err
+			}
+			delay = min(max(2*delay, minAcceptDelay), maxAcceptDelay)
+			fmt.Printf("Error accepting connection: %v; retrying in %v\n", err, delay)
+			select {
+			case <-time.After(delay):
+			case <-s.shutdownChan:
+				return nil
+			}
 			continue
 		}
+		delay = 0
+		s.stats.connectionsReceived.Add(1)
 		// go s.handleConnection(conn)
 		go s.handleConn(conn)
 	}
 }
 
-// Shutdown gracefully shuts down the server
+// Shutdown gracefully shuts down the server. It stops the background
+// goroutines before saving the final snapshot, so they can't race with it,
+// and waits for the AOF writer to drain so the last writes reach the disk.
 func (s *Server) Shutdown() {
-	if s.config.UseAOF {
-		if s.store.AOFChannel() != nil {
-			close(s.store.AOFChannel())
+	s.shutdownOnce.Do(func() {
+		close(s.shutdownChan)
+		s.mu.Lock()
+		if s.listener != nil {
+			s.listener.Close()
+		}
+		s.mu.Unlock()
+		s.background.Wait()
+
+		if s.config.UseAOF {
+			if s.store.AOFChannel() != nil {
+				close(s.store.AOFChannel())
+			}
+			s.aofWriter.Wait()
 		}
-	}
 
-	if s.config.UseRDB {
-		rdb.SaveSnapshot(s.store, "dump.rdb")
-	}
+		if s.config.UseRDB {
+			if err := rdb.SaveSnapshot(s.store, s.rdbFilepath()); err != nil {
+				fmt.Println("Error saving snapshot:", err)
+			}
+		}
+	})
 }
 
 func (s *Server) handleConn(conn net.Conn) {
-	defer conn.Close()
+	client := s.addClient(conn)
+	defer s.removeClient(client)
 	reader := bufio.NewReader(conn)
-	writer := bufio.NewWriter(conn)
+
+	// A command that panics closes its own connection rather than the server
+	var request protocol.RESPValue
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Printf("Panic executing %s for client %s: %v\n%s", describeRequest(request), conn.RemoteAddr(), r, debug.Stack())
+			s.send(client, protocol.ErrorString("ERR internal error"))
+		}
+	}()
 
 	for {
-		value, err := s.Protocol.Parse(reader)
+		value, err := s.readRequest(client, reader)
 
 		if err != nil {
-			if err.Error() == "EOF" {
+			// The connection is gone, either closed by the peer or by us
+			var netErr net.Error
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr) || client.isClosed() {
 				return
 			}
-			reply := protocol.ErrorString(fmt.Sprintf("parse error: %v", err))
-			s.Protocol.Encode(writer, reply)
-			writer.Flush()
+			// After a framing error the position in the stream is unknown, so
+			// as Redis we reply and close rather than try to resynchronize
+			s.send(client, protocol.ErrorString(fmt.Sprintf("ERR Protocol error: %v", err)))
+			return
+		}
+		if request, ok := value.(protocol.Array); ok && len(request) == 0 {
 			continue
 		}
 
 		// Execute commmand
-		reply, err := s.executeCommand(conn, value)
+		request = value
+		reply, err := s.executeCommand(client, value)
 		if err != nil {
-			reply := protocol.ErrorString(fmt.Sprintf("ERR %s", err.Error()))
-			s.Protocol.Encode(writer, reply)
-			writer.Flush()
+			s.send(client, protocol.ErrorString(fmt.Sprintf("ERR %s", err.Error())))
 			continue
 		}
 
-		s.Protocol.Encode(writer, reply)
-		writer.Flush()
-		continue
+		// Commands that already wrote their replies (e.g. SUBSCRIBE) return nil
+		if reply != nil {
+			s.send(client, reply)
+		}
+		// Returning closes the connection after the pending replies are written
+		if client.quitting {
+			return
+		}
+	}
+}
+
+// readRequest reads the next command of a client: a RESP array, or an inline
+// command for anything that doesn't start like one
+func (s *Server) readRequest(client *Client, reader *bufio.Reader) (protocol.RESPValue, error) {
+	prefix, err := reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if prefix[0] != '*' {
+		return protocol.ParseInline(reader, s.config.ProtoInlineMax)
 	}
+	return client.protocol().Parse(reader)
 }
 
-func (s *Server) executeCommand(conn net.Conn, request protocol.RESPValue) (protocol.RESPValue, error) {
+// send queues a reply to a client, disconnecting it when its pending output
+// grows past the output buffer limit of its class
+func (s *Server) send(client *Client, reply protocol.RESPValue) {
+	var buf bytes.Buffer
+	writer := bufio.NewWriter(&buf)
+	if err := client.protocol().Encode(writer, reply); err != nil {
+		fmt.Printf("Error encoding reply: %v\n", err)
+		return
+	}
+	writer.Flush()
+
+	class, limit := s.outputBufferLimit(client)
+	if client.noEvict.Load() {
+		limit = OutputBufferLimit{}
+	}
+	if !client.enqueue(buf.Bytes(), limit) {
+		fmt.Printf("Closing client %s: %s output buffer of %d bytes exceeds the limit\n",
+			client.conn.RemoteAddr(), class, client.outputBufferSize())
+		client.kill()
+	}
+	s.evictClients()
+}
+
+func (s *Server) executeCommand(client *Client, request protocol.RESPValue) (protocol.RESPValue, error) {
 	arr, ok := request.(protocol.Array)
 	if !ok {
 		return protocol.ErrorString("ERR expected array"), fmt.Errorf("expected array, got %T", request)
@@ -155,34 +309,119 @@ func (s *Server) executeCommand(conn net.Conn, request protocol.RESPValue) (prot
 	parts := convertArrayToStrings(rawParts)
 	fmt.Printf("Executing command: %s %v\n", parts[0], parts[1:])
 
-	dbIndex := s.getCurrentDb(conn)
+	dbIndex := client.db
+	command := strings.ToUpper(parts[0])
+	if name, ok := s.commandNames[command]; ok {
+		if name == "" {
+			return unknownCommand(parts), nil
+		}
+		command = name
+	}
 
-	switch strings.ToUpper(parts[0]) {
+	// Once subscribed, a RESP2 connection may only manage its subscriptions.
+	// RESP3 tells pushes apart from replies, so any command is allowed.
+	if client.subscriptions() > 0 && !client.isRESP3() && !allowedWhileSubscribed[command] {
+		return protocol.ErrorString(fmt.Sprintf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", strings.ToLower(parts[0]))), nil
+	}
+
+	if command != "AUTH" && command != "HELLO" && command != "QUIT" && s.requiresAuth(client) {
+		return protocol.ErrorString("NOAUTH Authentication required."), nil
+	}
+
+	if denied := s.checkPermissions(client, command, parts); denied != nil {
+		return denied, nil
+	}
+
+	if spec, ok := availableCommands[command]; ok && s.loading.Load() && !spec.isLoadingAllowed() {
+		return protocol.ErrorString("LOADING Redis is loading the dataset in memory"), nil
+	}
+
+	if s.config.ReadOnly && availableCommands[command].isWrite() {
+		return protocol.ErrorString("READONLY You can't write against a read only replica."), nil
+	}
+
+	if s.deniedOOM(dbIndex, command, parts) {
+		return protocol.ErrorString("OOM command not allowed when used memory > 'maxmemory'."), nil
+	}
+
+	s.touchKeys(client, dbIndex, command, parts)
+	// Counted once done, so INFO doesn't count itself. The counters are
+	// picked now, so CONFIG RESETSTAT counts in the ones it discards.
+	if _, ok := availableCommands[command]; ok {
+		defer s.stats.current().commandsProcessed.Add(1)
+	}
+	return s.dispatch(client, command, parts)
+}
+
+// dispatch runs a command on the database selected by client. executeCommand
+// calls it once the client may run the command, and the AOF replay calls it
+// directly with the records it reads.
+func (s *Server) dispatch(client *Client, command string, parts []string) (protocol.RESPValue, error) {
+	dbIndex := client.db
+	switch command {
 
 	case "AUTH":
-		if len(parts) != 2 {
+		// AUTH password is AUTH default password
+		var ok bool
+		switch len(parts) {
+		case 2:
+			ok = s.authenticate(client, "default", parts[1])
+		case 3:
+			ok = s.authenticate(client, parts[1], parts[2])
+		default:
 			return protocol.ErrorString("ERR wrong number of arguments for 'AUTH' command"), nil
 		}
-		if parts[1] == s.config.Password {
-			s.mu.Lock()
-			s.authenticatedConnections[conn] = true
-			s.mu.Unlock()
-			return protocol.SimpleString("OK"), nil
+		if !ok {
+			return protocol.ErrorString("WRONGPASS invalid username-password pair or user is disabled."), nil
+		}
+		return protocol.SimpleString("OK"), nil
+
+	case "HELLO":
+		return s.Hello(client, parts[1:])
+
+	case "ACL":
+		if len(parts) < 2 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'ACL' command"), nil
 		}
-		return protocol.ErrorString("ERR invalid password"), nil
+		return s.ACL(client, parts[1:])
 
 	case "SET":
 		if len(parts) < 3 {
 			return protocol.ErrorString("ERR wrong number of arguments for 'SET' command"), nil
 		}
-		ok, err := s.store.Set(dbIndex, parts[1], parts[2], parts[3:]...)
+		options, err := store.ParseSetOptions(parts[3:])
 		if err != nil {
 			return protocol.ErrorString(err.Error()), nil
 		}
+		old, ok, err := s.store.SetWithOptions(dbIndex, parts[1], parts[2], options)
+		if err != nil {
+			return protocol.ErrorString(err.Error()), nil
+		}
+		// With GET the reply is the old string (or nil) whether or not the value was set
+		if options.GET {
+			if old == nil {
+				return client.protocol().EncodeNil(), nil
+			}
+			return convertValueTypeToRESPType(old)
+		}
 		if ok {
 			return protocol.SimpleString("OK"), nil
 		}
-		return s.Protocol.EncodeNil(), nil
+		return client.protocol().EncodeNil(), nil
+
+	case "GETSET":
+		// GETSET is SET with the GET option and nothing else
+		if len(parts) != 3 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'GETSET' command"), nil
+		}
+		old, ok, err := s.store.GetSet(dbIndex, parts[1], parts[2])
+		if err != nil {
+			return errorReply(err), nil
+		}
+		if !ok {
+			return client.protocol().EncodeNil(), nil
+		}
+		return protocol.BulkString(old.(string)), nil
 
 	case "GET":
 		if len(parts) != 2 {
@@ -190,15 +429,45 @@ func (s *Server) executeCommand(conn net.Conn, request protocol.RESPValue) (prot
 		}
 		value, ok := s.store.Get(dbIndex, parts[1])
 		if !ok {
-			return s.Protocol.EncodeNil(), nil
+			return client.protocol().EncodeNil(), nil
 		}
 		// Convert to RESP type
 		r, err := convertValueTypeToRESPType(value)
 		if err != nil {
-			return protocol.ErrorString("ERR " + err.Error()), nil
+			return errorReply(err), nil
 		}
 		return r, nil
 
+	case "GETDEL":
+		if len(parts) != 2 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'GETDEL' command"), nil
+		}
+		value, ok, err := s.store.GetDel(dbIndex, parts[1])
+		if err != nil {
+			return errorReply(err), nil
+		}
+		if !ok {
+			return client.protocol().EncodeNil(), nil
+		}
+		return protocol.BulkString(value), nil
+
+	case "GETEX":
+		if len(parts) < 2 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'GETEX' command"), nil
+		}
+		opts, err := store.ParseGetExOptions(parts[2:])
+		if err != nil {
+			return errorReply(err), nil
+		}
+		value, ok, err := s.store.GetEx(dbIndex, parts[1], opts)
+		if err != nil {
+			return errorReply(err), nil
+		}
+		if !ok {
+			return client.protocol().EncodeNil(), nil
+		}
+		return protocol.BulkString(value), nil
+
 	case "DEL":
 		if len(parts) != 2 {
 			return protocol.ErrorString("ERR wrong number of arguments for 'DEL' command"), nil
@@ -206,6 +475,29 @@ func (s *Server) executeCommand(conn net.Conn, request protocol.RESPValue) (prot
 		s.store.Del(dbIndex, parts[1])
 		return protocol.Integer(1), nil // Return count of deleted keys
 
+	case "MGET":
+		if len(parts) < 2 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'MGET' command"), nil
+		}
+		// Keys that are missing or hold another type are nil
+		values := s.store.GetMulti(dbIndex, parts[1:])
+		reply := make(protocol.Array, len(values))
+		for i, value := range values {
+			if value == nil {
+				reply[i] = client.protocol().EncodeNil()
+				continue
+			}
+			reply[i] = protocol.BulkString(*value)
+		}
+		return reply, nil
+
+	case "MSET":
+		if len(parts) < 3 || len(parts)%2 == 0 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'MSET' command"), nil
+		}
+		s.store.MSet(dbIndex, parts[1:]...)
+		return protocol.SimpleString("OK"), nil
+
 	case "EXISTS":
 		if len(parts) < 2 {
 			return protocol.ErrorString("ERR wrong number of arguments for 'EXISTS' command"), nil
@@ -241,7 +533,7 @@ func (s *Server) executeCommand(conn net.Conn, request protocol.RESPValue) (prot
 		}
 		newValue, err := s.store.Incr(dbIndex, parts[1])
 		if err != nil {
-			return protocol.ErrorString("ERR " + err.Error()), nil
+			return errorReply(err), nil
 		}
 		return protocol.Integer(int64(newValue)), nil // FIX: Convert to protocol.Integer
 
@@ -251,31 +543,116 @@ func (s *Server) executeCommand(conn net.Conn, request protocol.RESPValue) (prot
 		}
 		newValue, err := s.store.Decr(dbIndex, parts[1])
 		if err != nil {
-			return protocol.ErrorString("ERR " + err.Error()), nil
+			return errorReply(err), nil
 		}
 		return protocol.Integer(int64(newValue)), nil // FIX: Convert to protocol.Integer
 
+	case "INCRBY", "DECRBY":
+		if len(parts) != 3 {
+			return protocol.ErrorString("ERR wrong number of arguments for '" + command + "' command"), nil
+		}
+		step, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return protocol.ErrorString("ERR value is not an integer or out of range"), nil
+		}
+		incr := s.store.IncrBy
+		if command == "DECRBY" {
+			incr = s.store.DecrBy
+		}
+		newValue, err := incr(dbIndex, parts[1], step)
+		if err != nil {
+			return errorReply(err), nil
+		}
+		return protocol.Integer(int64(newValue)), nil
+
+	case "INCRBYFLOAT":
+		if len(parts) != 3 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'INCRBYFLOAT' command"), nil
+		}
+		delta, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil || math.IsNaN(delta) || math.IsInf(delta, 0) {
+			return protocol.ErrorString("ERR value is not a valid float"), nil
+		}
+		result, err := s.store.IncrByFloat(dbIndex, parts[1], delta)
+		if err != nil {
+			return errorReply(err), nil
+		}
+		return protocol.BulkString(result), nil
+
 	case "TTL":
 		if len(parts) != 2 {
 			return protocol.ErrorString("ERR wrong number of arguments for 'TTL' command"), nil
 		}
 		ttl, err := s.store.TTL(dbIndex, parts[1])
 		if err != nil {
-			return protocol.ErrorString("ERR " + err.Error()), nil
+			return errorReply(err), nil
 		}
 		return protocol.Integer(int64(ttl)), nil // FIX: Convert to protocol.Integer
 
+	case "PTTL":
+		if len(parts) != 2 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'PTTL' command"), nil
+		}
+		ttl, err := s.store.PTTL(dbIndex, parts[1])
+		if err != nil {
+			return errorReply(err), nil
+		}
+		return protocol.Integer(ttl), nil
+
+	case "PEXPIRE":
+		if len(parts) != 3 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'PEXPIRE' command"), nil
+		}
+		ms, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return protocol.ErrorString("ERR value is not an integer or out of range"), nil
+		}
+		ok, err := s.store.PExpire(dbIndex, parts[1], ms)
+		if err != nil {
+			return errorReply(err), nil
+		}
+		if ok {
+			return protocol.Integer(1), nil
+		}
+		return protocol.Integer(0), nil
+
+	case "EXPIREAT", "PEXPIREAT":
+		if len(parts) != 3 {
+			return protocol.ErrorString("ERR wrong number of arguments for '" + command + "' command"), nil
+		}
+		at, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return protocol.ErrorString("ERR value is not an integer or out of range"), nil
+		}
+		expireAt := s.store.PExpireAt
+		if command == "EXPIREAT" {
+			expireAt = s.store.ExpireAt
+		}
+		if expireAt(dbIndex, parts[1], at) {
+			return protocol.Integer(1), nil
+		}
+		return protocol.Integer(0), nil
+
+	case "PERSIST":
+		if len(parts) != 2 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'PERSIST' command"), nil
+		}
+		if s.store.Persist(dbIndex, parts[1]) {
+			return protocol.Integer(1), nil
+		}
+		return protocol.Integer(0), nil
+
 	case "SELECT":
 		if len(parts) != 2 {
 			return protocol.ErrorString("ERR wrong number of arguments for 'SELECT' command"), nil
 		}
 		dbIndex, err := strconv.Atoi(parts[1])
 		if err != nil {
-			return protocol.ErrorString("ERR invalid DB index"), nil
+			return protocol.ErrorString("ERR value is not an integer or out of range"), nil
 		}
-		err = s.SelectDb(conn, dbIndex)
+		err = s.SelectDb(client, dbIndex)
 		if err != nil {
-			return protocol.ErrorString("ERR " + err.Error()), nil
+			return errorReply(err), nil
 		}
 		return protocol.SimpleString("OK"), nil // FIX: Use protocol.SimpleString
 
@@ -283,22 +660,14 @@ func (s *Server) executeCommand(conn net.Conn, request protocol.RESPValue) (prot
 		if len(parts) < 3 {
 			return protocol.ErrorString("ERR wrong number of arguments for 'LPUSH' command"), nil
 		}
-		slice := make([]any, len(parts)-2)
-		for i := 2; i < len(parts); i++ {
-			slice[i-2] = parts[i]
-		}
-		length := s.store.LPush(dbIndex, parts[1], slice...)
+		length := s.store.LPush(dbIndex, parts[1], parts[2:]...)
 		return protocol.Integer(int64(length)), nil // FIX: Convert to protocol.Integer
 
 	case "RPUSH":
 		if len(parts) < 3 {
 			return protocol.ErrorString("ERR wrong number of arguments for 'RPUSH' command"), nil
 		}
-		slice := make([]any, len(parts)-2)
-		for i := 2; i < len(parts); i++ {
-			slice[i-2] = parts[i]
-		}
-		length := s.store.RPush(dbIndex, parts[1], slice...)
+		length := s.store.RPush(dbIndex, parts[1], parts[2:]...)
 		return protocol.Integer(int64(length)), nil // FIX: Convert to protocol.Integer
 
 	case "LPOP":
@@ -315,13 +684,9 @@ func (s *Server) executeCommand(conn net.Conn, request protocol.RESPValue) (prot
 		}
 		value, err := s.store.LPop(dbIndex, parts[1], count)
 		if err != nil {
-			return protocol.ErrorString("ERR " + err.Error()), nil
-		}
-		// FIX: Convert to RESP type and return
-		if value == nil {
-			return s.Protocol.EncodeNil(), nil
+			return errorReply(err), nil
 		}
-		return anyToRESP(value), nil
+		return popReply(client, value, count != nil), nil
 
 	case "RPOP":
 		if len(parts) != 2 && len(parts) != 3 {
@@ -337,12 +702,9 @@ func (s *Server) executeCommand(conn net.Conn, request protocol.RESPValue) (prot
 		}
 		value, err := s.store.RPop(dbIndex, parts[1], count)
 		if err != nil {
-			return protocol.ErrorString("ERR " + err.Error()), nil
+			return errorReply(err), nil
 		}
-		if value == nil {
-			return s.Protocol.EncodeNil(), nil
-		}
-		return anyToRESP(value), nil
+		return popReply(client, value, count != nil), nil
 
 	case "LRANGE":
 		if len(parts) != 4 {
@@ -355,9 +717,66 @@ func (s *Server) executeCommand(conn net.Conn, request protocol.RESPValue) (prot
 		}
 		values, err := s.store.LRange(dbIndex, parts[1], start, stop)
 		if err != nil {
-			return protocol.ErrorString("ERR " + err.Error()), nil
+			return errorReply(err), nil
+		}
+		return stringSliceToRESPArray(values), nil
+
+	case "LLEN":
+		if len(parts) != 2 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'LLEN' command"), nil
+		}
+		length, err := s.store.LLen(dbIndex, parts[1])
+		if err != nil {
+			return errorReply(err), nil
 		}
-		return anySliceToRESPArray(values), nil
+		return protocol.Integer(length), nil
+
+	case "LPOS":
+		if len(parts) < 3 || len(parts)%2 == 0 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'LPOS' command"), nil
+		}
+		rank, count, maxLen := 1, 1, 0
+		withCount := false
+		for i := 3; i < len(parts); i += 2 {
+			n, err := strconv.Atoi(parts[i+1])
+			if err != nil {
+				return protocol.ErrorString("ERR value is not an integer or out of range"), nil
+			}
+			switch strings.ToUpper(parts[i]) {
+			case "RANK":
+				if n == 0 {
+					return protocol.ErrorString("ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list"), nil
+				}
+				rank = n
+			case "COUNT":
+				if n < 0 {
+					return protocol.ErrorString("ERR COUNT can't be negative"), nil
+				}
+				count, withCount = n, true
+			case "MAXLEN":
+				if n < 0 {
+					return protocol.ErrorString("ERR MAXLEN can't be negative"), nil
+				}
+				maxLen = n
+			default:
+				return protocol.ErrorString("ERR syntax error"), nil
+			}
+		}
+		positions, err := s.store.LPos(dbIndex, parts[1], parts[2], rank, count, maxLen)
+		if err != nil {
+			return errorReply(err), nil
+		}
+		if !withCount {
+			if len(positions) == 0 {
+				return client.protocol().EncodeNil(), nil
+			}
+			return protocol.Integer(positions[0]), nil
+		}
+		reply := make(protocol.Array, len(positions))
+		for i, position := range positions {
+			reply[i] = protocol.Integer(position)
+		}
+		return reply, nil
 
 	case "LTRIM":
 		if len(parts) != 4 {
@@ -370,19 +789,121 @@ func (s *Server) executeCommand(conn net.Conn, request protocol.RESPValue) (prot
 		}
 		err := s.store.LTrim(dbIndex, parts[1], start, stop)
 		if err != nil {
-			return protocol.ErrorString("ERR " + err.Error()), nil
+			return errorReply(err), nil
 		}
 		return protocol.SimpleString("OK"), nil
 
+	case "LSET":
+		if len(parts) != 4 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'LSET' command"), nil
+		}
+		index, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return protocol.ErrorString("ERR value is not an integer or out of range"), nil
+		}
+		if err := s.store.LSet(dbIndex, parts[1], index, parts[3]); err != nil {
+			return errorReply(err), nil
+		}
+		return protocol.SimpleString("OK"), nil
+
+	case "LINSERT":
+		if len(parts) != 5 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'LINSERT' command"), nil
+		}
+		var before bool
+		switch strings.ToUpper(parts[2]) {
+		case "BEFORE":
+			before = true
+		case "AFTER":
+		default:
+			return protocol.ErrorString("ERR syntax error"), nil
+		}
+		length, err := s.store.LInsert(dbIndex, parts[1], before, parts[3], parts[4])
+		if err != nil {
+			return errorReply(err), nil
+		}
+		return protocol.Integer(length), nil
+
+	case "LREM":
+		if len(parts) != 4 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'LREM' command"), nil
+		}
+		count, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return protocol.ErrorString("ERR value is not an integer or out of range"), nil
+		}
+		removed, err := s.store.LRem(dbIndex, parts[1], count, parts[3])
+		if err != nil {
+			return errorReply(err), nil
+		}
+		return protocol.Integer(removed), nil
+
+	case "LMOVE", "RPOPLPUSH":
+		fromLeft, toLeft := false, true
+		switch {
+		case command == "RPOPLPUSH" && len(parts) == 3:
+		case command == "LMOVE" && len(parts) == 5:
+			var ok1, ok2 bool
+			fromLeft, ok1 = parseListEnd(parts[3])
+			toLeft, ok2 = parseListEnd(parts[4])
+			if !ok1 || !ok2 {
+				return protocol.ErrorString("ERR syntax error"), nil
+			}
+		default:
+			return protocol.ErrorString("ERR wrong number of arguments for '" + strings.ToLower(command) + "' command"), nil
+		}
+		element, ok, err := s.store.LMove(dbIndex, parts[1], parts[2], fromLeft, toLeft)
+		if err != nil {
+			return errorReply(err), nil
+		}
+		if !ok {
+			return client.protocol().EncodeNil(), nil
+		}
+		return protocol.BulkString(element), nil
+
 	case "RENAME":
 		if len(parts) != 3 {
 			return protocol.ErrorString("ERR wrong number of arguments for 'RENAME' command"), nil
 		}
 		if err := s.store.Rename(dbIndex, parts[1], parts[2]); err != nil {
-			return protocol.ErrorString("ERR " + err.Error()), nil
+			return errorReply(err), nil
 		}
 		return protocol.SimpleString("OK"), nil
 
+	case "COPY":
+		if len(parts) < 3 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'COPY' command"), nil
+		}
+		dstDb, replace := dbIndex, false
+		for i := 3; i < len(parts); i++ {
+			switch strings.ToUpper(parts[i]) {
+			case "DB":
+				if i+1 >= len(parts) {
+					return protocol.ErrorString("ERR syntax error"), nil
+				}
+				db, err := strconv.Atoi(parts[i+1])
+				if err != nil {
+					return protocol.ErrorString("ERR value is not an integer or out of range"), nil
+				}
+				if db < 0 || db >= s.store.Count() {
+					return protocol.ErrorString("ERR DB index is out of range"), nil
+				}
+				dstDb = db
+				i++
+			case "REPLACE":
+				replace = true
+			default:
+				return protocol.ErrorString("ERR syntax error"), nil
+			}
+		}
+		if dstDb == dbIndex && parts[1] == parts[2] {
+			return protocol.ErrorString("ERR source and destination objects are the same"), nil
+		}
+		if s.store.Copy(dbIndex, parts[1], dstDb, parts[2], replace) {
+			return protocol.Integer(1), nil
+		}
+		return protocol.Integer(0), nil
+
 	case "TYPE":
 		if len(parts) != 2 {
 			return protocol.ErrorString("ERR wrong number of arguments for 'TYPE' command"), nil
@@ -397,15 +918,22 @@ func (s *Server) executeCommand(conn net.Conn, request protocol.RESPValue) (prot
 		pattern := parts[1]
 		keys, err := s.store.Keys(dbIndex, pattern)
 		if err != nil {
-			return protocol.ErrorString("ERR " + err.Error()), nil
+			return errorReply(err), nil
 		}
 		return stringSliceToRESPArray(keys), nil
 
 	case "INFO":
-		info := s.Info()
-		return protocol.BulkString([]byte(info)), nil
+		return textReply(client, string(s.Info(parts[1:]...))), nil
 
 	case "PING":
+		// Subscribed RESP2 clients get the pong as a message-like array
+		if client.subscriptions() > 0 && !client.isRESP3() {
+			message := ""
+			if len(parts) > 1 {
+				message = parts[1]
+			}
+			return protocol.Array{protocol.BulkString("pong"), protocol.BulkString(message)}, nil
+		}
 		if len(parts) == 1 {
 			return protocol.SimpleString("PONG"), nil
 		}
@@ -420,7 +948,8 @@ func (s *Server) executeCommand(conn net.Conn, request protocol.RESPValue) (prot
 		return protocol.BulkString([]byte(msg)), nil
 
 	case "QUIT":
-		// FIX: Return OK before closing
+		// handleConn disconnects once the OK is sent
+		client.quitting = true
 		return protocol.SimpleString("OK"), nil
 
 	case "FLUSHDB":
@@ -441,7 +970,7 @@ func (s *Server) executeCommand(conn net.Conn, request protocol.RESPValue) (prot
 		}
 
 		pattern := "*"
-		count := 10
+		count := s.config.ScanDefaultCount
 
 		for i := 2; i < len(parts); i++ {
 			switch strings.ToUpper(parts[i]) {
@@ -466,11 +995,13 @@ func (s *Server) executeCommand(conn net.Conn, request protocol.RESPValue) (prot
 			}
 		}
 
-		newCursor, keys, err := s.store.Scan(dbIndex, cursor, pattern, count)
-		if err != nil {
-			return protocol.ErrorString("ERR " + err.Error()), nil
+		// Clamp COUNT so a single call can't walk a huge database
+		if s.config.ScanMaxCount > 0 && count > s.config.ScanMaxCount {
+			count = s.config.ScanMaxCount
 		}
 
+		newCursor, keys := s.store.Scan(dbIndex, cursor, pattern, count)
+
 		// SCAN returns [cursor, [keys]]
 		keysArray := make([]protocol.RESPValue, len(keys))
 		for i, k := range keys {
@@ -483,10 +1014,9 @@ func (s *Server) executeCommand(conn net.Conn, request protocol.RESPValue) (prot
 		}
 		return result, nil
 
-	case "GETRANGE":
-		fmt.Println("executing GETRANGE")
+	case "GETRANGE", "SUBSTR":
 		if len(parts) != 4 {
-			return protocol.ErrorString("ERR wrong number of arguments for 'GETRANGE' command"), nil
+			return protocol.ErrorString("ERR wrong number of arguments for '" + strings.ToLower(parts[0]) + "' command"), nil
 		}
 		start, err1 := strconv.Atoi(parts[2])
 		end, err2 := strconv.Atoi(parts[3])
@@ -495,7 +1025,7 @@ func (s *Server) executeCommand(conn net.Conn, request protocol.RESPValue) (prot
 		}
 		value, err := s.store.GetRange(dbIndex, parts[1], start, end)
 		if err != nil {
-			return protocol.ErrorString("ERR " + err.Error()), nil
+			return errorReply(err), nil
 		}
 		return protocol.BulkString([]byte(value)), nil
 
@@ -505,21 +1035,467 @@ func (s *Server) executeCommand(conn net.Conn, request protocol.RESPValue) (prot
 		}
 		length, err := s.store.StrLen(dbIndex, parts[1])
 		if err != nil {
-			return protocol.ErrorString("ERR " + err.Error()), nil
+			return errorReply(err), nil
 		}
 		return protocol.Integer(int64(length)), nil
 
+	case "APPEND":
+		if len(parts) != 3 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'APPEND' command"), nil
+		}
+		length, err := s.store.Append(dbIndex, parts[1], parts[2])
+		if err != nil {
+			return errorReply(err), nil
+		}
+		return protocol.Integer(int64(length)), nil
+
+	case "SETRANGE":
+		if len(parts) != 4 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'SETRANGE' command"), nil
+		}
+		offset, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return protocol.ErrorString("ERR value is not an integer or out of range"), nil
+		}
+		length, err := s.store.SetRange(dbIndex, parts[1], offset, parts[3])
+		if err != nil {
+			return protocol.ErrorString(err.Error()), nil
+		}
+		return protocol.Integer(int64(length)), nil
+
+	case "HSET":
+		if len(parts) < 4 || len(parts)%2 != 0 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'HSET' command"), nil
+		}
+		added, err := s.store.HSet(dbIndex, parts[1], parts[2:]...)
+		if err != nil {
+			return protocol.ErrorString(err.Error()), nil
+		}
+		return protocol.Integer(int64(added)), nil
+
+	case "HGET":
+		if len(parts) != 3 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'HGET' command"), nil
+		}
+		value, ok, err := s.store.HGet(dbIndex, parts[1], parts[2])
+		if err != nil {
+			return protocol.ErrorString(err.Error()), nil
+		}
+		if !ok {
+			return client.protocol().EncodeNil(), nil
+		}
+		return protocol.BulkString(value), nil
+
+	case "HGETALL":
+		if len(parts) != 2 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'HGETALL' command"), nil
+		}
+		pairs, err := s.store.HGetAll(dbIndex, parts[1])
+		if err != nil {
+			return protocol.ErrorString(err.Error()), nil
+		}
+		return pairsReply(client, pairs), nil
+
+	case "HVALS":
+		if len(parts) != 2 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'HVALS' command"), nil
+		}
+		values, err := s.store.HVals(dbIndex, parts[1])
+		if err != nil {
+			return protocol.ErrorString(err.Error()), nil
+		}
+		return stringSliceToRESPArray(values), nil
+
+	case "SADD":
+		if len(parts) < 3 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'SADD' command"), nil
+		}
+		added, err := s.store.SAdd(dbIndex, parts[1], parts[2:]...)
+		if err != nil {
+			return errorReply(err), nil
+		}
+		return protocol.Integer(int64(added)), nil
+
+	case "SREM":
+		if len(parts) < 3 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'SREM' command"), nil
+		}
+		removed, err := s.store.SRem(dbIndex, parts[1], parts[2:]...)
+		if err != nil {
+			return errorReply(err), nil
+		}
+		return protocol.Integer(int64(removed)), nil
+
+	case "SMEMBERS":
+		if len(parts) != 2 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'SMEMBERS' command"), nil
+		}
+		members, err := s.store.SMembers(dbIndex, parts[1])
+		if err != nil {
+			return errorReply(err), nil
+		}
+		return setReply(client, members), nil
+
+	case "SISMEMBER":
+		if len(parts) != 3 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'SISMEMBER' command"), nil
+		}
+		isMember, err := s.store.SIsMember(dbIndex, parts[1], parts[2])
+		if err != nil {
+			return errorReply(err), nil
+		}
+		if isMember {
+			return protocol.Integer(1), nil
+		}
+		return protocol.Integer(0), nil
+
+	case "SCARD":
+		if len(parts) != 2 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'SCARD' command"), nil
+		}
+		count, err := s.store.SCard(dbIndex, parts[1])
+		if err != nil {
+			return errorReply(err), nil
+		}
+		return protocol.Integer(int64(count)), nil
+
+	case "ZADD":
+		if len(parts) < 4 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'ZADD' command"), nil
+		}
+		var opts store.ZAddOptions
+		i := 2
+	flags:
+		for ; i < len(parts); i++ {
+			switch strings.ToUpper(parts[i]) {
+			case "NX":
+				opts.NX = true
+			case "XX":
+				opts.XX = true
+			case "GT":
+				opts.GT = true
+			case "LT":
+				opts.LT = true
+			case "CH":
+				opts.CH = true
+			case "INCR":
+				opts.Incr = true
+			default:
+				break flags
+			}
+		}
+		pairs := parts[i:]
+		if len(pairs) == 0 || len(pairs)%2 != 0 {
+			return protocol.ErrorString("ERR syntax error"), nil
+		}
+		if err := opts.Validate(len(pairs) / 2); err != nil {
+			return errorReply(err), nil
+		}
+		members := make([]store.ZMember, 0, len(pairs)/2)
+		for i := 0; i < len(pairs); i += 2 {
+			score, err := strconv.ParseFloat(pairs[i], 64)
+			if err != nil || math.IsNaN(score) {
+				return protocol.ErrorString("ERR value is not a valid float"), nil
+			}
+			members = append(members, store.ZMember{Score: score, Member: pairs[i+1]})
+		}
+		if opts.Incr {
+			score, applied, err := s.store.ZAddIncr(dbIndex, parts[1], opts, members[0])
+			if err != nil {
+				return errorReply(err), nil
+			}
+			if !applied {
+				return client.protocol().EncodeNil(), nil
+			}
+			return scoreReply(client, score), nil
+		}
+		added, err := s.store.ZAdd(dbIndex, parts[1], opts, members...)
+		if err != nil {
+			return errorReply(err), nil
+		}
+		return protocol.Integer(int64(added)), nil
+
+	case "ZSCORE":
+		if len(parts) != 3 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'ZSCORE' command"), nil
+		}
+		score, ok, err := s.store.ZScore(dbIndex, parts[1], parts[2])
+		if err != nil {
+			return errorReply(err), nil
+		}
+		if !ok {
+			return client.protocol().EncodeNil(), nil
+		}
+		return scoreReply(client, score), nil
+
+	case "SRANDMEMBER":
+		return randomMembersReply(client, command, parts, "", func(count int, _ bool) ([]string, error) {
+			return s.store.SRandMember(dbIndex, parts[1], count)
+		}, nil), nil
+
+	case "HRANDFIELD":
+		return randomMembersReply(client, command, parts, "WITHVALUES", func(count int, withValues bool) ([]string, error) {
+			return s.store.HRandField(dbIndex, parts[1], count, withValues)
+		}, fieldValuesReply), nil
+
+	case "ZRANDMEMBER":
+		return randomMembersReply(client, command, parts, "WITHSCORES", func(count int, withScores bool) ([]string, error) {
+			return s.store.ZRandMember(dbIndex, parts[1], count, withScores)
+		}, withScoresReply), nil
+
+	case "ZRANGE":
+		if len(parts) != 4 && len(parts) != 5 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'ZRANGE' command"), nil
+		}
+		withScores := len(parts) == 5
+		if withScores && !strings.EqualFold(parts[4], "WITHSCORES") {
+			return protocol.ErrorString("ERR syntax error"), nil
+		}
+		start, err1 := strconv.Atoi(parts[2])
+		stop, err2 := strconv.Atoi(parts[3])
+		if err1 != nil || err2 != nil {
+			return protocol.ErrorString("ERR value is not an integer or out of range"), nil
+		}
+		members, err := s.store.ZRange(dbIndex, parts[1], start, stop, withScores)
+		if err != nil {
+			return errorReply(err), nil
+		}
+		if withScores {
+			return withScoresReply(client, members), nil
+		}
+		return stringSliceToRESPArray(members), nil
+
+	case "DUMP":
+		if len(parts) != 2 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'DUMP' command"), nil
+		}
+		payload, ok := s.store.Dump(dbIndex, parts[1])
+		if !ok {
+			return client.protocol().EncodeNil(), nil
+		}
+		return protocol.BulkString(payload), nil
+
+	case "RESTORE":
+		if len(parts) < 4 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'RESTORE' command"), nil
+		}
+		ttl, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return protocol.ErrorString("ERR value is not an integer or out of range"), nil
+		}
+		if ttl < 0 {
+			return protocol.ErrorString("ERR Invalid TTL value, must be >= 0"), nil
+		}
+		replace, absTTL := false, false
+		for _, option := range parts[4:] {
+			switch strings.ToUpper(option) {
+			case "REPLACE":
+				replace = true
+			case "ABSTTL":
+				absTTL = true
+			default:
+				return protocol.ErrorString("ERR syntax error"), nil
+			}
+		}
+		var expiresAt *time.Time
+		if ttl > 0 {
+			at := time.Now().Add(time.Duration(ttl) * time.Millisecond)
+			if absTTL {
+				at = time.UnixMilli(ttl)
+			}
+			expiresAt = &at
+		}
+		if err := s.store.Restore(dbIndex, parts[1], []byte(parts[3]), expiresAt, replace); err != nil {
+			return protocol.ErrorString(err.Error()), nil
+		}
+		return protocol.SimpleString("OK"), nil
+
+	case "MEMORY":
+		if len(parts) < 2 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'MEMORY' command"), nil
+		}
+		if strings.ToUpper(parts[1]) != "USAGE" {
+			return protocol.ErrorString("ERR unknown subcommand '" + parts[1] + "'. Try MEMORY HELP."), nil
+		}
+		if len(parts) != 3 && len(parts) != 5 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'MEMORY|USAGE' command"), nil
+		}
+		size, ok := s.store.MemoryUsage(dbIndex, parts[2])
+		if !ok {
+			return client.protocol().EncodeNil(), nil
+		}
+		return protocol.Integer(int64(size)), nil
+
+	case "SUBSCRIBE", "PSUBSCRIBE":
+		if len(parts) < 2 {
+			return protocol.ErrorString("ERR wrong number of arguments for '" + strings.ToLower(parts[0]) + "' command"), nil
+		}
+		s.subscribe(client, strings.ToLower(command), parts[1:])
+		return nil, nil
+
+	case "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		s.unsubscribe(client, strings.ToLower(command), parts[1:])
+		return nil, nil
+
+	case "PUBLISH":
+		if len(parts) != 3 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'PUBLISH' command"), nil
+		}
+		return protocol.Integer(int64(s.Publish(parts[1], parts[2]))), nil
+
+	case "COMMAND":
+		if len(parts) == 1 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'COMMAND' command"), nil
+		}
+		switch strings.ToUpper(parts[1]) {
+		case "COUNT":
+			return protocol.Integer(int64(len(availableCommands))), nil
+		case "DOCS":
+			return s.CommandDocs(parts[2:]), nil
+		default:
+			return protocol.ErrorString("ERR unknown subcommand '" + parts[1] + "'. Try COMMAND HELP."), nil
+		}
+
+	case "CLIENT":
+		if len(parts) < 2 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'CLIENT' command"), nil
+		}
+		return s.ClientCommand(client, parts[1:])
+
+	case "OBJECT":
+		if len(parts) < 2 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'OBJECT' command"), nil
+		}
+		return s.Object(client, parts[1:])
+
+	case "TOUCH":
+		if len(parts) < 2 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'TOUCH' command"), nil
+		}
+		return protocol.Integer(int64(s.store.Touch(dbIndex, parts[1:]...))), nil
+
+	case "CONFIG":
+		if len(parts) < 2 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'CONFIG' command"), nil
+		}
+		return s.Config(client, parts[1:])
+
+	case "SAVE":
+		if len(parts) != 1 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'SAVE' command"), nil
+		}
+		if err := rdb.SaveSnapshot(s.store, s.rdbFilepath()); err != nil {
+			return protocol.ErrorString("ERR Error trying to save the DB: " + err.Error()), nil
+		}
+		return protocol.SimpleString("OK"), nil
+
+	case "BGREWRITEAOF":
+		if len(parts) != 1 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'BGREWRITEAOF' command"), nil
+		}
+		return s.rewriteAOF(), nil
+
+	case "DEBUG":
+		if !s.config.EnableDebug {
+			return protocol.ErrorString("ERR DEBUG command not allowed. Set ENABLE_DEBUG=true in the configuration and restart the server."), nil
+		}
+		if len(parts) < 2 {
+			return protocol.ErrorString("ERR wrong number of arguments for 'DEBUG' command"), nil
+		}
+		return s.Debug(dbIndex, parts[1:])
+
 	default:
-		return protocol.ErrorString("ERR unknown command '" + parts[0] + "'"), nil
+		return unknownCommand(parts), nil
+	}
+}
+
+// allowedWhileSubscribed lists the commands a subscribed client may run
+var allowedWhileSubscribed = map[string]bool{
+	"SUBSCRIBE":    true,
+	"PSUBSCRIBE":   true,
+	"UNSUBSCRIBE":  true,
+	"PUNSUBSCRIBE": true,
+	"PING":         true,
+	"QUIT":         true,
+	"RESET":        true,
+}
+
+// noTouchCommands look keys up without counting as an access, as in Redis
+var noTouchCommands = map[string]bool{
+	"EXISTS": true,
+	"TYPE":   true,
+	"TTL":    true,
+	"PTTL":   true,
+	"OBJECT": true,
+	"MEMORY": true,
+	"DEBUG":  true,
+	"TOUCH":  true, // touches on its own, even for NO-TOUCH clients
+}
+
+// inPlaceCommands rewrite the value of an existing key without growing it,
+// so they only allocate when they create their key
+var inPlaceCommands = map[string]bool{
+	"INCR":   true,
+	"DECR":   true,
+	"INCRBY": true,
+	"DECRBY": true,
+}
+
+// touchKeys updates the access time of the keys a command is about to use.
+// Clients with NO-TOUCH on only touch keys through write commands.
+func (s *Server) touchKeys(client *Client, dbIndex int, command string, parts []string) {
+	spec, ok := availableCommands[command]
+	if !ok || noTouchCommands[command] {
+		return
+	}
+	if client.noTouch && spec.isReadOnly() {
+		return
+	}
+	if keys := commandKeys(spec, parts); len(keys) > 0 {
+		s.store.Touch(dbIndex, keys...)
+	}
+}
+
+// commandKeys returns the key arguments of a command, following the argument
+// specs only while the position of each argument is known
+func commandKeys(spec commandSpec, parts []string) []string {
+	var keys []string
+	for i, arg := range spec.Args {
+		if i+1 >= len(parts) {
+			break
+		}
+		if arg.Type == "key" {
+			if arg.Multiple {
+				return append(keys, parts[i+1:]...)
+			}
+			keys = append(keys, parts[i+1])
+		}
+		if arg.Optional || arg.Multiple {
+			break
+		}
 	}
-	return nil, nil
+	return keys
 }
 
 // Helper functions
+
+// errorReply turns an error into an error reply. Messages that already start
+// with an error code, such as "WRONGTYPE ..." or "ERR ...", are sent as is,
+// others get the generic ERR code.
+func errorReply(err error) protocol.ErrorString {
+	msg := err.Error()
+	code, _, _ := strings.Cut(msg, " ")
+	if code != "" && strings.ToUpper(code) == code && strings.ToLower(code) != code {
+		return protocol.ErrorString(msg)
+	}
+	return protocol.ErrorString("ERR " + msg)
+}
 func anyToRESP(value interface{}) protocol.RESPValue {
 	switch v := value.(type) {
 	case string:
 		return protocol.BulkString([]byte(v))
+	case []string:
+		return stringSliceToRESPArray(v)
 	case []any:
 		return anySliceToRESPArray(v)
 	default:
@@ -543,6 +1519,14 @@ func stringSliceToRESPArray(strs []string) protocol.Array {
 	return arr
 }
 
+// describeRequest formats a request for the logs
+func describeRequest(request protocol.RESPValue) string {
+	if arr, ok := request.(protocol.Array); ok {
+		return fmt.Sprintf("%q", convertArrayToStrings(arr))
+	}
+	return fmt.Sprintf("%v", request)
+}
+
 func convertArrayToStrings(rawParts protocol.Array) []string {
 	parts := make([]string, len(rawParts))
 	for i, part := range rawParts {
@@ -562,9 +1546,9 @@ func convertArrayToStrings(rawParts protocol.Array) []string {
 }
 
 func convertValueTypeToRESPType(val interface{}) (protocol.RESPValue, error) {
-	// If val is already a store.Value, extract it
-	value, ok := val.(store.Value)
-	if !ok {
+	// If val is a value handed out by the store, extract it
+	value, ok := val.(*store.Value)
+	if !ok || value == nil {
 		// If it's raw data, try to infer
 		switch v := val.(type) {
 		case string:
@@ -586,22 +1570,26 @@ func convertValueTypeToRESPType(val interface{}) (protocol.RESPValue, error) {
 		return protocol.BulkString([]byte(str)), nil
 
 	case store.TypeList:
-		list, ok := value.Data.([]any)
+		list, ok := value.Data.([]string)
 		if !ok {
 			return protocol.ErrorString("ERR invalid list value"), fmt.Errorf("invalid list value")
 		}
-		return anySliceToRESPArray(list), nil
+		return stringSliceToRESPArray(list), nil
 
 	case store.TypeHash:
 		hash, ok := value.Data.(map[string]any)
 		if !ok {
 			return protocol.ErrorString("ERR invalid hash value"), fmt.Errorf("invalid hash value")
 		}
-		// Convert hash to array of key-value pairs
+		// Convert hash to array of key-value pairs, emitting string values as raw bytes
 		arr := make(protocol.Array, 0, len(hash)*2)
 		for k, v := range hash {
 			arr = append(arr, protocol.BulkString([]byte(k)))
-			arr = append(arr, protocol.BulkString([]byte(fmt.Sprintf("%v", v))))
+			if str, ok := v.(string); ok {
+				arr = append(arr, protocol.BulkString(str))
+			} else {
+				arr = append(arr, protocol.BulkString([]byte(fmt.Sprintf("%v", v))))
+			}
 		}
 		return arr, nil
 
@@ -0,0 +1,2913 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andrelcunha/goodiesdb/internal/core/store"
+	"github.com/andrelcunha/goodiesdb/internal/persistence/aof"
+	"github.com/andrelcunha/goodiesdb/internal/persistence/rdb"
+	"github.com/andrelcunha/goodiesdb/internal/protocol"
+	"github.com/andrelcunha/goodiesdb/internal/protocol/resp2"
+	"github.com/andrelcunha/goodiesdb/internal/protocol/resp3"
+)
+
+// newTestServer creates a server without persistence writing to a temporary data directory
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	config := NewConfig()
+	config.DataDir = t.TempDir()
+	config.UseRDB = false
+	config.UseAOF = false
+	return NewServer(config)
+}
+
+// newTestClient registers a client backed by one end of an in-memory connection
+func newTestClient(t *testing.T, s *Server) *Client {
+	t.Helper()
+	conn, peer := net.Pipe()
+	client := s.addClient(conn)
+	t.Cleanup(func() {
+		s.removeClient(client)
+		peer.Close()
+	})
+	return client
+}
+
+// execute runs a command the way a client would send it and returns the reply
+func execute(t *testing.T, s *Server, client *Client, args ...string) protocol.RESPValue {
+	t.Helper()
+	request := make(protocol.Array, len(args))
+	for i, arg := range args {
+		request[i] = protocol.BulkString(arg)
+	}
+	reply, err := s.executeCommand(client, request)
+	if err != nil {
+		t.Fatalf("Unexpected error executing %v: %v", args, err)
+	}
+	return reply
+}
+
+// connect serves one end of an in-memory connection and returns the other end
+// with a reader for the replies
+func connect(t *testing.T, s *Server) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, peer := net.Pipe()
+	go s.handleConn(conn)
+	t.Cleanup(func() { peer.Close() })
+	peer.SetDeadline(time.Now().Add(5 * time.Second))
+	return peer, bufio.NewReader(peer)
+}
+
+// sendCommand writes a command to the server as a RESP array of bulk strings
+func sendCommand(t *testing.T, conn net.Conn, args ...string) {
+	t.Helper()
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := conn.Write([]byte(b.String())); err != nil {
+		t.Fatalf("Unexpected error sending %v: %v", args, err)
+	}
+}
+
+// readFrame reads a reply and returns it with the type byte it started with
+func readFrame(t *testing.T, reader *bufio.Reader) (byte, protocol.RESPValue) {
+	t.Helper()
+	prefix, err := reader.Peek(1)
+	if err != nil {
+		t.Fatalf("Unexpected error reading a reply: %v", err)
+	}
+	value, err := (&resp3.RESP3Protocol{}).Parse(reader)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing a reply: %v", err)
+	}
+	return prefix[0], value
+}
+
+func TestHashValuesAreBinarySafe(t *testing.T) {
+	s := newTestServer(t)
+	client := newTestClient(t, s)
+	binary := "nul\x00byte\x00\xff\r\n"
+
+	if reply := execute(t, s, client, "HSET", "hash", "field", binary, "other", "plain"); reply != protocol.Integer(2) {
+		t.Fatalf("Expected 2 fields added, got %v", reply)
+	}
+
+	reply, ok := execute(t, s, client, "HGET", "hash", "field").(protocol.BulkString)
+	if !ok || string(reply) != binary {
+		t.Fatalf("Expected %q, got %q", binary, reply)
+	}
+
+	all, ok := execute(t, s, client, "HGETALL", "hash").(protocol.Array)
+	if !ok || len(all) != 4 || string(all[1].(protocol.BulkString)) != binary {
+		t.Fatalf("Expected HGETALL to return %q as is, got %q", binary, all)
+	}
+
+	vals, ok := execute(t, s, client, "HVALS", "hash").(protocol.Array)
+	if !ok || len(vals) != 2 || string(vals[0].(protocol.BulkString)) != binary {
+		t.Fatalf("Expected HVALS to return %q as is, got %q", binary, vals)
+	}
+
+	execute(t, s, client, "SET", "string", "value")
+	if reply := execute(t, s, client, "HGET", "string", "field"); reply != protocol.ErrorString("WRONGTYPE Operation against a key holding the wrong kind of value") {
+		t.Fatalf("Expected WRONGTYPE error, got %v", reply)
+	}
+}
+
+func TestListElementsAreBinarySafe(t *testing.T) {
+	s := newTestServer(t)
+	client := newTestClient(t, s)
+	// Elements that only differ after a NUL byte or a control character
+	binary := "a\x00b\r\n"
+	execute(t, s, client, "RPUSH", "list", "a", "a\x00c\r\n", binary, "a b")
+
+	if reply := execute(t, s, client, "LPOS", "list", binary); reply != protocol.Integer(2) {
+		t.Fatalf("Expected %q at index 2, got %v", binary, reply)
+	}
+	if reply, ok := execute(t, s, client, "LPOS", "list", "a\x00").(protocol.BulkString); !ok || reply != nil {
+		t.Fatalf("Expected no match for a prefix, got %q", reply)
+	}
+	positions, ok := execute(t, s, client, "LPOS", "list", "a", "COUNT", "0").(protocol.Array)
+	if !ok || len(positions) != 1 || positions[0] != protocol.Integer(0) {
+		t.Fatalf("Expected only the exact match at index 0, got %v", positions)
+	}
+	if reply := execute(t, s, client, "LINSERT", "list", "BEFORE", binary, "inserted"); reply != protocol.Integer(5) {
+		t.Fatalf("Expected 5 elements after LINSERT, got %v", reply)
+	}
+	if reply := execute(t, s, client, "LREM", "list", "0", binary); reply != protocol.Integer(1) {
+		t.Fatalf("Expected LREM to remove 1 element, got %v", reply)
+	}
+
+	want := []string{"a", "a\x00c\r\n", "inserted", "a b"}
+	all, ok := execute(t, s, client, "LRANGE", "list", "0", "-1").(protocol.Array)
+	if !ok || len(all) != len(want) {
+		t.Fatalf("Expected %q, got %q", want, all)
+	}
+	for i, element := range all {
+		if string(element.(protocol.BulkString)) != want[i] {
+			t.Fatalf("Expected %q, got %q", want, all)
+		}
+	}
+}
+
+func TestSlowSubscriberIsDisconnected(t *testing.T) {
+	s := newTestServer(t)
+	s.config.OutputBufferLimitPubSub = OutputBufferLimit{Hard: 4096}
+
+	// The subscriber sends SUBSCRIBE and then never reads a single byte
+	conn, subscriber := net.Pipe()
+	defer subscriber.Close()
+	go s.handleConn(conn)
+	if _, err := subscriber.Write([]byte("*2\r\n$9\r\nSUBSCRIBE\r\n$4\r\nnews\r\n")); err != nil {
+		t.Fatalf("Unexpected error subscribing: %v", err)
+	}
+
+	publisher := newTestClient(t, s)
+	message := strings.Repeat("x", 512)
+	deadline := time.Now().Add(5 * time.Second)
+	delivered := 0
+	for {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the subscriber to be disconnected, %d messages were queued", delivered)
+		}
+		reply := execute(t, s, publisher, "PUBLISH", "news", message)
+		if reply == protocol.Integer(0) {
+			if delivered > 0 {
+				break
+			}
+			time.Sleep(time.Millisecond) // not subscribed yet
+			continue
+		}
+		delivered++
+	}
+
+	if delivered*len(message) > 2*4096 {
+		t.Fatalf("Expected the subscriber to be dropped near the 4096 byte limit, %d messages were queued", delivered)
+	}
+
+	subscriber.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(subscriber); err != nil {
+		t.Fatalf("Expected the connection to be closed, got %v", err)
+	}
+}
+
+// fields reads a flattened name/value reply such as the ones of COMMAND DOCS
+func fields(t *testing.T, reply protocol.RESPValue) map[string]protocol.RESPValue {
+	t.Helper()
+	arr, ok := reply.(protocol.Array)
+	if !ok || len(arr)%2 != 0 {
+		t.Fatalf("Expected a flattened map, got %v", reply)
+	}
+	m := make(map[string]protocol.RESPValue, len(arr)/2)
+	for i := 0; i < len(arr); i += 2 {
+		m[string(arr[i].(protocol.BulkString))] = arr[i+1]
+	}
+	return m
+}
+
+func TestCommandDocs(t *testing.T) {
+	s := newTestServer(t)
+	client := newTestClient(t, s)
+
+	docs := fields(t, execute(t, s, client, "COMMAND", "DOCS", "GET"))
+	get, ok := docs["get"]
+	if !ok || len(docs) != 1 {
+		t.Fatalf("Expected only the docs of get, got %v", docs)
+	}
+	doc := fields(t, get)
+	if string(doc["summary"].(protocol.BulkString)) == "" || string(doc["since"].(protocol.BulkString)) != "1.0.0" {
+		t.Fatalf("Expected summary and since, got %v", doc)
+	}
+	args, ok := doc["arguments"].(protocol.Array)
+	if !ok || len(args) != 1 {
+		t.Fatalf("Expected one argument, got %v", doc["arguments"])
+	}
+	arg := fields(t, args[0])
+	if string(arg["name"].(protocol.BulkString)) != "key" || string(arg["type"].(protocol.BulkString)) != "key" {
+		t.Fatalf("Expected argument key of type key, got %v", arg)
+	}
+
+	if docs := execute(t, s, client, "COMMAND", "DOCS", "NOSUCHCOMMAND"); len(docs.(protocol.Array)) != 0 {
+		t.Fatalf("Expected no docs for an unknown command, got %v", docs)
+	}
+}
+
+func TestAOFWriteFailureDoesNotStopServer(t *testing.T) {
+	config := NewConfig()
+	config.DataDir = t.TempDir()
+	config.UseRDB = false
+	config.UseAOF = true
+	s := NewServer(config)
+
+	// A directory can't be opened as the AOF file, not even by root
+	s.startAOF(config.DataDir)
+	defer close(s.store.AOFChannel())
+
+	// Far more writes than the AOF channel buffers must not block
+	client := newTestClient(t, s)
+	for i := 0; i < 1000; i++ {
+		if reply := execute(t, s, client, "SET", "key", "value"); reply != protocol.SimpleString("OK") {
+			t.Fatalf("Expected OK, got %v", reply)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.aofError() == nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the AOF error to be surfaced")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestIncrSurvivesDebugReload(t *testing.T) {
+	s := newTestServer(t)
+	s.config.EnableDebug = true
+	client := newTestClient(t, s)
+
+	execute(t, s, client, "INCR", "counter")
+	execute(t, s, client, "INCR", "counter")
+	if reply := execute(t, s, client, "DEBUG", "RELOAD"); reply != protocol.SimpleString("OK") {
+		t.Fatalf("Expected OK, got %v", reply)
+	}
+
+	if reply := execute(t, s, client, "TYPE", "counter"); reply != protocol.SimpleString("string") {
+		t.Fatalf("Expected type string after reload, got %v", reply)
+	}
+	if reply := execute(t, s, client, "INCR", "counter"); reply != protocol.Integer(3) {
+		t.Fatalf("Expected INCR to return 3 after reload, got %v", reply)
+	}
+}
+
+func TestIncrByRejectsNonIntegerSteps(t *testing.T) {
+	s := newTestServer(t)
+	client := newTestClient(t, s)
+
+	if reply := execute(t, s, client, "INCRBY", "counter", "7"); reply != protocol.Integer(7) {
+		t.Fatalf("Expected 7, got %v", reply)
+	}
+	if reply := execute(t, s, client, "DECRBY", "counter", "10"); reply != protocol.Integer(-3) {
+		t.Fatalf("Expected -3, got %v", reply)
+	}
+	for _, step := range []string{"1.5", "ten", ""} {
+		for _, command := range []string{"INCRBY", "DECRBY"} {
+			if reply := execute(t, s, client, command, "counter", step); reply != protocol.ErrorString("ERR value is not an integer or out of range") {
+				t.Fatalf("Expected %s with step %q to be rejected, got %v", command, step, reply)
+			}
+		}
+	}
+}
+
+func TestNoTouchKeepsIdleTime(t *testing.T) {
+	s := newTestServer(t)
+	client := newTestClient(t, s)
+
+	execute(t, s, client, "SET", "key", "value")
+	if reply := execute(t, s, client, "CLIENT", "NO-TOUCH", "ON"); reply != protocol.SimpleString("OK") {
+		t.Fatalf("Expected OK, got %v", reply)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	execute(t, s, client, "GET", "key")
+	if idle := execute(t, s, client, "OBJECT", "IDLETIME", "key"); idle != protocol.Integer(1) {
+		t.Fatalf("Expected the GET not to reset the idle time, got %v", idle)
+	}
+
+	execute(t, s, client, "CLIENT", "NO-TOUCH", "OFF")
+	execute(t, s, client, "GET", "key")
+	if idle := execute(t, s, client, "OBJECT", "IDLETIME", "key"); idle != protocol.Integer(0) {
+		t.Fatalf("Expected the GET to reset the idle time, got %v", idle)
+	}
+}
+
+func TestStringGrowthIsCappedByProtoMaxBulkLen(t *testing.T) {
+	s := newTestServer(t)
+	s.store.SetProtoMaxBulkLen(10)
+	client := newTestClient(t, s)
+
+	if reply := execute(t, s, client, "APPEND", "key", "hello"); reply != protocol.Integer(5) {
+		t.Fatalf("Expected length 5, got %v", reply)
+	}
+	if reply := execute(t, s, client, "APPEND", "key", " world"); reply != protocol.ErrorString("ERR string exceeds maximum allowed size") {
+		t.Fatalf("Expected the APPEND past the cap to be rejected, got %v", reply)
+	}
+	if reply := execute(t, s, client, "SETRANGE", "key", "8", "abc"); reply != protocol.ErrorString("ERR string exceeds maximum allowed size") {
+		t.Fatalf("Expected the SETRANGE past the cap to be rejected, got %v", reply)
+	}
+	if reply := execute(t, s, client, "GET", "key"); string(reply.(protocol.BulkString)) != "hello" {
+		t.Fatalf("Expected the value to be preserved, got %q", reply)
+	}
+
+	if reply := execute(t, s, client, "SETRANGE", "key", "7", "abc"); reply != protocol.Integer(10) {
+		t.Fatalf("Expected length 10, got %v", reply)
+	}
+	if reply := execute(t, s, client, "GET", "key"); string(reply.(protocol.BulkString)) != "hello\x00\x00abc" {
+		t.Fatalf("Expected a zero-padded value, got %q", reply)
+	}
+}
+
+func TestInfoReplication(t *testing.T) {
+	s := newTestServer(t)
+	client := newTestClient(t, s)
+
+	info := string(execute(t, s, client, "INFO", "replication").(protocol.BulkString))
+	if !strings.Contains(info, "# Replication\n") || !strings.Contains(info, "role:master\n") || !strings.Contains(info, "connected_slaves:0\n") {
+		t.Fatalf("Expected the replication section of a master, got %q", info)
+	}
+	if strings.Contains(info, "# Server") {
+		t.Fatalf("Expected only the replication section, got %q", info)
+	}
+
+	all := string(execute(t, s, client, "INFO").(protocol.BulkString))
+	if !strings.Contains(all, "# Server\n") || !strings.Contains(all, "role:master\n") {
+		t.Fatalf("Expected every section without arguments, got %q", all)
+	}
+}
+
+func TestZSetEncoding(t *testing.T) {
+	s := newTestServer(t)
+	s.store.SetEncodingLimits(store.EncodingLimits{ZSetMaxListpackEntries: 4, ZSetMaxListpackValue: 8})
+	client := newTestClient(t, s)
+
+	execute(t, s, client, "ZADD", "small", "1", "a", "2", "b")
+	if reply := execute(t, s, client, "OBJECT", "ENCODING", "small"); string(reply.(protocol.BulkString)) != "listpack" {
+		t.Fatalf("Expected listpack for a small zset, got %q", reply)
+	}
+
+	execute(t, s, client, "ZADD", "small", "3", "c", "4", "d", "5", "e")
+	if reply := execute(t, s, client, "OBJECT", "ENCODING", "small"); string(reply.(protocol.BulkString)) != "skiplist" {
+		t.Fatalf("Expected skiplist past the entries limit, got %q", reply)
+	}
+
+	execute(t, s, client, "ZADD", "long", "1", "a")
+	execute(t, s, client, "ZADD", "long", "2", "a-member-longer-than-8-bytes")
+	if reply := execute(t, s, client, "OBJECT", "ENCODING", "long"); string(reply.(protocol.BulkString)) != "skiplist" {
+		t.Fatalf("Expected skiplist past the value limit, got %q", reply)
+	}
+}
+
+func TestHashEncoding(t *testing.T) {
+	s := newTestServer(t)
+	s.store.SetEncodingLimits(store.EncodingLimits{HashMaxListpackEntries: 2, HashMaxListpackValue: 8})
+	client := newTestClient(t, s)
+
+	execute(t, s, client, "HSET", "small", "a", "1", "b", "2")
+	if reply := execute(t, s, client, "OBJECT", "ENCODING", "small"); string(reply.(protocol.BulkString)) != "listpack" {
+		t.Fatalf("Expected listpack for a small hash, got %q", reply)
+	}
+	execute(t, s, client, "HSET", "small", "c", "3")
+	if reply := execute(t, s, client, "OBJECT", "ENCODING", "small"); string(reply.(protocol.BulkString)) != "hashtable" {
+		t.Fatalf("Expected hashtable past the entries limit, got %q", reply)
+	}
+
+	execute(t, s, client, "HSET", "long", "a", "1")
+	execute(t, s, client, "HSET", "long", "a", "a value longer than 8 bytes")
+	if reply := execute(t, s, client, "OBJECT", "ENCODING", "long"); string(reply.(protocol.BulkString)) != "hashtable" {
+		t.Fatalf("Expected hashtable past the value limit, got %q", reply)
+	}
+	execute(t, s, client, "HSET", "field", "a field longer than 8 bytes", "1")
+	if reply := execute(t, s, client, "OBJECT", "ENCODING", "field"); string(reply.(protocol.BulkString)) != "hashtable" {
+		t.Fatalf("Expected hashtable for a field past the value limit, got %q", reply)
+	}
+}
+
+func TestZRange(t *testing.T) {
+	s := newTestServer(t)
+	client := newTestClient(t, s)
+	execute(t, s, client, "ZADD", "zset", "1.5", "b", "1.5", "a", "-2", "c")
+	execute(t, s, client, "SET", "string", "value")
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"zset", "0", "-1"}, "[c a b]"},
+		{[]string{"zset", "-2", "100", "withscores"}, "[a 1.5 b 1.5]"},
+		{[]string{"zset", "5", "10"}, "[]"},
+		{[]string{"missing", "0", "-1"}, "[]"},
+	}
+	for _, tt := range tests {
+		reply := execute(t, s, client, append([]string{"ZRANGE"}, tt.args...)...)
+		if got := fmt.Sprintf("%s", reply); got != tt.want {
+			t.Fatalf("ZRANGE %v: expected %s, got %s", tt.args, tt.want, got)
+		}
+	}
+
+	failures := []struct {
+		args []string
+		want protocol.ErrorString
+	}{
+		{[]string{"zset", "0", "-1", "SCORES"}, "ERR syntax error"},
+		{[]string{"zset", "a", "-1"}, "ERR value is not an integer or out of range"},
+		{[]string{"string", "0", "-1"}, "WRONGTYPE Operation against a key holding the wrong kind of value"},
+	}
+	for _, tt := range failures {
+		if reply := execute(t, s, client, append([]string{"ZRANGE"}, tt.args...)...); reply != tt.want {
+			t.Fatalf("ZRANGE %v: expected %q, got %v", tt.args, tt.want, reply)
+		}
+	}
+
+	// RESP3 pairs every member with its score as a double
+	client.setProtocol(&resp3.RESP3Protocol{})
+	reply := execute(t, s, client, "ZRANGE", "zset", "0", "0", "WITHSCORES").(protocol.Array)
+	pair, ok := reply[0].(protocol.Array)
+	if len(reply) != 1 || !ok || string(pair[0].(protocol.BulkString)) != "c" || pair[1] != protocol.Double(-2) {
+		t.Fatalf("Expected [[c -2]] in RESP3, got %v", reply)
+	}
+}
+
+// Test that values keep their encoding through DUMP and RESTORE, and through
+// DEBUG RELOAD, as their size is checked again against the limits
+func TestEncodingSurvivesRestoreAndReload(t *testing.T) {
+	s := newTestServer(t)
+	s.config.EnableDebug = true
+	s.store.SetEncodingLimits(store.EncodingLimits{
+		ListMaxListpackSize:    2,
+		HashMaxListpackEntries: 2, HashMaxListpackValue: 8,
+		ZSetMaxListpackEntries: 2, ZSetMaxListpackValue: 8,
+	})
+	client := newTestClient(t, s)
+
+	execute(t, s, client, "SET", "int", "12345")
+	execute(t, s, client, "SET", "embstr", "short")
+	execute(t, s, client, "SET", "raw", strings.Repeat("x", 100))
+	execute(t, s, client, "RPUSH", "listpack", "a", "b")
+	execute(t, s, client, "RPUSH", "quicklist", "a", "b", "c")
+	execute(t, s, client, "HSET", "small-hash", "a", "1")
+	execute(t, s, client, "HSET", "big-hash", "a", "1", "b", "2", "c", "3")
+	execute(t, s, client, "HSET", "long-hash", "a", "a value longer than 8 bytes")
+	execute(t, s, client, "SADD", "set", "1", "2")
+	execute(t, s, client, "ZADD", "small-zset", "1", "a")
+	execute(t, s, client, "ZADD", "big-zset", "1", "a", "2", "b", "3", "c")
+	execute(t, s, client, "ZADD", "long-zset", "1", "a member longer than 8 bytes")
+
+	keys := []string{"int", "embstr", "raw", "listpack", "quicklist", "small-hash", "big-hash",
+		"long-hash", "set", "small-zset", "big-zset", "long-zset"}
+	encodings := map[string]string{}
+	for _, key := range keys {
+		encodings[key] = string(execute(t, s, client, "OBJECT", "ENCODING", key).(protocol.BulkString))
+	}
+	if encodings["big-hash"] != "hashtable" || encodings["big-zset"] != "skiplist" || encodings["quicklist"] != "quicklist" {
+		t.Fatalf("Expected the big values to start with their large encoding, got %v", encodings)
+	}
+
+	for _, key := range keys {
+		payload := execute(t, s, client, "DUMP", key).(protocol.BulkString)
+		if reply := execute(t, s, client, "RESTORE", key+":restored", "0", string(payload)); reply != protocol.SimpleString("OK") {
+			t.Fatalf("Expected RESTORE of %s to succeed, got %v", key, reply)
+		}
+		if got := string(execute(t, s, client, "OBJECT", "ENCODING", key+":restored").(protocol.BulkString)); got != encodings[key] {
+			t.Fatalf("Expected %s restored as %s, got %s", key, encodings[key], got)
+		}
+	}
+
+	if reply := execute(t, s, client, "DEBUG", "RELOAD"); reply != protocol.SimpleString("OK") {
+		t.Fatalf("Expected DEBUG RELOAD to succeed, got %v", reply)
+	}
+	for _, key := range keys {
+		if got := string(execute(t, s, client, "OBJECT", "ENCODING", key).(protocol.BulkString)); got != encodings[key] {
+			t.Fatalf("Expected %s reloaded as %s, got %s", key, encodings[key], got)
+		}
+	}
+}
+
+func TestStringEncoding(t *testing.T) {
+	s := newTestServer(t)
+	client := newTestClient(t, s)
+
+	tests := []struct {
+		value    string
+		encoding string
+	}{
+		{"12345", "int"},
+		{"-9223372036854775808", "int"},
+		{"007", "embstr"},
+		{"9223372036854775808", "embstr"},
+		{"hello", "embstr"},
+		{strings.Repeat("x", 44), "embstr"},
+		{strings.Repeat("x", 45), "raw"},
+	}
+	for _, tt := range tests {
+		execute(t, s, client, "SET", "k", tt.value)
+		if reply := execute(t, s, client, "OBJECT", "ENCODING", "k"); string(reply.(protocol.BulkString)) != tt.encoding {
+			t.Fatalf("Expected %s for %q, got %q", tt.encoding, tt.value, reply)
+		}
+	}
+}
+
+func TestScanCount(t *testing.T) {
+	s := newTestServer(t)
+	s.config.ScanDefaultCount = 3
+	s.config.ScanMaxCount = 4
+	client := newTestClient(t, s)
+	for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		execute(t, s, client, "SET", key, "value")
+	}
+
+	// A call examines whole slots, so it may return a few more keys than
+	// COUNT, but not all of them
+	reply := execute(t, s, client, "SCAN", "0").(protocol.Array)
+	if n := len(reply[1].(protocol.Array)); string(reply[0].(protocol.BulkString)) == "0" || n < 3 || n > 4 {
+		t.Fatalf("Expected the default COUNT of 3 to be used, got %v", reply)
+	}
+
+	reply = execute(t, s, client, "SCAN", "0", "COUNT", "100").(protocol.Array)
+	if n := len(reply[1].(protocol.Array)); string(reply[0].(protocol.BulkString)) == "0" || n < 4 || n > 5 {
+		t.Fatalf("Expected COUNT to be clamped to 4, got %v", reply)
+	}
+}
+
+func TestPubSubFramingFollowsProtocol(t *testing.T) {
+	s := newTestServer(t)
+	publisher := newTestClient(t, s)
+
+	resp2Conn, resp2Reader := connect(t, s)
+	resp3Conn, resp3Reader := connect(t, s)
+
+	sendCommand(t, resp3Conn, "HELLO", "3")
+	if prefix, hello := readFrame(t, resp3Reader); prefix != '%' || hello.(protocol.Map)[protocol.SimpleString("proto")] != protocol.Integer(3) {
+		t.Fatalf("Expected a RESP3 map from HELLO 3, got %c %v", prefix, hello)
+	}
+
+	for _, sub := range []struct {
+		conn   net.Conn
+		reader *bufio.Reader
+		prefix byte
+	}{{resp2Conn, resp2Reader, '*'}, {resp3Conn, resp3Reader, '>'}} {
+		sendCommand(t, sub.conn, "SUBSCRIBE", "news")
+		if prefix, _ := readFrame(t, sub.reader); prefix != sub.prefix {
+			t.Fatalf("Expected the subscribe confirmation to start with %c, got %c", sub.prefix, prefix)
+		}
+	}
+
+	if reply := execute(t, s, publisher, "PUBLISH", "news", "hello"); reply != protocol.Integer(2) {
+		t.Fatalf("Expected 2 receivers, got %v", reply)
+	}
+
+	prefix, message := readFrame(t, resp2Reader)
+	if prefix != '*' || string(message.(protocol.Array)[2].(protocol.BulkString)) != "hello" {
+		t.Fatalf("Expected a RESP2 array message, got %c %v", prefix, message)
+	}
+	prefix, message = readFrame(t, resp3Reader)
+	if prefix != '>' || string(message.(protocol.Push)[2].(protocol.BulkString)) != "hello" {
+		t.Fatalf("Expected a RESP3 push message, got %c %v", prefix, message)
+	}
+}
+
+// waitForSubscribers waits until n clients are subscribed to channel
+func waitForSubscribers(t *testing.T, s *Server, channel string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.pubsub.mu.RLock()
+		subscribers := len(s.pubsub.channels[channel])
+		s.pubsub.mu.RUnlock()
+		if subscribers == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d subscribers to %s, got %d", n, channel, subscribers)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// subscriberFor returns the server side client of the subscriber to channel
+func subscriberFor(t *testing.T, s *Server, channel string) *Client {
+	t.Helper()
+	s.pubsub.mu.RLock()
+	defer s.pubsub.mu.RUnlock()
+	for client := range s.pubsub.channels[channel] {
+		return client
+	}
+	t.Fatalf("Expected a subscriber to %s", channel)
+	return nil
+}
+
+func TestMaxMemoryClientsEvictsLargestBuffers(t *testing.T) {
+	s := newTestServer(t)
+	s.config.OutputBufferLimitPubSub = OutputBufferLimit{}
+	s.config.MaxMemoryClients = 4000
+	publisher := newTestClient(t, s)
+
+	// Three subscribers that never read their replies
+	for _, channel := range []string{"small", "large", "growing"} {
+		conn, _ := connect(t, s)
+		sendCommand(t, conn, "SUBSCRIBE", channel)
+		waitForSubscribers(t, s, channel, 1)
+	}
+	small := subscriberFor(t, s, "small")
+	large := subscriberFor(t, s, "large")
+	growing := subscriberFor(t, s, "growing")
+
+	kb := strings.Repeat("x", 1000)
+	execute(t, s, publisher, "PUBLISH", "small", kb)
+	execute(t, s, publisher, "PUBLISH", "large", kb)
+	execute(t, s, publisher, "PUBLISH", "large", kb)
+
+	// Small messages to one subscriber push the total over the budget, but the
+	// subscriber with the largest buffer is the one evicted
+	for i := 0; !large.isClosed(); i++ {
+		if i == 100 {
+			t.Fatalf("Expected the largest buffer to be evicted, %d bytes pending", s.clientOutputBytes.Load())
+		}
+		execute(t, s, publisher, "PUBLISH", "growing", strings.Repeat("y", 100))
+	}
+	if small.isClosed() || growing.isClosed() {
+		t.Fatalf("Expected only the largest buffer to be evicted")
+	}
+	if total := s.clientOutputBytes.Load(); total > 4000 {
+		t.Fatalf("Expected the output buffers to fit the budget again, got %d bytes", total)
+	}
+}
+
+func TestPopErrors(t *testing.T) {
+	s := newTestServer(t)
+	client := newTestClient(t, s)
+	wrongType := protocol.ErrorString("WRONGTYPE Operation against a key holding the wrong kind of value")
+	outOfRange := protocol.ErrorString("ERR value is out of range, must be positive")
+
+	execute(t, s, client, "SET", "string", "value")
+	execute(t, s, client, "RPUSH", "list", "a", "b")
+	for _, command := range []string{"LPOP", "RPOP"} {
+		if reply := execute(t, s, client, command, "string"); reply != wrongType {
+			t.Fatalf("Expected %s on a string to fail with WRONGTYPE, got %v", command, reply)
+		}
+		if reply := execute(t, s, client, command, "string", "2"); reply != wrongType {
+			t.Fatalf("Expected %s with count on a string to fail with WRONGTYPE, got %v", command, reply)
+		}
+		if reply, ok := execute(t, s, client, command, "missing").(protocol.BulkString); !ok || reply != nil {
+			t.Fatalf("Expected %s on a missing key to return nil, got %v", command, reply)
+		}
+		for _, key := range []string{"list", "missing", "string"} {
+			if reply := execute(t, s, client, command, key, "-1"); reply != outOfRange {
+				t.Fatalf("Expected %s %s -1 to be out of range, got %v", command, key, reply)
+			}
+		}
+	}
+}
+
+func TestPopCountReplies(t *testing.T) {
+	s := newTestServer(t)
+	conn, reader := connect(t, s)
+	sendCommand(t, conn, "RPUSH", "list", "a", "b", "c", "d")
+	readFrame(t, reader)
+
+	tests := []struct {
+		args []string
+		want []string // the RESP2 reply, line by line
+	}{
+		{[]string{"LPOP", "list", "-1"}, []string{"-ERR value is out of range, must be positive\r\n"}},
+		{[]string{"LPOP", "list", "0"}, []string{"*0\r\n"}},
+		{[]string{"LPOP", "list", "1"}, []string{"*1\r\n", "$1\r\n", "a\r\n"}},
+		{[]string{"RPOP", "list", "0"}, []string{"*0\r\n"}},
+		{[]string{"RPOP", "list"}, []string{"$1\r\n", "d\r\n"}},
+		// Fewer elements than asked for, which deletes the list
+		{[]string{"RPOP", "list", "5"}, []string{"*2\r\n", "$1\r\n", "b\r\n", "$1\r\n", "c\r\n"}},
+		{[]string{"LPOP", "list", "2"}, []string{"*-1\r\n"}},
+		{[]string{"RPOP", "list", "0"}, []string{"*-1\r\n"}},
+		{[]string{"LPOP", "list"}, []string{"$-1\r\n"}},
+		{[]string{"RPOP", "list"}, []string{"$-1\r\n"}},
+	}
+	for _, test := range tests {
+		sendCommand(t, conn, test.args...)
+		for _, want := range test.want {
+			if line, _ := reader.ReadString('\n'); line != want {
+				t.Fatalf("%v: expected %q, got %q", test.args, want, line)
+			}
+		}
+	}
+
+	// RESP3 has a single null for both
+	sendCommand(t, conn, "HELLO", "3")
+	readFrame(t, reader)
+	for _, args := range [][]string{{"LPOP", "list"}, {"LPOP", "list", "2"}} {
+		sendCommand(t, conn, args...)
+		if prefix, reply := readFrame(t, reader); prefix != '_' {
+			t.Fatalf("RESP3 %v: expected a null, got %q %v", args, prefix, reply)
+		}
+	}
+}
+
+// infoField returns the value of a field in an INFO reply
+func infoField(t *testing.T, info protocol.RESPValue, field string) string {
+	t.Helper()
+	for _, line := range strings.Split(string(info.(protocol.BulkString)), "\n") {
+		if value, ok := strings.CutPrefix(line, field+":"); ok {
+			return value
+		}
+	}
+	t.Fatalf("Expected %s in INFO, got %q", field, info)
+	return ""
+}
+
+func TestInfoUptimeAndClients(t *testing.T) {
+	s := newTestServer(t)
+	s.startTime = time.Now().Add(-90 * time.Second)
+	client := newTestClient(t, s)
+	newTestClient(t, s)
+
+	info := execute(t, s, client, "INFO", "server")
+	if uptime := infoField(t, info, "uptime_in_seconds"); uptime != "90" {
+		t.Fatalf("Expected an uptime of 90 seconds, got %s", uptime)
+	}
+	if clients := infoField(t, info, "connected_clients"); clients != "2" {
+		t.Fatalf("Expected 2 connected clients, got %s", clients)
+	}
+}
+
+func TestInfoMemory(t *testing.T) {
+	s := newTestServer(t)
+	client := newTestClient(t, s)
+	usedMemory := func() int64 {
+		n, err := strconv.ParseInt(infoField(t, execute(t, s, client, "INFO", "memory"), "used_memory"), 10, 64)
+		if err != nil {
+			t.Fatalf("Expected used_memory to be an integer: %v", err)
+		}
+		return n
+	}
+
+	if used := usedMemory(); used != 0 {
+		t.Fatalf("Expected an empty dataset to use no memory, got %d", used)
+	}
+	execute(t, s, client, "SET", "a", strings.Repeat("x", 1000))
+	afterOne := usedMemory()
+	execute(t, s, client, "SET", "b", strings.Repeat("x", 1000))
+	afterTwo := usedMemory()
+	if afterOne < 1000 || afterTwo < afterOne+1000 {
+		t.Fatalf("Expected used_memory to grow by at least 1000 per SET, got %d then %d", afterOne, afterTwo)
+	}
+	execute(t, s, client, "DEL", "b")
+	if used := usedMemory(); used != afterOne {
+		t.Fatalf("Expected used_memory back to %d after DEL, got %d", afterOne, used)
+	}
+	execute(t, s, client, "DEL", "a")
+	if used := usedMemory(); used != 0 {
+		t.Fatalf("Expected used_memory back to 0, got %d", used)
+	}
+
+	info := execute(t, s, client, "INFO", "memory")
+	if policy := infoField(t, info, "maxmemory_policy"); policy != "noeviction" {
+		t.Fatalf("Expected the noeviction policy, got %s", policy)
+	}
+	if human := infoField(t, info, "used_memory_human"); human != "0B" {
+		t.Fatalf("Expected used_memory_human 0B, got %s", human)
+	}
+}
+
+func TestInfoStats(t *testing.T) {
+	s := newTestServer(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go s.Serve(ln)
+	t.Cleanup(s.Shutdown)
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		conn.Write([]byte("PING\r\n"))
+		bufio.NewReader(conn).ReadString('\n')
+		conn.Close()
+	}
+
+	client := newTestClient(t, s)
+	execute(t, s, client, "SET", "present", "value")
+	execute(t, s, client, "GET", "present")
+	execute(t, s, client, "GET", "present")
+	execute(t, s, client, "GET", "missing")
+	execute(t, s, client, "SET", "short-lived", "value", "PX", "1")
+	time.Sleep(5 * time.Millisecond)
+	execute(t, s, client, "SET", "short-lived", "again")
+
+	info := execute(t, s, client, "INFO", "stats")
+	want := map[string]string{
+		// 2 PINGs and the 6 commands above, but not this INFO
+		"total_commands_processed":   "8",
+		"total_connections_received": "2",
+		"keyspace_hits":              "2",
+		"keyspace_misses":            "1",
+		"expired_keys":               "1",
+	}
+	for field, value := range want {
+		if got := infoField(t, info, field); got != value {
+			t.Fatalf("Expected %s:%s, got %s", field, value, got)
+		}
+	}
+}
+
+func TestConfigResetStat(t *testing.T) {
+	s := newTestServer(t)
+	s.startTime = time.Now().Add(-90 * time.Second)
+	s.stats.connectionsReceived.Add(3)
+	client := newTestClient(t, s)
+
+	execute(t, s, client, "SET", "key", "value")
+	execute(t, s, client, "GET", "key")
+	execute(t, s, client, "GET", "missing")
+	if reply := execute(t, s, client, "CONFIG", "RESETSTAT"); reply != protocol.SimpleString("OK") {
+		t.Fatalf("Expected OK, got %v", reply)
+	}
+
+	info := execute(t, s, client, "INFO")
+	want := map[string]string{
+		// RESETSTAT itself is counted before the reset
+		"total_commands_processed":   "0",
+		"keyspace_hits":              "0",
+		"keyspace_misses":            "0",
+		"total_connections_received": "3",
+		"uptime_in_seconds":          "90",
+	}
+	for field, value := range want {
+		if got := infoField(t, info, field); got != value {
+			t.Fatalf("Expected %s:%s after RESETSTAT, got %s", field, value, got)
+		}
+	}
+}
+
+func TestOpsPerSecSampling(t *testing.T) {
+	var st stats
+	st.reset()
+	now := time.Now()
+	st.sampleOps(now)
+	for i := 1; i <= opsSamples; i++ {
+		st.current().commandsProcessed.Add(50)
+		st.sampleOps(now.Add(time.Duration(i) * opsSampleInterval))
+	}
+	// 50 commands every 100ms
+	if ops := st.opsPerSec(); ops != 500 {
+		t.Fatalf("Expected 500 ops per second, got %d", ops)
+	}
+}
+
+func TestSelectErrors(t *testing.T) {
+	s := newTestServer(t)
+	client := newTestClient(t, s)
+	execute(t, s, client, "SELECT", "3")
+
+	tests := []struct {
+		index string
+		want  protocol.RESPValue
+	}{
+		{"-1", protocol.ErrorString("ERR DB index is out of range")},
+		{strconv.Itoa(s.store.Count()), protocol.ErrorString("ERR DB index is out of range")},
+		{"abc", protocol.ErrorString("ERR value is not an integer or out of range")},
+		{"1.5", protocol.ErrorString("ERR value is not an integer or out of range")},
+		{"99999999999999999999", protocol.ErrorString("ERR value is not an integer or out of range")},
+	}
+	for _, tt := range tests {
+		if reply := execute(t, s, client, "SELECT", tt.index); reply != tt.want {
+			t.Fatalf("SELECT %s: expected %q, got %v", tt.index, tt.want, reply)
+		}
+		if client.db != 3 {
+			t.Fatalf("SELECT %s: expected the selected database to stay 3, got %d", tt.index, client.db)
+		}
+	}
+	if reply := execute(t, s, client, "SELECT", strconv.Itoa(s.store.Count()-1)); reply != protocol.SimpleString("OK") {
+		t.Fatalf("Expected the last database to be selectable, got %v", reply)
+	}
+}
+
+// Test that a SELECT applies to the commands pipelined right after it,
+// inline or not, and only on its own connection
+func TestPipelinedSelect(t *testing.T) {
+	s := newTestServer(t)
+	conn, reader := connect(t, s)
+	other, otherReader := connect(t, s)
+
+	pipelines := []struct {
+		db      int
+		request string
+	}{
+		{2, "SELECT 2\r\nSET k v\r\nGET k\r\n"},
+		{5, "*2\r\n$6\r\nSELECT\r\n$1\r\n5\r\n*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n*2\r\n$3\r\nGET\r\n$1\r\nk\r\n"},
+	}
+	for _, p := range pipelines {
+		// The whole batch is written before any reply is read
+		written := make(chan error, 1)
+		go func() {
+			_, err := conn.Write([]byte(p.request))
+			written <- err
+		}()
+		for i, want := range []protocol.RESPValue{protocol.SimpleString("OK"), protocol.SimpleString("OK"), protocol.BulkString("v")} {
+			if _, reply := readFrame(t, reader); !reflect.DeepEqual(reply, want) {
+				t.Fatalf("Expected reply %d to the pipeline selecting %d to be %v, got %v", i, p.db, want, reply)
+			}
+		}
+		if err := <-written; err != nil {
+			t.Fatalf("Unexpected error writing the pipeline: %v", err)
+		}
+		if _, ok := s.store.Get(p.db, "k"); !ok {
+			t.Fatalf("Expected k to be set in db %d", p.db)
+		}
+	}
+	if _, ok := s.store.Get(0, "k"); ok {
+		t.Fatalf("Expected k not to be set in db 0")
+	}
+
+	sendCommand(t, other, "EXISTS", "k")
+	if _, reply := readFrame(t, otherReader); reply != protocol.Integer(0) {
+		t.Fatalf("Expected another connection to stay on db 0, got %v", reply)
+	}
+}
+
+func TestExistsCountsRepeatedKeys(t *testing.T) {
+	s := newTestServer(t)
+	client := newTestClient(t, s)
+
+	execute(t, s, client, "SET", "k", "value")
+	if reply := execute(t, s, client, "EXISTS", "k", "k"); reply != protocol.Integer(2) {
+		t.Fatalf("Expected EXISTS k k to return 2, got %v", reply)
+	}
+	if reply := execute(t, s, client, "EXISTS", "missing", "missing"); reply != protocol.Integer(0) {
+		t.Fatalf("Expected EXISTS on a missing key to return 0, got %v", reply)
+	}
+}
+
+func TestNilReplyWireFormat(t *testing.T) {
+	resp2Proto, resp3Proto := &resp2.RESP2Protocol{}, &resp3.RESP3Protocol{}
+	tests := []struct {
+		proto protocol.Protocol
+		value protocol.RESPValue
+		want  string
+	}{
+		{resp2Proto, resp2Proto.EncodeNil(), "$-1\r\n"},
+		{resp2Proto, protocol.Null{}, "$-1\r\n"},
+		{resp2Proto, protocol.Array(nil), "*-1\r\n"},
+		{resp2Proto, protocol.Array{resp2Proto.EncodeNil()}, "*1\r\n$-1\r\n"},
+		{resp3Proto, resp3Proto.EncodeNil(), "_\r\n"},
+		{resp3Proto, protocol.BulkString(nil), "_\r\n"},
+		{resp3Proto, protocol.Array(nil), "_\r\n"},
+		{resp3Proto, protocol.Array{resp3Proto.EncodeNil()}, "*1\r\n_\r\n"},
+	}
+	for _, tt := range tests {
+		var b strings.Builder
+		w := bufio.NewWriter(&b)
+		if err := tt.proto.Encode(w, tt.value); err != nil {
+			t.Fatalf("%s: unexpected error encoding %#v: %v", tt.proto.Version(), tt.value, err)
+		}
+		w.Flush()
+		if b.String() != tt.want {
+			t.Fatalf("%s: expected %#v to encode as %q, got %q", tt.proto.Version(), tt.value, tt.want, b.String())
+		}
+	}
+
+	s := newTestServer(t)
+	conn, reader := connect(t, s)
+	sendCommand(t, conn, "GET", "missing")
+	if line, _ := reader.ReadString('\n'); line != "$-1\r\n" {
+		t.Fatalf("Expected a RESP2 null bulk string, got %q", line)
+	}
+	sendCommand(t, conn, "HELLO", "3")
+	readFrame(t, reader)
+	sendCommand(t, conn, "GET", "missing")
+	if line, _ := reader.ReadString('\n'); line != "_\r\n" {
+		t.Fatalf("Expected a RESP3 null, got %q", line)
+	}
+}
+
+func TestScoreReplyFraming(t *testing.T) {
+	s := newTestServer(t)
+	conn, reader := connect(t, s)
+	sendCommand(t, conn, "ZADD", "zset", "1.5", "a", "inf", "b")
+
+	// RESP2 clients get bulk strings, RESP3 clients doubles
+	expected := map[string][]string{
+		"2": {"$3\r\n", "1.5\r\n", "$3\r\n", "inf\r\n", "$3\r\n", "2.5\r\n"},
+		"3": {",1.5\r\n", ",inf\r\n", ",2.5\r\n"},
+	}
+	for _, version := range []string{"2", "3"} {
+		sendCommand(t, conn, "HELLO", version)
+		sendCommand(t, conn, "ZSCORE", "zset", "a")
+		sendCommand(t, conn, "ZSCORE", "zset", "b")
+		sendCommand(t, conn, "ZADD", "zset", "INCR", "1", "a")
+		sendCommand(t, conn, "ZADD", "zset", "XX", "1.5", "a")
+	}
+	readFrame(t, reader) // ZADD
+	for _, version := range []string{"2", "3"} {
+		readFrame(t, reader) // HELLO
+		for _, want := range expected[version] {
+			if line, _ := reader.ReadString('\n'); line != want {
+				t.Fatalf("RESP%s: expected %q, got %q", version, want, line)
+			}
+		}
+		readFrame(t, reader) // ZADD XX
+	}
+}
+
+func TestHGetAllFraming(t *testing.T) {
+	s := newTestServer(t)
+	conn, reader := connect(t, s)
+	sendCommand(t, conn, "HSET", "hash", "b", "2", "a", "1")
+	readFrame(t, reader)
+
+	// The same pairs, as a flat array in RESP2 and a map in RESP3, ordered by
+	// field either way
+	pairs := []string{"$1\r\n", "a\r\n", "$1\r\n", "1\r\n", "$1\r\n", "b\r\n", "$1\r\n", "2\r\n"}
+	expected := map[string][]string{
+		"2": append([]string{"*4\r\n"}, pairs...),
+		"3": append([]string{"%2\r\n"}, pairs...),
+	}
+	for _, version := range []string{"2", "3"} {
+		sendCommand(t, conn, "HELLO", version)
+		readFrame(t, reader)
+		sendCommand(t, conn, "HGETALL", "hash")
+		for _, want := range expected[version] {
+			if line, _ := reader.ReadString('\n'); line != want {
+				t.Fatalf("RESP%s: expected %q, got %q", version, want, line)
+			}
+		}
+	}
+
+	sendCommand(t, conn, "CONFIG", "GET", "appendonly", "port")
+	prefix, reply := readFrame(t, reader)
+	config, ok := reply.(protocol.Map)
+	if prefix != '%' || !ok || len(config) != 2 || string(config[protocol.BulkKey("appendonly")].(protocol.BulkString)) != "no" {
+		t.Fatalf("Expected CONFIG GET to reply with a map in RESP3, got %q %v", prefix, reply)
+	}
+}
+
+func TestSetCommands(t *testing.T) {
+	aofFilename := filepath.Join(t.TempDir(), "appendonly.aof")
+	aofChan := make(chan string, 100)
+	errChan := make(chan error, 1)
+	go aof.AOFWriter(aofChan, aofFilename, errChan)
+
+	s := newTestServer(t)
+	s.store = store.NewStore(aofChan)
+	client := newTestClient(t, s)
+	wrongType := protocol.ErrorString("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+	tests := []struct {
+		args []string
+		want protocol.RESPValue
+	}{
+		{[]string{"SADD", "set", "b", "a", "c", "a"}, protocol.Integer(3)},
+		{[]string{"SADD", "set", "c", "d"}, protocol.Integer(1)},
+		{[]string{"SREM", "set", "d", "missing"}, protocol.Integer(1)},
+		{[]string{"SISMEMBER", "set", "a"}, protocol.Integer(1)},
+		{[]string{"SISMEMBER", "set", "d"}, protocol.Integer(0)},
+		{[]string{"SISMEMBER", "missing", "a"}, protocol.Integer(0)},
+		{[]string{"SCARD", "set"}, protocol.Integer(3)},
+		{[]string{"SCARD", "missing"}, protocol.Integer(0)},
+		{[]string{"SREM", "missing", "a"}, protocol.Integer(0)},
+		{[]string{"TYPE", "set"}, protocol.SimpleString("set")},
+		{[]string{"SET", "string", "value"}, protocol.SimpleString("OK")},
+		{[]string{"SADD", "string", "a"}, wrongType},
+		{[]string{"SREM", "string", "a"}, wrongType},
+		{[]string{"SMEMBERS", "string"}, wrongType},
+		{[]string{"SISMEMBER", "string", "a"}, wrongType},
+		{[]string{"SCARD", "string"}, wrongType},
+		{[]string{"SADD", "set"}, protocol.ErrorString("ERR wrong number of arguments for 'SADD' command")},
+	}
+	for _, test := range tests {
+		if reply := execute(t, s, client, test.args...); reply != test.want {
+			t.Fatalf("%v: expected %v, got %v", test.args, test.want, reply)
+		}
+	}
+	if members := execute(t, s, client, "SMEMBERS", "missing").(protocol.Array); len(members) != 0 {
+		t.Fatalf("Expected no members for a missing key, got %v", members)
+	}
+
+	// The same members, as an array in RESP2 and a set in RESP3
+	conn, reader := connect(t, s)
+	members := []string{"$1\r\n", "a\r\n", "$1\r\n", "b\r\n", "$1\r\n", "c\r\n"}
+	expected := map[string][]string{
+		"2": append([]string{"*3\r\n"}, members...),
+		"3": append([]string{"~3\r\n"}, members...),
+	}
+	for _, version := range []string{"2", "3"} {
+		sendCommand(t, conn, "HELLO", version)
+		readFrame(t, reader)
+		sendCommand(t, conn, "SMEMBERS", "set")
+		for _, want := range expected[version] {
+			if line, _ := reader.ReadString('\n'); line != want {
+				t.Fatalf("RESP%s: expected %q, got %q", version, want, line)
+			}
+		}
+	}
+
+	// Sets survive a restart
+	close(aofChan)
+	if err := <-errChan; err != nil {
+		t.Fatalf("Unexpected AOF error: %v", err)
+	}
+	restored := rebuildFromAOF(t, aofFilename).store
+	if members, err := restored.SMembers(0, "set"); err != nil || !slices.Equal(members, []string{"a", "b", "c"}) {
+		t.Fatalf("Expected [a b c] after the rebuild, got %v (%v)", members, err)
+	}
+}
+
+func TestServerName(t *testing.T) {
+	s := newTestServer(t)
+	if s.config.ServerName != "goodiesdb" {
+		t.Fatalf("Expected the default server name goodiesdb, got %q", s.config.ServerName)
+	}
+	s.config.ServerName = "cache-eu-1"
+	client := newTestClient(t, s)
+
+	if name := infoField(t, execute(t, s, client, "INFO", "server"), "server_name"); name != "cache-eu-1" {
+		t.Fatalf("Expected server_name:cache-eu-1 in INFO, got %q", name)
+	}
+	hello, ok := execute(t, s, client, "HELLO", "3").(protocol.Map)
+	if !ok {
+		t.Fatalf("Expected a map from HELLO 3, got %v", hello)
+	}
+	if name, _ := hello[protocol.SimpleString("server_name")].(protocol.BulkString); string(name) != "cache-eu-1" {
+		t.Fatalf("Expected server_name cache-eu-1 in HELLO, got %v", hello)
+	}
+}
+
+func TestInfoFraming(t *testing.T) {
+	s := newTestServer(t)
+	conn, reader := connect(t, s)
+
+	// Preformatted text is a bulk string in RESP2 and a verbatim string in RESP3
+	prefixes := map[string]byte{"2": '$', "3": '='}
+	for _, version := range []string{"2", "3"} {
+		want := prefixes[version]
+		sendCommand(t, conn, "HELLO", version)
+		readFrame(t, reader)
+		sendCommand(t, conn, "INFO", "server")
+		prefix, reply := readFrame(t, reader)
+		if prefix != want {
+			t.Fatalf("RESP%s: expected INFO to start with %q, got %q", version, want, prefix)
+		}
+		text := ""
+		switch reply := reply.(type) {
+		case protocol.BulkString:
+			text = string(reply)
+		case protocol.VerbatimString:
+			if reply.Format != "txt" {
+				t.Fatalf("Expected the txt format, got %q", reply.Format)
+			}
+			text = reply.Text
+		}
+		if !strings.HasPrefix(text, "# Server") {
+			t.Fatalf("RESP%s: expected the server section, got %q", version, text)
+		}
+	}
+}
+
+func TestDebugObjectQuicklistNodes(t *testing.T) {
+	s := newTestServer(t)
+	s.config.EnableDebug = true
+	s.config.ListMaxListpackSize = 4
+	limits := store.DefaultEncodingLimits()
+	limits.ListMaxListpackSize = 4
+	s.store.SetEncodingLimits(limits)
+	client := newTestClient(t, s)
+
+	// A single node is a plain listpack, without quicklist details
+	execute(t, s, client, "RPUSH", "list", "a", "b", "c", "d")
+	if reply := string(execute(t, s, client, "DEBUG", "OBJECT", "list").(protocol.SimpleString)); strings.Contains(reply, "ql_nodes") {
+		t.Fatalf("Expected no quicklist details for a listpack, got %q", reply)
+	}
+
+	execute(t, s, client, "RPUSH", "list", "e", "f", "g", "h", "i", "j")
+	reply := string(execute(t, s, client, "DEBUG", "OBJECT", "list").(protocol.SimpleString))
+	if !strings.Contains(reply, "encoding:quicklist") || !strings.Contains(reply, " ql_nodes:3 ql_avg_node:3.33 ql_listpack_max:4 ") {
+		t.Fatalf("Expected 10 entries in 3 nodes of at most 4, got %q", reply)
+	}
+
+	s.config.EnableDebug = false
+	if _, ok := execute(t, s, client, "DEBUG", "OBJECT", "list").(protocol.ErrorString); !ok {
+		t.Fatalf("Expected DEBUG to be refused without ENABLE_DEBUG")
+	}
+}
+
+func TestListMemoryUsage(t *testing.T) {
+	s := newTestServer(t)
+	s.config.EnableDebug = true
+	client := newTestClient(t, s)
+	element := strings.Repeat("x", 100)
+
+	var sizes []int64
+	transition := 0
+	for n := 1; n <= 120; n++ {
+		execute(t, s, client, "RPUSH", "list", element)
+		sizes = append(sizes, int64(execute(t, s, client, "MEMORY", "USAGE", "list").(protocol.Integer)))
+		encoding := string(execute(t, s, client, "OBJECT", "ENCODING", "list").(protocol.BulkString))
+		if encoding == "quicklist" && transition == 0 {
+			transition = n
+		} else if encoding == "listpack" && transition != 0 {
+			t.Fatalf("Expected the list to stay a quicklist after %d elements, got listpack at %d", transition, n)
+		}
+	}
+	if transition == 0 {
+		t.Fatalf("Expected the list to become a quicklist past 8kb")
+	}
+
+	step := sizes[1] - sizes[0]
+	for i := 1; i < len(sizes); i++ {
+		growth := sizes[i] - sizes[i-1]
+		if i+1 == transition {
+			if growth <= step {
+				t.Fatalf("Expected memory to jump when becoming a quicklist, grew by %d (step %d)", growth, step)
+			}
+		} else if growth < step || growth > step+1 {
+			t.Fatalf("Expected memory to grow by about %d per element, grew by %d at %d", step, growth, i+1)
+		}
+	}
+
+	if reply := execute(t, s, client, "DEBUG", "LISTPACK-ENTRIES", "list"); reply != protocol.Integer(120) {
+		t.Fatalf("Expected 120 listpack entries, got %v", reply)
+	}
+	execute(t, s, client, "SET", "string", "value")
+	if reply, ok := execute(t, s, client, "DEBUG", "LISTPACK-ENTRIES", "string").(protocol.ErrorString); !ok || !strings.HasPrefix(string(reply), "WRONGTYPE") {
+		t.Fatalf("Expected WRONGTYPE, got %v", reply)
+	}
+}
+
+func TestDirtyCounterResetsOnSave(t *testing.T) {
+	s := newTestServer(t)
+	client := newTestClient(t, s)
+
+	execute(t, s, client, "SET", "key", "value")
+	execute(t, s, client, "LPUSH", "list", "a", "b")
+	execute(t, s, client, "DEL", "key")
+	execute(t, s, client, "GET", "list")
+	if dirty := s.store.Dirty(); dirty != 3 {
+		t.Fatalf("Expected 3 dirty writes, got %d", dirty)
+	}
+
+	execute(t, s, client, "SELECT", "1")
+	execute(t, s, client, "SET", "key", "value")
+	if dirty := s.store.DBDirty(1); dirty != 1 {
+		t.Fatalf("Expected 1 dirty write in db 1, got %d", dirty)
+	}
+	if dirty := s.store.Dirty(); dirty != 4 {
+		t.Fatalf("Expected 4 dirty writes, got %d", dirty)
+	}
+
+	if reply := execute(t, s, client, "SAVE"); reply != protocol.SimpleString("OK") {
+		t.Fatalf("Expected OK, got %v", reply)
+	}
+	if dirty := s.store.Dirty(); dirty != 0 {
+		t.Fatalf("Expected SAVE to reset the dirty counter, got %d", dirty)
+	}
+	if _, err := os.Stat(s.rdbFilepath()); err != nil {
+		t.Fatalf("Expected SAVE to write the snapshot: %v", err)
+	}
+}
+
+func TestShutdownWaitsForAOFDrain(t *testing.T) {
+	config := NewConfig()
+	config.DataDir = t.TempDir()
+	config.UseRDB = false
+	config.UseAOF = true
+	s := NewServer(config)
+
+	// Fill the AOF channel before the writer starts, so every record is
+	// still in flight when Shutdown closes the channel
+	client := newTestClient(t, s)
+	writes := cap(s.store.AOFChannel())
+	for i := 0; i < writes; i++ {
+		execute(t, s, client, "SET", fmt.Sprintf("key:%d", i), strconv.Itoa(i))
+	}
+	s.startAOF(s.aofFilepath())
+	s.Shutdown()
+
+	restored := rebuildFromAOF(t, s.aofFilepath())
+	for i := 0; i < writes; i++ {
+		key := fmt.Sprintf("key:%d", i)
+		if value, ok := restored.store.Get(0, key); !ok || value.Data.(string) != strconv.Itoa(i) {
+			t.Fatalf("Expected %s to be in the AOF after Shutdown, got %v", key, value)
+		}
+	}
+}
+
+func TestShutdownSavesSnapshotInDataDir(t *testing.T) {
+	config := NewConfig()
+	config.DataDir = t.TempDir()
+	config.UseRDB = true
+	config.UseAOF = false
+	s := NewServer(config)
+	s.startRDB()
+	client := newTestClient(t, s)
+	execute(t, s, client, "SET", "key", "value")
+
+	done := make(chan struct{})
+	go func() {
+		s.Shutdown()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected Shutdown to stop the RDB goroutine and return")
+	}
+
+	if _, err := os.Stat(filepath.Join(config.DataDir, "dump.rdb")); err != nil {
+		t.Fatalf("Expected the snapshot in the data directory: %v", err)
+	}
+	restored := NewServer(config)
+	restored.recoverStore()
+	if value, ok := restored.store.Get(0, "key"); !ok || value.Data.(string) != "value" {
+		t.Fatalf("Expected the snapshot to hold key, got %v", value)
+	}
+
+	// A second Shutdown is harmless
+	s.Shutdown()
+}
+
+func TestRecoveryPreference(t *testing.T) {
+	dataDir := t.TempDir()
+
+	// The snapshot holds an older value than the AOF
+	snapshot := store.NewStore(nil)
+	snapshot.Set(0, "key", "from rdb")
+	if err := rdb.SaveSnapshot(snapshot, filepath.Join(dataDir, "dump.rdb")); err != nil {
+		t.Fatalf("Unexpected error saving the snapshot: %v", err)
+	}
+	aofChan := make(chan string, 100)
+	errChan := make(chan error, 1)
+	go aof.AOFWriter(aofChan, filepath.Join(dataDir, "appendonly.aof"), errChan)
+	store.NewStore(aofChan).Set(0, "key", "from aof")
+	close(aofChan)
+	if err := <-errChan; err != nil {
+		t.Fatalf("Unexpected AOF error: %v", err)
+	}
+
+	tests := []struct {
+		preference string
+		want       string
+	}{
+		{RecoveryAOFPreferred, "from aof"},
+		{RecoveryRDBPreferred, "from rdb"},
+	}
+	for _, tt := range tests {
+		config := NewConfig()
+		config.DataDir = dataDir
+		config.RecoveryPreference = tt.preference
+		s := NewServer(config)
+		s.recoverStore()
+		if value, ok := s.store.Get(0, "key"); !ok || value.Data.(string) != tt.want {
+			t.Fatalf("%s: expected %q, got %v", tt.preference, tt.want, value)
+		}
+	}
+
+	// The other file is the fallback when the preferred one is missing
+	os.Remove(filepath.Join(dataDir, "appendonly.aof"))
+	config := NewConfig()
+	config.DataDir = dataDir
+	s := NewServer(config)
+	s.recoverStore()
+	if value, ok := s.store.Get(0, "key"); !ok || value.Data.(string) != "from rdb" {
+		t.Fatalf("Expected the RDB snapshot without an AOF, got %v", value)
+	}
+}
+
+// Test that the records replayed before a corrupt AOF record are dropped,
+// whether the snapshot is loaded instead or the store starts empty
+func TestRecoveryDropsPartialLoad(t *testing.T) {
+	dataDir := t.TempDir()
+	snapshot := store.NewStore(nil)
+	snapshot.Set(0, "key", "from rdb")
+	if err := rdb.SaveSnapshot(snapshot, filepath.Join(dataDir, "dump.rdb")); err != nil {
+		t.Fatalf("Unexpected error saving the snapshot: %v", err)
+	}
+	aofFilename := filepath.Join(dataDir, "appendonly.aof")
+	aofChan := make(chan string, 100)
+	errChan := make(chan error, 1)
+	go aof.AOFWriter(aofChan, aofFilename, errChan)
+	store.NewStore(aofChan).Set(0, "partial", "from aof")
+	close(aofChan)
+	if err := <-errChan; err != nil {
+		t.Fatalf("Unexpected AOF error: %v", err)
+	}
+	file, err := os.OpenFile(aofFilename, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Unexpected error opening the AOF: %v", err)
+	}
+	// A malformed length, followed by more records
+	file.WriteString("*1\r\n$x\r\nDEL\r\n*1\r\n$8\r\nFLUSHALL\r\n")
+	file.Close()
+
+	config := NewConfig()
+	config.DataDir = dataDir
+	config.RecoveryPreference = RecoveryAOFPreferred
+	s := NewServer(config)
+	s.recoverStore()
+	if _, ok := s.store.Get(0, "partial"); ok {
+		t.Fatalf("Expected the records of the failed AOF load to be dropped")
+	}
+	if value, ok := s.store.Get(0, "key"); !ok || value.Data.(string) != "from rdb" {
+		t.Fatalf("Expected the snapshot to be loaded, got %v", value)
+	}
+
+	// Without a snapshot the store is left empty
+	os.Remove(filepath.Join(dataDir, "dump.rdb"))
+	s = NewServer(config)
+	s.recoverStore()
+	if _, ok := s.store.Get(0, "partial"); ok {
+		t.Fatalf("Expected an empty store after the failed AOF load")
+	}
+}
+
+// rebuildFromAOF replays an AOF file into a new test server
+func rebuildFromAOF(t *testing.T, filename string) *Server {
+	t.Helper()
+	s := newTestServer(t)
+	if err := aof.RebuildStoreFromAOF(s.store, filename, s.replay); err != nil {
+		t.Fatalf("Failed to rebuild state from AOF: %v", err)
+	}
+	return s
+}
+
+func TestRebuildStoreFromAOF(t *testing.T) {
+	aofFilename := filepath.Join(t.TempDir(), "appendonly.aof")
+	aofChan := make(chan string, 100)
+	errChan := make(chan error, 1)
+	go aof.AOFWriter(aofChan, aofFilename, errChan)
+
+	// Initialize the store with AOF logging
+	s := store.NewStore(aofChan)
+
+	dbIndex := 0
+
+	// Set and expire commands
+	s.Set(dbIndex, "Key1", "Value1")
+	s.Set(dbIndex, "Key2", "Value2")
+	s.Expire(dbIndex, "Key1", 3*time.Second)
+
+	// SETNX command
+	s.SetNX(dbIndex, "Key3", "Value3")   // Should succeed
+	s.SetNX(dbIndex, "Key3", "NewValue") // Should fail because Key3 already exists
+
+	// List commands
+	s.LPush(dbIndex, "List1", "Value1", "Value2", "Value3")
+	s.RPush(dbIndex, "List1", "Value4")
+	s.LPop(dbIndex, "List1", nil)
+	s.RPop(dbIndex, "List1", nil)
+
+	// List trimming commands
+	s.LTrim(dbIndex, "List1", 1, 2)
+
+	// Rename command
+	s.Rename(dbIndex, "Key2", "RenamedKey")
+	close(aofChan)
+	if err := <-errChan; err != nil {
+		t.Fatalf("Unexpected AOF error: %v", err)
+	}
+
+	newStore := rebuildFromAOF(t, aofFilename).store
+
+	// Verify Key2 has been renamed to RenamedKey
+	value, ok := newStore.Get(dbIndex, "RenamedKey")
+	if !ok || value.Data.(string) != "Value2" {
+		t.Fatalf("Expected Value2 for RenamedKey, got %v", value)
+	}
+	if value, ok := newStore.Get(dbIndex, "Key3"); !ok || value.Data.(string) != "Value3" {
+		t.Fatalf("Expected Value3 for Key3, got %v", value)
+	}
+
+	// Verify List1 contents
+	list, _ := newStore.LRange(dbIndex, "List1", 0, -1)
+	expectedList := []string{"Value1"}
+	if !slices.Equal(list, expectedList) {
+		t.Fatalf("Expected %v, got %v", expectedList, list)
+	}
+
+	// Wait for the key to expire
+	time.Sleep(4 * time.Second)
+
+	// Verify Key1 exists after it expires
+	if newStore.Exists(dbIndex, "Key1") > 0 {
+		t.Fatalf("Expected Key1 to be expired after waiting more than 3 seconds")
+	}
+}
+
+// Test that rebuilding from an AOF doesn't append the replayed writes to the
+// AOF the store logs to
+func TestRebuildDoesNotEchoWrites(t *testing.T) {
+	dataDir := t.TempDir()
+	aofFilename := filepath.Join(dataDir, "appendonly.aof")
+	aofChan := make(chan string, 100)
+	errChan := make(chan error, 1)
+	go aof.AOFWriter(aofChan, aofFilename, errChan)
+	s := store.NewStore(aofChan)
+	for i := 0; i < 500; i++ {
+		s.Set(0, "key"+strconv.Itoa(i), "value")
+	}
+	close(aofChan)
+	if err := <-errChan; err != nil {
+		t.Fatalf("Unexpected AOF error: %v", err)
+	}
+	before, err := os.Stat(aofFilename)
+	if err != nil {
+		t.Fatalf("Unexpected error reading the AOF: %v", err)
+	}
+
+	// The rebuilt server logs to the same file, as after a restart. More
+	// records than the channel holds would also block without a writer.
+	newAOFChan := make(chan string, 100)
+	newErrChan := make(chan error, 1)
+	go aof.AOFWriter(newAOFChan, aofFilename, newErrChan)
+	server := newTestServer(t)
+	server.store = store.NewStore(newAOFChan)
+	if err := aof.RebuildStoreFromAOF(server.store, aofFilename, server.replay); err != nil {
+		t.Fatalf("Failed to rebuild state from AOF: %v", err)
+	}
+	client := newTestClient(t, server)
+	execute(t, server, client, "SET", "after", "rebuild")
+	close(newAOFChan)
+	if err := <-newErrChan; err != nil {
+		t.Fatalf("Unexpected AOF error: %v", err)
+	}
+
+	if keys, _ := server.store.Keys(0, "*"); len(keys) != 501 {
+		t.Fatalf("Expected 501 keys after the rebuild, got %d", len(keys))
+	}
+	after, err := os.Stat(aofFilename)
+	if err != nil {
+		t.Fatalf("Unexpected error reading the AOF: %v", err)
+	}
+	// Only the write made after the rebuild is appended
+	record := "*4\r\n$3\r\nSET\r\n$1\r\n0\r\n$5\r\nafter\r\n$7\r\nrebuild\r\n"
+	if after.Size() != before.Size()+int64(len(record)) {
+		t.Fatalf("Expected the AOF to grow by %d bytes, from %d to %d", len(record), before.Size(), after.Size())
+	}
+}
+
+func TestReplayRename(t *testing.T) {
+	s := newTestServer(t)
+	s.store.Set(0, "Key1", "value1")
+	if err := s.replay(0, []string{"RENAME", "Key1", "newName"}); err != nil {
+		t.Fatalf("Unexpected error replaying RENAME: %v", err)
+	}
+	value, ok := s.store.Get(0, "newName")
+	if !ok || value.Data.(string) != "value1" {
+		t.Fatalf("Expected 'value1', got %v", value)
+	}
+}
+
+func TestReplayLTrim(t *testing.T) {
+	s := newTestServer(t)
+	s.store.LPush(0, "List1", "Value1", "Value2", "Value3")
+	if err := s.replay(0, []string{"LTRIM", "List1", "1", "2"}); err != nil {
+		t.Fatalf("Unexpected error replaying LTRIM: %v", err)
+	}
+	list, _ := s.store.LRange(0, "List1", 0, -1)
+	expectedList := []string{"Value2", "Value1"}
+	if !slices.Equal(list, expectedList) {
+		t.Fatalf("Expected %v, got %v", expectedList, list)
+	}
+}
+
+func TestReplayRejectsReadsAndBadDatabases(t *testing.T) {
+	s := newTestServer(t)
+	if err := s.replay(0, []string{"GET", "key"}); err == nil {
+		t.Fatalf("Expected replaying a read command to fail")
+	}
+	if err := s.replay(s.store.Count(), []string{"SET", "key", "value"}); err == nil {
+		t.Fatalf("Expected replaying into a missing database to fail")
+	}
+	if err := s.replay(0, []string{"INCR", "key", "extra"}); err == nil {
+		t.Fatalf("Expected an error reply to be reported")
+	}
+}
+
+// Test that write commands replay through the dispatcher without a handler
+// written for the AOF: INCR, DECR and FLUSHDB were logged but not replayed
+// before the dispatcher was shared
+func TestRebuildReplaysCommandsWithoutDedicatedHandler(t *testing.T) {
+	aofFilename := filepath.Join(t.TempDir(), "appendonly.aof")
+	aofChan := make(chan string, 100)
+	errChan := make(chan error, 1)
+	go aof.AOFWriter(aofChan, aofFilename, errChan)
+
+	s := newTestServer(t)
+	s.store = store.NewStore(aofChan)
+	client := newTestClient(t, s)
+	execute(t, s, client, "SET", "flushed", "value")
+	execute(t, s, client, "FLUSHDB")
+	execute(t, s, client, "INCR", "counter")
+	execute(t, s, client, "INCR", "counter")
+	execute(t, s, client, "DECR", "counter")
+	execute(t, s, client, "SET", "expiring", "value")
+	execute(t, s, client, "PEXPIREAT", "expiring", "99999999999999")
+	execute(t, s, client, "SET", "expiring-seconds", "value")
+	execute(t, s, client, "EXPIREAT", "expiring-seconds", "99999999999")
+	execute(t, s, client, "SET", "expired", "value")
+	if reply := execute(t, s, client, "EXPIREAT", "expired", "1"); reply != protocol.Integer(1) {
+		t.Fatalf("Expected EXPIREAT in the past to reply 1, got %v", reply)
+	}
+	execute(t, s, client, "SET", "persisted", "value", "EX", "100")
+	execute(t, s, client, "PERSIST", "persisted")
+	close(aofChan)
+	if err := <-errChan; err != nil {
+		t.Fatalf("Unexpected AOF error: %v", err)
+	}
+
+	newStore := rebuildFromAOF(t, aofFilename).store
+	if newStore.Exists(0, "flushed") != 0 {
+		t.Fatalf("Expected FLUSHDB to be replayed")
+	}
+	if value, ok := newStore.Get(0, "counter"); !ok || fmt.Sprint(value.Data) != "1" {
+		t.Fatalf("Expected the counter to be 1 after the rebuild, got %v", value)
+	}
+	if value, ok := newStore.Get(0, "expiring"); !ok || value.ExpiresAt == nil || value.ExpiresAt.UnixMilli() != 99999999999999 {
+		t.Fatalf("Expected PEXPIREAT to be replayed, got %v", value)
+	}
+	if value, ok := newStore.Get(0, "expiring-seconds"); !ok || value.ExpiresAt == nil || value.ExpiresAt.UnixMilli() != 99999999999000 {
+		t.Fatalf("Expected EXPIREAT to be replayed, got %v", value)
+	}
+	if newStore.Exists(0, "expired") != 0 {
+		t.Fatalf("Expected the key expired by EXPIREAT to stay deleted")
+	}
+	if value, ok := newStore.Get(0, "persisted"); !ok || value.ExpiresAt != nil {
+		t.Fatalf("Expected PERSIST to be replayed, got %v", value)
+	}
+}
+
+// Test that PEXPIRE keeps its milliseconds, live and through a rebuild
+func TestPExpireKeepsMilliseconds(t *testing.T) {
+	aofFilename := filepath.Join(t.TempDir(), "appendonly.aof")
+	aofChan := make(chan string, 100)
+	errChan := make(chan error, 1)
+	go aof.AOFWriter(aofChan, aofFilename, errChan)
+
+	s := newTestServer(t)
+	s.store = store.NewStore(aofChan)
+	client := newTestClient(t, s)
+	execute(t, s, client, "SET", "key", "value")
+	if reply := execute(t, s, client, "PEXPIRE", "key", "1500"); reply != protocol.Integer(1) {
+		t.Fatalf("Expected PEXPIRE to set the TTL, got %v", reply)
+	}
+	if reply := execute(t, s, client, "PEXPIRE", "missing", "1500"); reply != protocol.Integer(0) {
+		t.Fatalf("Expected PEXPIRE on a missing key to reply 0, got %v", reply)
+	}
+	if reply := execute(t, s, client, "PEXPIRE", "key", "9223372036854775807"); reply != protocol.ErrorString("ERR invalid expire time in 'pexpire' command") {
+		t.Fatalf("Expected PEXPIRE to reject an overflowing TTL, got %v", reply)
+	}
+	if reply, ok := execute(t, s, client, "PTTL", "key").(protocol.Integer); !ok || reply <= 1000 || reply > 1500 {
+		t.Fatalf("Expected a PTTL between 1000 and 1500, got %v", reply)
+	}
+	close(aofChan)
+	if err := <-errChan; err != nil {
+		t.Fatalf("Unexpected AOF error: %v", err)
+	}
+
+	newStore := rebuildFromAOF(t, aofFilename).store
+	if pttl, err := newStore.PTTL(0, "key"); err != nil || pttl <= 1000 || pttl > 1500 {
+		t.Fatalf("Expected a PTTL between 1000 and 1500 after the rebuild, got %d (%v)", pttl, err)
+	}
+}
+
+// Test that list elements with spaces and newlines survive an AOF rebuild
+func TestRebuildListWithBinaryElements(t *testing.T) {
+	aofFilename := filepath.Join(t.TempDir(), "appendonly.aof")
+	aofChan := make(chan string, 100)
+	go aof.AOFWriter(aofChan, aofFilename, nil)
+
+	s := store.NewStore(aofChan)
+	dbIndex := 0
+
+	s.RPush(dbIndex, "list", "two words", "line\nbreak", "crlf\r\nend")
+	s.LPush(dbIndex, "list", " leading space", "")
+	s.Set(dbIndex, "multi word key", "multi word value")
+
+	// Give some time for commands to be written to AOF
+	time.Sleep(500 * time.Millisecond)
+
+	newStore := rebuildFromAOF(t, aofFilename).store
+
+	list, _ := newStore.LRange(dbIndex, "list", 0, -1)
+	expected := []string{"", " leading space", "two words", "line\nbreak", "crlf\r\nend"}
+	listStr := list
+	if !slices.Equal(listStr, expected) {
+		t.Fatalf("Expected %q, got %q", expected, listStr)
+	}
+
+	value, ok := newStore.Get(dbIndex, "multi word key")
+	if !ok || value.Data.(string) != "multi word value" {
+		t.Fatalf("Expected 'multi word value', got %v", value)
+	}
+}
+
+// Test that renaming over an existing key replays to the same state
+func TestRebuildRenameOverExistingKey(t *testing.T) {
+	aofFilename := filepath.Join(t.TempDir(), "appendonly.aof")
+	aofChan := make(chan string, 100)
+	errChan := make(chan error, 1)
+	go aof.AOFWriter(aofChan, aofFilename, errChan)
+
+	s := store.NewStore(aofChan)
+	s.Set(0, "a", "value of a")
+	s.Set(0, "b", "value of b")
+	if err := s.Rename(0, "a", "b"); err != nil {
+		t.Fatalf("Unexpected error renaming: %v", err)
+	}
+	s.Rename(0, "b", "b")
+	close(aofChan)
+	if err := <-errChan; err != nil {
+		t.Fatalf("Unexpected AOF error: %v", err)
+	}
+
+	newStore := rebuildFromAOF(t, aofFilename).store
+	if newStore.Exists(0, "a") != 0 {
+		t.Fatalf("Expected a to be gone after the rebuild")
+	}
+	value, ok := newStore.Get(0, "b")
+	if !ok || value.Data.(string) != "value of a" {
+		t.Fatalf("Expected b to hold the value of a, got %v", value)
+	}
+}
+
+func TestRebuildCopyKeepsAbsoluteExpiry(t *testing.T) {
+	aofFilename := filepath.Join(t.TempDir(), "appendonly.aof")
+	aofChan := make(chan string, 100)
+	errChan := make(chan error, 1)
+	go aof.AOFWriter(aofChan, aofFilename, errChan)
+
+	s := store.NewStore(aofChan)
+	s.Set(0, "source", "value")
+	s.Expire(0, "source", 10*time.Second)
+	s.Copy(0, "source", 1, "copy", false)
+	close(aofChan)
+	if err := <-errChan; err != nil {
+		t.Fatalf("Unexpected AOF error: %v", err)
+	}
+	original, _ := s.Get(1, "copy")
+
+	newStore := rebuildFromAOF(t, aofFilename).store
+	value, ok := newStore.Get(1, "copy")
+	if !ok || value.Data.(string) != "value" || value.ExpiresAt == nil {
+		t.Fatalf("Expected the copy with a TTL after the rebuild, got %v", value)
+	}
+	if value.ExpiresAt.UnixMilli() != original.ExpiresAt.UnixMilli() {
+		t.Fatalf("Expected the copy to expire at %v after the rebuild, got %v", original.ExpiresAt, value.ExpiresAt)
+	}
+}
+
+func TestRebuildGetDelGetEx(t *testing.T) {
+	aofFilename := filepath.Join(t.TempDir(), "appendonly.aof")
+	aofChan := make(chan string, 100)
+	errChan := make(chan error, 1)
+	go aof.AOFWriter(aofChan, aofFilename, errChan)
+
+	s := store.NewStore(aofChan)
+	s.Set(0, "deleted", "value")
+	s.Set(0, "expiring", "value")
+	s.Set(0, "persisted", "value", "EX", "100")
+	s.Set(0, "read", "value")
+	s.GetEx(0, "read", &store.GetExOptions{})
+	s.GetDel(0, "deleted")
+	s.GetEx(0, "expiring", &store.GetExOptions{SetOptions: store.SetOptions{EX: 100}})
+	s.GetEx(0, "persisted", &store.GetExOptions{PERSIST: true})
+	close(aofChan)
+	if err := <-errChan; err != nil {
+		t.Fatalf("Unexpected AOF error: %v", err)
+	}
+	original, _ := s.Get(0, "expiring")
+
+	newStore := rebuildFromAOF(t, aofFilename).store
+	if _, ok := newStore.Get(0, "deleted"); ok {
+		t.Fatalf("Expected the GETDEL key to stay deleted after the rebuild")
+	}
+	value, ok := newStore.Get(0, "expiring")
+	if !ok || value.ExpiresAt == nil || value.ExpiresAt.UnixMilli() != original.ExpiresAt.UnixMilli() {
+		t.Fatalf("Expected the GETEX key to expire at %v after the rebuild, got %v", original.ExpiresAt, value)
+	}
+	value, ok = newStore.Get(0, "persisted")
+	if !ok || value.ExpiresAt != nil {
+		t.Fatalf("Expected the GETEX PERSIST key to have no TTL after the rebuild, got %v", value)
+	}
+	if ttl, _ := newStore.TTL(0, "read"); ttl != -1 {
+		t.Fatalf("Expected the plain read key to have no TTL after the rebuild, got %d", ttl)
+	}
+}
+
+func TestRebuildListMutators(t *testing.T) {
+	aofFilename := filepath.Join(t.TempDir(), "appendonly.aof")
+	aofChan := make(chan string, 100)
+	errChan := make(chan error, 1)
+	go aof.AOFWriter(aofChan, aofFilename, errChan)
+
+	s := store.NewStore(aofChan)
+	s.RPush(0, "list", "a", "b", "c", "b", "d", "b")
+	s.LPush(0, "list", "z", "y")
+	s.LPop(0, "list", nil)
+	s.RPop(0, "list", nil)
+	s.LSet(0, "list", -1, "last")
+	s.LInsert(0, "list", true, "c", "before c")
+	s.LInsert(0, "list", false, "c", "after c")
+	s.LRem(0, "list", -1, "b")
+	s.LMove(0, "list", "other", true, false)
+	s.LMove(0, "list", "other", false, true)
+	s.LTrim(0, "list", 0, 3)
+	close(aofChan)
+	if err := <-errChan; err != nil {
+		t.Fatalf("Unexpected AOF error: %v", err)
+	}
+
+	newStore := rebuildFromAOF(t, aofFilename).store
+	for _, key := range []string{"list", "other"} {
+		expected, _ := s.LRange(0, key, 0, -1)
+		actual, _ := newStore.LRange(0, key, 0, -1)
+		if !slices.Equal(actual, expected) {
+			t.Fatalf("Expected %s to be %q after the rebuild, got %q", key, expected, actual)
+		}
+	}
+}
+
+func TestRebuildKeepsDatabases(t *testing.T) {
+	aofFilename := filepath.Join(t.TempDir(), "appendonly.aof")
+	aofChan := make(chan string, 100)
+	errChan := make(chan error, 1)
+	go aof.AOFWriter(aofChan, aofFilename, errChan)
+
+	s := newTestServer(t)
+	s.store = store.NewStore(aofChan)
+	client := newTestClient(t, s)
+	for _, db := range []string{"0", "3", "7"} {
+		execute(t, s, client, "SELECT", db)
+		execute(t, s, client, "SET", "key", "value in "+db)
+		execute(t, s, client, "RPUSH", "list:"+db, "a", "b")
+	}
+	// Writes after switching back land in the database selected last
+	execute(t, s, client, "SELECT", "3")
+	execute(t, s, client, "DEL", "list:3")
+	execute(t, s, client, "SELECT", "7")
+	execute(t, s, client, "FLUSHDB")
+	execute(t, s, client, "SET", "after flush", "value")
+	close(aofChan)
+	if err := <-errChan; err != nil {
+		t.Fatalf("Unexpected AOF error: %v", err)
+	}
+
+	newStore := rebuildFromAOF(t, aofFilename).store
+	expected := map[int][]string{
+		0: {"key", "list:0"},
+		3: {"key"},
+		7: {"after flush"},
+	}
+	if actual := newStore.AllKeys("*"); !maps.EqualFunc(actual, expected, slices.Equal) {
+		t.Fatalf("Expected the keys %q after the rebuild, got %q", expected, actual)
+	}
+	for _, db := range []int{0, 3} {
+		if value, ok := newStore.Get(db, "key"); !ok || value.Data.(string) != fmt.Sprintf("value in %d", db) {
+			t.Fatalf("Expected key in db %d to be restored, got %v", db, value)
+		}
+	}
+}
+
+func TestZAddFlags(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		want  protocol.RESPValue
+		score string // score of member afterwards
+	}{
+		{"GT INCR applied", []string{"GT", "CH", "INCR", "5", "member"}, protocol.BulkString("15"), "15"},
+		{"GT INCR skipped", []string{"GT", "INCR", "-5", "member"}, protocol.BulkString(nil), "10"},
+		{"LT INCR applied", []string{"LT", "INCR", "-5", "member"}, protocol.BulkString("5"), "5"},
+		{"NX INCR skipped", []string{"NX", "INCR", "5", "member"}, protocol.BulkString(nil), "10"},
+		{"XX INCR on a new member", []string{"XX", "INCR", "5", "other"}, protocol.BulkString(nil), "10"},
+		{"GT update", []string{"GT", "CH", "20", "member"}, protocol.Integer(1), "20"},
+		{"GT no update", []string{"GT", "CH", "1", "member"}, protocol.Integer(0), "10"},
+		{"GT still adds", []string{"GT", "1", "other"}, protocol.Integer(1), "10"},
+		{"LT update without CH", []string{"LT", "1", "member"}, protocol.Integer(0), "1"},
+		{"XX update", []string{"XX", "CH", "3", "member", "4", "other"}, protocol.Integer(1), "3"},
+		{"GT NX rejected", []string{"GT", "NX", "5", "member"}, protocol.ErrorString("ERR GT, LT, and/or NX options at the same time are not compatible"), "10"},
+		{"GT LT rejected", []string{"GT", "LT", "5", "member"}, protocol.ErrorString("ERR GT, LT, and/or NX options at the same time are not compatible"), "10"},
+		{"NX XX rejected", []string{"NX", "XX", "5", "member"}, protocol.ErrorString("ERR XX and NX options at the same time are not compatible"), "10"},
+		{"INCR with two pairs rejected", []string{"INCR", "1", "member", "2", "other"}, protocol.ErrorString("ERR INCR option supports a single increment-element pair"), "10"},
+		{"missing member", []string{"GT", "5"}, protocol.ErrorString("ERR syntax error"), "10"},
+	}
+	for _, tt := range tests {
+		s := newTestServer(t)
+		client := newTestClient(t, s)
+		execute(t, s, client, "ZADD", "zset", "10", "member")
+
+		reply := execute(t, s, client, append([]string{"ZADD", "zset"}, tt.args...)...)
+		if fmt.Sprintf("%#v", reply) != fmt.Sprintf("%#v", tt.want) {
+			t.Fatalf("%s: expected %#v, got %#v", tt.name, tt.want, reply)
+		}
+		value, _ := s.store.Get(0, "zset")
+		zset, _ := value.AsZSet()
+		if got := store.FormatScore(zset["member"]); got != tt.score {
+			t.Fatalf("%s: expected member to score %s, got %s", tt.name, tt.score, got)
+		}
+	}
+}
+
+func TestGetDel(t *testing.T) {
+	s := newTestServer(t)
+	aofChan := make(chan string, 100)
+	s.store = store.NewStore(aofChan)
+	client := newTestClient(t, s)
+	execute(t, s, client, "SET", "string", "value")
+	execute(t, s, client, "RPUSH", "list", "a")
+	for len(aofChan) > 0 {
+		<-aofChan
+	}
+
+	if reply := execute(t, s, client, "GETDEL", "string"); !reflect.DeepEqual(reply, protocol.BulkString("value")) {
+		t.Fatalf("Expected GETDEL to return the value, got %v", reply)
+	}
+	if record := <-aofChan; !strings.Contains(record, "DEL") {
+		t.Fatalf("Expected GETDEL to log a DEL, got %q", record)
+	}
+	if reply := execute(t, s, client, "GETDEL", "string"); !reflect.DeepEqual(reply, protocol.BulkString(nil)) {
+		t.Fatalf("Expected GETDEL on a deleted key to return nil, got %v", reply)
+	}
+	reply := execute(t, s, client, "GETDEL", "list")
+	if e, ok := reply.(protocol.ErrorString); !ok || !strings.HasPrefix(string(e), "WRONGTYPE") {
+		t.Fatalf("Expected GETDEL on a list to fail with WRONGTYPE, got %v", reply)
+	}
+	if len(aofChan) != 0 || s.store.Exists(0, "list") != 1 {
+		t.Fatalf("Expected only the GETDEL of an existing string to write")
+	}
+}
+
+func TestGetSetMatchesSetGet(t *testing.T) {
+	setup := func(t *testing.T) (*Server, *Client, chan string) {
+		s := newTestServer(t)
+		aofChan := make(chan string, 100)
+		s.store = store.NewStore(aofChan)
+		client := newTestClient(t, s)
+		execute(t, s, client, "SET", "string", "old", "EX", "100")
+		execute(t, s, client, "RPUSH", "list", "a")
+		for len(aofChan) > 0 {
+			<-aofChan
+		}
+		return s, client, aofChan
+	}
+
+	for _, key := range []string{"string", "missing", "list"} {
+		s1, client1, aof1 := setup(t)
+		getset := execute(t, s1, client1, "GETSET", key, "new")
+		s2, client2, aof2 := setup(t)
+		setget := execute(t, s2, client2, "SET", key, "new", "GET")
+
+		if fmt.Sprintf("%#v", getset) != fmt.Sprintf("%#v", setget) {
+			t.Fatalf("%s: expected GETSET to reply %#v like SET GET, got %#v", key, setget, getset)
+		}
+		if len(aof1) != len(aof2) || (len(aof1) > 0 && <-aof1 != <-aof2) {
+			t.Fatalf("%s: expected GETSET to log the same record as SET GET", key)
+		}
+		// Like SET, GETSET discards the TTL
+		if ttl := execute(t, s1, client1, "TTL", key); key == "string" && ttl != protocol.Integer(-1) {
+			t.Fatalf("Expected GETSET to discard the TTL, got %v", ttl)
+		}
+	}
+
+	s, client, _ := setup(t)
+	if reply := execute(t, s, client, "GETSET", "string", "a", "b"); reply != protocol.ErrorString("ERR wrong number of arguments for 'GETSET' command") {
+		t.Fatalf("Expected GETSET to take no options, got %v", reply)
+	}
+	docs := fmt.Sprintf("%s", execute(t, s, client, "COMMAND", "DOCS", "GETSET"))
+	if !strings.Contains(docs, "deprecated") || !strings.Contains(docs, "SET") {
+		t.Fatalf("Expected GETSET to be documented as deprecated in favor of SET, got %s", docs)
+	}
+}
+
+func TestSubstrMatchesGetRange(t *testing.T) {
+	s := newTestServer(t)
+	client := newTestClient(t, s)
+	execute(t, s, client, "SET", "key", "Hello World")
+
+	tests := []struct {
+		key        string
+		start, end string
+		want       string
+	}{
+		{"key", "0", "4", "Hello"},
+		{"key", "-5", "-1", "World"},
+		{"key", "-100", "100", "Hello World"},
+		{"key", "0", "-100", "H"},
+		{"key", "-1", "-5", ""},
+		{"key", "5", "2", ""},
+		{"key", "20", "30", ""},
+		{"missing", "0", "-1", ""},
+	}
+	for _, tt := range tests {
+		getrange := execute(t, s, client, "GETRANGE", tt.key, tt.start, tt.end)
+		substr := execute(t, s, client, "SUBSTR", tt.key, tt.start, tt.end)
+		if string(getrange.(protocol.BulkString)) != tt.want {
+			t.Fatalf("GETRANGE %s %s %s: expected %q, got %q", tt.key, tt.start, tt.end, tt.want, getrange)
+		}
+		if fmt.Sprintf("%#v", substr) != fmt.Sprintf("%#v", getrange) {
+			t.Fatalf("SUBSTR %s %s %s: expected %#v like GETRANGE, got %#v", tt.key, tt.start, tt.end, getrange, substr)
+		}
+	}
+
+	docs := fmt.Sprintf("%s", execute(t, s, client, "COMMAND", "DOCS", "SUBSTR"))
+	if !strings.Contains(docs, "deprecated") || !strings.Contains(docs, "GETRANGE") {
+		t.Fatalf("Expected SUBSTR to be documented as deprecated in favor of GETRANGE, got %s", docs)
+	}
+}
+
+func TestCommandClassificationMatchesAOF(t *testing.T) {
+	// Arguments that make each write command change the dataset. A write
+	// command added to the table without an entry here fails the test, so it
+	// can't silently skip the AOF.
+	writes := map[string][]string{
+		"APPEND":      {"string", "more"},
+		"COPY":        {"string", "copy"},
+		"DECR":        {"counter"},
+		"DECRBY":      {"counter", "5"},
+		"DEL":         {"string"},
+		"EXPIRE":      {"string", "100"},
+		"EXPIREAT":    {"string", "99999999999"},
+		"FLUSHALL":    {},
+		"FLUSHDB":     {},
+		"GETDEL":      {"string"},
+		"GETEX":       {"string", "EX", "100"},
+		"GETSET":      {"string", "new"},
+		"HSET":        {"hash", "field", "value"},
+		"INCR":        {"counter"},
+		"INCRBY":      {"counter", "5"},
+		"INCRBYFLOAT": {"counter", "0.5"},
+		"LPOP":        {"list"},
+		"LPUSH":       {"list", "a"},
+		"LINSERT":     {"list", "BEFORE", "a", "x"},
+		"LMOVE":       {"list", "other", "LEFT", "RIGHT"},
+		"LREM":        {"list", "0", "a"},
+		"LSET":        {"list", "0", "x"},
+		"LTRIM":       {"list", "0", "0"},
+		"MSET":        {"string", "new", "other", "value"},
+		"PERSIST":     {"expiring"},
+		"PEXPIRE":     {"string", "100000"},
+		"PEXPIREAT":   {"string", "99999999999999"},
+		"RENAME":      {"string", "renamed"},
+		"RESTORE":     {}, // the payload is dumped below
+		"RPOP":        {"list"},
+		"RPOPLPUSH":   {"list", "other"},
+		"RPUSH":       {"list", "a"},
+		"SADD":        {"set", "b"},
+		"SET":         {"string", "value"},
+		"SETNX":       {"new", "value"},
+		"SETRANGE":    {"string", "1", "x"},
+		"SREM":        {"set", "a"},
+		"ZADD":        {"zset", "2", "other"},
+	}
+	setup := func(t *testing.T) (*Server, *Client, chan string) {
+		s := newTestServer(t)
+		aofChan := make(chan string, 100)
+		s.store = store.NewStore(aofChan)
+		client := newTestClient(t, s)
+		execute(t, s, client, "SET", "string", "value")
+		execute(t, s, client, "RPUSH", "list", "a", "b", "c")
+		execute(t, s, client, "HSET", "hash", "field", "value")
+		execute(t, s, client, "ZADD", "zset", "1", "member")
+		execute(t, s, client, "SADD", "set", "a")
+		execute(t, s, client, "SET", "counter", "1")
+		execute(t, s, client, "SET", "expiring", "value", "EX", "100")
+		for len(aofChan) > 0 {
+			<-aofChan
+		}
+		return s, client, aofChan
+	}
+
+	for name, spec := range availableCommands {
+		switch {
+		case spec.isWrite():
+			args, ok := writes[name]
+			if !ok {
+				t.Fatalf("%s is a write command without a sample in this test", name)
+			}
+			s, client, aofChan := setup(t)
+			if name == "RESTORE" {
+				payload, _ := s.store.Dump(0, "string")
+				args = []string{"restored", "0", string(payload)}
+			}
+			reply := execute(t, s, client, append([]string{name}, args...)...)
+			if len(aofChan) == 0 {
+				t.Fatalf("Expected %s to be logged to the AOF, got reply %v", name, reply)
+			}
+		case spec.isReadOnly():
+			s, client, aofChan := setup(t)
+			args := []string{"string"}
+			for len(args)+1 < spec.Arity {
+				args = append(args, "0")
+			}
+			execute(t, s, client, append([]string{name}, args...)...)
+			if len(aofChan) != 0 {
+				t.Fatalf("Expected the read-only %s not to be logged to the AOF", name)
+			}
+		}
+	}
+}
+
+func TestMaxMemoryDeniesGrowth(t *testing.T) {
+	s := newTestServer(t)
+	s.config.MaxMemory = 2000
+	client := newTestClient(t, s)
+	execute(t, s, client, "SET", "counter", "1")
+
+	// Fill until the limit is crossed: the write that crosses it runs, the
+	// next one is rejected
+	oom := protocol.ErrorString("OOM command not allowed when used memory > 'maxmemory'.")
+	value := strings.Repeat("x", 100)
+	for i := 0; ; i++ {
+		reply := execute(t, s, client, "SET", fmt.Sprintf("key:%d", i), value)
+		if reply == oom {
+			break
+		}
+		if reply != protocol.SimpleString("OK") || i > 100 {
+			t.Fatalf("Expected SET to succeed until the limit is crossed, got %v", reply)
+		}
+	}
+	if used := s.store.UsedMemory(); used <= s.config.MaxMemory {
+		t.Fatalf("Expected the used memory to be over maxmemory, got %d", used)
+	}
+
+	// INCR of an existing key doesn't grow the dataset
+	if reply := execute(t, s, client, "INCR", "counter"); reply != protocol.Integer(2) {
+		t.Fatalf("Expected INCR of an existing key to succeed, got %v", reply)
+	}
+	if reply := execute(t, s, client, "INCR", "new counter"); reply != oom {
+		t.Fatalf("Expected INCR creating a key to be rejected, got %v", reply)
+	}
+	if reply := execute(t, s, client, "SET", "large", strings.Repeat("x", 10000)); reply != oom {
+		t.Fatalf("Expected SET of a new large key to be rejected, got %v", reply)
+	}
+
+	// Reads and commands that free memory still run
+	if reply := execute(t, s, client, "GET", "counter"); string(reply.(protocol.BulkString)) != "2" {
+		t.Fatalf("Expected GET to run, got %v", reply)
+	}
+	execute(t, s, client, "FLUSHDB")
+	if reply := execute(t, s, client, "SET", "large", strings.Repeat("x", 1000)); reply != protocol.SimpleString("OK") {
+		t.Fatalf("Expected SET to run once memory is freed, got %v", reply)
+	}
+}
+
+func TestReadOnlyRejectsWrites(t *testing.T) {
+	s := newTestServer(t)
+	s.config.ReadOnly = true
+	client := newTestClient(t, s)
+
+	reply, ok := execute(t, s, client, "SET", "key", "value").(protocol.ErrorString)
+	if !ok || !strings.HasPrefix(string(reply), "READONLY") {
+		t.Fatalf("Expected SET to be rejected with READONLY, got %v", reply)
+	}
+	if reply := execute(t, s, client, "GET", "key"); reply.(protocol.BulkString) != nil {
+		t.Fatalf("Expected GET to run and find nothing, got %v", reply)
+	}
+}
+
+func TestDebugDumpKeyspace(t *testing.T) {
+	s := newTestServer(t)
+	s.config.EnableDebug = true
+	client := newTestClient(t, s)
+	execute(t, s, client, "SET", "b-string", "value")
+	execute(t, s, client, "RPUSH", "a-list", "x", "y")
+	execute(t, s, client, "HSET", "d-hash", "field", "value")
+	execute(t, s, client, "ZADD", "c-zset", "2", "two", "1", "one", "inf", "top", "-inf", "bottom")
+	execute(t, s, client, "EXPIRE", "b-string", "100")
+
+	reply, ok := execute(t, s, client, "DEBUG", "DUMP-KEYSPACE").(protocol.BulkString)
+	if !ok {
+		t.Fatalf("Expected a bulk string, got %v", reply)
+	}
+	var entries []struct {
+		Key   string
+		Type  string
+		TTL   int64
+		Value any
+	}
+	if err := json.Unmarshal(reply, &entries); err != nil {
+		t.Fatalf("Expected valid JSON, got %q: %v", reply, err)
+	}
+
+	want := []struct{ key, typ string }{
+		{"a-list", "list"}, {"b-string", "string"}, {"c-zset", "zset"}, {"d-hash", "hash"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("Expected %d keys, got %s", len(want), reply)
+	}
+	for i, w := range want {
+		if entries[i].Key != w.key || entries[i].Type != w.typ {
+			t.Fatalf("Expected %s of type %s at %d, got %s", w.key, w.typ, i, reply)
+		}
+	}
+	if entries[1].TTL <= 0 || entries[1].TTL > 100000 || entries[0].TTL != -1 {
+		t.Fatalf("Expected the TTLs to be reported, got %s", reply)
+	}
+	if !strings.Contains(string(reply), `[{"member":"bottom","score":"-inf"},{"member":"one","score":1},{"member":"two","score":2},{"member":"top","score":"inf"}]`) {
+		t.Fatalf("Expected the zset members ordered by score, got %s", reply)
+	}
+
+}
+
+func TestInlineCommands(t *testing.T) {
+	s := newTestServer(t)
+	conn, reader := connect(t, s)
+
+	conn.Write([]byte("PING\r\n\r\nSET key  value\r\nGET key\n"))
+	for _, want := range []string{"+PONG\r\n", "+OK\r\n", "$5\r\n"} {
+		if line, _ := reader.ReadString('\n'); line != want {
+			t.Fatalf("Expected %q, got %q", want, line)
+		}
+	}
+}
+
+func TestInlineCommandLengthIsCapped(t *testing.T) {
+	s := newTestServer(t)
+	s.config.ProtoInlineMax = 1024
+	conn, reader := connect(t, s)
+
+	// A line just under the limit is still a command
+	conn.Write([]byte("SET key " + strings.Repeat("a", 1000) + "\r\n"))
+	if line, _ := reader.ReadString('\n'); line != "+OK\r\n" {
+		t.Fatalf("Expected +OK, got %q", line)
+	}
+
+	// Longer lines are rejected before their end arrives
+	conn.Write([]byte("SET key " + strings.Repeat("a", 2000)))
+	line, err := reader.ReadString('\n')
+	if err != nil || line != "-ERR Protocol error: too big inline request\r\n" {
+		t.Fatalf("Expected a too big inline request error, got %q (%v)", line, err)
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Fatalf("Expected the connection to be closed after a too big inline request, got %v", err)
+	}
+}
+
+func TestProtocolErrorClosesConnection(t *testing.T) {
+	s := newTestServer(t)
+	conn, reader := connect(t, s)
+
+	conn.Write([]byte("*abc\r\n$3\r\nGET\r\n"))
+	line, err := reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "-ERR Protocol error") {
+		t.Fatalf("Expected a protocol error reply, got %q (%v)", line, err)
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Fatalf("Expected the connection to be closed after a protocol error, got %v", err)
+	}
+}
+
+func TestAuthUsers(t *testing.T) {
+	s := newTestServer(t)
+	s.config.Password = "pass"
+	s.config.Users = map[string]string{"alice": "secret"}
+	s.users = newUsers(s.config)
+
+	tests := []struct {
+		args []string
+		ok   bool
+		user string
+	}{
+		{[]string{"AUTH", "pass"}, true, "default"},
+		{[]string{"AUTH", "default", "pass"}, true, "default"},
+		{[]string{"AUTH", "alice", "secret"}, true, "alice"},
+		{[]string{"AUTH", "alice", "pass"}, false, "default"},
+		{[]string{"AUTH", "wrong"}, false, "default"},
+		{[]string{"AUTH", "bob", "secret"}, false, "default"},
+	}
+	for _, tt := range tests {
+		client := newTestClient(t, s)
+		reply := execute(t, s, client, tt.args...)
+		if tt.ok && reply != protocol.SimpleString("OK") {
+			t.Fatalf("%v: expected OK, got %v", tt.args, reply)
+		}
+		if !tt.ok {
+			if e, ok := reply.(protocol.ErrorString); !ok || !strings.HasPrefix(string(e), "WRONGPASS") {
+				t.Fatalf("%v: expected WRONGPASS, got %v", tt.args, reply)
+			}
+		}
+		if client.user != tt.user || client.authenticated != tt.ok {
+			t.Fatalf("%v: expected user %s authenticated %v, got %s %v", tt.args, tt.user, tt.ok, client.user, client.authenticated)
+		}
+	}
+
+	// The password is required, not just accepted
+	client := newTestClient(t, s)
+	if reply := execute(t, s, client, "SET", "key", "value"); reply != protocol.ErrorString("NOAUTH Authentication required.") {
+		t.Fatalf("Expected a client that didn't AUTH to be refused, got %v", reply)
+	}
+	execute(t, s, client, "AUTH", "wrong")
+	if reply := execute(t, s, client, "SET", "key", "value"); reply != protocol.ErrorString("NOAUTH Authentication required.") {
+		t.Fatalf("Expected a client that failed to AUTH to be refused, got %v", reply)
+	}
+}
+
+func TestACLPermissions(t *testing.T) {
+	s := newTestServer(t)
+	s.config.ACL = []string{
+		"USER alice on >secret ~cache:* +get +set",
+		"USER reader on >secret allkeys +@read",
+		"USER bad on +nosuchcommand",
+	}
+	s.users = newUsers(s.config)
+	if _, ok := s.users["bad"]; ok {
+		t.Fatalf("Expected the rule with an unknown command to be ignored")
+	}
+
+	admin := newTestClient(t, s)
+	execute(t, s, admin, "SET", "cache:a", "value")
+	execute(t, s, admin, "SET", "other", "value")
+
+	alice := newTestClient(t, s)
+	if reply := execute(t, s, alice, "AUTH", "alice", "secret"); reply != protocol.SimpleString("OK") {
+		t.Fatalf("Expected alice to log in, got %v", reply)
+	}
+	tests := []struct {
+		args   []string
+		denied string
+	}{
+		{[]string{"GET", "cache:a"}, ""},
+		{[]string{"SET", "cache:b", "value"}, ""},
+		{[]string{"DEL", "cache:a"}, "NOPERM User alice has no permissions to run the 'del' command"},
+		{[]string{"GET", "other"}, "NOPERM No permissions to access a key"},
+		{[]string{"SET", "other", "value"}, "NOPERM No permissions to access a key"},
+	}
+	for _, tt := range tests {
+		reply := execute(t, s, alice, tt.args...)
+		e, isErr := reply.(protocol.ErrorString)
+		if tt.denied == "" && isErr {
+			t.Fatalf("%v: expected to be allowed, got %v", tt.args, reply)
+		}
+		if tt.denied != "" && string(e) != tt.denied {
+			t.Fatalf("%v: expected %q, got %v", tt.args, tt.denied, reply)
+		}
+	}
+
+	reader := newTestClient(t, s)
+	execute(t, s, reader, "AUTH", "reader", "secret")
+	if reply := execute(t, s, reader, "GET", "other"); string(reply.(protocol.BulkString)) != "value" {
+		t.Fatalf("Expected the reader to GET any key, got %v", reply)
+	}
+	if reply, ok := execute(t, s, reader, "SET", "other", "x").(protocol.ErrorString); !ok || !strings.HasPrefix(string(reply), "NOPERM") {
+		t.Fatalf("Expected the reader not to SET, got %v", reply)
+	}
+}
+
+func TestCommandsRequireAuth(t *testing.T) {
+	s := newTestServer(t)
+	s.config.Password = "pass"
+	s.users = newUsers(s.config)
+	conn, reader := connect(t, s)
+
+	tests := []struct {
+		command string
+		reply   string
+	}{
+		{"GET key", "-NOAUTH Authentication required.\r\n"},
+		{"DEL key", "-NOAUTH Authentication required.\r\n"},
+		{"HELLO 3", "-NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time\r\n"},
+		{"HELLO 2 AUTH default wrong", "-WRONGPASS invalid username-password pair or user is disabled.\r\n"},
+		{"AUTH pass", "+OK\r\n"},
+		{"DEL key", ":1\r\n"},
+	}
+	for _, tt := range tests {
+		conn.Write([]byte(tt.command + "\r\n"))
+		if line, err := reader.ReadString('\n'); err != nil || line != tt.reply {
+			t.Fatalf("%s: expected %q, got %q (%v)", tt.command, tt.reply, line, err)
+		}
+	}
+
+	// A default user limited by an ACL rule needs AUTH too
+	s.config.Password = ""
+	s.config.ACL = []string{"USER default on nopass ~cache:* +get"}
+	s.users = newUsers(s.config)
+	if reply := execute(t, s, newTestClient(t, s), "GET", "cache:a"); reply != protocol.ErrorString("NOAUTH Authentication required.") {
+		t.Fatalf("Expected NOAUTH for a restricted default user, got %v", reply)
+	}
+
+	// HELLO may log in itself
+	s.config.ACL = nil
+	s.config.Password = "pass"
+	s.users = newUsers(s.config)
+	client := newTestClient(t, s)
+	if _, ok := execute(t, s, client, "HELLO", "2", "AUTH", "default", "pass").(protocol.Array); !ok {
+		t.Fatalf("Expected HELLO with AUTH to log in")
+	}
+	if reply, ok := execute(t, s, client, "GET", "key").(protocol.ErrorString); ok {
+		t.Fatalf("Expected GET to run after HELLO AUTH, got %v", reply)
+	}
+}
+
+func TestUnknownCommandPreviewsArgs(t *testing.T) {
+	s := newTestServer(t)
+	client := newTestClient(t, s)
+
+	tests := []struct {
+		parts []string
+		want  string
+	}{
+		{[]string{"FOO"}, "ERR unknown command 'FOO'"},
+		{[]string{"foo", "bar", "baz"}, "ERR unknown command 'foo', with args beginning with: 'bar' 'baz' "},
+		// Newlines would end the error line early
+		{[]string{"foo", "a\r\nb"}, "ERR unknown command 'foo', with args beginning with: 'a  b' "},
+		// The arguments quoted stop after 128 bytes
+		{
+			[]string{"foo", strings.Repeat("a", 100), strings.Repeat("b", 100), "c"},
+			"ERR unknown command 'foo', with args beginning with: '" + strings.Repeat("a", 100) + "' '" + strings.Repeat("b", 25) + "' ",
+		},
+		{[]string{strings.Repeat("x", 200)}, "ERR unknown command '" + strings.Repeat("x", 128) + "'"},
+	}
+	for _, tt := range tests {
+		if reply := execute(t, s, client, tt.parts...); reply != protocol.ErrorString(tt.want) {
+			t.Fatalf("Expected %q for %q, got %q", tt.want, tt.parts, reply)
+		}
+	}
+}
+
+func TestRenameCommand(t *testing.T) {
+	s := newTestServer(t)
+	renames, err := parseRenameCommand(`FLUSHALL "";config secretconfig; GET SET`)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing the rules: %v", err)
+	}
+	s.config.RenameCommand = renames
+	s.commandNames = newCommandNames(s.config)
+	client := newTestClient(t, s)
+	execute(t, s, client, "SET", "key", "value")
+
+	// Disabled
+	if reply := execute(t, s, client, "FLUSHALL"); reply != protocol.ErrorString("ERR unknown command 'FLUSHALL'") {
+		t.Fatalf("Expected FLUSHALL to be unknown, got %v", reply)
+	}
+	if reply := execute(t, s, client, "EXISTS", "key"); reply != protocol.Integer(1) {
+		t.Fatalf("Expected the disabled FLUSHALL to leave key alone, got %v", reply)
+	}
+
+	// Renamed: only the new name works
+	if reply := execute(t, s, client, "config", "GET", "port"); reply != protocol.ErrorString("ERR unknown command 'config', with args beginning with: 'GET' 'port' ") {
+		t.Fatalf("Expected CONFIG to be unknown, got %v", reply)
+	}
+	if reply, ok := execute(t, s, client, "secretconfig", "GET", "port").(protocol.Array); !ok || len(reply) != 2 {
+		t.Fatalf("Expected SECRETCONFIG to run CONFIG, got %v", reply)
+	}
+
+	// Renaming to an existing command is ignored
+	if reply := execute(t, s, client, "GET", "key"); string(reply.(protocol.BulkString)) != "value" {
+		t.Fatalf("Expected GET to keep its name, got %v", reply)
+	}
+
+	if _, err := parseRenameCommand("FLUSHALL"); err == nil {
+		t.Fatalf("Expected a rule without a new name to be rejected")
+	}
+}
+
+func TestACLIntrospection(t *testing.T) {
+	s := newTestServer(t)
+	s.config.ACL = []string{"USER alice on >secret ~cache:* +set +get +acl"}
+	s.users = newUsers(s.config)
+	client := newTestClient(t, s)
+
+	if reply := execute(t, s, client, "ACL", "WHOAMI"); string(reply.(protocol.BulkString)) != "default" {
+		t.Fatalf("Expected default before AUTH, got %v", reply)
+	}
+	execute(t, s, client, "AUTH", "alice", "secret")
+	if reply := execute(t, s, client, "ACL", "WHOAMI"); string(reply.(protocol.BulkString)) != "alice" {
+		t.Fatalf("Expected alice after AUTH, got %v", reply)
+	}
+
+	rules := execute(t, s, client, "ACL", "LIST").(protocol.Array)
+	if len(rules) != 2 {
+		t.Fatalf("Expected the default user and alice, got %s", rules)
+	}
+	want := fmt.Sprintf("user alice on #%x ~cache:* -@all +acl +get +set", sha256.Sum256([]byte("secret")))
+	if string(rules[0].(protocol.BulkString)) != want {
+		t.Fatalf("Expected %q, got %q", want, rules[0])
+	}
+	if rule := string(rules[1].(protocol.BulkString)); !strings.HasPrefix(rule, "user default on #") || !strings.HasSuffix(rule, " ~* +@all") {
+		t.Fatalf("Expected the default user with every permission, got %q", rule)
+	}
+
+	categories := fmt.Sprintf("%s", execute(t, s, client, "ACL", "CAT"))
+	for _, category := range []string{"read", "write", "keyspace"} {
+		if !strings.Contains(categories, category) {
+			t.Fatalf("Expected the %s category, got %s", category, categories)
+		}
+	}
+	if reply := fmt.Sprintf("%s", execute(t, s, client, "ACL", "CAT", "keyspace")); !strings.Contains(reply, "del") || strings.Contains(reply, "get ") {
+		t.Fatalf("Expected the keyspace commands, got %s", reply)
+	}
+}
+
+// temporaryError is an accept error the server should retry
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "too many open files" }
+func (temporaryError) Temporary() bool { return true }
+func (temporaryError) Timeout() bool   { return false }
+
+// fakeListener fails Accept with errs, then blocks until it is closed
+type fakeListener struct {
+	mu        sync.Mutex
+	errs      []error
+	calls     []time.Time
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *fakeListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	l.calls = append(l.calls, time.Now())
+	if len(l.errs) > 0 {
+		err := l.errs[0]
+		l.errs = l.errs[1:]
+		l.mu.Unlock()
+		return nil, err
+	}
+	l.mu.Unlock()
+	<-l.closed
+	return nil, net.ErrClosed
+}
+
+func (l *fakeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *fakeListener) Addr() net.Addr { return &net.TCPAddr{} }
+
+func TestServeBacksOffOnTemporaryAcceptErrors(t *testing.T) {
+	s := newTestServer(t)
+	ln := &fakeListener{errs: []error{temporaryError{}, temporaryError{}, temporaryError{}}, closed: make(chan struct{})}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ln) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ln.mu.Lock()
+		calls := slices.Clone(ln.calls)
+		ln.mu.Unlock()
+		if len(calls) == 4 {
+			// Each retry waits at least twice as long as the previous one
+			for i, want := range []time.Duration{minAcceptDelay, 2 * minAcceptDelay, 4 * minAcceptDelay} {
+				if got := calls[i+1].Sub(calls[i]); got < want {
+					t.Fatalf("Expected retry %d after at least %v, got %v", i+1, want, got)
+				}
+			}
+			break
+		}
+		if len(calls) > 4 || time.Now().After(deadline) {
+			t.Fatalf("Expected 4 accept calls, got %d", len(calls))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	s.Shutdown()
+	if err := <-done; err != nil {
+		t.Fatalf("Expected Serve to return nil after Shutdown, got %v", err)
+	}
+}
+
+func TestServeStopsOnPermanentAcceptError(t *testing.T) {
+	s := newTestServer(t)
+	permanent := errors.New("listener broken")
+	ln := &fakeListener{errs: []error{permanent}, closed: make(chan struct{})}
+	if err := s.Serve(ln); err != permanent {
+		t.Fatalf("Expected Serve to return the permanent error, got %v", err)
+	}
+}
+
+func TestLoadingRejectsDataCommands(t *testing.T) {
+	s := newTestServer(t)
+	client := newTestClient(t, s)
+
+	release := make(chan struct{})
+	s.loadInBackground(func() {
+		<-release
+		s.store.Set(0, "key", "value")
+	})
+
+	for _, args := range [][]string{{"GET", "key"}, {"SET", "key", "other"}} {
+		reply := execute(t, s, client, args...)
+		if reply != protocol.ErrorString("LOADING Redis is loading the dataset in memory") {
+			t.Fatalf("Expected a LOADING error for %v during the load, got %v", args, reply)
+		}
+	}
+	if reply := execute(t, s, client, "PING"); reply != protocol.SimpleString("PONG") {
+		t.Fatalf("Expected PONG during the load, got %v", reply)
+	}
+	if _, ok := execute(t, s, client, "INFO", "server").(protocol.BulkString); !ok {
+		t.Fatalf("Expected INFO to work during the load")
+	}
+
+	close(release)
+	s.background.Wait()
+	if reply, _ := execute(t, s, client, "GET", "key").(protocol.BulkString); string(reply) != "value" {
+		t.Fatalf("Expected the loaded value after the load, got %v", reply)
+	}
+}
+
+func TestInfoPersistence(t *testing.T) {
+	s := newTestServer(t)
+	client := newTestClient(t, s)
+	before := time.Now().Unix()
+
+	execute(t, s, client, "SET", "key", "value")
+	info := execute(t, s, client, "INFO", "persistence")
+	if changes := infoField(t, info, "rdb_changes_since_last_save"); changes != "1" {
+		t.Fatalf("Expected 1 change before SAVE, got %q", changes)
+	}
+	if reply := execute(t, s, client, "SAVE"); reply != protocol.SimpleString("OK") {
+		t.Fatalf("Expected OK from SAVE, got %v", reply)
+	}
+
+	info = execute(t, s, client, "INFO", "persistence")
+	if changes := infoField(t, info, "rdb_changes_since_last_save"); changes != "0" {
+		t.Fatalf("Expected no change after SAVE, got %q", changes)
+	}
+	lastSave, err := strconv.ParseInt(infoField(t, info, "rdb_last_save_time"), 10, 64)
+	if err != nil || lastSave < before {
+		t.Fatalf("Expected rdb_last_save_time of at least %d, got %d, %v", before, lastSave, err)
+	}
+	want := map[string]string{"loading": "0", "rdb_bgsave_in_progress": "0", "aof_enabled": "0", "aof_last_write_status": "ok"}
+	for field, value := range want {
+		if got := infoField(t, info, field); got != value {
+			t.Fatalf("Expected %s:%s, got %q", field, value, got)
+		}
+	}
+}
+
+// Test that QUIT replies OK and then closes the connection, without running
+// the commands pipelined after it
+func TestQuitClosesConnection(t *testing.T) {
+	s := newTestServer(t)
+	conn, reader := connect(t, s)
+
+	written := make(chan error, 1)
+	go func() {
+		_, err := conn.Write([]byte("QUIT\r\nSET k v\r\n"))
+		written <- err
+	}()
+	if _, reply := readFrame(t, reader); reply != protocol.SimpleString("OK") {
+		t.Fatalf("Expected QUIT to reply OK, got %v", reply)
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Fatalf("Expected the connection to be closed after QUIT, got %v", err)
+	}
+	<-written
+	if s.store.Exists(0, "k") != 0 {
+		t.Fatalf("Expected the command after QUIT not to run")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.clients) != 0 {
+		t.Fatalf("Expected the client to be removed after QUIT, %d left", len(s.clients))
+	}
+}
+
+func TestPanicClosesOnlyItsConnection(t *testing.T) {
+	s := newTestServer(t)
+
+	// A string holding something else makes INCR's type assertion panic
+	data := make([]map[string]*store.Value, 16)
+	for i := range data {
+		data[i] = map[string]*store.Value{}
+	}
+	data[0]["corrupt"] = &store.Value{Type: store.TypeString, Data: 42}
+	data[0]["key"] = store.NewStringValue("value")
+	s.store.RestoreFromSnapshot(data)
+
+	conn, reader := connect(t, s)
+	other, otherReader := connect(t, s)
+
+	sendCommand(t, conn, "INCR", "corrupt")
+	if _, reply := readFrame(t, reader); reply != protocol.ErrorString("ERR internal error") {
+		t.Fatalf("Expected ERR internal error, got %v", reply)
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Fatalf("Expected the connection to be closed after the panic, got %v", err)
+	}
+
+	sendCommand(t, other, "GET", "key")
+	if _, reply := readFrame(t, otherReader); string(reply.(protocol.BulkString)) != "value" {
+		t.Fatalf("Expected the other connection to keep working, got %v", reply)
+	}
+	client := newTestClient(t, s)
+	if reply := execute(t, s, client, "PING"); reply != protocol.SimpleString("PONG") {
+		t.Fatalf("Expected the server to stay up, got %v", reply)
+	}
+}
+
+func TestBGRewriteAOF(t *testing.T) {
+	withoutAOF := newTestServer(t)
+	if reply := execute(t, withoutAOF, newTestClient(t, withoutAOF), "BGREWRITEAOF"); reply != protocol.ErrorString("ERR AOF persistence is not enabled") {
+		t.Fatalf("Expected an error without AOF, got %v", reply)
+	}
+
+	aofFilename := filepath.Join(t.TempDir(), "appendonly.aof")
+	s := newTestServer(t)
+	s.store = store.NewStore(make(chan string, 100))
+	s.startAOF(aofFilename)
+	client := newTestClient(t, s)
+
+	// Enough data for several incremental fsyncs, and writes the rewrite
+	// compacts away
+	value := strings.Repeat("x", 1024)
+	for i := 0; i < 10000; i++ {
+		execute(t, s, client, "SET", "key:"+strconv.Itoa(i), value)
+	}
+	for i := 0; i < 100; i++ {
+		execute(t, s, client, "INCR", "counter")
+		execute(t, s, client, "RPUSH", "list", strconv.Itoa(i))
+	}
+	execute(t, s, client, "HSET", "hash", "field", "value")
+	execute(t, s, client, "ZADD", "zset", "1.5", "member")
+	execute(t, s, client, "SADD", "set", "a", "b")
+	execute(t, s, client, "SET", "expiring", "value", "EX", "1000")
+	execute(t, s, client, "SELECT", "1")
+	execute(t, s, client, "SET", "other", "db")
+	execute(t, s, client, "SELECT", "0")
+
+	if reply := execute(t, s, client, "BGREWRITEAOF"); reply != protocol.SimpleString("Background append only file rewriting started") {
+		t.Fatalf("Expected the rewrite to start, got %v", reply)
+	}
+	// Writes made during the rewrite are appended to the new file
+	execute(t, s, client, "INCR", "counter")
+	execute(t, s, client, "DEL", "key:1")
+	deadline := time.Now().Add(10 * time.Second)
+	for s.aofRewriting.Load() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the rewrite to finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	execute(t, s, client, "INCR", "counter")
+	if status := infoField(t, execute(t, s, client, "INFO", "persistence"), "aof_last_write_status"); status != "ok" {
+		t.Fatalf("Expected the AOF to keep working after the rewrite, got %s", status)
+	}
+
+	close(s.store.AOFChannel())
+	s.aofWriter.Wait()
+	data, err := os.ReadFile(aofFilename)
+	if err != nil || bytes.Contains(data, []byte("RPUSH")) || !bytes.Contains(data, []byte("RESTORE")) {
+		t.Fatalf("Expected the list pushes to be rewritten as a RESTORE (%v)", err)
+	}
+	restored := rebuildFromAOF(t, aofFilename)
+	for _, dbIndex := range []int{0, 1} {
+		want, _ := s.store.DumpKeyspace(dbIndex)
+		got, _ := restored.store.DumpKeyspace(dbIndex)
+		// TTLs are reported relative to now, which moves between the dumps
+		want = regexp.MustCompile(`"ttl":\d+`).ReplaceAll(want, []byte(`"ttl":0`))
+		got = regexp.MustCompile(`"ttl":\d+`).ReplaceAll(got, []byte(`"ttl":0`))
+		if !bytes.Equal(got, want) {
+			t.Fatalf("Expected database %d to be rebuilt from the rewritten AOF", dbIndex)
+		}
+	}
+	if ttl, _ := restored.store.TTL(0, "expiring"); ttl <= 0 {
+		t.Fatalf("Expected the rewritten key to keep its expiry, got a TTL of %d", ttl)
+	}
+	if value, _ := restored.store.Get(0, "counter"); value.Data != "102" {
+		t.Fatalf("Expected counter 102 after the rebuild, got %v", value.Data)
+	}
+}
+
+func TestMSetMGet(t *testing.T) {
+	aofFilename := filepath.Join(t.TempDir(), "appendonly.aof")
+	aofChan := make(chan string, 100)
+	errChan := make(chan error, 1)
+	go aof.AOFWriter(aofChan, aofFilename, errChan)
+
+	s := newTestServer(t)
+	s.store = store.NewStore(aofChan)
+	client := newTestClient(t, s)
+
+	if reply := execute(t, s, client, "MSET", "a", "1", "b"); reply != protocol.ErrorString("ERR wrong number of arguments for 'MSET' command") {
+		t.Fatalf("Expected an arity error for an odd number of arguments, got %v", reply)
+	}
+	if reply := execute(t, s, client, "MSET", "a", "1", "b", "2", "a", "3"); reply != protocol.SimpleString("OK") {
+		t.Fatalf("Expected OK, got %v", reply)
+	}
+	execute(t, s, client, "RPUSH", "list", "x")
+
+	reply, ok := execute(t, s, client, "MGET", "a", "missing", "b", "list").(protocol.Array)
+	if !ok || len(reply) != 4 {
+		t.Fatalf("Expected 4 values, got %v", reply)
+	}
+	// Later pairs win, and missing or wrong-typed keys are nil
+	want := []string{"3", "", "2", ""}
+	for i, value := range reply {
+		bulk, ok := value.(protocol.BulkString)
+		if !ok || string(bulk) != want[i] || (want[i] == "") != (bulk == nil) {
+			t.Fatalf("Expected %q (nil for empty), got %q", want, reply)
+		}
+	}
+
+	// Each pair is logged as its own SET
+	close(aofChan)
+	if err := <-errChan; err != nil {
+		t.Fatalf("Unexpected AOF error: %v", err)
+	}
+	r := rebuildFromAOF(t, aofFilename)
+	if value, _ := r.store.Get(0, "a"); value == nil || value.Data != "3" {
+		t.Fatalf("Expected a to be 3 after the rebuild, got %v", value)
+	}
+	data, _ := os.ReadFile(aofFilename)
+	if count := bytes.Count(data, []byte("$3\r\nSET\r\n")); count != 3 {
+		t.Fatalf("Expected 3 SET records, got %d", count)
+	}
+}
+
+func TestRandomMemberReplies(t *testing.T) {
+	s := newTestServer(t)
+	client := newTestClient(t, s)
+	execute(t, s, client, "SADD", "set", "a")
+	execute(t, s, client, "HSET", "hash", "field", "value")
+	execute(t, s, client, "ZADD", "zset", "1.5", "member")
+
+	bulks := func(items ...string) protocol.RESPValue { return stringSliceToRESPArray(items) }
+	tests := []struct {
+		args []string
+		want protocol.RESPValue
+	}{
+		{[]string{"SRANDMEMBER", "set"}, protocol.BulkString("a")},
+		{[]string{"SRANDMEMBER", "missing"}, protocol.BulkString(nil)},
+		{[]string{"SRANDMEMBER", "set", "-3"}, bulks("a", "a", "a")},
+		{[]string{"SRANDMEMBER", "set", "0"}, bulks()},
+		{[]string{"SRANDMEMBER", "missing", "2"}, bulks()},
+		{[]string{"SRANDMEMBER", "set", "1", "WITHVALUES"}, protocol.ErrorString("ERR wrong number of arguments for 'SRANDMEMBER' command")},
+		{[]string{"SRANDMEMBER", "hash"}, protocol.ErrorString("WRONGTYPE Operation against a key holding the wrong kind of value")},
+		{[]string{"HRANDFIELD", "hash"}, protocol.BulkString("field")},
+		{[]string{"HRANDFIELD", "hash", "2", "WITHVALUES"}, bulks("field", "value")},
+		{[]string{"HRANDFIELD", "hash", "2", "WITHSCORES"}, protocol.ErrorString("ERR syntax error")},
+		{[]string{"ZRANDMEMBER", "zset", "-2", "WITHSCORES"}, bulks("member", "1.5", "member", "1.5")},
+		{[]string{"ZRANDMEMBER", "zset", "x"}, protocol.ErrorString("ERR value is not an integer or out of range")},
+		// Counts are bounded before anything is allocated for them
+		{[]string{"SRANDMEMBER", "set", "1000000000000"}, bulks("a")},
+		{[]string{"HRANDFIELD", "hash", "9223372036854775807", "WITHVALUES"}, bulks("field", "value")},
+		{[]string{"SRANDMEMBER", "set", "-9223372036854775808"}, protocol.ErrorString("ERR value is out of range")},
+		{[]string{"HRANDFIELD", "hash", "-9223372036854775808", "WITHVALUES"}, protocol.ErrorString("ERR value is out of range")},
+		{[]string{"ZRANDMEMBER", "zset", "-1000000000000", "WITHSCORES"}, protocol.ErrorString("ERR value is out of range")},
+		{[]string{"SRANDMEMBER", "missing", "-9223372036854775808"}, protocol.ErrorString("ERR value is out of range")},
+	}
+	for _, test := range tests {
+		if reply := execute(t, s, client, test.args...); !reflect.DeepEqual(reply, test.want) {
+			t.Fatalf("%v: expected %q, got %q", test.args, test.want, reply)
+		}
+	}
+
+	// Pairs are nested for RESP3 clients
+	execute(t, s, client, "HELLO", "3")
+	pairs, ok := execute(t, s, client, "ZRANDMEMBER", "zset", "1", "WITHSCORES").(protocol.Array)
+	if !ok || len(pairs) != 1 || !reflect.DeepEqual(pairs[0], protocol.Array{protocol.BulkString("member"), protocol.Double(1.5)}) {
+		t.Fatalf("Expected a [member, score] pair, got %v", pairs)
+	}
+	pairs, ok = execute(t, s, client, "HRANDFIELD", "hash", "1", "WITHVALUES").(protocol.Array)
+	if !ok || len(pairs) != 1 || !reflect.DeepEqual(pairs[0], protocol.Array{protocol.BulkString("field"), protocol.BulkString("value")}) {
+		t.Fatalf("Expected a [field, value] pair, got %v", pairs)
+	}
+}
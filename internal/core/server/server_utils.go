@@ -1,63 +1,358 @@
 package server
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/andrelcunha/goodiesdb/internal/core/store"
 	"github.com/andrelcunha/goodiesdb/internal/persistence/aof"
 	"github.com/andrelcunha/goodiesdb/internal/persistence/rdb"
+	"github.com/andrelcunha/goodiesdb/internal/protocol"
+	"github.com/andrelcunha/goodiesdb/internal/protocol/resp2"
 )
 
-func (s *Server) isAuthenticates(conn net.Conn) bool {
+// addClient registers a new connection
+func (s *Server) addClient(conn net.Conn) *Client {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.authenticatedConnections[conn]
+	s.nextClientID++
+	client := newClient(s.nextClientID, conn, s.Protocol, &s.clientOutputBytes)
+	s.clients[client] = struct{}{}
+	return client
 }
 
-func (s *Server) getCurrentDb(conn net.Conn) int {
+// removeClient drops a connection and its subscriptions, closing it once the
+// pending replies are written
+func (s *Server) removeClient(client *Client) {
+	s.unsubscribeAll(client)
 	s.mu.Lock()
-	defer s.mu.Unlock()
-	db, ok := s.connectionDbs[conn]
-	if !ok {
-		db = 0
-		s.connectionDbs[conn] = db
+	delete(s.clients, client)
+	s.mu.Unlock()
+	client.close()
+}
+
+// outputBufferLimit returns the output buffer class of a client and its limit
+func (s *Server) outputBufferLimit(client *Client) (string, OutputBufferLimit) {
+	if client.subscriptions() > 0 {
+		return "pubsub", s.config.OutputBufferLimitPubSub
 	}
-	return db
+	return "normal", s.config.OutputBufferLimitNormal
 }
 
-// Quit closes the connection
-func (s *Server) Quit(conn net.Conn) {
+// evictClients disconnects the clients with the largest output buffers until
+// the output of all clients fits in maxmemory-clients again
+func (s *Server) evictClients() {
+	budget := s.config.MaxMemoryClients
+	if budget <= 0 || s.clientOutputBytes.Load() <= budget {
+		return
+	}
+
 	s.mu.Lock()
-	defer s.mu.Unlock()
-	fmt.Fprintln(conn, "OK")
-	delete(s.authenticatedConnections, conn)
-	conn.Close()
+	type candidate struct {
+		client *Client
+		size   int64
+	}
+	candidates := make([]candidate, 0, len(s.clients))
+	for client := range s.clients {
+		if client.noEvict.Load() || client.isClosed() {
+			continue
+		}
+		candidates = append(candidates, candidate{client, client.outputBufferSize()})
+	}
+	s.mu.Unlock()
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].size > candidates[j].size })
+	for _, c := range candidates {
+		if s.clientOutputBytes.Load() <= budget {
+			return
+		}
+		fmt.Printf("Closing client %s: clients output buffers exceed maxmemory-clients (%d bytes), this one holds %d bytes\n",
+			c.client.conn.RemoteAddr(), budget, c.size)
+		c.client.kill()
+	}
 }
 
-// SelectDb selects the database
-func (s *Server) SelectDb(conn net.Conn, dbIndex int) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// ErrDBIndexOutOfRange is returned when selecting a database that doesn't exist
+var ErrDBIndexOutOfRange = errors.New("ERR DB index is out of range")
 
+// SelectDb selects the database
+func (s *Server) SelectDb(client *Client, dbIndex int) error {
 	if dbIndex < 0 || dbIndex >= s.store.Count() {
-		return fmt.Errorf("invalid DB index")
+		return ErrDBIndexOutOfRange
 	}
-	s.connectionDbs[conn] = dbIndex
+	client.db = dbIndex
 	return nil
 }
 
+// yesNo formats a boolean as Redis formats boolean configuration values
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// scoreReply returns a sorted set score as a RESP3 double, or as a bulk
+// string for RESP2 clients
+func scoreReply(client *Client, score float64) protocol.RESPValue {
+	if client.isRESP3() {
+		return protocol.Double(score)
+	}
+	return protocol.BulkString(store.FormatScore(score))
+}
+
+// textReply returns preformatted text, such as INFO's, as a RESP3 verbatim
+// string, or as a bulk string for RESP2 clients
+func textReply(client *Client, text string) protocol.RESPValue {
+	if client.isRESP3() {
+		return protocol.VerbatimString{Format: "txt", Text: text}
+	}
+	return protocol.BulkString(text)
+}
+
+// withScoresReply replies with flat member/score pairs. RESP2 clients get
+// them interleaved as bulk strings, RESP3 ones as [member, score] arrays with
+// the score a double, as Redis does.
+func withScoresReply(client *Client, pairs []string) protocol.RESPValue {
+	if !client.isRESP3() {
+		return stringSliceToRESPArray(pairs)
+	}
+	reply := make(protocol.Array, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		score, _ := strconv.ParseFloat(pairs[i+1], 64)
+		reply = append(reply, protocol.Array{protocol.BulkString(pairs[i]), protocol.Double(score)})
+	}
+	return reply
+}
+
+// fieldValuesReply replies with flat field/value pairs, interleaved for
+// RESP2 clients and as [field, value] arrays for RESP3 ones, as HRANDFIELD
+// WITHVALUES does in Redis
+func fieldValuesReply(client *Client, pairs []string) protocol.RESPValue {
+	if !client.isRESP3() {
+		return stringSliceToRESPArray(pairs)
+	}
+	reply := make(protocol.Array, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		reply = append(reply, protocol.Array{protocol.BulkString(pairs[i]), protocol.BulkString(pairs[i+1])})
+	}
+	return reply
+}
+
+// randomMembersReply replies to SRANDMEMBER, HRANDFIELD and ZRANDMEMBER,
+// called as "command key [count [option]]". Without a count the reply is a
+// single element, or nil. With one it is an array, whose elements are paired
+// by pairsReply when the option, WITHVALUES or WITHSCORES, is given.
+func randomMembersReply(client *Client, command string, parts []string, option string,
+	pick func(count int, withOption bool) ([]string, error),
+	pairsReply func(*Client, []string) protocol.RESPValue) protocol.RESPValue {
+	maxArgs := 3
+	if option != "" {
+		maxArgs = 4
+	}
+	if len(parts) < 2 || len(parts) > maxArgs {
+		return protocol.ErrorString("ERR wrong number of arguments for '" + command + "' command")
+	}
+
+	if len(parts) == 2 {
+		members, err := pick(1, false)
+		if err != nil {
+			return errorReply(err)
+		}
+		if len(members) == 0 {
+			return client.protocol().EncodeNil()
+		}
+		return protocol.BulkString(members[0])
+	}
+
+	count, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return protocol.ErrorString("ERR value is not an integer or out of range")
+	}
+	withOption := len(parts) == 4
+	if withOption && !strings.EqualFold(parts[3], option) {
+		return protocol.ErrorString("ERR syntax error")
+	}
+	members, err := pick(count, withOption)
+	if err != nil {
+		return errorReply(err)
+	}
+	if withOption {
+		return pairsReply(client, members)
+	}
+	return stringSliceToRESPArray(members)
+}
+
+// deniedOOM reports whether a command must be rejected because the dataset
+// uses more than maxmemory. Only the commands flagged denyoom, which may
+// grow the dataset, are rejected, and in-place commands only when they would
+// create their key. Keys are never evicted, so every policy rejects them as
+// noeviction does.
+func (s *Server) deniedOOM(dbIndex int, command string, parts []string) bool {
+	if s.config.MaxMemory <= 0 || !availableCommands[command].isDenyOOM() {
+		return false
+	}
+	if s.store.UsedMemory() <= s.config.MaxMemory {
+		return false
+	}
+	if inPlaceCommands[command] && len(parts) > 1 {
+		if _, exists := s.store.Describe(dbIndex, parts[1]); exists {
+			return false
+		}
+	}
+	return true
+}
+
+// unknownPreviewLen caps the bytes of the command name, and of its arguments
+// together, quoted in the reply to an unknown command, as Redis does
+const unknownPreviewLen = 128
+
+// errorLineReplacer keeps text quoted in an error on the error's single line
+var errorLineReplacer = strings.NewReplacer("\r", " ", "\n", " ")
+
+// unknownCommand is the reply to a command the server doesn't know, or that
+// is renamed or disabled. Like Redis, it quotes the arguments the command
+// began with, which helps spotting typos and mangled requests.
+func unknownCommand(parts []string) protocol.ErrorString {
+	msg := "ERR unknown command '" + truncate(parts[0], unknownPreviewLen) + "'"
+	if len(parts) > 1 {
+		var args strings.Builder
+		for _, arg := range parts[1:] {
+			if args.Len() >= unknownPreviewLen {
+				break
+			}
+			args.WriteString("'" + truncate(arg, unknownPreviewLen-args.Len()) + "' ")
+		}
+		msg += ", with args beginning with: " + args.String()
+	}
+	return protocol.ErrorString(errorLineReplacer.Replace(msg))
+}
+
+// truncate returns the first n bytes of s
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// pairsReply replies with the flat name/value pairs as a map to RESP3
+// clients, and as an array to RESP2 ones
+func pairsReply(client *Client, pairs []string) protocol.RESPValue {
+	if !client.isRESP3() {
+		return stringSliceToRESPArray(pairs)
+	}
+	reply := make(protocol.Map, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		reply[protocol.BulkKey(pairs[i])] = protocol.BulkString(pairs[i+1])
+	}
+	return reply
+}
+
+// setReply replies with the members of a set as a RESP3 set, which clients
+// deserialize into a set, or as an array for RESP2 clients
+func setReply(client *Client, members []string) protocol.RESPValue {
+	if !client.isRESP3() {
+		return stringSliceToRESPArray(members)
+	}
+	reply := make(protocol.Set, len(members))
+	for i, member := range members {
+		reply[i] = protocol.BulkString(member)
+	}
+	return reply
+}
+
+// popReply replies to LPOP and RPOP. Without a count a missing key is a null
+// bulk string and the element a bulk string. With one it is a null array and
+// the elements, none for a count of 0, an array.
+func popReply(client *Client, value any, withCount bool) protocol.RESPValue {
+	switch {
+	case value != nil:
+		return anyToRESP(value)
+	case withCount:
+		return protocol.Array(nil)
+	default:
+		return client.protocol().EncodeNil()
+	}
+}
+
+// parseListEnd parses the LEFT or RIGHT argument of LMOVE
+func parseListEnd(arg string) (left bool, ok bool) {
+	switch strings.ToUpper(arg) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
+	}
+	return false, false
+}
+
+// boolToInt formats a flag the way INFO does, as 1 or 0
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// humanBytes formats a number of bytes the way INFO does, e.g. 1.50K or 2.00M
+func humanBytes(n int64) string {
+	units := []string{"K", "M", "G", "T", "P"}
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	size := float64(n) / 1024
+	unit := 0
+	for size >= 1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.2f%s", size, units[unit])
+}
+
+// newReplID returns a random 40 characters replication ID
+func newReplID() string {
+	id := make([]byte, 20)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// rdbFilepath returns where snapshots are saved and loaded
+func (s *Server) rdbFilepath() string {
+	return filepath.Join(s.dataDir, "dump.rdb")
+}
+
+// aofFilepath returns where the append only file is written and replayed
+func (s *Server) aofFilepath() string {
+	return filepath.Join(s.dataDir, "appendonly.aof")
+}
+
+// startRDB starts saving a snapshot every minute until Shutdown
 func (s *Server) startRDB() {
-	rdbFilepath := filepath.Join(s.dataDir, "dump.rdb")
+	s.background.Add(1)
+	go s.saveSnapshots(s.rdbFilepath())
+}
+
+func (s *Server) saveSnapshots(rdbFilepath string) {
+	defer s.background.Done()
 	for {
 		select {
 		case <-time.After(1 * time.Minute):
+			s.bgsaveInProgress.Store(true)
 			if err := rdb.SaveSnapshot(s.store, rdbFilepath); err != nil {
 				fmt.Println("Error saving snapshot:", err)
 			} else {
 				fmt.Println("Snapshot saved successfully")
 			}
+			s.bgsaveInProgress.Store(false)
 
 		case <-s.shutdownChan:
 			return
@@ -65,29 +360,177 @@ func (s *Server) startRDB() {
 	}
 }
 
+// activeExpireInterval is how often the expired keys are swept, ten times a
+// second as with the default hz of Redis
+const activeExpireInterval = 100 * time.Millisecond
+
+// startExpirationSweeper sweeps the expired keys until Shutdown, which waits
+// for the sweeper before closing the AOF it logs to
+func (s *Server) startExpirationSweeper() {
+	done := s.store.StartExpirationSweeper(activeExpireInterval, s.shutdownChan)
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		<-done
+	}()
+}
+
+// startAOF starts the AOF writer and watches it for errors
+func (s *Server) startAOF(filename string) {
+	errChan := make(chan error, 1)
+	writer := aof.NewWriter(filename)
+	s.mu.Lock()
+	s.aofLog = writer
+	s.mu.Unlock()
+	s.aofWriter.Add(1)
+	go func() {
+		defer s.aofWriter.Done()
+		writer.Run(s.store.AOFChannel(), errChan)
+	}()
+	go s.monitorAOF(errChan)
+}
+
+// rewriteAOF starts rewriting the AOF in the background, as the RESTORE of
+// every key followed by the writes made in the meantime. Shutdown waits for
+// the rewrite before closing the AOF.
+func (s *Server) rewriteAOF() protocol.RESPValue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.aofLog == nil {
+		return protocol.ErrorString("ERR AOF persistence is not enabled")
+	}
+	select {
+	case <-s.shutdownChan:
+		return protocol.ErrorString("ERR the server is shutting down")
+	default:
+	}
+	if !s.aofRewriting.CompareAndSwap(false, true) {
+		return protocol.ErrorString("ERR Background append only file rewriting already in progress")
+	}
+
+	writer := s.aofLog
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		defer s.aofRewriting.Store(false)
+		if err := writer.Rewrite(s.store.RewriteRecords(), s.config.AOFRewriteIncrementalFsync); err != nil {
+			fmt.Println("Background AOF rewrite failed:", err)
+			return
+		}
+		fmt.Println("Background AOF rewrite finished successfully")
+	}()
+	return protocol.SimpleString("Background append only file rewriting started")
+}
+
+// monitorAOF records the errors of the AOF writer. A failed writer stops
+// persisting commands, but the server keeps serving from memory.
+func (s *Server) monitorAOF(errChan <-chan error) {
+	for err := range errChan {
+		fmt.Printf("AOF error: %v. AOF persistence is disabled until restart\n", err)
+		s.mu.Lock()
+		s.aofErr = err
+		s.mu.Unlock()
+	}
+}
+
+// aofError returns the last error of the AOF writer, if any
+func (s *Server) aofError() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.aofErr
+}
+
+// loadData recovers the dataset and then starts persisting it. The periodic
+// snapshots only start once loading is over, so they never save a partial
+// dataset.
+func (s *Server) loadData() {
+	s.recoverStore()
+	if s.config.UseRDB {
+		s.startRDB()
+		fmt.Println("RDB persistence enabled")
+	}
+	if s.config.UseAOF {
+		s.startAOF(s.aofFilepath())
+		fmt.Println("AOF persistence enabled")
+	}
+}
+
+// loadInBackground runs load while the server accepts connections. Until it
+// returns, commands that need the dataset are rejected with a LOADING error.
+// Shutdown waits for it, so the final snapshot is never partial.
+func (s *Server) loadInBackground(load func()) {
+	s.loading.Store(true)
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		defer s.loading.Store(false)
+		load()
+	}()
+}
+
+// recoverStore loads the dataset from the persistence files. With both RDB
+// and AOF enabled the configured preference is tried first, as the AOF is
+// usually more recent than the last snapshot, and the other file is the
+// fallback. A file that fails to load leaves nothing behind.
 func (s *Server) recoverStore() {
-	rdbFilepath := filepath.Join(s.dataDir, "dump.rdb")
-	aofFilepath := filepath.Join(s.dataDir, "appendonly.aof")
-	flagOk := false
+	type source struct {
+		name string
+		load func() error
+	}
+	rdbSource := source{"RDB snapshot " + s.rdbFilepath(), func() error {
+		return rdb.LoadSnapshot(s.store, s.rdbFilepath())
+	}}
+	aofSource := source{"AOF " + s.aofFilepath(), func() error {
+		return aof.RebuildStoreFromAOF(s.store, s.aofFilepath(), s.replay)
+	}}
+
+	var sources []source
+	if s.config.UseAOF {
+		sources = append(sources, aofSource)
+	}
 	if s.config.UseRDB {
-		if err := rdb.LoadSnapshot(s.store, rdbFilepath); err != nil {
-			fmt.Println("No snapshot found.")
+		if s.config.RecoveryPreference == RecoveryRDBPreferred {
+			sources = append([]source{rdbSource}, sources...)
 		} else {
-			flagOk = true
+			sources = append(sources, rdbSource)
 		}
 	}
 
-	if s.config.UseAOF && !flagOk {
-		if err := aof.RebuildStoreFromAOF(s.store, aofFilepath); err != nil {
-			fmt.Println("Error loading from AOF:", err)
-
-		} else {
-			flagOk = true
+	for _, src := range sources {
+		if err := src.load(); err != nil {
+			fmt.Printf("Could not load the %s: %v\n", src.name, err)
+			// Drop what was loaded before the error, without logging it
+			s.store.SetReplaying(true)
+			s.store.FlushAll()
+			s.store.SetReplaying(false)
+			continue
 		}
+		fmt.Printf("Data recovered from the %s\n", src.name)
+		return
+	}
+	fmt.Println("None of the recovery files are healthy. Starting with an empty store.")
+}
+
+// replay runs a write command read from the AOF on dbIndex. It goes through
+// dispatch like the commands of clients, skipping the checks that only apply
+// to them, such as ACLs and read-only mode.
+func (s *Server) replay(dbIndex int, args []string) error {
+	command := strings.ToUpper(args[0])
+	if !availableCommands[command].isWrite() {
+		return fmt.Errorf("%s is not a write command", command)
 	}
-	if !flagOk {
-		fmt.Println("None of the recovery files are healthy. Starting with an empty store.")
+	if dbIndex < 0 || dbIndex >= s.store.Count() {
+		return fmt.Errorf("invalid DB index %d", dbIndex)
+	}
+	client := &Client{db: dbIndex, user: "default", proto: &resp2.RESP2Protocol{}}
+	reply, err := s.dispatch(client, command, args)
+	if err != nil {
+		return err
 	}
+	if e, ok := reply.(protocol.ErrorString); ok {
+		return errors.New(string(e))
+	}
+	return nil
 }
 
 func (s *Server) asciiLogo() string {
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Sampling of instantaneous_ops_per_sec: the rate is averaged over the last
+// opsSamples samples taken every opsSampleInterval, as Redis does
+const (
+	opsSampleInterval = 100 * time.Millisecond
+	opsSamples        = 16
+)
+
+// stats are the server counters reported by INFO stats. The keyspace
+// counters are kept by the store.
+type stats struct {
+	// connectionsReceived survives CONFIG RESETSTAT, as in Redis
+	connectionsReceived atomic.Int64
+	// counters are swapped for zeroed ones by CONFIG RESETSTAT
+	counters atomic.Pointer[statCounters]
+
+	mu          sync.Mutex
+	samples     [opsSamples]int64 // ops per second, a ring buffer
+	sampleIndex int
+	lastSample  time.Time
+	lastCount   int64
+}
+
+// statCounters are the counters reset by CONFIG RESETSTAT
+type statCounters struct {
+	commandsProcessed atomic.Int64
+}
+
+// current returns the counters in use
+func (st *stats) current() *statCounters {
+	return st.counters.Load()
+}
+
+// reset zeroes the counters and the ops per second samples
+func (st *stats) reset() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.counters.Store(&statCounters{})
+	st.samples = [opsSamples]int64{}
+	st.lastCount = 0
+}
+
+// sampleOps records the ops per second since the previous sample
+func (st *stats) sampleOps(now time.Time) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	count := st.current().commandsProcessed.Load()
+	if !st.lastSample.IsZero() {
+		if elapsed := now.Sub(st.lastSample); elapsed > 0 {
+			st.samples[st.sampleIndex] = (count - st.lastCount) * int64(time.Second) / int64(elapsed)
+			st.sampleIndex = (st.sampleIndex + 1) % opsSamples
+		}
+	}
+	st.lastSample, st.lastCount = now, count
+}
+
+// opsPerSec returns the average of the samples
+func (st *stats) opsPerSec() int64 {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var sum int64
+	for _, sample := range st.samples {
+		sum += sample
+	}
+	return sum / opsSamples
+}
+
+// startStatsSampler samples the ops per second until Shutdown
+func (s *Server) startStatsSampler() {
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		ticker := time.NewTicker(opsSampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				s.stats.sampleOps(now)
+			case <-s.shutdownChan:
+				return
+			}
+		}
+	}()
+}
+
+// resetStats runs CONFIG RESETSTAT. Info holds s.mu too, so it sees the
+// server and keyspace counters either all before or all after the reset.
+func (s *Server) resetStats() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.reset()
+	s.store.ResetStats()
+}
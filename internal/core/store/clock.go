@@ -0,0 +1,31 @@
+package store
+
+import "time"
+
+// Clock tells the store the current time, which decides when keys expire.
+// Tests replace the real clock to expire keys without sleeping.
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock is the wall clock
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// SetClock replaces the clock of the store. It must be called before the
+// store is shared with other goroutines.
+func (s *Store) SetClock(clock Clock) {
+	s.clock = clock
+}
+
+// now returns the current time according to the clock of the store
+func (s *Store) now() time.Time {
+	return s.clock.Now()
+}
+
+// isExpired reports whether value has expired according to the clock of the
+// store
+func (s *Store) isExpired(value *Value) bool {
+	return value.IsExpiredAt(s.now())
+}
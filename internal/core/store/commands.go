@@ -2,33 +2,51 @@ package store
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Set sets the value for a key
 // Consider ret
 func (s *Store) Set(dbIndex int, key string, rawValue any, args ...string) (bool, error) {
-	setOptions, err := parseSetOptions(args)
+	setOptions, err := ParseSetOptions(args)
 	if err != nil {
 		return false, err
 	}
+	_, ok, err := s.SetWithOptions(dbIndex, key, rawValue, setOptions)
+	return ok, err
+}
+
+// SetWithOptions sets the value for a key honoring already parsed SET options.
+// It returns the value previously stored at key (nil if there was none) and
+// whether the new value was actually written.
+func (s *Store) SetWithOptions(dbIndex int, key string, rawValue any, setOptions *SetOptions) (*Value, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, exists := s.data[dbIndex][key]
+	if exists && s.isExpired(old) {
+		old, exists = nil, false
+	}
+	// GET only works against string values, and fails before anything is written
+	if setOptions.GET && exists && old.Type != TypeString {
+		return nil, false, ErrWrongType
+	}
 	// Handle NX and XX options
-	if setOptions.NX && s.Exists(dbIndex, key) > 0 {
-		return false, nil
+	if setOptions.NX && exists {
+		return old, false, nil
 	}
-	if setOptions.XX && s.Exists(dbIndex, key) == 0 {
-		return false, nil
+	if setOptions.XX && !exists {
+		return nil, false, nil
 	}
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	// write to AOF before setting the value (WAL)
-	s.aofChan <- fmt.Sprintf("SET %d %s %v", dbIndex, key, rawValue)
+
 	var value *Value
 	switch v := rawValue.(type) {
 	case string:
 		value = NewStringValue(v)
-	case []any:
+	case []string:
 		value = NewListValue(v)
 	case map[string]any:
 		value = NewHashValue(v)
@@ -40,68 +58,191 @@ func (s *Store) Set(dbIndex int, key string, rawValue any, args ...string) (bool
 		// Fallback to string representation
 		value = NewStringValue(fmt.Sprintf("%v", rawValue))
 	}
-	s.data[dbIndex][key] = value
-	return true, nil
+
+	// write to AOF before setting the value (WAL)
+	record := []string{key, fmt.Sprintf("%v", rawValue)}
+	if expiresAt := setOptions.expiresAt(s.now()); expiresAt != nil {
+		value.ExpiresAt = expiresAt
+		record = append(record, "PXAT", strconv.FormatInt(expiresAt.UnixMilli(), 10))
+	} else if setOptions.KEEPTTL && exists {
+		value.ExpiresAt = old.ExpiresAt
+		record = append(record, "KEEPTTL")
+	}
+	s.logAOF("SET", dbIndex, record...)
+
+	s.putKey(dbIndex, key, value)
+	return old, true, nil
 }
 
 type SetOptions struct {
-	NX bool // Only set if key does not exist
-	XX bool // Only set if key exists
-	EX int  // Expire time in seconds
-	PX int  // Expire time in milliseconds
+	NX      bool  // Only set if key does not exist
+	XX      bool  // Only set if key exists
+	GET     bool  // Return the old string stored at key
+	KEEPTTL bool  // Retain the time to live associated with the key
+	EX      int   // Expire time in seconds
+	PX      int   // Expire time in milliseconds
+	EXAT    int64 // Expire at the given unix time in seconds
+	PXAT    int64 // Expire at the given unix time in milliseconds
+}
+
+// expiresAt returns the absolute expiration requested by the options, or nil
+// when no expiry option was given.
+func (o *SetOptions) expiresAt(now time.Time) *time.Time {
+	var ms int64
+	switch {
+	case o.EX > 0:
+		ms = now.UnixMilli() + int64(o.EX)*1000
+	case o.PX > 0:
+		ms = now.UnixMilli() + int64(o.PX)
+	case o.EXAT > 0:
+		ms = o.EXAT * 1000
+	case o.PXAT > 0:
+		ms = o.PXAT
+	default:
+		return nil
+	}
+	expiresAt := time.UnixMilli(ms)
+	return &expiresAt
+}
+
+// hasExpiry reports whether one of EX, PX, EXAT or PXAT was given
+func (o *SetOptions) hasExpiry() bool {
+	return o.EX > 0 || o.PX > 0 || o.EXAT > 0 || o.PXAT > 0
 }
 
-func parseSetOptions(args []string) (*SetOptions, error) {
+// ParseSetOptions parses the optional arguments of the SET command, rejecting
+// the same option combinations Redis does.
+func ParseSetOptions(args []string) (*SetOptions, error) {
 	options := &SetOptions{}
 	i := 0
 	for i < len(args) {
-		switch strings.ToUpper(args[i]) {
+		option := strings.ToUpper(args[i])
+		switch option {
 		case "NX":
+			if options.XX {
+				return nil, ErrSyntax
+			}
 			options.NX = true
 			i++
 		case "XX":
+			if options.NX {
+				return nil, ErrSyntax
+			}
 			options.XX = true
 			i++
-		case "EX":
-			if i+1 >= len(args) {
-				return nil, fmt.Errorf("missing value for EX option")
-			}
-			seconds, err := strconv.Atoi(args[i+1])
-			if err != nil {
-				return nil, fmt.Errorf("invalid value for EX option")
+		case "GET":
+			options.GET = true
+			i++
+		case "KEEPTTL":
+			if options.hasExpiry() {
+				return nil, ErrSyntax
 			}
-			options.EX = seconds
-			i += 2
-		case "PX":
-			if i+1 >= len(args) {
-				return nil, fmt.Errorf("missing value for PX option")
+			options.KEEPTTL = true
+			i++
+		case "EX", "PX", "EXAT", "PXAT":
+			if options.KEEPTTL || options.hasExpiry() || i+1 >= len(args) {
+				return nil, ErrSyntax
 			}
-			milliseconds, err := strconv.Atoi(args[i+1])
-			if err != nil {
-				return nil, fmt.Errorf("invalid value for PX option")
+			if err := options.parseExpiry("set", option, args[i+1]); err != nil {
+				return nil, err
 			}
-			options.PX = milliseconds
 			i += 2
 		default:
-			return nil, fmt.Errorf("unknown option: %s", args[i])
+			return nil, ErrSyntax
 		}
 	}
-	if options.NX && options.XX {
-		return nil, fmt.Errorf("ERR syntax error")
-	}
 	return options, nil
 }
 
+// parseExpiry sets the EX, PX, EXAT or PXAT option from its argument
+func (o *SetOptions) parseExpiry(command, option, arg string) error {
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return ErrNotInteger
+	}
+	if !validExpireTime(option, n) {
+		return ErrInvalidExpireTime(command)
+	}
+	switch option {
+	case "EX":
+		o.EX = int(n)
+	case "PX":
+		o.PX = int(n)
+	case "EXAT":
+		o.EXAT = n
+	case "PXAT":
+		o.PXAT = n
+	}
+	return nil
+}
+
+// validExpireTime checks that an EX/PX/EXAT/PXAT argument is positive and
+// that, once converted to an absolute unix time in milliseconds, it does not
+// overflow.
+func validExpireTime(option string, n int64) bool {
+	if n <= 0 {
+		return false
+	}
+	if option == "EX" || option == "EXAT" {
+		if n > math.MaxInt64/1000 {
+			return false
+		}
+		n *= 1000
+	}
+	if option == "EX" || option == "PX" {
+		return n <= math.MaxInt64-time.Now().UnixMilli()
+	}
+	return true
+}
+
 // Get retrieves the value for a key
 func (s *Store) Get(dbIndex int, key string) (*Value, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	value, ok := s.data[dbIndex][key]
-	if !ok {
-		return nil, false
+	return s.lookupRead(dbIndex, key)
+}
+
+// MSet sets keys to string values, given as pairs of key and value, all
+// under the same lock. Each pair is logged as its own SET.
+func (s *Store) MSet(dbIndex int, pairs ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := 0; i+1 < len(pairs); i += 2 {
+		s.logAOF("SET", dbIndex, pairs[i], pairs[i+1])
+		s.putKey(dbIndex, pairs[i], NewStringValue(pairs[i+1]))
 	}
-	if value != nil && value.IsExpired() {
-		return nil, false
+}
+
+// GetMulti retrieves the string values of keys under a single read lock,
+// with nil for the keys that don't exist, have expired or hold another type.
+func (s *Store) GetMulti(dbIndex int, keys []string) []*string {
+	values := make([]*string, len(keys))
+	s.readMulti(dbIndex, keys, func(i int, value *Value) {
+		if str, err := value.AsString(); err == nil {
+			values[i] = &str
+		}
+	})
+	return values
+}
+
+// readMulti calls read with the index and value of each of keys that exists,
+// under a single read lock. Expired keys are collected afterwards, in a
+// separate pass under the write lock.
+func (s *Store) readMulti(dbIndex int, keys []string, read func(i int, value *Value)) {
+	var expired []string
+	s.mu.RLock()
+	for i, key := range keys {
+		if value, ok := s.lookupRead(dbIndex, key); ok {
+			read(i, value)
+		} else if _, ok := s.data[dbIndex][key]; ok {
+			expired = append(expired, key)
+		}
+	}
+	s.mu.RUnlock()
+
+	// expireIfNeeded checks again, the key may have been rewritten meanwhile
+	for _, key := range expired {
+		s.expireIfNeeded(dbIndex, key)
 	}
-	return value, ok
 }
@@ -0,0 +1,67 @@
+package store
+
+import "time"
+
+// KeyInfo is what the introspection commands (TYPE, TTL, PTTL, OBJECT and
+// DEBUG OBJECT) report about a key, read at once so they stay consistent
+type KeyInfo struct {
+	Type     string        // as TYPE reports it
+	Encoding string        // as OBJECT ENCODING reports it
+	TTL      int64         // milliseconds left, -1 without an expiry
+	Idle     time.Duration // since the key was last accessed
+
+	// Size and ListNodes walk the whole value, so only DescribeSize fills
+	// them in.
+	Size int // serialized length, as DEBUG OBJECT reports it
+	// ListNodes holds the number of entries in each quicklist node of a
+	// list, and is nil for the other types
+	ListNodes []int
+}
+
+// TTLSeconds returns the time to live in seconds, rounded up so a key is
+// never reported as expiring sooner than it will, or -1 without an expiry
+func (k KeyInfo) TTLSeconds() int64 {
+	switch {
+	case k.TTL < 0:
+		return -1
+	case k.TTL == 0:
+		return 0
+	}
+	return (k.TTL + 999) / 1000
+}
+
+// Describe returns the KeyInfo of key, under a single read lock, without
+// Size and ListNodes. It takes the same time whatever the size of the value,
+// and doesn't count as an access of the key. The bool is false when the key
+// doesn't exist.
+func (s *Store) Describe(dbIndex int, key string) (KeyInfo, bool) {
+	return s.describe(dbIndex, key, false)
+}
+
+// DescribeSize is Describe with Size and ListNodes too, which takes time
+// proportional to the size of the value
+func (s *Store) DescribeSize(dbIndex int, key string) (KeyInfo, bool) {
+	return s.describe(dbIndex, key, true)
+}
+
+func (s *Store) describe(dbIndex int, key string, withSize bool) (KeyInfo, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.data[dbIndex][key]
+	if !ok || s.isExpired(value) {
+		return KeyInfo{}, false
+	}
+	info := KeyInfo{
+		Type:     value.Type.String(),
+		Encoding: s.encoding(value),
+		TTL:      max(value.TTLMillis(s.now()), -1),
+		Idle:     value.idleTime(s.now()),
+	}
+	if withSize {
+		info.Size = value.SerializedSize()
+		if list, err := value.AsList(); err == nil {
+			info.ListNodes = s.listNodes(list)
+		}
+	}
+	return info, true
+}
@@ -0,0 +1,58 @@
+package store
+
+import "time"
+
+// DirtyMark holds the dirty counters of every database at the time a
+// snapshot was taken, so that saving it only clears the writes it holds
+type DirtyMark []int64
+
+// markDirty counts a write to dbIndex. Writes replayed from the AOF are
+// already persisted, so they aren't counted. The caller holds s.mu for
+// writing.
+func (s *Store) markDirty(dbIndex int) {
+	if s.replaying.Load() {
+		return
+	}
+	s.dirty[dbIndex]++
+}
+
+// Dirty returns the number of writes to all databases since the last
+// successful save
+func (s *Store) Dirty() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var total int64
+	for _, dirty := range s.dirty {
+		total += dirty
+	}
+	return total
+}
+
+// DBDirty returns the number of writes to dbIndex since the last successful
+// save
+func (s *Store) DBDirty(dbIndex int) int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.dirty[dbIndex]
+}
+
+// MarkSaved clears the writes counted up to mark, once the snapshot taken
+// with it is safely on disk. Writes made while the snapshot was being saved
+// stay counted.
+func (s *Store) MarkSaved(mark DirtyMark) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for dbIndex, saved := range mark {
+		s.dirty[dbIndex] = max(s.dirty[dbIndex]-saved, 0)
+	}
+	s.lastSave = time.Now()
+}
+
+// LastSave returns when the dataset was last saved successfully
+func (s *Store) LastSave() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastSave
+}
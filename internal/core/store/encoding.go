@@ -0,0 +1,240 @@
+package store
+
+import "strconv"
+
+// EncodingLimits are the sizes up to which values keep their compact
+// encoding, as the *-max-listpack-* settings of Redis
+type EncodingLimits struct {
+	// ListMaxListpackSize caps each listpack node of a list: a positive value
+	// is a number of entries, -1 to -5 a size of 4kb to 64kb
+	ListMaxListpackSize    int
+	HashMaxListpackEntries int
+	HashMaxListpackValue   int
+	ZSetMaxListpackEntries int
+	ZSetMaxListpackValue   int
+}
+
+// DefaultEncodingLimits returns the Redis defaults
+func DefaultEncodingLimits() EncodingLimits {
+	return EncodingLimits{
+		ListMaxListpackSize:    -2,
+		HashMaxListpackEntries: 128,
+		HashMaxListpackValue:   64,
+		ZSetMaxListpackEntries: 128,
+		ZSetMaxListpackValue:   64,
+	}
+}
+
+// SetEncodingLimits changes the limits applied from now on. Values that were
+// already converted keep their large encoding.
+func (s *Store) SetEncodingLimits(limits EncodingLimits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.encodingLimits = limits
+}
+
+// updateEncoding converts value to its large encoding once it outgrows the
+// compact one. As in Redis the conversion is never undone while the value
+// lives, even if it shrinks again. The caller holds s.mu.
+func (s *Store) updateEncoding(value *Value) {
+	if value.converted {
+		return
+	}
+	switch value.Type {
+	case TypeHash:
+		hash, _ := value.AsHash()
+		if len(hash) > s.encodingLimits.HashMaxListpackEntries {
+			value.converted = true
+			return
+		}
+		for field, v := range hash {
+			if len(field) > s.encodingLimits.HashMaxListpackValue || len(stringOf(v)) > s.encodingLimits.HashMaxListpackValue {
+				value.converted = true
+				return
+			}
+		}
+	case TypeZSet:
+		zset, _ := value.AsZSet()
+		if len(zset) > s.encodingLimits.ZSetMaxListpackEntries {
+			value.converted = true
+			return
+		}
+		for member := range zset {
+			if len(member) > s.encodingLimits.ZSetMaxListpackValue {
+				value.converted = true
+				return
+			}
+		}
+	}
+}
+
+// encoding returns the name of the internal encoding Redis would use for the
+// value, as reported by OBJECT ENCODING. Strings are int, embstr or raw, see
+// stringEncoding. Lists are a single listpack while
+// they fit in one node and a quicklist of listpacks beyond that; unlike the
+// other types they switch back when they shrink. The caller holds s.mu.
+func (s *Store) encoding(value *Value) string {
+	switch value.Type {
+	case TypeString:
+		return stringEncoding(stringOf(value.Data))
+	case TypeList:
+		list, _ := value.AsList()
+		return s.listEncoding(list)
+	case TypeHash:
+		if value.converted {
+			return "hashtable"
+		}
+		return "listpack"
+	case TypeSet:
+		return "hashtable"
+	case TypeZSet:
+		if value.converted {
+			return "skiplist"
+		}
+		return "listpack"
+	default:
+		return "unknown"
+	}
+}
+
+// listEncoding returns the encoding of list, a quicklist when it doesn't
+// fit in a single node. Only the entries of the first node are examined. The
+// caller holds s.mu.
+func (s *Store) listEncoding(list []string) string {
+	maxEntries, maxBytes := s.listNodeLimits()
+	if maxEntries > 0 {
+		if len(list) > maxEntries {
+			return "quicklist"
+		}
+		return "listpack"
+	}
+	bytes := listpackOverhead
+	for i, item := range list {
+		size := listpackEntrySize(len(item))
+		if i > 0 && bytes+size > maxBytes {
+			return "quicklist"
+		}
+		bytes += size
+	}
+	return "listpack"
+}
+
+// ObjectEncoding returns the encoding of the value stored at key
+func (s *Store) ObjectEncoding(dbIndex int, key string) (string, bool) {
+	info, ok := s.Describe(dbIndex, key)
+	return info.Encoding, ok
+}
+
+// Sizes of the structures Redis allocates around list entries
+const (
+	listpackOverhead  = 7  // total bytes and entry count header, end byte
+	quicklistOverhead = 40 // the quicklist itself
+	quicklistNodeSize = 32 // each node pointing to a listpack
+)
+
+// listpackEntrySize returns the bytes an entry of n bytes takes in a
+// listpack: the encoding header, the data and the back length
+func listpackEntrySize(n int) int {
+	header := 5
+	switch {
+	case n < 64:
+		header = 1
+	case n < 4096:
+		header = 2
+	}
+	size := header + n
+	switch {
+	case size < 1<<7:
+		return size + 1
+	case size < 1<<14:
+		return size + 2
+	case size < 1<<21:
+		return size + 3
+	case size < 1<<28:
+		return size + 4
+	default:
+		return size + 5
+	}
+}
+
+// listNodeLimits returns the most entries, or else bytes, a quicklist node
+// holds according to list-max-listpack-size. The other limit is 0.
+func (s *Store) listNodeLimits() (maxEntries, maxBytes int) {
+	limit := s.encodingLimits.ListMaxListpackSize
+	if limit > 0 {
+		return limit, 0
+	}
+	if limit < -5 || limit == 0 {
+		limit = -2
+	}
+	return 0, 4096 << (-limit - 1)
+}
+
+// listNodes splits list the way a quicklist fills its listpack nodes and
+// returns the number of entries in each node. The caller holds s.mu.
+func (s *Store) listNodes(list []string) []int {
+	maxEntries, maxBytes := s.listNodeLimits()
+	var nodes []int
+	entries, bytes := 0, listpackOverhead
+	for _, item := range list {
+		size := listpackEntrySize(len(item))
+		full := (maxEntries > 0 && entries == maxEntries) || (maxBytes > 0 && bytes+size > maxBytes)
+		if entries > 0 && full {
+			nodes = append(nodes, entries)
+			entries, bytes = 0, listpackOverhead
+		}
+		entries++
+		bytes += size
+	}
+	if entries > 0 {
+		nodes = append(nodes, entries)
+	}
+	return nodes
+}
+
+// listOverhead returns the bytes a list takes on top of its serialized
+// entries: one listpack, or a quicklist with a node and a listpack per node.
+// The caller holds s.mu.
+func (s *Store) listOverhead(list []string) int {
+	nodes := len(s.listNodes(list))
+	if nodes <= 1 {
+		return listpackOverhead
+	}
+	return quicklistOverhead + nodes*(quicklistNodeSize+listpackOverhead)
+}
+
+// ListpackEntries returns the number of listpack entries holding the list at
+// key, summed over the quicklist nodes
+func (s *Store) ListpackEntries(dbIndex int, key string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.data[dbIndex][key]
+	if !ok || s.isExpired(value) {
+		return 0, ErrNoSuchKey
+	}
+	list, err := value.AsList()
+	if err != nil {
+		return 0, err
+	}
+	entries := 0
+	for _, n := range s.listNodes(list) {
+		entries += n
+	}
+	return entries, nil
+}
+
+// embstrSizeLimit is the longest string Redis allocates together with its
+// object header
+const embstrSizeLimit = 44
+
+// stringEncoding returns int for a string holding a 64 bit integer in its
+// canonical form, embstr for a short string and raw for a longer one
+func stringEncoding(str string) string {
+	if n, err := strconv.ParseInt(str, 10, 64); err == nil && strconv.FormatInt(n, 10) == str {
+		return "int"
+	}
+	if len(str) <= embstrSizeLimit {
+		return "embstr"
+	}
+	return "raw"
+}
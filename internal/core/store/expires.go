@@ -0,0 +1,192 @@
+package store
+
+import (
+	"container/heap"
+	"time"
+)
+
+// expiryEntry is a key of the expiry index with the time it expires at
+type expiryEntry struct {
+	key string
+	at  time.Time
+	// index is the position of the entry in the heap
+	index int
+}
+
+// expiryIndex holds the keys of a database that have a TTL, in a min-heap
+// ordered by expiry so the soonest to expire is always first. byKey finds
+// the entry of a key to update or remove it in O(log n).
+type expiryIndex struct {
+	entries []*expiryEntry
+	byKey   map[string]*expiryEntry
+}
+
+func newExpiryIndex() *expiryIndex {
+	return &expiryIndex{byKey: make(map[string]*expiryEntry)}
+}
+
+func (x *expiryIndex) Len() int           { return len(x.entries) }
+func (x *expiryIndex) Less(i, j int) bool { return x.entries[i].at.Before(x.entries[j].at) }
+
+func (x *expiryIndex) Swap(i, j int) {
+	x.entries[i], x.entries[j] = x.entries[j], x.entries[i]
+	x.entries[i].index = i
+	x.entries[j].index = j
+}
+
+func (x *expiryIndex) Push(e any) {
+	entry := e.(*expiryEntry)
+	entry.index = len(x.entries)
+	x.entries = append(x.entries, entry)
+}
+
+func (x *expiryIndex) Pop() any {
+	last := len(x.entries) - 1
+	entry := x.entries[last]
+	x.entries[last] = nil
+	x.entries = x.entries[:last]
+	return entry
+}
+
+// set indexes key as expiring at at, moving it if it already was
+func (x *expiryIndex) set(key string, at time.Time) {
+	if entry, ok := x.byKey[key]; ok {
+		entry.at = at
+		heap.Fix(x, entry.index)
+		return
+	}
+	entry := &expiryEntry{key: key, at: at}
+	x.byKey[key] = entry
+	heap.Push(x, entry)
+}
+
+// remove drops key from the index, if it is there
+func (x *expiryIndex) remove(key string) {
+	if entry, ok := x.byKey[key]; ok {
+		heap.Remove(x, entry.index)
+		delete(x.byKey, key)
+	}
+}
+
+// peek returns the entry that expires first, or nil when no key has a TTL
+func (x *expiryIndex) peek() *expiryEntry {
+	if len(x.entries) == 0 {
+		return nil
+	}
+	return x.entries[0]
+}
+
+// indexExpiry brings the expiry index in line with the TTL of value, stored
+// at key. It is called whenever a key is stored or its TTL changes. The
+// caller holds s.mu.
+func (s *Store) indexExpiry(dbIndex int, key string, value *Value) {
+	if value.ExpiresAt == nil {
+		s.expires[dbIndex].remove(key)
+		return
+	}
+	s.expires[dbIndex].set(key, *value.ExpiresAt)
+}
+
+// reindexExpiries rebuilds the expiry index of every database, after the
+// whole dataset was replaced. The caller holds s.mu.
+func (s *Store) reindexExpiries() {
+	s.expires = make([]*expiryIndex, len(s.data))
+	for dbIndex, db := range s.data {
+		s.expires[dbIndex] = newExpiryIndex()
+		for key, value := range db {
+			s.indexExpiry(dbIndex, key, value)
+		}
+	}
+}
+
+// NextExpiry returns when the soonest key to expire, in any database, does.
+// It returns false when no key has a TTL.
+func (s *Store) NextExpiry() (time.Time, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var next time.Time
+	found := false
+	for _, index := range s.expires {
+		if entry := index.peek(); entry != nil && (!found || entry.at.Before(next)) {
+			next, found = entry.at, true
+		}
+	}
+	return next, found
+}
+
+// ActiveExpire deletes up to limit keys whose TTL has passed, across every
+// database, for the active expiry cycle. Only the keys that are due are
+// looked at, taken from the expiry index in the order they expire, so keys
+// without a TTL cost nothing. It returns how many keys were examined and how
+// many of them were deleted; each deletion is logged as expireIfNeeded does.
+func (s *Store) ActiveExpire(limit int) (examined, expired int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for dbIndex, index := range s.expires {
+		for examined < limit {
+			entry := index.peek()
+			if entry == nil || !now.After(entry.at) {
+				break
+			}
+			examined++
+			value, ok := s.data[dbIndex][entry.key]
+			if !ok || !s.isExpired(value) {
+				// The index is stale, resync it with the key
+				if ok {
+					s.indexExpiry(dbIndex, entry.key, value)
+				} else {
+					index.remove(entry.key)
+				}
+				continue
+			}
+			s.delKey(dbIndex, entry.key)
+			s.logAOF("DEL", dbIndex, entry.key)
+			expired++
+		}
+	}
+	return examined, expired
+}
+
+// sweepBatch is the most keys a sweep examines while holding the lock, so it
+// never blocks the commands for long
+const sweepBatch = 100
+
+// StartExpirationSweeper starts a goroutine that deletes the expired keys
+// every interval, so the memory of keys that are never read again is
+// reclaimed. Each sweep deletes every key that is due, sweepBatch at a time,
+// releasing the lock between batches. Nothing is swept while the AOF is
+// replayed. The goroutine stops once stop is closed, and closes the returned
+// channel when it has.
+func (s *Store) StartExpirationSweeper(interval time.Duration, stop <-chan struct{}) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.sweepExpired(stop)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return done
+}
+
+// sweepExpired deletes the keys that are due until none is left or stop is
+// closed
+func (s *Store) sweepExpired(stop <-chan struct{}) {
+	for !s.replaying.Load() {
+		if examined, _ := s.ActiveExpire(sweepBatch); examined < sweepBatch {
+			return
+		}
+		select {
+		case <-stop:
+			return
+		default:
+		}
+	}
+}
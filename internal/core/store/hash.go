@@ -0,0 +1,96 @@
+package store
+
+// HSet sets field/value pairs in the hash stored at key and returns the
+// number of fields that were added
+func (s *Store) HSet(dbIndex int, key string, pairs ...string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.data[dbIndex][key]
+	isNew := !ok || s.isExpired(value)
+	if isNew {
+		value = NewHashValue(make(map[string]any, len(pairs)/2))
+	}
+	hash, err := value.AsHash()
+	if err != nil {
+		return 0, err
+	}
+
+	s.logAOF("HSET", dbIndex, append([]string{key}, pairs...)...)
+
+	added, delta := 0, 0
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if old, exists := hash[pairs[i]]; exists {
+			delta -= stringSize(stringOf(old))
+		} else {
+			added++
+			delta += stringSize(pairs[i])
+		}
+		// Values are stored as strings so they are returned byte for byte
+		hash[pairs[i]] = pairs[i+1]
+		delta += stringSize(pairs[i+1])
+	}
+	s.updateEncoding(value)
+	if isNew {
+		s.putKey(dbIndex, key, value)
+	} else {
+		s.grow(value, delta)
+	}
+	return added, nil
+}
+
+// HGet returns the value of a field in the hash stored at key
+func (s *Store) HGet(dbIndex int, key, field string) (string, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hash, err := s.getHash(dbIndex, key)
+	if err != nil || hash == nil {
+		return "", false, err
+	}
+	value, ok := hash[field]
+	if !ok {
+		return "", false, nil
+	}
+	return stringOf(value), true, nil
+}
+
+// HGetAll returns the fields and values of the hash stored at key as a flat
+// field, value, ... slice ordered by field
+func (s *Store) HGetAll(dbIndex int, key string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hash, err := s.getHash(dbIndex, key)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0, len(hash)*2)
+	for _, field := range sortedKeys(hash) {
+		result = append(result, field, stringOf(hash[field]))
+	}
+	return result, nil
+}
+
+// HVals returns the values of the hash stored at key ordered by field
+func (s *Store) HVals(dbIndex int, key string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hash, err := s.getHash(dbIndex, key)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0, len(hash))
+	for _, field := range sortedKeys(hash) {
+		result = append(result, stringOf(hash[field]))
+	}
+	return result, nil
+}
+
+// getHash returns the hash stored at key, or nil if the key does not exist.
+// The caller must hold the lock.
+func (s *Store) getHash(dbIndex int, key string) (map[string]any, error) {
+	value, ok := s.lookupRead(dbIndex, key)
+	if !ok {
+		return nil, nil
+	}
+	return value.AsHash()
+}
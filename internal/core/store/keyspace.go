@@ -0,0 +1,111 @@
+package store
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"time"
+)
+
+// keyspaceEntry is a key as written by DumpKeyspace
+type keyspaceEntry struct {
+	Key   string `json:"key"`
+	Type  string `json:"type"`
+	TTL   int64  `json:"ttl"` // milliseconds, -1 without an expiry
+	Value any    `json:"value"`
+}
+
+// zsetEntry is a sorted set member as written by DumpKeyspace
+type zsetEntry struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+// MarshalJSON writes the score as a number, or as "inf" or "-inf", which
+// JSON numbers can't hold
+func (e zsetEntry) MarshalJSON() ([]byte, error) {
+	var score any = e.Score
+	if math.IsInf(e.Score, 0) {
+		score = FormatScore(e.Score)
+	}
+	return json.Marshal(struct {
+		Member string `json:"member"`
+		Score  any    `json:"score"`
+	}{e.Member, score})
+}
+
+// DumpKeyspace returns the keys of a database as deterministic JSON, for
+// tests and inspection: keys are sorted, set members sorted and sorted set
+// members ordered by score. Each value is serialized under the read lock, a
+// copy taking time proportional to its size, and converted to JSON after
+// releasing it.
+func (s *Store) DumpKeyspace(dbIndex int) ([]byte, error) {
+	type snapshot struct {
+		payload   []byte
+		expiresAt *time.Time
+	}
+	s.mu.RLock()
+	keys := make(map[string]snapshot, len(s.data[dbIndex]))
+	for key, value := range s.data[dbIndex] {
+		if !s.isExpired(value) {
+			keys[key] = snapshot{value.Serialize(), value.ExpiresAt}
+		}
+	}
+	s.mu.RUnlock()
+
+	now := s.now()
+	entries := make([]keyspaceEntry, 0, len(keys))
+	for key, snap := range keys {
+		value, err := DeserializeValue(snap.payload)
+		if err != nil {
+			return nil, err
+		}
+		entry := keyspaceEntry{Key: key, Type: value.Type.String(), TTL: -1}
+		if snap.expiresAt != nil {
+			entry.TTL = snap.expiresAt.Sub(now).Milliseconds()
+		}
+		entry.Value = keyspaceValue(value)
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return json.Marshal(entries)
+}
+
+// keyspaceValue converts value to a JSON friendly form with a stable order
+func keyspaceValue(value *Value) any {
+	switch value.Type {
+	case TypeList:
+		list, _ := value.AsList()
+		return list
+	case TypeHash:
+		hash, _ := value.AsHash()
+		fields := make(map[string]string, len(hash))
+		for field, v := range hash {
+			fields[field] = stringOf(v)
+		}
+		return fields // encoding/json sorts map keys
+	case TypeSet:
+		set, _ := value.AsSet()
+		members := make([]string, 0, len(set))
+		for member := range set {
+			members = append(members, member)
+		}
+		sort.Strings(members)
+		return members
+	case TypeZSet:
+		zset, _ := value.AsZSet()
+		members := make([]zsetEntry, 0, len(zset))
+		for member, score := range zset {
+			members = append(members, zsetEntry{member, score})
+		}
+		sort.Slice(members, func(i, j int) bool {
+			if members[i].Score != members[j].Score {
+				return members[i].Score < members[j].Score
+			}
+			return members[i].Member < members[j].Member
+		})
+		return members
+	default:
+		return stringOf(value.Data)
+	}
+}
@@ -0,0 +1,245 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+)
+
+var ErrIndexOutOfRange = fmt.Errorf("ERR index out of range")
+
+// getList returns the live list at key and its elements, or nil when there is
+// none. The caller holds s.mu.
+func (s *Store) getList(dbIndex int, key string) (*Value, []string, error) {
+	value, ok := s.data[dbIndex][key]
+	if !ok || s.isExpired(value) {
+		return nil, nil, nil
+	}
+	list, err := value.AsList()
+	if err != nil {
+		return nil, nil, err
+	}
+	return value, list, nil
+}
+
+// LSet replaces the element at index, counting from the tail when negative
+func (s *Store) LSet(dbIndex int, key string, index int, element string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, list, err := s.getList(dbIndex, key)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		return ErrNoSuchKey
+	}
+	if index < 0 {
+		index += len(list)
+	}
+	if index < 0 || index >= len(list) {
+		return ErrIndexOutOfRange
+	}
+	s.grow(value, stringSize(element)-stringSize(list[index]))
+	list[index] = element
+	s.logAOF("LSET", dbIndex, key, strconv.Itoa(index), element)
+	return nil
+}
+
+// LInsert inserts element before or after the first occurrence of pivot. It
+// returns the new length, 0 when the key doesn't exist and -1 when pivot
+// isn't found.
+func (s *Store) LInsert(dbIndex int, key string, before bool, pivot, element string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, list, err := s.getList(dbIndex, key)
+	if err != nil || value == nil {
+		return 0, err
+	}
+	at := -1
+	for i, item := range list {
+		if item == pivot {
+			at = i
+			break
+		}
+	}
+	if at < 0 {
+		return -1, nil
+	}
+	where := "BEFORE"
+	if !before {
+		at++
+		where = "AFTER"
+	}
+	list = append(list[:at], append([]string{element}, list[at:]...)...)
+	value.Data = list
+	s.grow(value, stringSize(element))
+	s.logAOF("LINSERT", dbIndex, key, where, pivot, element)
+	return len(list), nil
+}
+
+// LRem removes the elements equal to element: the first count from the head
+// when count is positive, the last -count from the tail when negative, and
+// all of them when zero. It returns how many were removed.
+func (s *Store) LRem(dbIndex int, key string, count int, element string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, list, err := s.getList(dbIndex, key)
+	if err != nil || value == nil {
+		return 0, err
+	}
+
+	remove := make([]bool, len(list))
+	removed := 0
+	for n := 0; n < len(list) && (count == 0 || removed < abs(count)); n++ {
+		i := n
+		if count < 0 {
+			i = len(list) - 1 - n
+		}
+		if list[i] == element {
+			remove[i] = true
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+
+	kept := make([]string, 0, len(list)-removed)
+	for i, item := range list {
+		if !remove[i] {
+			kept = append(kept, item)
+		}
+	}
+	if len(kept) == 0 {
+		s.delKey(dbIndex, key)
+	} else {
+		value.Data = kept
+		s.grow(value, -removed*stringSize(element))
+	}
+	s.logAOF("LREM", dbIndex, key, strconv.Itoa(count), element)
+	return removed, nil
+}
+
+// LMove pops an element from one end of the list at source and pushes it to
+// one end of the list at destination, which may be the same key. The bool is
+// false when source doesn't exist.
+func (s *Store) LMove(dbIndex int, source, destination string, fromLeft, toLeft bool) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	srcValue, srcList, err := s.getList(dbIndex, source)
+	if err != nil || srcValue == nil {
+		return "", false, err
+	}
+	// Check the destination before changing anything
+	dstValue, _, err := s.getList(dbIndex, destination)
+	if err != nil {
+		return "", false, err
+	}
+
+	var element string
+	if fromLeft {
+		element, srcList = srcList[0], srcList[1:]
+	} else {
+		element, srcList = srcList[len(srcList)-1], srcList[:len(srcList)-1]
+	}
+	if len(srcList) == 0 {
+		s.delKey(dbIndex, source)
+		if source == destination {
+			dstValue = nil
+		}
+	} else {
+		srcValue.Data = srcList
+		s.grow(srcValue, -stringSize(element))
+	}
+
+	if dstValue == nil {
+		s.putKey(dbIndex, destination, NewListValue([]string{element}))
+	} else {
+		dstList, _ := dstValue.AsList()
+		if toLeft {
+			dstList = append([]string{element}, dstList...)
+		} else {
+			dstList = append(dstList, element)
+		}
+		dstValue.Data = dstList
+		s.grow(dstValue, stringSize(element))
+	}
+
+	s.logAOF("LMOVE", dbIndex, source, destination, listEnd(fromLeft), listEnd(toLeft))
+	return element, true, nil
+}
+
+// LPos returns the indexes of the elements equal to element. The search
+// starts at the head, or at the tail when rank is negative, and skips the
+// first abs(rank)-1 matches. It stops after count matches, or at the end of
+// the list when count is 0, and after comparing maxLen elements when maxLen
+// is positive. rank must not be 0.
+func (s *Store) LPos(dbIndex int, key, element string, rank, count, maxLen int) ([]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, ok := s.lookupRead(dbIndex, key)
+	if !ok {
+		return nil, nil
+	}
+	list, err := value.AsList()
+	if err != nil {
+		return nil, err
+	}
+
+	var positions []int
+	skip := abs(rank) - 1
+	for n := 0; n < len(list) && (maxLen == 0 || n < maxLen); n++ {
+		i := n
+		if rank < 0 {
+			i = len(list) - 1 - n
+		}
+		if list[i] != element {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		positions = append(positions, i)
+		if count > 0 && len(positions) == count {
+			break
+		}
+	}
+	return positions, nil
+}
+
+// LLen returns the number of elements of the list at key, 0 when it doesn't
+// exist
+func (s *Store) LLen(dbIndex int, key string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, ok := s.lookupRead(dbIndex, key)
+	if !ok {
+		return 0, nil
+	}
+	list, err := value.AsList()
+	if err != nil {
+		return 0, err
+	}
+	return len(list), nil
+}
+
+// listEnd names the end of a list as LMOVE does
+func listEnd(left bool) string {
+	if left {
+		return "LEFT"
+	}
+	return "RIGHT"
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
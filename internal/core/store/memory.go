@@ -0,0 +1,67 @@
+package store
+
+// keyOverhead approximates what a key costs besides its name and serialized
+// value: the dict entry, the Value itself and its expiry
+const keyOverhead = 64
+
+// entrySize returns the memory accounted for key holding value
+func entrySize(key string, value *Value) int64 {
+	return int64(keyOverhead + len(key) + value.SerializedSize())
+}
+
+// putKey stores value at key and accounts for its memory, replacing whatever
+// was there, and counts it as accessed. value may already be stored at key
+// and have changed in place. The caller holds s.mu.
+func (s *Store) putKey(dbIndex int, key string, value *Value) {
+	if old, ok := s.data[dbIndex][key]; ok && old != value {
+		s.usedMemory -= old.memSize
+		old.memSize = 0
+		if s.isExpired(old) {
+			s.counters.Load().expiredKeys.Add(1)
+		}
+	}
+	s.data[dbIndex][key] = value
+	value.touch(s.now())
+	s.keys[dbIndex].add(key)
+	s.indexExpiry(dbIndex, key, value)
+	size := entrySize(key, value)
+	s.usedMemory += size - value.memSize
+	value.memSize = size
+}
+
+// grow adjusts the memory accounted for a stored value by delta bytes, for
+// changes in place whose size is cheaper to compute than the whole value's.
+// The caller holds s.mu.
+func (s *Store) grow(value *Value, delta int) {
+	value.memSize += int64(delta)
+	s.usedMemory += int64(delta)
+}
+
+// recountMemory recomputes the memory of every key, after the whole dataset
+// was replaced. The caller holds s.mu.
+func (s *Store) recountMemory() {
+	s.usedMemory = 0
+	for _, db := range s.data {
+		for key, value := range db {
+			value.memSize = entrySize(key, value)
+			s.usedMemory += value.memSize
+		}
+	}
+}
+
+// UsedMemory returns the approximate number of bytes used by the dataset. It
+// is kept up to date by every write, so it costs nothing to read.
+func (s *Store) UsedMemory() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.usedMemory
+}
+
+// listItemsSize returns the serialized size of list items
+func listItemsSize(items []string) int {
+	size := 0
+	for _, item := range items {
+		size += stringSize(item)
+	}
+	return size
+}
@@ -0,0 +1,141 @@
+package store
+
+import "math/rand/v2"
+
+// maxRandomCount is the most members a negative count may ask for, as the
+// reply is built in memory rather than streamed
+const maxRandomCount = 1 << 24
+
+// checkRandomCount rejects the negative counts beyond maxRandomCount,
+// including the one that can't be negated
+func checkRandomCount(count int) error {
+	if count < -maxRandomCount {
+		return ErrValueOutOfRange
+	}
+	return nil
+}
+
+// pickCount returns how many members count picks from a collection of n
+func pickCount(n, count int) int {
+	if count < 0 {
+		return -count
+	}
+	return min(count, n)
+}
+
+// SRandMember returns random members of the set at key. A positive count
+// returns up to count distinct members, a negative one -count members that
+// may repeat.
+func (s *Store) SRandMember(dbIndex int, key string, count int) ([]string, error) {
+	if err := checkRandomCount(count); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	set, err := s.getSet(dbIndex, key)
+	if err != nil || len(set) == 0 {
+		return nil, err
+	}
+
+	members := make([]string, 0, len(set))
+	for member := range set {
+		members = append(members, member)
+	}
+	result := make([]string, 0, pickCount(len(members), count))
+	for _, i := range randomIndexes(len(members), count) {
+		result = append(result, members[i])
+	}
+	return result, nil
+}
+
+// HRandField returns random fields of the hash at key, picked as
+// SRandMember does, each followed by its value when withValues is set
+func (s *Store) HRandField(dbIndex int, key string, count int, withValues bool) ([]string, error) {
+	if err := checkRandomCount(count); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hash, err := s.getHash(dbIndex, key)
+	if err != nil || len(hash) == 0 {
+		return nil, err
+	}
+
+	fields := make([]string, 0, len(hash))
+	for field := range hash {
+		fields = append(fields, field)
+	}
+	result := make([]string, 0, pickCount(len(fields), count)*2)
+	for _, i := range randomIndexes(len(fields), count) {
+		result = append(result, fields[i])
+		if withValues {
+			result = append(result, stringOf(hash[fields[i]]))
+		}
+	}
+	return result, nil
+}
+
+// ZRandMember returns random members of the sorted set at key, picked as
+// SRandMember does, each followed by its score when withScores is set
+func (s *Store) ZRandMember(dbIndex int, key string, count int, withScores bool) ([]string, error) {
+	if err := checkRandomCount(count); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.lookupRead(dbIndex, key)
+	if !ok {
+		return nil, nil
+	}
+	zset, err := value.AsZSet()
+	if err != nil || len(zset) == 0 {
+		return nil, err
+	}
+
+	members := make([]string, 0, len(zset))
+	for member := range zset {
+		members = append(members, member)
+	}
+	result := make([]string, 0, pickCount(len(members), count)*2)
+	for _, i := range randomIndexes(len(members), count) {
+		result = append(result, members[i])
+		if withScores {
+			result = append(result, FormatScore(zset[members[i]]))
+		}
+	}
+	return result, nil
+}
+
+// randomIndexes picks indexes below n uniformly. A positive count picks
+// min(count, n) distinct ones, in random order, with Floyd's algorithm so
+// that small samples of big collections stay cheap. A negative count picks
+// -count independent ones, which may repeat, and must be checked by
+// checkRandomCount.
+func randomIndexes(n, count int) []int {
+	if n == 0 {
+		return nil
+	}
+	if count < 0 {
+		indexes := make([]int, -count)
+		for i := range indexes {
+			indexes[i] = rand.IntN(n)
+		}
+		return indexes
+	}
+
+	count = min(count, n)
+	picked := make(map[int]bool, count)
+	indexes := make([]int, 0, count)
+	for j := n - count; j < n; j++ {
+		i := rand.IntN(j + 1)
+		if picked[i] {
+			i = j
+		}
+		picked[i] = true
+		indexes = append(indexes, i)
+	}
+	rand.Shuffle(len(indexes), func(i, j int) {
+		indexes[i], indexes[j] = indexes[j], indexes[i]
+	})
+	return indexes
+}
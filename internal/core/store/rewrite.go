@@ -0,0 +1,52 @@
+package store
+
+import (
+	"strconv"
+	"time"
+)
+
+// AOFRewriteMarker is sent on the AOF channel by RewriteRecords, to mark the
+// point of the stream its records were taken at. It can't be mistaken for a
+// record, which is always a RESP array.
+const AOFRewriteMarker = "\x00rewrite"
+
+// RewriteRecords returns the AOF records that rebuild the dataset, a RESTORE
+// of each live key in the format logged by Restore. AOFRewriteMarker is sent
+// on the AOF channel while the values are copied, so the records logged
+// before it are covered by the rewrite and those logged after it are not.
+// Collections change in place, so every value is serialized under the read
+// lock, which holds writers back for time proportional to the size of the
+// dataset. Only the checksums and records are built after releasing it.
+func (s *Store) RewriteRecords() []string {
+	type snapshot struct {
+		dbIndex   int
+		key       string
+		value     []byte // serialized, without the DUMP footer
+		expiresAt *time.Time
+	}
+	s.mu.RLock()
+	var keys []snapshot
+	for dbIndex, db := range s.data {
+		for key, value := range db {
+			if !s.isExpired(value) {
+				keys = append(keys, snapshot{dbIndex, key, value.Serialize(), value.ExpiresAt})
+			}
+		}
+	}
+	if s.aofChan != nil {
+		s.aofChan <- AOFRewriteMarker
+	}
+	s.mu.RUnlock()
+
+	records := make([]string, len(keys))
+	for i, snap := range keys {
+		payload := appendDumpFooter(snap.value)
+		args := []string{"RESTORE", strconv.Itoa(snap.dbIndex), snap.key, "0", string(payload)}
+		if snap.expiresAt != nil {
+			args[3] = strconv.FormatInt(snap.expiresAt.UnixMilli(), 10)
+			args = append(args, "ABSTTL")
+		}
+		records[i] = encodeAOFRecord(append(args, "REPLACE")...)
+	}
+	return records
+}
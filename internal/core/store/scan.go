@@ -0,0 +1,141 @@
+package store
+
+import (
+	"math/bits"
+
+	"github.com/andrelcunha/goodiesdb/internal/utils/glob"
+)
+
+// minScanSlots is the number of slots of an empty key index
+const minScanSlots = 16
+
+// scanIndex groups the keys of a database in slots by the hash of their
+// name, for SCAN to walk a few slots per call. The table doubles when there
+// are more keys than slots, and the cursor is advanced in reverse binary
+// order as in Redis, so a key present for the whole iteration is returned
+// even if the table grew, or other keys were added or deleted, in between.
+type scanIndex struct {
+	slots []map[string]struct{}
+	count int
+}
+
+func newScanIndex() *scanIndex {
+	return &scanIndex{slots: make([]map[string]struct{}, minScanSlots)}
+}
+
+// keyHash is the 64-bit FNV-1a hash of key
+func keyHash(key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+// add indexes key, if it isn't already
+func (x *scanIndex) add(key string) {
+	slot := &x.slots[keyHash(key)&uint64(len(x.slots)-1)]
+	if *slot == nil {
+		*slot = make(map[string]struct{})
+	}
+	if _, ok := (*slot)[key]; ok {
+		return
+	}
+	(*slot)[key] = struct{}{}
+	x.count++
+	if x.count > len(x.slots) {
+		x.grow()
+	}
+}
+
+// remove drops key from the index, if it is there
+func (x *scanIndex) remove(key string) {
+	slot := x.slots[keyHash(key)&uint64(len(x.slots)-1)]
+	if _, ok := slot[key]; ok {
+		delete(slot, key)
+		x.count--
+	}
+}
+
+// grow doubles the number of slots
+func (x *scanIndex) grow() {
+	slots := make([]map[string]struct{}, 2*len(x.slots))
+	mask := uint64(len(slots) - 1)
+	for _, slot := range x.slots {
+		for key := range slot {
+			i := keyHash(key) & mask
+			if slots[i] == nil {
+				slots[i] = make(map[string]struct{})
+			}
+			slots[i][key] = struct{}{}
+		}
+	}
+	x.slots = slots
+}
+
+// scan calls visit for the keys of the slots from cursor on, until at least
+// count keys or ten times as many empty slots were visited, and returns the
+// cursor to continue from: 0 once every slot was visited
+func (x *scanIndex) scan(cursor uint64, count int, visit func(key string)) uint64 {
+	mask := uint64(len(x.slots) - 1)
+	examined, empty := 0, 0
+	for {
+		slot := x.slots[cursor&mask]
+		for key := range slot {
+			visit(key)
+		}
+		examined += len(slot)
+		if len(slot) == 0 {
+			empty++
+		}
+
+		// Increment the reversed cursor, so the slots a smaller table had
+		// split into are visited together
+		cursor |= ^mask
+		cursor = bits.Reverse64(bits.Reverse64(cursor) + 1)
+		if cursor == 0 || examined >= count || empty >= 10*count {
+			return cursor
+		}
+	}
+}
+
+// reindexKeys rebuilds the key index of every database, after the whole
+// dataset was replaced. The caller holds s.mu.
+func (s *Store) reindexKeys() {
+	s.keys = make([]*scanIndex, len(s.data))
+	for dbIndex, db := range s.data {
+		s.keys[dbIndex] = newScanIndex()
+		for key := range db {
+			s.keys[dbIndex].add(key)
+		}
+	}
+}
+
+// Scan iterates over the keys of a database. As in Redis, count is a hint of
+// how many keys to examine per call rather than how many to return: MATCH is
+// applied to the examined keys only, so a call may return fewer keys (or
+// none) while the iteration still moves on. A key present from the first
+// call to the last is returned at least once, whatever is written meanwhile.
+func (s *Store) Scan(dbIndex int, cursor int, pattern string, count int) (int, []string) {
+	if count <= 0 {
+		count = 10 // default count
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	matchedKeys := []string{}
+	if cursor < 0 {
+		return 0, matchedKeys
+	}
+	next := s.keys[dbIndex].scan(uint64(cursor), count, func(key string) {
+		if s.isExpired(s.data[dbIndex][key]) {
+			return
+		}
+		if pattern == "" || pattern == "*" || glob.Match(pattern, key) {
+			matchedKeys = append(matchedKeys, key)
+		}
+	})
+	return int(next), matchedKeys
+}
@@ -0,0 +1,293 @@
+package store
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc64"
+	"math"
+	"sort"
+)
+
+/* Serialization */
+
+// Serialize encodes the value in the deterministic format used by DUMP: a
+// type byte followed by uvarint length-prefixed strings. Collections start
+// with their element count and unordered ones are written sorted, so equal
+// values always serialize to the same bytes.
+func (v *Value) Serialize() []byte {
+	buf := make([]byte, 0, v.SerializedSize())
+	buf = append(buf, byte(v.Type))
+
+	switch v.Type {
+	case TypeString:
+		buf = appendString(buf, stringOf(v.Data))
+	case TypeList:
+		list, _ := v.AsList()
+		buf = binary.AppendUvarint(buf, uint64(len(list)))
+		for _, item := range list {
+			buf = appendString(buf, item)
+		}
+	case TypeHash:
+		hash, _ := v.AsHash()
+		buf = binary.AppendUvarint(buf, uint64(len(hash)))
+		for _, field := range sortedKeys(hash) {
+			buf = appendString(buf, field)
+			buf = appendString(buf, stringOf(hash[field]))
+		}
+	case TypeSet:
+		set, _ := v.AsSet()
+		buf = binary.AppendUvarint(buf, uint64(len(set)))
+		for _, member := range sortedKeys(set) {
+			buf = appendString(buf, member)
+		}
+	case TypeZSet:
+		zset, _ := v.AsZSet()
+		buf = binary.AppendUvarint(buf, uint64(len(zset)))
+		for _, member := range sortedKeys(zset) {
+			buf = appendString(buf, member)
+			buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(zset[member]))
+		}
+	}
+	return buf
+}
+
+// SerializedSize returns the exact length of Serialize() without encoding
+// the value. It is the single size estimate shared by MEMORY USAGE and DEBUG
+// OBJECT; DUMP payloads add a footer of dumpFooterSize bytes.
+func (v *Value) SerializedSize() int {
+	size := 1 // type byte
+
+	switch v.Type {
+	case TypeString:
+		size += stringSize(stringOf(v.Data))
+	case TypeList:
+		list, _ := v.AsList()
+		size += uvarintSize(len(list))
+		for _, item := range list {
+			size += stringSize(item)
+		}
+	case TypeHash:
+		hash, _ := v.AsHash()
+		size += uvarintSize(len(hash))
+		for field, value := range hash {
+			size += stringSize(field) + stringSize(stringOf(value))
+		}
+	case TypeSet:
+		set, _ := v.AsSet()
+		size += uvarintSize(len(set))
+		for member := range set {
+			size += stringSize(member)
+		}
+	case TypeZSet:
+		zset, _ := v.AsZSet()
+		size += uvarintSize(len(zset))
+		for member := range zset {
+			size += stringSize(member) + 8
+		}
+	}
+	return size
+}
+
+// DeserializeValue decodes a value produced by Serialize
+func DeserializeValue(payload []byte) (*Value, error) {
+	d := &decoder{buf: payload}
+	valueType, ok := d.byte()
+	if !ok {
+		return nil, ErrBadDataFormat
+	}
+
+	var value *Value
+	switch ValueType(valueType) {
+	case TypeString:
+		str, ok := d.string()
+		if !ok {
+			return nil, ErrBadDataFormat
+		}
+		value = NewStringValue(str)
+	case TypeList:
+		n, ok := d.count()
+		if !ok {
+			return nil, ErrBadDataFormat
+		}
+		list := make([]string, 0, n)
+		for i := 0; i < n; i++ {
+			item, ok := d.string()
+			if !ok {
+				return nil, ErrBadDataFormat
+			}
+			list = append(list, item)
+		}
+		value = NewListValue(list)
+	case TypeHash:
+		n, ok := d.count()
+		if !ok {
+			return nil, ErrBadDataFormat
+		}
+		hash := make(map[string]any, n)
+		for i := 0; i < n; i++ {
+			field, ok1 := d.string()
+			val, ok2 := d.string()
+			if !ok1 || !ok2 {
+				return nil, ErrBadDataFormat
+			}
+			hash[field] = val
+		}
+		value = NewHashValue(hash)
+	case TypeSet:
+		n, ok := d.count()
+		if !ok {
+			return nil, ErrBadDataFormat
+		}
+		set := make(map[string]struct{}, n)
+		for i := 0; i < n; i++ {
+			member, ok := d.string()
+			if !ok {
+				return nil, ErrBadDataFormat
+			}
+			set[member] = struct{}{}
+		}
+		value = NewSetValue(set)
+	case TypeZSet:
+		n, ok := d.count()
+		if !ok {
+			return nil, ErrBadDataFormat
+		}
+		zset := make(map[string]float64, n)
+		for i := 0; i < n; i++ {
+			member, ok1 := d.string()
+			bits, ok2 := d.uint64()
+			if !ok1 || !ok2 {
+				return nil, ErrBadDataFormat
+			}
+			zset[member] = math.Float64frombits(bits)
+		}
+		value = NewZSetValue(zset)
+	default:
+		return nil, ErrBadDataFormat
+	}
+
+	if !d.done() {
+		return nil, ErrBadDataFormat
+	}
+	return value, nil
+}
+
+/* DUMP payloads */
+
+// DumpVersion is the version of the DUMP payload format. RESTORE rejects
+// payloads of any other version.
+const DumpVersion = 1
+
+// dumpFooterSize is the length of the footer DUMP appends to the serialized
+// value: the version byte and the CRC-64 of everything before it
+const dumpFooterSize = 1 + 8
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+// DumpPayload returns the payload DUMP replies with: the serialized value,
+// which starts with its type, followed by the footer
+func (v *Value) DumpPayload() []byte {
+	return appendDumpFooter(v.Serialize())
+}
+
+// appendDumpFooter turns a serialized value into a DUMP payload
+func appendDumpFooter(serialized []byte) []byte {
+	payload := append(serialized, DumpVersion)
+	return binary.LittleEndian.AppendUint64(payload, crc64.Checksum(payload, crcTable))
+}
+
+// ParseDumpPayload checks the version and checksum of a payload produced by
+// DumpPayload and decodes its value
+func ParseDumpPayload(payload []byte) (*Value, error) {
+	if len(payload) < dumpFooterSize {
+		return nil, ErrDumpPayload
+	}
+	body, crc := payload[:len(payload)-8], payload[len(payload)-8:]
+	if body[len(body)-1] != DumpVersion || binary.LittleEndian.Uint64(crc) != crc64.Checksum(body, crcTable) {
+		return nil, ErrDumpPayload
+	}
+	return DeserializeValue(body[:len(body)-1])
+}
+
+/* Helpers */
+
+// stringOf returns the string form of a stored element
+func stringOf(data any) string {
+	if str, ok := data.(string); ok {
+		return str
+	}
+	return fmt.Sprintf("%v", data)
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func stringSize(s string) int {
+	return uvarintSize(len(s)) + len(s)
+}
+
+func uvarintSize(n int) int {
+	size := 1
+	for x := uint64(n); x >= 0x80; x >>= 7 {
+		size++
+	}
+	return size
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// decoder reads the primitives written by Serialize
+type decoder struct {
+	buf []byte
+	pos int
+}
+
+func (d *decoder) byte() (byte, bool) {
+	if d.pos >= len(d.buf) {
+		return 0, false
+	}
+	b := d.buf[d.pos]
+	d.pos++
+	return b, true
+}
+
+func (d *decoder) count() (int, bool) {
+	n, size := binary.Uvarint(d.buf[d.pos:])
+	if size <= 0 || n > uint64(len(d.buf)) {
+		return 0, false
+	}
+	d.pos += size
+	return int(n), true
+}
+
+func (d *decoder) string() (string, bool) {
+	n, ok := d.count()
+	if !ok || d.pos+n > len(d.buf) {
+		return "", false
+	}
+	s := string(d.buf[d.pos : d.pos+n])
+	d.pos += n
+	return s, true
+}
+
+func (d *decoder) uint64() (uint64, bool) {
+	if d.pos+8 > len(d.buf) {
+		return 0, false
+	}
+	n := binary.LittleEndian.Uint64(d.buf[d.pos:])
+	d.pos += 8
+	return n, true
+}
+
+func (d *decoder) done() bool {
+	return d.pos == len(d.buf)
+}
@@ -0,0 +1,122 @@
+package store
+
+// SAdd adds members to the set stored at key, creating it if needed, and
+// returns the number of members that were not already present. Only those
+// are logged.
+func (s *Store) SAdd(dbIndex int, key string, members ...string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.data[dbIndex][key]
+	isNew := !ok || s.isExpired(value)
+	if isNew {
+		value = NewSetValue(make(map[string]struct{}, len(members)))
+	}
+	set, err := value.AsSet()
+	if err != nil {
+		return 0, err
+	}
+
+	args := []string{key}
+	delta := 0
+	for _, member := range members {
+		if _, exists := set[member]; exists {
+			continue
+		}
+		set[member] = struct{}{}
+		delta += stringSize(member)
+		args = append(args, member)
+	}
+	if len(args) == 1 {
+		return 0, nil
+	}
+	if isNew {
+		s.putKey(dbIndex, key, value)
+	} else {
+		s.grow(value, delta)
+	}
+	s.logAOF("SADD", dbIndex, args...)
+	return len(args) - 1, nil
+}
+
+// SRem removes members from the set stored at key and returns the number of
+// members that were removed. A set left empty is deleted.
+func (s *Store) SRem(dbIndex int, key string, members ...string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.data[dbIndex][key]
+	if !ok || s.isExpired(value) {
+		return 0, nil
+	}
+	set, err := value.AsSet()
+	if err != nil {
+		return 0, err
+	}
+
+	args := []string{key}
+	delta := 0
+	for _, member := range members {
+		if _, exists := set[member]; !exists {
+			continue
+		}
+		delete(set, member)
+		delta -= stringSize(member)
+		args = append(args, member)
+	}
+	if len(args) == 1 {
+		return 0, nil
+	}
+	if len(set) == 0 {
+		s.delKey(dbIndex, key)
+	} else {
+		s.grow(value, delta)
+	}
+	s.logAOF("SREM", dbIndex, args...)
+	return len(args) - 1, nil
+}
+
+// SMembers returns the members of the set stored at key in lexicographic
+// order, none when the key doesn't exist
+func (s *Store) SMembers(dbIndex int, key string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	set, err := s.getSet(dbIndex, key)
+	if err != nil {
+		return nil, err
+	}
+	return sortedKeys(set), nil
+}
+
+// SIsMember reports whether member belongs to the set stored at key
+func (s *Store) SIsMember(dbIndex int, key, member string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	set, err := s.getSet(dbIndex, key)
+	if err != nil {
+		return false, err
+	}
+	_, ok := set[member]
+	return ok, nil
+}
+
+// SCard returns the number of members of the set stored at key
+func (s *Store) SCard(dbIndex int, key string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	set, err := s.getSet(dbIndex, key)
+	if err != nil {
+		return 0, err
+	}
+	return len(set), nil
+}
+
+// getSet returns the set stored at key, or nil if the key does not exist.
+// The caller must hold the lock.
+func (s *Store) getSet(dbIndex int, key string) (map[string]struct{}, error) {
+	value, ok := s.lookupRead(dbIndex, key)
+	if !ok {
+		return nil, nil
+	}
+	return value.AsSet()
+}
@@ -0,0 +1,44 @@
+package store
+
+import "sync/atomic"
+
+// KeyspaceStats are the keyspace counters reported by INFO stats
+type KeyspaceStats struct {
+	Hits        int64 // reads that found their key
+	Misses      int64 // reads that didn't
+	ExpiredKeys int64 // expired keys removed from the dataset
+}
+
+// keyspaceCounters are the live counters behind KeyspaceStats. They are
+// updated under the read lock too, so they are atomic.
+type keyspaceCounters struct {
+	hits, misses, expiredKeys atomic.Int64
+}
+
+// Stats returns the keyspace counters
+func (s *Store) Stats() KeyspaceStats {
+	counters := s.counters.Load()
+	return KeyspaceStats{
+		Hits:        counters.hits.Load(),
+		Misses:      counters.misses.Load(),
+		ExpiredKeys: counters.expiredKeys.Load(),
+	}
+}
+
+// ResetStats zeroes the keyspace counters at once, by swapping them for new
+// ones
+func (s *Store) ResetStats() {
+	s.counters.Store(&keyspaceCounters{})
+}
+
+// lookupRead returns the live value at key for a read command, counting a
+// keyspace hit or miss. The caller holds s.mu, for reading or writing.
+func (s *Store) lookupRead(dbIndex int, key string) (*Value, bool) {
+	value, ok := s.data[dbIndex][key]
+	if !ok || s.isExpired(value) {
+		s.counters.Load().misses.Add(1)
+		return nil, false
+	}
+	s.counters.Load().hits.Add(1)
+	return value, true
+}
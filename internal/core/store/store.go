@@ -2,12 +2,17 @@ package store
 
 import (
 	"fmt"
+	"math"
 	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/andrelcunha/goodiesdb/internal/utils/glob"
 	"github.com/andrelcunha/goodiesdb/internal/utils/slice"
 )
 
@@ -17,6 +22,24 @@ type Store struct {
 	data    []map[string]*Value
 	mu      sync.RWMutex
 	aofChan chan string
+	// replaying stops the writes from being logged while the AOF is replayed
+	replaying atomic.Bool
+	clock     Clock // decides when keys expire
+	// protoMaxBulkLen caps the strings built by APPEND and SETRANGE
+	protoMaxBulkLen int64
+	encodingLimits  EncodingLimits
+	// usedMemory is the sum of the memSize of every stored value
+	usedMemory int64
+	counters   atomic.Pointer[keyspaceCounters]
+	// dirty counts the writes to each database since the last successful save
+	dirty []int64
+	// lastSave is when the dataset was last saved, or when the store was
+	// created if it never was
+	lastSave time.Time
+	// expires indexes the keys of each database that have a TTL
+	expires []*expiryIndex
+	// keys indexes the keys of each database for SCAN
+	keys []*scanIndex
 }
 
 // NewStore creates a new store
@@ -25,10 +48,19 @@ func NewStore(aofChan chan string) *Store {
 	for i := range data {
 		data[i] = make(map[string]*Value)
 	}
-	return &Store{
-		data:    data,
-		aofChan: aofChan,
-	}
+	s := &Store{
+		data:            data,
+		aofChan:         aofChan,
+		protoMaxBulkLen: DefaultProtoMaxBulkLen,
+		encodingLimits:  DefaultEncodingLimits(),
+		clock:           realClock{},
+		dirty:           make([]int64, len(data)),
+		lastSave:        time.Now(),
+	}
+	s.counters.Store(&keyspaceCounters{})
+	s.reindexExpiries()
+	s.reindexKeys()
+	return s
 }
 
 func (s *Store) Count() int {
@@ -37,25 +69,44 @@ func (s *Store) Count() int {
 	return len(s.data)
 }
 
-// GetSnapshot returns a snapshot of store data for persistence
-// This is safe to call as it returns a copy
-func (s *Store) GetSnapshot() []map[string]*Value {
+// GetSnapshot returns a snapshot of store data for persistence, and the
+// dirty counters it covers, to be passed to MarkSaved once it is saved.
+// Collections change in place, so each value is serialized under the read
+// lock and the copies handed out are decoded after releasing it.
+func (s *Store) GetSnapshot() ([]map[string]*Value, DirtyMark, error) {
+	type snapshot struct {
+		payload   []byte
+		expiresAt *time.Time
+	}
 	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	// Create deep copies to avoid data races
-	dataCopy := make([]map[string]*Value, len(s.data))
-
-	for i := range s.data {
-		dataCopy[i] = make(map[string]*Value)
-
-		for k, v := range s.data[i] {
-			dataCopy[i][k] = v
+	serialized := make([]map[string]snapshot, len(s.data))
+	for i, db := range s.data {
+		serialized[i] = make(map[string]snapshot, len(db))
+		for key, value := range db {
+			var expiresAt *time.Time
+			if value.ExpiresAt != nil {
+				at := *value.ExpiresAt
+				expiresAt = &at
+			}
+			serialized[i][key] = snapshot{value.Serialize(), expiresAt}
 		}
-
 	}
+	mark := slices.Clone(s.dirty)
+	s.mu.RUnlock()
 
-	return dataCopy
+	dataCopy := make([]map[string]*Value, len(serialized))
+	for i, db := range serialized {
+		dataCopy[i] = make(map[string]*Value, len(db))
+		for key, snap := range db {
+			value, err := DeserializeValue(snap.payload)
+			if err != nil {
+				return nil, nil, err
+			}
+			value.ExpiresAt = snap.expiresAt
+			dataCopy[i][key] = value
+		}
+	}
+	return dataCopy, mark, nil
 }
 
 // RestoreFromSnapshot restores store data from persistence
@@ -63,7 +114,19 @@ func (s *Store) RestoreFromSnapshot(data []map[string]*Value) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	now := s.now()
+	for _, db := range data {
+		for _, value := range db {
+			value.touch(now)
+			s.updateEncoding(value)
+		}
+	}
 	s.data = data
+	s.recountMemory()
+	s.reindexExpiries()
+	s.reindexKeys()
+	// The dataset now matches the snapshot on disk
+	clear(s.dirty)
 }
 
 // Test helper methods - only use in tests
@@ -82,7 +145,7 @@ func (s *Store) GetListLength(dbIndex int, key string) int {
 }
 
 // GetList returns a copy of the list for testing
-func (s *Store) GetList(dbIndex int, key string) []any {
+func (s *Store) GetList(dbIndex int, key string) []string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -92,7 +155,7 @@ func (s *Store) GetList(dbIndex int, key string) []any {
 			return nil
 		}
 		// Return a copy to avoid data races
-		result := make([]any, len(list))
+		result := make([]string, len(list))
 		copy(result, list)
 		return result
 	}
@@ -104,27 +167,29 @@ func (s *Store) SetRawValue(dbIndex int, key string, value interface{}) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	data := &Value{Data: value}
-	s.data[dbIndex][key] = data
+	s.putKey(dbIndex, key, data)
 }
 
 func (s *Store) AOFChannel() chan string {
 	return s.aofChan
 }
 
-// GetRange gets a substring of the string value for a key
+// GetRange gets a substring of the string value for a key. As in Redis,
+// negative indexes count from the end, out of range indexes are clamped and
+// a missing key is an empty string.
 func (s *Store) GetRange(dbIndex int, key string, start, end int) (string, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	value, ok := s.data[dbIndex][key]
+	value, ok := s.lookupRead(dbIndex, key)
 	if !ok {
-		return "", ErrNoSuchKey
-	}
-	if value.IsExpired() {
-		return "", ErrNoSuchKey
+		return "", nil
 	}
 	strValue, ok := value.Data.(string)
 	if !ok {
-		return "", fmt.Errorf("value is not a string")
+		return "", ErrWrongType
+	}
+	if start < 0 && end < 0 && start > end {
+		return "", nil
 	}
 	if start < 0 {
 		start = len(strValue) + start
@@ -135,10 +200,13 @@ func (s *Store) GetRange(dbIndex int, key string, start, end int) (string, error
 	if start < 0 {
 		start = 0
 	}
+	if end < 0 {
+		end = 0
+	}
 	if end >= len(strValue) {
 		end = len(strValue) - 1
 	}
-	if start > end {
+	if start > end || len(strValue) == 0 {
 		return "", nil
 	}
 	return strValue[start : end+1], nil
@@ -148,21 +216,18 @@ func (s *Store) Del(dbIndex int, key string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.delKey(dbIndex, key)
-	s.aofChan <- fmt.Sprintf("DEL %d %s", dbIndex, key)
+	s.logAOF("DEL", dbIndex, key)
 }
 
-// Exists checks if a key exists
+// Exists returns how many of keys exist. A key given more than once is counted
+// each time, as in Redis.
 func (s *Store) Exists(dbIndex int, keys ...string) int {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
 	count := 0
-	for _, key := range keys {
-		value, ok := s.data[dbIndex][key]
-		if ok && !value.IsExpired() && value.Data != nil {
+	s.readMulti(dbIndex, keys, func(_ int, value *Value) {
+		if value.Data != nil {
 			count++
 		}
-	}
-
+	})
 	return count
 }
 
@@ -170,13 +235,10 @@ func (s *Store) Exists(dbIndex int, keys ...string) int {
 func (s *Store) StrLen(dbIndex int, key string) (int, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	value, ok := s.data[dbIndex][key]
+	value, ok := s.lookupRead(dbIndex, key)
 	if !ok {
 		return 0, ErrNoSuchKey
 	}
-	if value.IsExpired() {
-		return 0, ErrNoSuchKey
-	}
 	strValue, ok := value.Data.(string)
 	if !ok {
 		return 0, ErrWrongType
@@ -200,47 +262,169 @@ func (s *Store) Expire(dbIndex int, key string, ttl time.Duration) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if value, exists := s.data[dbIndex][key]; exists {
-		expiration := time.Now().Add(ttl)
+		expiration := s.now().Add(ttl)
 		value.ExpiresAt = &expiration
-		s.data[dbIndex][key] = value
-		s.aofChan <- fmt.Sprintf("EXPIRE %d %s %d", dbIndex, key, int(ttl.Seconds()))
+		s.indexExpiry(dbIndex, key, value)
+		// Log the deadline, so a rebuild neither rounds nor restarts the TTL
+		s.logAOF("PEXPIREAT", dbIndex, key, strconv.FormatInt(expiration.UnixMilli(), 10))
 		return true
 	}
 	return false
 }
 
+// PExpire sets the expiration time for a key in milliseconds. A deadline
+// past the range of a unix time in milliseconds is rejected, as for SET PX.
+func (s *Store) PExpire(dbIndex int, key string, ms int64) (bool, error) {
+	now := s.now().UnixMilli()
+	if (ms > 0 && ms > math.MaxInt64-now) || (ms < 0 && ms < math.MinInt64+now) {
+		return false, ErrInvalidExpireTime("pexpire")
+	}
+	return s.expireAt(dbIndex, key, time.UnixMilli(now+ms)), nil
+}
+
+// ExpireAt sets the expiration of a key to an absolute unix time in seconds,
+// as expireAt does
+func (s *Store) ExpireAt(dbIndex int, key string, seconds int64) bool {
+	return s.expireAt(dbIndex, key, time.Unix(seconds, 0))
+}
+
+// PExpireAt sets the expiration of a key to an absolute unix time in
+// milliseconds, as expireAt does
+func (s *Store) PExpireAt(dbIndex int, key string, ms int64) bool {
+	return s.expireAt(dbIndex, key, time.UnixMilli(ms))
+}
+
+// expireAt makes key expire at the given instant and reports whether the key
+// exists. An instant that has already passed deletes the key right away and
+// logs a DEL; otherwise the absolute time is logged as a PEXPIREAT, so a
+// rebuild restores the same deadline.
+func (s *Store) expireAt(dbIndex int, key string, at time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, exists := s.data[dbIndex][key]
+	if !exists || s.isExpired(value) {
+		return false
+	}
+	if !at.After(s.now()) {
+		s.delKey(dbIndex, key)
+		s.logAOF("DEL", dbIndex, key)
+		return true
+	}
+	value.ExpiresAt = &at
+	s.indexExpiry(dbIndex, key, value)
+	s.logAOF("PEXPIREAT", dbIndex, key, strconv.FormatInt(at.UnixMilli(), 10))
+	return true
+}
+
+// Persist removes the expiration of a key. It returns false when the key
+// doesn't exist or has no expiration.
+func (s *Store) Persist(dbIndex int, key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, exists := s.data[dbIndex][key]
+	if !exists || s.isExpired(value) || value.ExpiresAt == nil {
+		return false
+	}
+	value.ExpiresAt = nil
+	s.indexExpiry(dbIndex, key, value)
+	s.logAOF("PERSIST", dbIndex, key)
+	return true
+}
+
 // Incr increments the value for a key
 func (s *Store) Incr(dbIndex int, key string) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	value, ok := s.data[dbIndex][key]
-	if !ok {
-		value = &Value{Data: "0", Type: TypeString}
+	intValue, err := s.addInt(dbIndex, key, 1)
+	if err != nil {
+		return 0, err
 	}
-	if value.Type != TypeString {
-		return 0, ErrNotInteger
+	s.logAOF("INCR", dbIndex, key)
+	return intValue, nil
+}
+
+// Decr decrements the value for a key
+func (s *Store) Decr(dbIndex int, key string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	intValue, err := s.addInt(dbIndex, key, -1)
+	if err != nil {
+		return 0, err
 	}
+	s.logAOF("DECR", dbIndex, key)
+	return intValue, nil
+}
 
-	intValue, err := strconv.Atoi(value.Data.(string))
+// IncrBy adds increment to the value for a key
+func (s *Store) IncrBy(dbIndex int, key string, increment int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	intValue, err := s.addInt(dbIndex, key, increment)
 	if err != nil {
-		return 0, ErrNotInteger
+		return 0, err
 	}
-	intValue++
-	value.Data = strconv.Itoa(intValue)
-	s.data[dbIndex][key] = value
-	s.aofChan <- fmt.Sprintf("INCR %d %s", dbIndex, key)
+	s.logAOF("INCRBY", dbIndex, key, strconv.Itoa(increment))
 	return intValue, nil
 }
 
-// Decr decrements the value for a key
-func (s *Store) Decr(dbIndex int, key string) (int, error) {
+// DecrBy subtracts decrement from the value for a key
+func (s *Store) DecrBy(dbIndex int, key string, decrement int) (int, error) {
+	// The smallest integer can't be negated
+	if decrement == math.MinInt {
+		return 0, ErrDecrOverflow
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	intValue, err := s.addInt(dbIndex, key, -decrement)
+	if err != nil {
+		return 0, err
+	}
+	s.logAOF("DECRBY", dbIndex, key, strconv.Itoa(decrement))
+	return intValue, nil
+}
+
+// IncrByFloat adds delta to the floating point value for a key, starting
+// from 0 when the key is missing, and returns the result as stored: without
+// trailing zeros. The result is logged as a SET, so a replay doesn't depend
+// on float arithmetic.
+func (s *Store) IncrByFloat(dbIndex int, key string, delta float64) (string, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	value, ok := s.data[dbIndex][key]
-	if !ok {
-		value = &Value{Data: "0", Type: TypeString}
+	if !ok || s.isExpired(value) {
+		value = NewStringValue("0")
+	}
+	if value.Type != TypeString {
+		return "", ErrNotFloat
+	}
+
+	current, err := strconv.ParseFloat(value.Data.(string), 64)
+	if err != nil || math.IsNaN(current) {
+		return "", ErrNotFloat
+	}
+	result := current + delta
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		return "", ErrNaNOrInfinity
+	}
+	formatted := strconv.FormatFloat(result, 'f', -1, 64)
+	s.putString(dbIndex, key, value, formatted)
+	s.logAOF("SET", dbIndex, key, formatted, "KEEPTTL")
+	return formatted, nil
+}
+
+// addInt adds delta to the integer stored as a string at key, starting from
+// 0 when the key is missing, and returns the result. A result that doesn't
+// fit in an int is an error, the value is left as it is. The caller holds
+// s.mu and logs the write.
+func (s *Store) addInt(dbIndex int, key string, delta int) (int, error) {
+	value, ok := s.data[dbIndex][key]
+	if !ok || s.isExpired(value) {
+		value = NewStringValue("0")
 	}
 	if value.Type != TypeString {
 		return 0, ErrNotInteger
@@ -250,194 +434,156 @@ func (s *Store) Decr(dbIndex int, key string) (int, error) {
 	if err != nil {
 		return 0, ErrNotInteger
 	}
-	intValue--
-	value.Data = strconv.Itoa(intValue)
-	s.data[dbIndex][key] = value
-	s.aofChan <- fmt.Sprintf("DECR %d %s", dbIndex, key)
+	if (delta > 0 && intValue > math.MaxInt-delta) || (delta < 0 && intValue < math.MinInt-delta) {
+		return 0, ErrIncrOverflow
+	}
+	intValue += delta
+	s.putString(dbIndex, key, value, strconv.Itoa(intValue))
 	return intValue, nil
 }
 
-// TTL Retrieve the remaining time to live for a key
+// TTL returns the remaining time to live of a key in seconds, rounded up:
+// -2 when the key doesn't exist or has expired, in which case it is deleted,
+// and -1 when it has no expiration
 func (s *Store) TTL(dbIndex int, key string) (int, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	value, ok := s.data[dbIndex][key]
+	info, ok := s.Describe(dbIndex, key)
 	if !ok {
+		s.expireIfNeeded(dbIndex, key)
 		return -2, nil
 	}
-	if value.ExpiresAt == nil {
-		return -1, nil
+	return int(info.TTLSeconds()), nil
+}
+
+// PTTL is TTL in milliseconds
+func (s *Store) PTTL(dbIndex int, key string) (int64, error) {
+	info, ok := s.Describe(dbIndex, key)
+	if !ok {
+		s.expireIfNeeded(dbIndex, key)
+		return -2, nil
 	}
-	ttl := time.Until(*value.ExpiresAt)
-	return int(ttl.Seconds()), nil
+	return info.TTL, nil
 }
 
 // LPush inserts values at the begining of a list
-func (s *Store) LPush(dbIndex int, key string, values ...any) int {
-	strValues := make([]string, len(values))
-	for i, v := range values {
-		strValues[i] = fmt.Sprintf("%v", v)
-	}
-	s.aofChan <- fmt.Sprintf("LPUSH %d %s %s", dbIndex, key, strings.Join(strValues, " "))
-	if len(values) > 1 {
-		slice.Reverse(values)
-	}
+func (s *Store) LPush(dbIndex int, key string, values ...string) int {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	// Each element is logged as its own argument so the record is binary safe
+	s.logAOF("LPUSH", dbIndex, append([]string{key}, values...)...)
+	// Reverse a copy, as values may alias the caller's slice
+	values = slices.Clone(values)
+	slice.Reverse(values)
+
 	value, ok := s.data[dbIndex][key]
 	if !ok {
-		s.data[dbIndex][key] = NewListValue(values)
+		s.putKey(dbIndex, key, NewListValue(values))
 		return len(values)
 	}
 	list, _ := value.AsList()
 	list = append(values, list...)
 	value.Data = list
-	s.data[dbIndex][key] = value
+	s.grow(value, listItemsSize(values))
 	return len(list)
 }
 
 // RPush inserts values at the end of a list
-func (s *Store) RPush(dbIndex int, key string, values ...any) int {
-	strValues := make([]string, len(values))
-	for i, v := range values {
-		strValues[i] = fmt.Sprintf("%v", v)
-	}
-	s.aofChan <- fmt.Sprintf("RPUSH %d %s %s", dbIndex, key, strings.Join(strValues, " "))
+func (s *Store) RPush(dbIndex int, key string, values ...string) int {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	// Each element is logged as its own argument so the record is binary safe
+	s.logAOF("RPUSH", dbIndex, append([]string{key}, values...)...)
 	value, ok := s.data[dbIndex][key]
 	if !ok {
-		s.data[dbIndex][key] = NewListValue(values)
+		s.putKey(dbIndex, key, NewListValue(values))
 		return len(values)
 	}
 	list, _ := value.AsList()
 	list = append(list, values...)
 	value.Data = list
-	s.data[dbIndex][key] = value
+	s.grow(value, listItemsSize(values))
 	return len(list)
 }
 
 // LPop removes and returns the first N elements of the list, where N is equal to count, or nil if the list is empty.
+// The element is a string without a count and a []string with one.
 func (s *Store) LPop(dbIndex int, key string, pcount *int) (interface{}, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	value, ok := s.data[dbIndex][key]
-	if !ok {
-		return nil, nil
-	}
-	// Check if the key has expired
-	if value.IsExpired() {
-		return nil, nil
-	}
+	return s.pop(dbIndex, key, pcount, true)
+}
 
+// RPop removes and returns the last N elements of the list, where N is equal to count, or nil if the list is empty.
+func (s *Store) RPop(dbIndex int, key string, pcount *int) (interface{}, error) {
+	return s.pop(dbIndex, key, pcount, false)
+}
+
+// pop implements LPOP (left) and RPOP. Without a count it returns a single
+// element, with one a slice of up to count elements. A missing key yields nil
+// and a list left empty is deleted.
+func (s *Store) pop(dbIndex int, key string, pcount *int, left bool) (interface{}, error) {
 	count := 1
 	//if not nil, get the count from the caller
 	if pcount != nil {
 		count = *pcount
 	}
-
-	// Check if count is smaller than 0 and value came from caller
 	if count < 0 {
 		return nil, fmt.Errorf("value is out of range, must be positive")
 	}
 
-	list, err := value.AsList()
-	if err != nil {
-		return nil, err
-	}
-
-	len := len(list)
-	if len == 0 {
-		return nil, nil
-	}
-	if count > len {
-		count = len
-	}
-	popped := list[:count]
-
-	// Remove the popped elements from the list
-	value.Data = list[count:]
-	s.data[dbIndex][key] = value
-
-	// Log the operation
-	s.aofChan <- fmt.Sprintf("LPOP %d %s %d", dbIndex, key, count)
-
-	if count == 1 && pcount == nil {
-		return popped[0], nil
-	} else {
-		return popped, nil
-	}
-}
-
-// RPop removes and returns the last N elements of the list, where N is equal to count, or nil if the list is empty.
-func (s *Store) RPop(dbIndex int, key string, pcount *int) (interface{}, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	value, ok := s.data[dbIndex][key]
-	if !ok {
+	if !ok || s.isExpired(value) {
 		return nil, nil
 	}
-
-	// Check if the key has expired
-	if value.IsExpired() {
+	list, err := value.AsList()
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
 		return nil, nil
 	}
-	count := 1
-	//if not nil, get the count from the caller
-	if pcount != nil {
-		count = *pcount
+	if count == 0 {
+		return []string{}, nil
 	}
 
-	// Check if count is smaller than 0 and value came from caller
-	if count < 0 && pcount != nil {
-		return nil, fmt.Errorf("value is out of range, must be positive")
+	count = min(count, len(list))
+	var popped []string
+	command := "LPOP"
+	// Copy the popped elements, a later RPUSH may reuse the backing array
+	if left {
+		popped = slices.Clone(list[:count])
+		value.Data = list[count:]
 	} else {
-		list, err := value.AsList()
-		if err != nil {
-			return nil, err
-		}
-
-		len := len(list)
-		if len == 0 {
-			return nil, nil
-		}
-		if count > len {
-			count = len
-		}
-		popped := list[(len - count):]
-		value.Data = list[:(len - count)]
-
-		// Remove the popped elements from the list
-		s.data[dbIndex][key] = value
+		command = "RPOP"
+		popped = slices.Clone(list[len(list)-count:])
+		value.Data = list[:len(list)-count]
+	}
+	if count == len(list) {
+		s.delKey(dbIndex, key)
+	} else {
+		s.grow(value, -listItemsSize(popped))
+	}
 
-		// Log the operation
-		s.aofChan <- fmt.Sprintf("RPOP %d %s %d", dbIndex, key, count)
+	// Log the operation
+	s.logAOF(command, dbIndex, key, strconv.Itoa(count))
 
-		if count == 1 && pcount == nil {
-			return popped[0], nil
-		} else {
-			return popped, nil
-		}
+	if pcount == nil {
+		return popped[0], nil
 	}
+	return popped, nil
 }
 
 // LRange returns the elements of a list between start and stop
-func (s *Store) LRange(dbIndex int, key string, start, stop int) ([]any, error) {
+func (s *Store) LRange(dbIndex int, key string, start, stop int) ([]string, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	value, ok := s.data[dbIndex][key]
+	value, ok := s.lookupRead(dbIndex, key)
 	if !ok {
 		return nil, nil
 	}
-
-	// Check if the key has expired
-	if value.IsExpired() {
-		return nil, nil
-	}
 	list, err := value.AsList()
 	if err != nil {
 		return nil, err
@@ -460,10 +606,10 @@ func (s *Store) LRange(dbIndex int, key string, start, stop int) ([]any, error)
 	}
 
 	if start > stop || start >= len || stop < 0 {
-		return []any{}, nil
+		return []string{}, nil
 	}
 
-	return list[start : stop+1], nil
+	return slices.Clone(list[start : stop+1]), nil
 }
 
 // LTrim trims a list to the specified range
@@ -475,7 +621,7 @@ func (s *Store) LTrim(dbIndex int, key string, start, stop int) error {
 		return nil
 	}
 	// Check if the key has expired
-	if value.IsExpired() {
+	if s.isExpired(value) {
 		return nil
 	}
 
@@ -501,16 +647,18 @@ func (s *Store) LTrim(dbIndex int, key string, start, stop int) error {
 	}
 
 	if start > stop || start >= len {
-		s.Del(dbIndex, key)
+		// s.mu is held, so Del would deadlock
+		s.delKey(dbIndex, key)
+		s.logAOF("DEL", dbIndex, key)
 		return nil
 	}
 
 	// Remove the elements from the list
 	value.Data = list[start : stop+1]
-	s.data[dbIndex][key] = value
+	s.putKey(dbIndex, key, value)
 
 	// Log the operation
-	s.aofChan <- fmt.Sprintf("LTRIM %d %s %d %d", dbIndex, key, start, stop)
+	s.logAOF("LTRIM", dbIndex, key, strconv.Itoa(start), strconv.Itoa(stop))
 
 	return nil
 }
@@ -520,49 +668,153 @@ func (s *Store) Rename(dbIndex int, oldKey, newKey string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Check if the key has expired
+	// An expired key no longer exists
 	value, ok := s.data[dbIndex][oldKey]
-	if !ok {
+	if !ok || s.isExpired(value) {
 		return ErrNoSuchKey
 	}
-	if value.IsExpired() {
+	if oldKey == newKey {
 		return nil
 	}
 
-	// Check if the new key already exists
-	if _, ok := s.data[dbIndex][newKey]; ok {
-		// Overwrite the destination
-		s.delKey(dbIndex, newKey)
-	}
-	s.data[dbIndex][newKey] = value
+	// The destination, if any, is overwritten by the assignment. Replaying the
+	// RENAME from the AOF does the same, so no DEL needs to be logged for it.
 	s.delKey(dbIndex, oldKey)
+	s.putKey(dbIndex, newKey, value)
 
 	// Log the operation
-	s.aofChan <- fmt.Sprintf("RENAME %d %s %s", dbIndex, oldKey, newKey)
+	s.logAOF("RENAME", dbIndex, oldKey, newKey)
 
 	return nil
 }
 
+// Copy copies the value at key in srcDb to dstKey in dstDb, keeping the
+// source's expiry. It returns false when the source doesn't exist or the
+// destination does and replace is false.
+func (s *Store) Copy(srcDb int, key string, dstDb int, dstKey string, replace bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.data[srcDb][key]
+	if !ok || s.isExpired(value) {
+		return false
+	}
+	if old, ok := s.data[dstDb][dstKey]; ok && !s.isExpired(old) && !replace {
+		return false
+	}
+
+	// Round-trip through the DUMP format for a deep copy
+	payload := value.DumpPayload()
+	dup, err := ParseDumpPayload(payload)
+	if err != nil {
+		return false
+	}
+	dup.converted = value.converted
+
+	// The copy expires at the same instant as the source, logged as an
+	// absolute time so a rebuild does not restart the TTL
+	args := []string{dstKey, "0", string(payload)}
+	if value.ExpiresAt != nil {
+		at := *value.ExpiresAt
+		dup.ExpiresAt = &at
+		args[1] = strconv.FormatInt(at.UnixMilli(), 10)
+		args = append(args, "ABSTTL")
+	}
+	s.logAOF("RESTORE", dstDb, append(args, "REPLACE")...)
+
+	s.putKey(dstDb, dstKey, dup)
+	return true
+}
+
 // Type returns the (Redis) type of the value stored at key
 func (s *Store) Type(dbIndex int, key string) string {
+	if info, ok := s.Describe(dbIndex, key); ok {
+		return info.Type
+	}
+	return "none"
+}
+
+// Dump returns the DUMP payload of the value stored at key
+func (s *Store) Dump(dbIndex int, key string) ([]byte, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.data[dbIndex][key]
+	if !ok || s.isExpired(value) {
+		return nil, false
+	}
+	return value.DumpPayload(), true
+}
+
+// Restore creates key from a payload produced by Dump. A nil expiresAt
+// restores the key without a TTL.
+func (s *Store) Restore(dbIndex int, key string, payload []byte, expiresAt *time.Time, replace bool) error {
+	value, err := ParseDumpPayload(payload)
+	if err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	// verify if key exists
-	if val, exists := s.data[dbIndex][key]; exists {
-		switch val.Type {
-		case TypeString:
-			return "string"
-		case TypeList:
-			return "list"
-		case TypeHash:
-			return "hash"
-		case TypeSet:
-			return "set"
-		case TypeZSet:
-			return "zset"
+	if old, ok := s.data[dbIndex][key]; ok && !s.isExpired(old) && !replace {
+		return ErrBusyKey
+	}
+
+	// Log an absolute expiry so a rebuild does not extend the TTL
+	args := []string{key, "0", string(payload)}
+	if expiresAt != nil {
+		value.ExpiresAt = expiresAt
+		args[1] = strconv.FormatInt(expiresAt.UnixMilli(), 10)
+		args = append(args, "ABSTTL")
+	}
+	s.logAOF("RESTORE", dbIndex, append(args, "REPLACE")...)
+
+	// The payload doesn't record the encoding, so the restored value checks
+	// its size against the limits again
+	s.updateEncoding(value)
+	s.putKey(dbIndex, key, value)
+	return nil
+}
+
+// MemoryUsage returns the number of bytes needed to store the value at key.
+// Lists also account for their listpack and quicklist nodes.
+func (s *Store) MemoryUsage(dbIndex int, key string) (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.data[dbIndex][key]
+	if !ok || s.isExpired(value) {
+		return 0, false
+	}
+	size := value.SerializedSize()
+	if list, err := value.AsList(); err == nil {
+		size += s.listOverhead(list)
+	}
+	return size, true
+}
+
+// Touch updates the access time of the given keys and returns how many exist
+func (s *Store) Touch(dbIndex int, keys ...string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := s.now()
+	touched := 0
+	for _, key := range keys {
+		value, ok := s.data[dbIndex][key]
+		if !ok || s.isExpired(value) {
+			continue
 		}
+		value.touch(now)
+		touched++
 	}
-	return "none"
+	return touched
+}
+
+// IdleTime returns how long ago the key was last accessed
+func (s *Store) IdleTime(dbIndex int, key string) (time.Duration, bool) {
+	info, ok := s.Describe(dbIndex, key)
+	if !ok {
+		return 0, false
+	}
+	return info.Idle, true
 }
 
 // Keys returns all keys matching a pattern
@@ -586,12 +838,36 @@ func (s *Store) Keys(dbIndex int, pattern string) ([]string, error) {
 	return keys, nil
 }
 
+// AllKeys returns the live keys matching a glob-style pattern in every
+// database, sorted and grouped by database index. Empty databases are left out.
+// It is meant for tooling such as backups that need to walk the whole instance.
+func (s *Store) AllKeys(pattern string) map[int][]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	all := make(map[int][]string)
+	for dbIndex, db := range s.data {
+		var keys []string
+		for key, value := range db {
+			if s.isExpired(value) || !glob.Match(pattern, key) {
+				continue
+			}
+			keys = append(keys, key)
+		}
+		if len(keys) > 0 {
+			sort.Strings(keys)
+			all[dbIndex] = keys
+		}
+	}
+	return all
+}
+
 func (s *Store) FlushDb(dbIndex int) string {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	s.flushDb(dbIndex)
-	s.aofChan <- fmt.Sprintf("FLUSHDB %d", dbIndex)
+	s.logAOF("FLUSHDB", dbIndex)
 	return "OK"
 }
 
@@ -601,62 +877,8 @@ func (s *Store) FlushAll() string {
 
 	for dbIndex := range s.data {
 		s.flushDb(dbIndex)
+		s.markDirty(dbIndex)
 	}
-	s.aofChan <- "FLUSHALL"
+	s.appendAOF(encodeAOFRecord("FLUSHALL"))
 	return "OK"
 }
-
-func (s *Store) Scan(dbIndex int, cursor int, pattern string, count int) (int, []string, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	allKeys := make([]string, 0, len(s.data[dbIndex]))
-	for key := range s.data[dbIndex] {
-		// if s.isExpired(dbIndex, key) {
-		// 	continue
-		// }
-		value, ok := s.data[dbIndex][key]
-		if ok && value.IsExpired() {
-			continue
-		}
-		allKeys = append(allKeys, key)
-	}
-	if cursor < 0 || cursor >= len(allKeys) {
-		return 0, []string{}, nil
-	}
-	if count <= 0 {
-		count = 10 // default count
-	}
-
-	start := cursor
-	end := cursor + count
-	if end > len(allKeys) {
-		end = len(allKeys)
-	}
-	keySlice := allKeys[start:end]
-	var matchedKeys []string
-	if pattern != "" && pattern != "*" {
-		regexPattern := "^" + strings.ReplaceAll(strings.ReplaceAll(pattern, "?", "."), "*", ".*") + "$"
-		re, err := regexp.Compile(regexPattern)
-		if err != nil {
-			return 0, nil, err
-		}
-
-		for _, key := range keySlice {
-			if re.MatchString(key) {
-				matchedKeys = append(matchedKeys, key)
-			}
-		}
-	} else {
-		matchedKeys = keySlice
-	}
-
-	var nextCursor int
-	if end >= len(allKeys) {
-		nextCursor = 0
-	} else {
-		nextCursor = end
-	}
-
-	return nextCursor, matchedKeys, nil
-}
@@ -1,6 +1,11 @@
 package store
 
 import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -58,21 +63,274 @@ func TestSetNX(t *testing.T) {
 	}
 }
 
+// fakeClock is a Clock that only moves when advanced
+type fakeClock struct {
+	now time.Time
+}
+
+func newFakeClock() *fakeClock { return &fakeClock{now: time.Unix(1700000000, 0)} }
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
 func TestExpire(t *testing.T) {
 	aofChan := make(chan string, 100)
 
 	s := NewStore(aofChan)
+	clock := newFakeClock()
+	s.SetClock(clock)
 	s.Set(0, "Key1", "Value1")
 	if !s.Expire(0, "Key1", 1*time.Second) {
 		t.Fatalf("Expected Expire to succeed for Key1")
 	}
 
-	time.Sleep(2 * time.Second)
+	clock.Advance(999 * time.Millisecond)
+	if s.Exists(0, "Key1") != 1 {
+		t.Fatalf("Expected Key1 to exist until its TTL elapses")
+	}
+	clock.Advance(2 * time.Millisecond)
 	if s.Exists(0, "Key1") > 0 {
 		t.Fatalf("Expected Key1 to be expired")
 	}
 }
 
+// Test that relative expirations are logged as their deadline in
+// milliseconds, so a rebuild keeps sub-second TTLs and doesn't restart them
+func TestExpireLogsDeadline(t *testing.T) {
+	aofChan := make(chan string, 100)
+	s := NewStore(aofChan)
+	clock := newFakeClock()
+	s.SetClock(clock)
+	s.Set(0, "a", "value")
+	s.Set(0, "b", "value")
+	s.Set(0, "c", "value")
+	for range 3 {
+		<-aofChan
+	}
+
+	s.Expire(0, "a", 500*time.Millisecond)
+	s.PExpire(0, "b", 1500)
+	s.WithLock(0, func(tx *Tx) { tx.Expire("c", 2500*time.Millisecond) })
+
+	now := clock.Now().UnixMilli()
+	for _, want := range [][]string{
+		{"PEXPIREAT", "0", "a", strconv.FormatInt(now+500, 10)},
+		{"PEXPIREAT", "0", "b", strconv.FormatInt(now+1500, 10)},
+		{"PEXPIREAT", "0", "c", strconv.FormatInt(now+2500, 10)},
+	} {
+		if record := <-aofChan; record != encodeAOFRecord(want...) {
+			t.Fatalf("Expected %q to be logged, got %q", want, record)
+		}
+	}
+}
+
+// Test that the sweeper deletes the expired keys nobody reads, in several
+// batches, and logs a DEL for each
+func TestExpirationSweeper(t *testing.T) {
+	aofChan := make(chan string, 1000)
+	s := NewStore(aofChan)
+	clock := newFakeClock()
+	s.SetClock(clock)
+	s.Set(0, "persistent", "value")
+	const expiring = 3*sweepBatch + 7
+	for i := 0; i < expiring; i++ {
+		s.Set(i%3, "key:"+strconv.Itoa(i), "value", "PX", "100")
+	}
+	for len(aofChan) > 0 {
+		<-aofChan
+	}
+	clock.Advance(time.Second)
+
+	stop := make(chan struct{})
+	done := s.StartExpirationSweeper(time.Millisecond, stop)
+	deleted := map[string]bool{}
+	timeout := time.After(5 * time.Second)
+	for len(deleted) < expiring {
+		select {
+		case record := <-aofChan:
+			if !strings.HasPrefix(record, "*3\r\n$3\r\nDEL\r\n") {
+				t.Fatalf("Expected the sweeper to log DEL records, got %q", record)
+			}
+			deleted[record] = true
+		case <-timeout:
+			t.Fatalf("Expected %d keys to be swept, got %d", expiring, len(deleted))
+		}
+	}
+	close(stop)
+	<-done
+
+	if len(aofChan) != 0 {
+		t.Fatalf("Expected each key to be swept once, got %q", <-aofChan)
+	}
+	for dbIndex := 0; dbIndex < 3; dbIndex++ {
+		if n := len(s.data[dbIndex]); n != 0 && !(dbIndex == 0 && n == 1) {
+			t.Fatalf("Expected db %d to hold no expired key, %d keys left", dbIndex, n)
+		}
+	}
+	if s.Exists(0, "persistent") != 1 {
+		t.Fatalf("Expected the key without a TTL to be kept")
+	}
+}
+
+func TestPersist(t *testing.T) {
+	aofChan := make(chan string, 100)
+	s := NewStore(aofChan)
+	s.Set(0, "plain", "value")
+	s.Set(0, "expiring", "value", "EX", "100")
+	for len(aofChan) > 0 {
+		<-aofChan
+	}
+
+	for _, key := range []string{"missing", "plain"} {
+		if s.Persist(0, key) {
+			t.Fatalf("Expected PERSIST on %s to remove no TTL", key)
+		}
+	}
+	if len(aofChan) != 0 {
+		t.Fatalf("Expected a PERSIST that removes no TTL not to be logged, got %q", <-aofChan)
+	}
+
+	if !s.Persist(0, "expiring") {
+		t.Fatalf("Expected PERSIST to remove the TTL of expiring")
+	}
+	if record := <-aofChan; record != encodeAOFRecord("PERSIST", "0", "expiring") {
+		t.Fatalf("Expected PERSIST to be logged, got %q", record)
+	}
+	if ttl, _ := s.TTL(0, "expiring"); ttl != -1 {
+		t.Fatalf("Expected no TTL after PERSIST, got %d", ttl)
+	}
+	if s.Persist(0, "expiring") {
+		t.Fatalf("Expected a second PERSIST to remove no TTL")
+	}
+}
+
+func TestExpireAt(t *testing.T) {
+	aofChan := make(chan string, 100)
+	s := NewStore(aofChan)
+	clock := newFakeClock()
+	s.SetClock(clock)
+	s.Set(0, "seconds", "value")
+	s.Set(0, "millis", "value")
+	s.Set(0, "past", "value")
+	for len(aofChan) > 0 {
+		<-aofChan
+	}
+
+	now := clock.Now()
+	if !s.ExpireAt(0, "seconds", now.Unix()+10) {
+		t.Fatalf("Expected EXPIREAT to set the expiration of seconds")
+	}
+	if record := <-aofChan; record != encodeAOFRecord("PEXPIREAT", "0", "seconds", strconv.FormatInt(now.UnixMilli()+10000, 10)) {
+		t.Fatalf("Expected EXPIREAT to be logged as an absolute PEXPIREAT, got %q", record)
+	}
+	if !s.PExpireAt(0, "millis", now.UnixMilli()+1500) {
+		t.Fatalf("Expected PEXPIREAT to set the expiration of millis")
+	}
+	<-aofChan
+	if ttl, _ := s.PTTL(0, "millis"); ttl != 1500 {
+		t.Fatalf("Expected a PTTL of 1500, got %d", ttl)
+	}
+	if ttl, _ := s.TTL(0, "seconds"); ttl != 10 {
+		t.Fatalf("Expected a TTL of 10, got %d", ttl)
+	}
+
+	// A deadline that has passed deletes the key at once
+	if !s.ExpireAt(0, "past", now.Unix()-1) {
+		t.Fatalf("Expected EXPIREAT in the past to report the key existed")
+	}
+	if record := <-aofChan; record != encodeAOFRecord("DEL", "0", "past") {
+		t.Fatalf("Expected EXPIREAT in the past to log a DEL, got %q", record)
+	}
+	if _, ok := s.data[0]["past"]; ok {
+		t.Fatalf("Expected EXPIREAT in the past to delete the key")
+	}
+	if s.ExpireAt(0, "past", now.Unix()+10) || s.PExpireAt(0, "missing", now.UnixMilli()) {
+		t.Fatalf("Expected EXPIREAT and PEXPIREAT on a missing key to fail")
+	}
+	if len(aofChan) != 0 {
+		t.Fatalf("Expected nothing logged for missing keys, got %q", <-aofChan)
+	}
+}
+
+// Test that every expiration goes by the clock of the store
+func TestFakeClockExpiry(t *testing.T) {
+	s := NewStore(make(chan string, 100))
+	clock := newFakeClock()
+	s.SetClock(clock)
+
+	s.Set(0, "set", "value", "EX", "10")
+	s.Set(0, "pexpire", "value")
+	s.PExpire(0, "pexpire", 1500)
+	if ttl, _ := s.PTTL(0, "pexpire"); ttl != 1500 {
+		t.Fatalf("Expected a PTTL of 1500, got %d", ttl)
+	}
+	if ttl, _ := s.TTL(0, "set"); ttl != 10 {
+		t.Fatalf("Expected a TTL of 10, got %d", ttl)
+	}
+
+	clock.Advance(2 * time.Second)
+	if _, ok := s.Get(0, "pexpire"); ok {
+		t.Fatalf("Expected the PEXPIRE key to be expired after 2s")
+	}
+	if ttl, _ := s.TTL(0, "set"); ttl != 8 {
+		t.Fatalf("Expected a TTL of 8 after 2s, got %d", ttl)
+	}
+
+	clock.Advance(8*time.Second + time.Millisecond)
+	if ttl, _ := s.TTL(0, "set"); ttl != -2 {
+		t.Fatalf("Expected the SET EX key to be expired after 10s, got a TTL of %d", ttl)
+	}
+}
+
+// Test that the active expiry cycle only looks at the keys that have a TTL
+func TestActiveExpireOnlyExaminesKeysWithTTL(t *testing.T) {
+	s := NewStore(nil)
+	clock := newFakeClock()
+	s.SetClock(clock)
+
+	for i := 0; i < 10000; i++ {
+		s.Set(0, "plain:"+strconv.Itoa(i), "value")
+	}
+	for i := 0; i < 5; i++ {
+		s.Set(i%2, "ttl:"+strconv.Itoa(i), "value", "PX", strconv.Itoa(100*(i+1)))
+	}
+	// Keys whose TTL is removed or that are deleted leave the index
+	s.Set(0, "persisted", "value", "EX", "1")
+	s.Persist(0, "persisted")
+	s.Set(0, "deleted", "value", "EX", "1")
+	s.Del(0, "deleted")
+	s.Set(0, "overwritten", "value", "EX", "1")
+	s.Set(0, "overwritten", "value")
+	s.Set(1, "later", "value", "EX", "60")
+
+	if next, ok := s.NextExpiry(); !ok || !next.Equal(clock.Now().Add(100*time.Millisecond)) {
+		t.Fatalf("Expected the next expiry in 100ms, got %v, %v", next, ok)
+	}
+	if examined, expired := s.ActiveExpire(100); examined != 0 || expired != 0 {
+		t.Fatalf("Expected nothing to be due yet, examined %d and expired %d", examined, expired)
+	}
+
+	clock.Advance(2 * time.Second)
+	if examined, expired := s.ActiveExpire(3); examined != 3 || expired != 3 {
+		t.Fatalf("Expected 3 keys examined and expired, got %d and %d", examined, expired)
+	}
+	if examined, expired := s.ActiveExpire(100); examined != 2 || expired != 2 {
+		t.Fatalf("Expected the 2 remaining due keys examined and expired, got %d and %d", examined, expired)
+	}
+	for i := 0; i < 5; i++ {
+		if s.Exists(i%2, "ttl:"+strconv.Itoa(i)) != 0 {
+			t.Fatalf("Expected ttl:%d to be deleted", i)
+		}
+	}
+	if n := s.Exists(0, "plain:0", "plain:9999", "persisted", "overwritten"); n != 4 {
+		t.Fatalf("Expected the keys without a TTL to remain, %d do", n)
+	}
+	if next, ok := s.NextExpiry(); !ok || !next.Equal(clock.Now().Add(58*time.Second)) {
+		t.Fatalf("Expected only the key expiring in 58s to be left indexed, got %v, %v", next, ok)
+	}
+}
+
 func TestIncr(t *testing.T) {
 	aofChan := make(chan string, 100)
 	s := NewStore(aofChan)
@@ -119,34 +377,142 @@ func TesDecr(t *testing.T) {
 	}
 }
 
+func TestIncrByDecrBy(t *testing.T) {
+	aofChan := make(chan string, 100)
+	s := NewStore(aofChan)
+
+	if n, err := s.IncrBy(0, "counter", 10); err != nil || n != 10 {
+		t.Fatalf("Expected 10 from a missing key, got %d, %v", n, err)
+	}
+	if n, err := s.DecrBy(0, "counter", 15); err != nil || n != -5 {
+		t.Fatalf("Expected -5, got %d, %v", n, err)
+	}
+
+	s.Set(0, "string", "abc")
+	s.RPush(0, "list", "1")
+	for _, key := range []string{"string", "list"} {
+		if _, err := s.IncrBy(0, key, 1); err != ErrNotInteger {
+			t.Fatalf("Expected ErrNotInteger for %s, got %v", key, err)
+		}
+	}
+
+	// The step is logged, so a replay applies the same deltas
+	for _, want := range [][]string{{"INCRBY", "0", "counter", "10"}, {"DECRBY", "0", "counter", "15"}} {
+		if record := <-aofChan; record != encodeAOFRecord(want...) {
+			t.Fatalf("Expected %q to be logged, got %q", want, record)
+		}
+	}
+}
+
+func TestIncrOverflow(t *testing.T) {
+	s := NewStore(nil)
+	s.Set(0, "max", strconv.Itoa(math.MaxInt))
+	s.Set(0, "min", strconv.Itoa(math.MinInt))
+
+	tests := []struct {
+		name string
+		incr func() (int, error)
+		want error
+	}{
+		{"INCR max", func() (int, error) { return s.Incr(0, "max") }, ErrIncrOverflow},
+		{"INCRBY max 1", func() (int, error) { return s.IncrBy(0, "max", 1) }, ErrIncrOverflow},
+		{"DECRBY max -1", func() (int, error) { return s.DecrBy(0, "max", -1) }, ErrIncrOverflow},
+		{"DECR min", func() (int, error) { return s.Decr(0, "min") }, ErrIncrOverflow},
+		{"INCRBY min -1", func() (int, error) { return s.IncrBy(0, "min", -1) }, ErrIncrOverflow},
+		{"DECRBY missing MinInt", func() (int, error) { return s.DecrBy(0, "missing", math.MinInt) }, ErrDecrOverflow},
+	}
+	for _, tt := range tests {
+		if _, err := tt.incr(); err != tt.want {
+			t.Fatalf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+	if value, _ := s.Get(0, "max"); value.Data != strconv.Itoa(math.MaxInt) {
+		t.Fatalf("Expected the value to be left as it is, got %v", value.Data)
+	}
+	if s.Exists(0, "missing") != 0 {
+		t.Fatalf("Expected the rejected DECRBY not to create the key")
+	}
+
+	// The limits themselves are reachable
+	if n, err := s.IncrBy(0, "min", math.MaxInt); err != nil || n != -1 {
+		t.Fatalf("Expected -1, got %d, %v", n, err)
+	}
+	if n, err := s.DecrBy(0, "max", math.MaxInt); err != nil || n != 0 {
+		t.Fatalf("Expected 0, got %d, %v", n, err)
+	}
+}
+
+func TestIncrByFloat(t *testing.T) {
+	aofChan := make(chan string, 100)
+	s := NewStore(aofChan)
+
+	steps := []struct {
+		delta float64
+		want  string
+	}{
+		{10.5, "10.5"},
+		{0.1, "10.6"},
+		{-5.6, "5"},
+		{5.0e3, "5005"},
+	}
+	for _, step := range steps {
+		if got, err := s.IncrByFloat(0, "counter", step.delta); err != nil || got != step.want {
+			t.Fatalf("Expected %s after adding %v, got %s, %v", step.want, step.delta, got, err)
+		}
+	}
+	if value, _ := s.Get(0, "counter"); value.Data != "5005" {
+		t.Fatalf("Expected the result to be stored, got %v", value.Data)
+	}
+
+	s.Set(0, "string", "abc")
+	s.RPush(0, "list", "1")
+	for _, key := range []string{"string", "list"} {
+		if _, err := s.IncrByFloat(0, key, 1); err != ErrNotFloat {
+			t.Fatalf("Expected ErrNotFloat for %s, got %v", key, err)
+		}
+	}
+	s.Set(0, "huge", "1.7e308")
+	if _, err := s.IncrByFloat(0, "huge", 1.7e308); err != ErrNaNOrInfinity {
+		t.Fatalf("Expected ErrNaNOrInfinity, got %v", err)
+	}
+
+	// The result is logged, so a replay doesn't redo the arithmetic
+	if record := <-aofChan; record != encodeAOFRecord("SET", "0", "counter", "10.5", "KEEPTTL") {
+		t.Fatalf("Expected the result to be logged as a SET, got %q", record)
+	}
+}
+
 // test Ttl
 func TestTtl(t *testing.T) {
 	aofChan := make(chan string, 100)
 	s := NewStore(aofChan)
 
+	clock := newFakeClock()
+	s.SetClock(clock)
+
 	s.Set(0, "Key1", "Value1")
 	if !s.Expire(0, "Key1", 4*time.Second) {
 		t.Fatalf("Expected Expire to succeed for Key1")
 	}
-	time.Sleep(1 * time.Second)
+	clock.Advance(1 * time.Second)
 
 	// Test that TTL returns the correct remaining time
 	ttl, err := s.TTL(0, "Key1")
 	if err != nil {
 		t.Fatalf("Expected TTL to succeed for Key1")
 	}
-	if ttl != 2 {
-		t.Fatalf("Expected TTL to be 2 seconds, got %v", ttl)
+	if ttl != 3 {
+		t.Fatalf("Expected TTL to be 3 seconds, got %v", ttl)
 	}
 
-	time.Sleep(3 * time.Second)
+	clock.Advance(3*time.Second + time.Millisecond)
 
 	// Test that TTL returns -2 for expired key
 	ttl, err = s.TTL(0, "Key1")
 	if err != nil {
 		t.Fatalf("Expected TTL to succeed for Key1")
 	}
-	if ttl != 0 {
+	if ttl != -2 {
 		t.Fatalf("Expected TTL to be -2, got %v", ttl)
 	}
 
@@ -169,6 +535,52 @@ func TestTtl(t *testing.T) {
 	}
 }
 
+func TestTTLContract(t *testing.T) {
+	aofChan := make(chan string, 100)
+	s := NewStore(aofChan)
+	clock := newFakeClock()
+	s.SetClock(clock)
+	s.Set(0, "persistent", "value")
+	s.Set(0, "expiring", "value", "PX", "1500")
+	s.Set(0, "exact", "value", "PX", "2000")
+	s.Set(0, "expired", "value", "PX", "100")
+	clock.Advance(500 * time.Millisecond)
+	for len(aofChan) > 0 {
+		<-aofChan
+	}
+
+	tests := []struct {
+		key  string
+		ttl  int
+		pttl int64
+	}{
+		{"missing", -2, -2},
+		{"persistent", -1, -1},
+		{"expiring", 1, 1000}, // exactly 1s left
+		{"exact", 2, 1500},    // 1.5s left, rounded up
+		{"expired", -2, -2},
+	}
+	for _, tt := range tests {
+		if ttl, err := s.TTL(0, tt.key); err != nil || ttl != tt.ttl {
+			t.Fatalf("TTL %s: expected %d, got %d (%v)", tt.key, tt.ttl, ttl, err)
+		}
+		if pttl, err := s.PTTL(0, tt.key); err != nil || pttl != tt.pttl {
+			t.Fatalf("PTTL %s: expected %d, got %d (%v)", tt.key, tt.pttl, pttl, err)
+		}
+	}
+
+	// The expired key was deleted when it was found, once
+	if _, ok := s.data[0]["expired"]; ok {
+		t.Fatalf("Expected the expired key to be deleted")
+	}
+	if expired := s.Stats().ExpiredKeys; expired != 1 {
+		t.Fatalf("Expected 1 expired key, got %d", expired)
+	}
+	if len(aofChan) != 1 || !strings.Contains(<-aofChan, "DEL") {
+		t.Fatalf("Expected the deletion of the expired key to be logged once")
+	}
+}
+
 // test LPush
 func TestLPush(t *testing.T) {
 	aofChan := make(chan string, 100)
@@ -189,10 +601,7 @@ func TestLPush(t *testing.T) {
 	//test if the list contents are correct
 	list := s.GetList(0, "list")
 	expected := []string{"value3", "value2", "value1"}
-	listStr := make([]string, len(list))
-	for i, v := range list {
-		listStr[i] = v.(string)
-	}
+	listStr := list
 	if !slice.Equal(listStr, expected) {
 		t.Fatalf("Expected list to be [value3 value2 value1], got %v", listStr)
 	}
@@ -246,8 +655,8 @@ func TestLPop(t *testing.T) {
 	//test if LPOP returns empty list when called with count = 0
 	count = 0
 	value, err = s.LPop(0, "list", &count)
-	if (err != nil) || len(value.([]any)) != 0 {
-		t.Fatalf("Expected [] (empty list), got %s, value length is %d ", value, len(value.([]any)))
+	if (err != nil) || len(value.([]string)) != 0 {
+		t.Fatalf("Expected [] (empty list), got %s, value length is %d ", value, len(value.([]string)))
 	}
 
 	// test if LPOP returns the first element as string when called with count = nil
@@ -260,11 +669,7 @@ func TestLPop(t *testing.T) {
 	count = 3
 	value, err = s.LPop(0, "list", &count)
 	expected := []string{"value2", "value1"}
-	listStr := make([]string, len(value.([]any)))
-	for i, v := range value.([]any) {
-		listStr[i] = v.(string)
-	}
-	if (err != nil) || !slice.Equal(listStr, expected) {
+	if (err != nil) || !slice.Equal(value.([]string), expected) {
 		t.Fatalf("Expected [value2 value1], got %v", value)
 	}
 
@@ -299,12 +704,8 @@ func TestRPop(t *testing.T) {
 	//test if RPop returns empty list when called with count = 0
 	count = 0
 	list, err := s.RPop(0, "list", &count)
-	listStr := make([]string, len(list.([]any)))
-	for i, v := range list.([]any) {
-		listStr[i] = v.(string)
-	}
-	if (err != nil) || len(listStr) != 0 {
-		t.Fatalf("Expected [] (empty list), got %s, value length is %d ", list, len(listStr))
+	if (err != nil) || len(list.([]string)) != 0 {
+		t.Fatalf("Expected [] (empty list), got %s, value length is %d ", list, len(list.([]string)))
 	}
 	s.Del(0, "list")
 
@@ -320,11 +721,7 @@ func TestRPop(t *testing.T) {
 	t.Log("test if RPop returns the list when called with count argument greater than list length")
 	count = 3
 	list, err = s.RPop(0, "list", &count)
-	listStr = make([]string, len(list.([]any)))
-	for i, v := range list.([]any) {
-		listStr[i] = v.(string)
-	}
-
+	listStr := list.([]string)
 	expected := []string{"value3", "value2"}
 	if (err != nil) || !slice.Equal(listStr, expected) {
 		t.Fatalf("Expected [value3 value2], got %v", listStr)
@@ -352,10 +749,7 @@ func TestLRange(t *testing.T) {
 		t.Fatalf("Expected no error, got %v", err)
 	}
 	expected := []string{"value4", "value3", "value2", "value1"}
-	listStr := make([]string, len(list))
-	for i, v := range list {
-		listStr[i] = v.(string)
-	}
+	listStr := list
 	if !slice.Equal(listStr, expected) {
 		t.Fatalf("Expected %v, got %v", expected, listStr)
 	}
@@ -367,15 +761,130 @@ func TestLRange(t *testing.T) {
 		t.Fatalf("Expected no error, got %v", err)
 	}
 	expected = []string{"value3", "value2"}
-	listStr = make([]string, len(list))
-	for i, v := range list {
-		listStr[i] = v.(string)
-	}
+	listStr = list
 	if !slice.Equal(listStr, expected) {
 		t.Fatalf("Expected %v, got %v", expected, listStr)
 	}
 }
 
+func TestAppend(t *testing.T) {
+	aofChan := make(chan string, 100)
+	s := NewStore(aofChan)
+
+	if n, err := s.Append(0, "key", "hello"); err != nil || n != 5 {
+		t.Fatalf("Expected a new string of length 5, got %d, %v", n, err)
+	}
+	if n, err := s.Append(0, "key", " world"); err != nil || n != 11 {
+		t.Fatalf("Expected length 11 after appending, got %d, %v", n, err)
+	}
+	if value, _ := s.Get(0, "key"); value.Data != "hello world" {
+		t.Fatalf("Expected %q, got %v", "hello world", value.Data)
+	}
+
+	s.RPush(0, "list", "a")
+	s.HSet(0, "hash", "field", "value")
+	for _, key := range []string{"list", "hash"} {
+		if _, err := s.Append(0, key, "more"); err != ErrWrongType {
+			t.Fatalf("Expected ErrWrongType for %s, got %v", key, err)
+		}
+	}
+
+	// Each appended suffix is logged so a replay rebuilds the string
+	for _, suffix := range []string{"hello", " world"} {
+		if record := <-aofChan; record != encodeAOFRecord("APPEND", "0", "key", suffix) {
+			t.Fatalf("Expected APPEND of %q to be logged, got %q", suffix, record)
+		}
+	}
+}
+
+func TestLLen(t *testing.T) {
+	s := NewStore(make(chan string, 100))
+	s.RPush(0, "list", "a", "b", "c")
+	s.Set(0, "string", "value")
+	s.HSet(0, "hash", "field", "value")
+	s.SAdd(0, "set", "member")
+	s.ZAdd(0, "zset", ZAddOptions{}, ZMember{Member: "member", Score: 1})
+
+	if n, err := s.LLen(0, "list"); err != nil || n != 3 {
+		t.Fatalf("Expected 3 elements, got %d, %v", n, err)
+	}
+	if n, err := s.LLen(0, "missing"); err != nil || n != 0 {
+		t.Fatalf("Expected 0 for a missing key, got %d, %v", n, err)
+	}
+	for _, key := range []string{"string", "hash", "set", "zset"} {
+		if _, err := s.LLen(0, key); err != ErrWrongType {
+			t.Fatalf("Expected ErrWrongType for %s, got %v", key, err)
+		}
+	}
+}
+
+func TestLPos(t *testing.T) {
+	s := NewStore(make(chan string, 100))
+	s.RPush(0, "list", "a", "b", "c", "1", "2", "3", "c", "c")
+
+	cases := []struct {
+		rank, count, maxLen int
+		want                []int
+	}{
+		{1, 1, 0, []int{2}},
+		{2, 1, 0, []int{6}},
+		{-1, 1, 0, []int{7}},
+		{1, 0, 0, []int{2, 6, 7}},
+		{-2, 0, 0, []int{6, 2}},
+		{1, 0, 7, []int{2, 6}},
+		{-1, 0, 1, []int{7}},
+		{4, 1, 0, nil},
+	}
+	for _, c := range cases {
+		got, err := s.LPos(0, "list", "c", c.rank, c.count, c.maxLen)
+		if err != nil || !slices.Equal(got, c.want) {
+			t.Fatalf("LPos(rank %d, count %d, maxlen %d): expected %v, got %v, %v", c.rank, c.count, c.maxLen, c.want, got, err)
+		}
+	}
+}
+
+// Test that every list method hands out []string, and copies of the list
+func TestListMethodsReturnStrings(t *testing.T) {
+	aofChan := make(chan string, 100)
+	s := NewStore(aofChan)
+
+	values := []string{"a", "b", "c"}
+	s.LPush(0, "list", values...)
+	if !slice.Equal(values, []string{"a", "b", "c"}) {
+		t.Fatalf("Expected LPUSH to leave its arguments untouched, got %v", values)
+	}
+	s.RPush(0, "list", "d", "e")
+
+	value, _ := s.Get(0, "list")
+	if _, ok := value.Data.([]string); !ok {
+		t.Fatalf("Expected the list to be stored as []string, got %T", value.Data)
+	}
+	if list, err := value.AsList(); err != nil || !slice.Equal(list, []string{"c", "b", "a", "d", "e"}) {
+		t.Fatalf("Expected AsList to return [c b a d e], got %v (%v)", list, err)
+	}
+
+	list, _ := s.LRange(0, "list", 0, -1)
+	list[0] = "changed"
+	if got := s.GetList(0, "list"); got[0] != "c" {
+		t.Fatalf("Expected LRANGE to return a copy, the list now starts with %q", got[0])
+	}
+
+	single, _ := s.LPop(0, "list", nil)
+	if _, ok := single.(string); !ok {
+		t.Fatalf("Expected LPOP without a count to return a string, got %T", single)
+	}
+	count := 2
+	multiple, _ := s.RPop(0, "list", &count)
+	popped, ok := multiple.([]string)
+	if !ok || !slice.Equal(popped, []string{"d", "e"}) {
+		t.Fatalf("Expected RPOP with a count to return []string [d e], got %#v", multiple)
+	}
+	s.RPush(0, "list", "x", "y")
+	if !slice.Equal(popped, []string{"d", "e"}) {
+		t.Fatalf("Expected popped elements to survive a later RPUSH, got %v", popped)
+	}
+}
+
 // Test Rename
 func TestRename(t *testing.T) {
 	aofChan := make(chan string, 100)
@@ -429,12 +938,7 @@ func TestType(t *testing.T) {
 
 	// Arrange
 	s.Set(dbIndex, "myString", "value1")
-	myList := []string{"one", "two", "three"}
-	mySlice := make([]any, len(myList))
-	for i, v := range myList {
-		mySlice[i] = v
-	}
-	s.RPush(dbIndex, "myList", mySlice...)
+	s.RPush(dbIndex, "myList", "one", "two", "three")
 
 	// int
 	s.SetRawValue(dbIndex, "myInt", 123)
@@ -466,6 +970,16 @@ func TestType(t *testing.T) {
 		t.Logf("expected 'string', got '%s'", itype)
 		t.Fail()
 	}
+
+	// test if a key past its TTL is type 'none'
+	s.Set(dbIndex, "myExpired", "value")
+	s.PExpire(dbIndex, "myExpired", 1)
+	time.Sleep(5 * time.Millisecond)
+	etype := s.Type(dbIndex, "myExpired")
+	if etype != "none" {
+		t.Logf("expected 'none', got '%s'", etype)
+		t.Fail()
+	}
 }
 
 // Test Keys
@@ -476,12 +990,7 @@ func TestKeys(t *testing.T) {
 
 	s.Set(indexDb, "key1", "value1")
 	s.Set(indexDb, "key2", "value2")
-	list1 := []string{"one", "two", "tree"}
-	mySlice := make([]any, len(list1))
-	for i, v := range list1 {
-		mySlice[i] = v
-	}
-	s.RPush(indexDb, "list1", mySlice...)
+	s.RPush(indexDb, "list1", "one", "two", "tree")
 
 	keys, err := s.Keys(indexDb, "*")
 	if err != nil {
@@ -492,3 +1001,1004 @@ func TestKeys(t *testing.T) {
 		t.Logf("expected %v, got %v", expeted, keys)
 	}
 }
+
+// Test SET option parsing
+func TestParseSetOptions(t *testing.T) {
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	futureMs := strconv.FormatInt(time.Now().Add(time.Hour).UnixMilli(), 10)
+
+	tests := []struct {
+		name string
+		args []string
+		err  error
+	}{
+		{"no options", nil, nil},
+		{"NX", []string{"NX"}, nil},
+		{"XX", []string{"xx"}, nil},
+		{"GET", []string{"GET"}, nil},
+		{"NX GET", []string{"NX", "GET"}, nil},
+		{"XX GET", []string{"XX", "GET"}, nil},
+		{"EX", []string{"EX", "10"}, nil},
+		{"PX", []string{"PX", "100"}, nil},
+		{"EXAT", []string{"EXAT", future}, nil},
+		{"PXAT", []string{"PXAT", futureMs}, nil},
+		{"KEEPTTL", []string{"KEEPTTL"}, nil},
+		{"XX KEEPTTL GET", []string{"XX", "KEEPTTL", "GET"}, nil},
+		{"NX EX GET", []string{"NX", "EX", "10", "GET"}, nil},
+		{"NX XX", []string{"NX", "XX"}, ErrSyntax},
+		{"XX NX", []string{"XX", "NX"}, ErrSyntax},
+		{"EX PX", []string{"EX", "10", "PX", "100"}, ErrSyntax},
+		{"EX EXAT", []string{"EX", "10", "EXAT", future}, ErrSyntax},
+		{"PX PXAT", []string{"PX", "10", "PXAT", futureMs}, ErrSyntax},
+		{"EXAT PXAT", []string{"EXAT", future, "PXAT", futureMs}, ErrSyntax},
+		{"EX EX", []string{"EX", "10", "EX", "10"}, ErrSyntax},
+		{"KEEPTTL EX", []string{"KEEPTTL", "EX", "10"}, ErrSyntax},
+		{"PXAT KEEPTTL", []string{"PXAT", futureMs, "KEEPTTL"}, ErrSyntax},
+		{"EX without value", []string{"EX"}, ErrSyntax},
+		{"unknown option", []string{"FOO"}, ErrSyntax},
+		{"EX not an integer", []string{"EX", "ten"}, ErrNotInteger},
+		{"EX zero", []string{"EX", "0"}, ErrInvalidExpireTime("set")},
+		{"PX negative", []string{"PX", "-5"}, ErrInvalidExpireTime("set")},
+		{"EX overflow", []string{"EX", "9223372036854775807"}, ErrInvalidExpireTime("set")},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseSetOptions(tt.args)
+		if tt.err == nil && err != nil {
+			t.Errorf("%s: expected no error, got %v", tt.name, err)
+		}
+		if tt.err != nil && (err == nil || err.Error() != tt.err.Error()) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.err, err)
+		}
+	}
+}
+
+// Test SET with options
+func TestSetWithOptions(t *testing.T) {
+	aofChan := make(chan string, 100)
+	s := NewStore(aofChan)
+
+	// GET on a missing key returns nil and sets the value
+	options, _ := ParseSetOptions([]string{"GET"})
+	old, ok, err := s.SetWithOptions(0, "key", "v1", options)
+	if err != nil || !ok || old != nil {
+		t.Fatalf("Expected (nil, true, nil), got (%v, %v, %v)", old, ok, err)
+	}
+
+	// NX GET on an existing key returns the old value without setting
+	options, _ = ParseSetOptions([]string{"NX", "GET"})
+	old, ok, err = s.SetWithOptions(0, "key", "v2", options)
+	if err != nil || ok || old == nil || old.Data.(string) != "v1" {
+		t.Fatalf("Expected (v1, false, nil), got (%v, %v, %v)", old, ok, err)
+	}
+
+	// XX on a missing key does nothing
+	if ok, _ := s.Set(0, "missing", "v", "XX"); ok {
+		t.Fatalf("Expected SET XX to fail on a missing key")
+	}
+
+	// EX sets a TTL and KEEPTTL retains it
+	s.Set(0, "key", "v3", "EX", "100")
+	s.Set(0, "key", "v4", "KEEPTTL")
+	if ttl, _ := s.TTL(0, "key"); ttl < 98 || ttl > 100 {
+		t.Fatalf("Expected TTL close to 100, got %d", ttl)
+	}
+
+	// A plain SET discards the TTL
+	s.Set(0, "key", "v5")
+	if ttl, _ := s.TTL(0, "key"); ttl != -1 {
+		t.Fatalf("Expected TTL -1, got %d", ttl)
+	}
+
+	// GET against a non string value fails and leaves the value alone
+	s.RPush(0, "list", "a")
+	options, _ = ParseSetOptions([]string{"GET"})
+	if _, _, err := s.SetWithOptions(0, "list", "v", options); err != ErrWrongType {
+		t.Fatalf("Expected ErrWrongType, got %v", err)
+	}
+	if s.Type(0, "list") != "list" {
+		t.Fatalf("Expected list to be left untouched")
+	}
+}
+
+// Test that a full Scan returns every key that stays in the database while
+// other keys are deleted and added, growing the index between calls
+func TestScanWhileKeysChange(t *testing.T) {
+	s := NewStore(nil)
+	for i := 0; i < 200; i++ {
+		s.Set(0, "key:"+strconv.Itoa(i), "value")
+	}
+
+	seen := map[string]bool{}
+	cursor, deleted, added := 0, 0, 0
+	for {
+		next, keys := s.Scan(0, cursor, "", 10)
+		for _, key := range keys {
+			seen[key] = true
+		}
+		// Delete the odd keys, and add more new ones, as the scan runs
+		for i := 0; i < 5 && deleted < 100; i++ {
+			s.Del(0, "key:"+strconv.Itoa(2*deleted+1))
+			deleted++
+		}
+		for i := 0; i < 20 && added < 400; i++ {
+			s.Set(0, "new:"+strconv.Itoa(added), "value")
+			added++
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+
+	for i := 0; i < 200; i += 2 {
+		if key := "key:" + strconv.Itoa(i); !seen[key] {
+			t.Fatalf("Expected %s, present for the whole scan, to be returned", key)
+		}
+	}
+}
+
+// Test Scan with a sparse pattern
+func TestScanSparsePattern(t *testing.T) {
+	aofChan := make(chan string, 200)
+	s := NewStore(aofChan)
+	dbIndex := 0
+
+	expected := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		key := "key:" + strconv.Itoa(i)
+		if i%20 == 7 {
+			key = "match:" + strconv.Itoa(i)
+			expected[key] = true
+		}
+		s.Set(dbIndex, key, "value")
+	}
+
+	// A single call only examines COUNT keys, so it may not return every match
+	_, keys := s.Scan(dbIndex, 0, "match:*", 10)
+	if len(keys) >= len(expected) {
+		t.Fatalf("Expected a single call to examine only 10 keys, got %v", keys)
+	}
+
+	// A full iteration returns every match exactly once
+	seen := map[string]int{}
+	cursor, calls := 0, 0
+	for {
+		next, keys := s.Scan(dbIndex, cursor, "match:*", 10)
+		for _, key := range keys {
+			seen[key]++
+		}
+		calls++
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	// Each call but the last examines at least COUNT keys
+	if calls > 10 {
+		t.Fatalf("Expected at most 10 calls to scan 100 keys with COUNT 10, got %d", calls)
+	}
+	if len(seen) != len(expected) {
+		t.Fatalf("Expected %d matches, got %v", len(expected), seen)
+	}
+	for key, n := range seen {
+		if !expected[key] || n != 1 {
+			t.Fatalf("Expected %s to be returned once, got %d", key, n)
+		}
+	}
+}
+
+// Test WithLock
+func TestWithLock(t *testing.T) {
+	aofChan := make(chan string, 100)
+	s := NewStore(aofChan)
+	dbIndex := 0
+
+	s.Set(dbIndex, "counter", "0")
+	<-aofChan
+
+	// A read-modify-write made through the Tx
+	s.WithLock(dbIndex, func(tx *Tx) {
+		value, _ := tx.Get("counter")
+		n, _ := strconv.Atoi(value.Data.(string))
+		tx.Set("counter", strconv.Itoa(n+1))
+		tx.Set("other", "value")
+	})
+	value, _ := s.Get(dbIndex, "counter")
+	if value.Data.(string) != "1" {
+		t.Fatalf("Expected counter to be 1, got %v", value.Data)
+	}
+	if len(aofChan) != 2 {
+		t.Fatalf("Expected 2 AOF records after the transaction, got %d", len(aofChan))
+	}
+
+	// Concurrent readers never observe the intermediate state
+	done := make(chan struct{})
+	observed := make(chan string, 1)
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			if value, ok := s.Get(dbIndex, "state"); ok && value.Data.(string) == "intermediate" {
+				observed <- value.Data.(string)
+				return
+			}
+		}
+	}()
+	go func() {
+		for range aofChan {
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		s.WithLock(dbIndex, func(tx *Tx) {
+			tx.Set("state", "intermediate")
+			tx.Set("state", "final")
+		})
+	}
+	<-done
+	close(aofChan)
+	select {
+	case v := <-observed:
+		t.Fatalf("Observed intermediate state %q", v)
+	default:
+	}
+}
+
+// Test that the serialized size matches the DUMP payload for each type
+func TestSerializedSize(t *testing.T) {
+	aofChan := make(chan string, 100)
+	s := NewStore(aofChan)
+	dbIndex := 0
+
+	long := make([]byte, 300)
+	for i := range long {
+		long[i] = byte(i)
+	}
+	values := map[string]any{
+		"string": "Value1",
+		"binary": string(long),
+		"empty":  "",
+		"list":   []string{"one", "two words", string(long)},
+		"hash":   map[string]any{"field1": "value1", "field2": string(long)},
+		"set":    map[string]struct{}{"a": {}, "b": {}, "c": {}},
+		"zset":   map[string]float64{"a": 1, "b": 2.5, "c": -3},
+	}
+
+	for key, raw := range values {
+		s.Set(dbIndex, key, raw)
+
+		payload, ok := s.Dump(dbIndex, key)
+		if !ok {
+			t.Fatalf("Expected DUMP of %s to succeed", key)
+		}
+		value, _ := s.Get(dbIndex, key)
+		if value.SerializedSize() != len(payload)-dumpFooterSize {
+			t.Errorf("%s: serialized size %d differs from DUMP payload length %d without its footer", key, value.SerializedSize(), len(payload))
+		}
+		// Lists also count the listpack holding their entries
+		overhead := 0
+		if key == "list" {
+			overhead = listpackOverhead
+		}
+		if usage, _ := s.MemoryUsage(dbIndex, key); usage != value.SerializedSize()+overhead {
+			t.Errorf("%s: memory usage %d differs from serialized size %d plus %d", key, usage, value.SerializedSize(), overhead)
+		}
+
+		// The payload restores to an identical value
+		if err := s.Restore(dbIndex, key+":restored", payload, nil, false); err != nil {
+			t.Fatalf("%s: restore failed: %v", key, err)
+		}
+		restored, _ := s.Dump(dbIndex, key+":restored")
+		if string(restored) != string(payload) {
+			t.Errorf("%s: restored payload differs from the original", key)
+		}
+	}
+
+	// Restoring over an existing key requires REPLACE
+	payload, _ := s.Dump(dbIndex, "string")
+	if err := s.Restore(dbIndex, "list", payload, nil, false); err != ErrBusyKey {
+		t.Fatalf("Expected ErrBusyKey, got %v", err)
+	}
+	if err := s.Restore(dbIndex, "list", payload[:len(payload)-1], nil, true); err != ErrDumpPayload {
+		t.Fatalf("Expected ErrDumpPayload for a truncated payload, got %v", err)
+	}
+}
+
+// Test that RESTORE checks the version and checksum of DUMP payloads
+func TestRestoreValidatesDumpFooter(t *testing.T) {
+	s := NewStore(nil)
+	s.RPush(0, "list", "a", "b", "c")
+	payload, _ := s.Dump(0, "list")
+
+	corrupt := func(i int) []byte {
+		bad := slices.Clone(payload)
+		bad[i] ^= 0xff
+		return bad
+	}
+	tests := map[string][]byte{
+		"checksum":  corrupt(len(payload) - 1),
+		"version":   corrupt(len(payload) - dumpFooterSize),
+		"value":     corrupt(1),
+		"truncated": payload[:dumpFooterSize-1],
+	}
+	for name, bad := range tests {
+		err := s.Restore(0, "restored", bad, nil, false)
+		if err != ErrDumpPayload || err.Error() != "ERR DUMP payload version or checksum are wrong" {
+			t.Fatalf("%s: expected ErrDumpPayload, got %v", name, err)
+		}
+		if s.Exists(0, "restored") != 0 {
+			t.Fatalf("%s: expected the failed RESTORE not to create the key", name)
+		}
+	}
+
+	if err := s.Restore(0, "restored", payload, nil, false); err != nil {
+		t.Fatalf("Expected the intact payload to restore, got %v", err)
+	}
+}
+
+// Test PExpire and PTTL
+func TestPExpire(t *testing.T) {
+	aofChan := make(chan string, 100)
+	s := NewStore(aofChan)
+
+	s.Set(0, "Key1", "Value1")
+	if ok, _ := s.PExpire(0, "Key1", 1500); !ok {
+		t.Fatalf("Expected PExpire to succeed for Key1")
+	}
+
+	// 1.5 seconds are reported as 2 seconds, not truncated to 1
+	ttl, _ := s.TTL(0, "Key1")
+	if ttl != 2 {
+		t.Fatalf("Expected TTL to be 2 seconds, got %v", ttl)
+	}
+	pttl, _ := s.PTTL(0, "Key1")
+	if pttl < 1400 || pttl > 1500 {
+		t.Fatalf("Expected PTTL close to 1500, got %v", pttl)
+	}
+
+	if ok, _ := s.PExpire(0, "missing", 1500); ok {
+		t.Fatalf("Expected PExpire to fail for a missing key")
+	}
+	if pttl, _ := s.PTTL(0, "missing"); pttl != -2 {
+		t.Fatalf("Expected PTTL to be -2, got %v", pttl)
+	}
+
+	// A deadline out of the range of unix milliseconds is an error, and the
+	// TTL is left alone
+	for _, ms := range []int64{math.MaxInt64, math.MinInt64} {
+		if _, err := s.PExpire(0, "Key1", ms); err == nil || err.Error() != "ERR invalid expire time in 'pexpire' command" {
+			t.Fatalf("Expected an invalid expire time error for %d, got %v", ms, err)
+		}
+	}
+	if pttl, _ := s.PTTL(0, "Key1"); pttl < 1400 || pttl > 1500 {
+		t.Fatalf("Expected PTTL close to 1500, got %v", pttl)
+	}
+}
+
+func TestAllKeys(t *testing.T) {
+	s := NewStore(nil)
+
+	s.Set(0, "user:2", "b")
+	s.Set(0, "user:1", "a")
+	s.Set(0, "session", "x")
+	s.Set(3, "user:3", "c")
+	s.Set(7, "expired:user", "gone")
+	s.PExpire(7, "expired:user", 1)
+	s.Set(9, "other", "y")
+	time.Sleep(5 * time.Millisecond)
+
+	all := s.AllKeys("user:*")
+	if len(all) != 2 {
+		t.Fatalf("Expected keys in 2 databases, got %v", all)
+	}
+	if !slice.Equal(all[0], []string{"user:1", "user:2"}) {
+		t.Fatalf("Expected [user:1 user:2] in db 0, got %v", all[0])
+	}
+	if !slice.Equal(all[3], []string{"user:3"}) {
+		t.Fatalf("Expected [user:3] in db 3, got %v", all[3])
+	}
+
+	if all := s.AllKeys("*"); len(all) != 3 || len(all[0]) != 3 {
+		t.Fatalf("Expected every live key grouped in dbs 0, 3 and 9, got %v", all)
+	}
+}
+
+func TestCopyKeepsTTLAcrossDatabases(t *testing.T) {
+	aofChan := make(chan string, 100)
+	s := NewStore(aofChan)
+
+	s.Set(0, "source", "value")
+	s.Expire(0, "source", 10*time.Second)
+	if !s.Copy(0, "source", 1, "copy", false) {
+		t.Fatalf("Expected the copy to succeed")
+	}
+
+	source, _ := s.Get(0, "source")
+	copied, ok := s.Get(1, "copy")
+	if !ok || copied.Data.(string) != "value" {
+		t.Fatalf("Expected the copy to hold value, got %v", copied)
+	}
+	if copied.ExpiresAt == nil {
+		t.Fatalf("Expected the copy to keep the TTL")
+	}
+	if diff := copied.ExpiresAt.Sub(*source.ExpiresAt); diff < -time.Second || diff > time.Second {
+		t.Fatalf("Expected the copy to expire within a second of the source, got %v apart", diff)
+	}
+
+	// The destination is only overwritten with replace
+	s.Set(1, "other", "old")
+	if s.Copy(0, "source", 1, "other", false) {
+		t.Fatalf("Expected the copy over an existing key to fail without replace")
+	}
+	if !s.Copy(0, "source", 1, "other", true) {
+		t.Fatalf("Expected the copy over an existing key to succeed with replace")
+	}
+	if s.Copy(0, "missing", 1, "copy", true) {
+		t.Fatalf("Expected copying a missing key to fail")
+	}
+}
+
+// Test that GETDEL and GETEX are only logged when they change the key
+func TestGetDelGetExLogOnlyChanges(t *testing.T) {
+	aofChan := make(chan string, 100)
+	s := NewStore(aofChan)
+	s.Set(0, "key", "value")
+	<-aofChan
+
+	noops := []func(){
+		func() { s.GetEx(0, "key", &GetExOptions{}) },
+		func() { s.GetEx(0, "key", &GetExOptions{PERSIST: true}) },
+		func() { s.GetEx(0, "missing", &GetExOptions{SetOptions: SetOptions{EX: 100}}) },
+		func() { s.GetDel(0, "missing") },
+	}
+	for i, noop := range noops {
+		noop()
+		if len(aofChan) != 0 {
+			t.Fatalf("Expected no-op call %d not to be logged, got %q", i, <-aofChan)
+		}
+	}
+
+	if value, ok, err := s.GetEx(0, "key", &GetExOptions{SetOptions: SetOptions{PX: 5000}}); err != nil || !ok || value != "value" {
+		t.Fatalf("Expected GETEX to return value, got %q %v %v", value, ok, err)
+	}
+	if record := <-aofChan; !strings.Contains(record, "PEXPIREAT") {
+		t.Fatalf("Expected GETEX PX to log PEXPIREAT, got %q", record)
+	}
+	s.GetEx(0, "key", &GetExOptions{PERSIST: true})
+	if record := <-aofChan; !strings.Contains(record, "PERSIST") {
+		t.Fatalf("Expected GETEX PERSIST to log PERSIST, got %q", record)
+	}
+	if value, ok, _ := s.GetDel(0, "key"); !ok || value != "value" {
+		t.Fatalf("Expected GETDEL to return value, got %q %v", value, ok)
+	}
+	if record := <-aofChan; !strings.Contains(record, "DEL") {
+		t.Fatalf("Expected GETDEL to log DEL, got %q", record)
+	}
+	if s.Exists(0, "key") != 0 {
+		t.Fatalf("Expected GETDEL to delete the key")
+	}
+
+	s.RPush(0, "list", "a")
+	<-aofChan
+	if _, _, err := s.GetDel(0, "list"); err != ErrWrongType {
+		t.Fatalf("Expected GETDEL on a list to fail with WRONGTYPE, got %v", err)
+	}
+	if _, err := ParseGetExOptions([]string{"EX", "10", "PERSIST"}); err != ErrSyntax {
+		t.Fatalf("Expected GETEX with two options to be a syntax error, got %v", err)
+	}
+}
+
+// Test that GETSET swaps values atomically: under concurrent swaps every
+// value written is returned exactly once, by the swap that replaced it
+func TestGetSet(t *testing.T) {
+	aofChan := make(chan string, 1000)
+	s := NewStore(aofChan)
+
+	if old, ok, err := s.GetSet(0, "key", "first"); err != nil || ok || old != nil {
+		t.Fatalf("Expected GETSET on a missing key to return nil, got %v %v %v", old, ok, err)
+	}
+	if record := <-aofChan; !strings.Contains(record, "SET") || !strings.Contains(record, "first") {
+		t.Fatalf("Expected GETSET to log a SET, got %q", record)
+	}
+
+	const swaps = 200
+	returned := make(chan string, swaps)
+	var wg sync.WaitGroup
+	for i := 0; i < swaps; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			old, _, _ := s.GetSet(0, "key", strconv.Itoa(i))
+			returned <- old.(string)
+		}(i)
+	}
+	wg.Wait()
+	close(returned)
+
+	seen := map[string]bool{}
+	for old := range returned {
+		if seen[old] {
+			t.Fatalf("Expected %q to be returned once", old)
+		}
+		seen[old] = true
+	}
+	last, _ := s.Get(0, "key")
+	if seen[last.Data.(string)] || len(seen) != swaps {
+		t.Fatalf("Expected each value but the last to be returned once, got %d values and %q last", len(seen), last.Data)
+	}
+
+	s.RPush(0, "list", "a")
+	for len(aofChan) > 0 {
+		<-aofChan
+	}
+	if _, _, err := s.GetSet(0, "list", "value"); err != ErrWrongType {
+		t.Fatalf("Expected GETSET on a list to fail with WRONGTYPE, got %v", err)
+	}
+	if len(aofChan) != 0 || s.GetListLength(0, "list") != 1 {
+		t.Fatalf("Expected a failed GETSET to leave the list alone")
+	}
+}
+
+// Test that the running memory counter matches a full recount after writes
+func TestUsedMemoryMatchesRecount(t *testing.T) {
+	aofChan := make(chan string, 1000)
+	s := NewStore(aofChan)
+
+	s.Set(0, "string", "value")
+	s.Append(0, "string", "more")
+	s.SetRange(0, "string", 2, "xyz")
+	s.Set(0, "counter", "9")
+	s.Incr(0, "counter")
+	s.RPush(0, "list", "a", "b", "c", "d")
+	s.LPush(0, "list", "z")
+	count := 2
+	s.LPop(0, "list", &count)
+	s.LTrim(0, "list", 0, 0)
+	s.HSet(0, "hash", "f1", "v1", "f2", "v2")
+	s.HSet(0, "hash", "f1", "a longer value")
+	s.ZAdd(0, "zset", ZAddOptions{}, ZMember{Score: 1, Member: "a"}, ZMember{Score: 2, Member: "b"})
+	s.ZAdd(0, "zset", ZAddOptions{}, ZMember{Score: 3, Member: "a"})
+	s.SAdd(0, "set", "a", "b", "c")
+	s.SAdd(0, "set", "c", "d")
+	s.SRem(0, "set", "a", "missing")
+	s.Rename(0, "string", "renamed")
+	s.Copy(0, "hash", 1, "hash", false)
+	s.Set(0, "counter", "overwritten")
+	s.GetDel(0, "counter")
+	s.FlushDb(1)
+
+	used := s.UsedMemory()
+	s.mu.Lock()
+	s.recountMemory()
+	s.mu.Unlock()
+	if recount := s.UsedMemory(); used != recount {
+		t.Fatalf("Expected the running counter %d to match the recount %d", used, recount)
+	}
+
+	s.FlushAll()
+	if used := s.UsedMemory(); used != 0 {
+		t.Fatalf("Expected no memory used after FLUSHALL, got %d", used)
+	}
+}
+
+// Test that the idle time follows the clock of the store, and that Touch
+// resets it
+func TestIdleTime(t *testing.T) {
+	s := NewStore(nil)
+	clock := newFakeClock()
+	s.SetClock(clock)
+	s.Set(0, "key", "value")
+
+	clock.Advance(10 * time.Second)
+	if idle, ok := s.IdleTime(0, "key"); !ok || idle != 10*time.Second {
+		t.Fatalf("Expected an idle time of 10s, got %v", idle)
+	}
+	if n := s.Touch(0, "key", "missing"); n != 1 {
+		t.Fatalf("Expected 1 key touched, got %d", n)
+	}
+	if idle, _ := s.IdleTime(0, "key"); idle != 0 {
+		t.Fatalf("Expected Touch to reset the idle time, got %v", idle)
+	}
+	if _, ok := s.IdleTime(0, "missing"); ok {
+		t.Fatalf("Expected no idle time for a missing key")
+	}
+}
+
+// Test that the encoding of a list, which stops at the first node, agrees
+// with the nodes the whole list is split into
+func TestListEncodingMatchesNodes(t *testing.T) {
+	s := NewStore(nil)
+	lists := [][]string{
+		nil,
+		{"a"},
+		{strings.Repeat("x", 10000)},
+		{strings.Repeat("x", 4000), strings.Repeat("x", 4000)},
+		strings.Split(strings.Repeat("ab,", 2000), ","),
+		{"a", "b", "c"},
+	}
+	for _, limit := range []int{2, 3, -1, -2} {
+		s.SetEncodingLimits(EncodingLimits{ListMaxListpackSize: limit})
+		for _, list := range lists {
+			want := "listpack"
+			if len(s.listNodes(list)) > 1 {
+				want = "quicklist"
+			}
+			if got := s.listEncoding(list); got != want {
+				t.Fatalf("list-max-listpack-size %d, %d entries: expected %s, got %s", limit, len(list), want, got)
+			}
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	s := NewStore(make(chan string, 100))
+	clock := newFakeClock()
+	s.SetClock(clock)
+
+	s.Set(0, "int", "12345")
+	s.Set(0, "embstr", "hello", "PX", "1500")
+	s.Set(0, "raw", strings.Repeat("x", 45))
+	s.RPush(0, "list", "a", "b")
+	s.HSet(0, "hash", "field", "value")
+	s.Set(0, "set", map[string]struct{}{"member": {}})
+	s.ZAdd(0, "zset", ZAddOptions{}, ZMember{Score: 1, Member: "member"})
+
+	tests := []struct {
+		key      string
+		typ      string
+		encoding string
+		ttl      int64
+	}{
+		{"int", "string", "int", -1},
+		{"embstr", "string", "embstr", 1500},
+		{"raw", "string", "raw", -1},
+		{"list", "list", "listpack", -1},
+		{"hash", "hash", "listpack", -1},
+		{"set", "set", "hashtable", -1},
+		{"zset", "zset", "listpack", -1},
+	}
+	for _, tt := range tests {
+		info, ok := s.Describe(0, tt.key)
+		if !ok {
+			t.Fatalf("%s: expected the key to be described", tt.key)
+		}
+		if info.Type != tt.typ || info.Encoding != tt.encoding || info.TTL != tt.ttl {
+			t.Fatalf("%s: expected type %s, encoding %s and TTL %d, got %+v", tt.key, tt.typ, tt.encoding, tt.ttl, info)
+		}
+		if info.Size != 0 || info.ListNodes != nil {
+			t.Fatalf("%s: expected Describe to leave out the size, got %+v", tt.key, info)
+		}
+		sized, _ := s.DescribeSize(0, tt.key)
+		payload, _ := s.Dump(0, tt.key)
+		if sized.Size != len(payload)-dumpFooterSize {
+			t.Fatalf("%s: expected a size of %d, got %d", tt.key, len(payload)-dumpFooterSize, sized.Size)
+		}
+		if sized.Type != info.Type || sized.Encoding != info.Encoding || (tt.typ == "list") != (sized.ListNodes != nil) {
+			t.Fatalf("%s: expected DescribeSize to add to Describe, got %+v", tt.key, sized)
+		}
+		if info.Idle < 0 || info.Idle > time.Minute {
+			t.Fatalf("%s: expected the key to have been accessed when stored, got an idle time of %v", tt.key, info.Idle)
+		}
+		if s.Type(0, tt.key) != info.Type {
+			t.Fatalf("%s: expected TYPE to match Describe", tt.key)
+		}
+	}
+	if info, _ := s.Describe(0, "embstr"); info.TTLSeconds() != 2 {
+		t.Fatalf("Expected the TTL in seconds to be rounded up to 2, got %d", info.TTLSeconds())
+	}
+	if stats := s.Stats(); stats.Hits != 0 || stats.Misses != 0 {
+		t.Fatalf("Expected Describe not to count as a keyspace access, got %+v", stats)
+	}
+
+	clock.Advance(2 * time.Second)
+	if _, ok := s.Describe(0, "embstr"); ok {
+		t.Fatalf("Expected an expired key not to be described")
+	}
+	if _, ok := s.Describe(0, "missing"); ok {
+		t.Fatalf("Expected a missing key not to be described")
+	}
+}
+
+func TestMarkSavedKeepsLaterWrites(t *testing.T) {
+	s := NewStore(nil)
+	s.Set(0, "key", "value")
+	s.RPush(1, "list", "a")
+
+	_, mark, _ := s.GetSnapshot()
+	// Written while the snapshot is being saved
+	s.Set(0, "other", "value")
+	s.MarkSaved(mark)
+
+	if dirty := s.DBDirty(0); dirty != 1 {
+		t.Fatalf("Expected the write after the snapshot to stay dirty, got %d", dirty)
+	}
+	if dirty := s.Dirty(); dirty != 1 {
+		t.Fatalf("Expected 1 dirty write, got %d", dirty)
+	}
+
+	// Replayed writes are already persisted
+	s.SetReplaying(true)
+	s.Set(0, "replayed", "value")
+	s.SetReplaying(false)
+	if dirty := s.Dirty(); dirty != 1 {
+		t.Fatalf("Expected replayed writes not to count, got %d", dirty)
+	}
+}
+
+func TestGetMultiCollectsExpiredKeys(t *testing.T) {
+	aofChan := make(chan string, 100)
+	s := NewStore(aofChan)
+	clock := newFakeClock()
+	s.SetClock(clock)
+	s.Set(0, "live", "1")
+	s.Set(0, "expiring", "2", "PX", "100")
+	clock.Advance(time.Second)
+	for len(aofChan) > 0 {
+		<-aofChan
+	}
+
+	s.RPush(0, "list", "x")
+	<-aofChan
+	values := s.GetMulti(0, []string{"live", "expiring", "missing", "live", "list"})
+	if len(values) != 5 || values[0] == nil || *values[0] != "1" || values[1] != nil || values[2] != nil || values[3] == nil || *values[3] != "1" || values[4] != nil {
+		t.Fatalf("Expected [1 nil nil 1 nil], got %v", values)
+	}
+	if _, ok := s.data[0]["expiring"]; ok {
+		t.Fatalf("Expected the expired key to be collected")
+	}
+	if record := <-aofChan; !strings.Contains(record, "DEL") || !strings.Contains(record, "expiring") {
+		t.Fatalf("Expected the collection to be logged as a DEL, got %q", record)
+	}
+}
+
+// Test that the values returned by GetMulti are unaffected by the writes
+// that follow. Run with -race.
+func TestGetMultiCopiesValues(t *testing.T) {
+	s := NewStore(nil)
+	s.Set(0, "key", "")
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range 1000 {
+			s.Append(0, "key", "x")
+		}
+	}()
+	for range 1000 {
+		value := s.GetMulti(0, []string{"key"})[0]
+		if value == nil || strings.Trim(*value, "x") != "" {
+			t.Fatalf("Expected a string of x, got %v", value)
+		}
+	}
+	wg.Wait()
+}
+
+// Test that a value returned by Get isn't changed by the string commands that
+// follow, which keep the TTL of the key. Run with -race.
+func TestGetWhileStringsChange(t *testing.T) {
+	s := NewStore(nil)
+	s.Set(0, "key", "1")
+	s.Expire(0, "key", time.Hour)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range 1000 {
+			s.Incr(0, "key")
+			s.Append(0, "key", "0")
+			s.SetRange(0, "key", 0, "1")
+			s.Set(0, "key", "1", "KEEPTTL")
+		}
+	}()
+	for range 1000 {
+		value, ok := s.Get(0, "key")
+		if !ok {
+			t.Fatalf("Expected the key to exist")
+		}
+		if _, err := strconv.Atoi(value.Data.(string)); err != nil {
+			t.Fatalf("Expected a number, got %q", value.Data)
+		}
+	}
+	wg.Wait()
+	if ttl, _ := s.TTL(0, "key"); ttl <= 0 {
+		t.Fatalf("Expected the TTL to be kept, got %d", ttl)
+	}
+}
+
+// Test that SCAN MATCH is a glob pattern, in which regular expression
+// metacharacters have no special meaning
+func TestScanPatternIsGlob(t *testing.T) {
+	s := NewStore(nil)
+	for _, key := range []string{"a.b", "axb", "a(", "a+", "aa", "a*"} {
+		s.Set(0, key, "value")
+	}
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"a.b", []string{"a.b"}},
+		{"a(", []string{"a("}},
+		{"a+", []string{"a+"}},
+		{"a\\*", []string{"a*"}},
+		{"a[.x]b", []string{"a.b", "axb"}},
+	}
+	for _, tt := range tests {
+		_, keys := s.Scan(0, 0, tt.pattern, 100)
+		slices.Sort(keys)
+		if !slice.Equal(keys, tt.want) {
+			t.Fatalf("%s: expected %v, got %v", tt.pattern, tt.want, keys)
+		}
+	}
+}
+
+// setupMultiKeys stores 1000 keys for the multi-key benchmarks
+func setupMultiKeys() (*Store, []string) {
+	s := NewStore(nil)
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = "key:" + strconv.Itoa(i)
+		s.Set(0, keys[i], "value")
+	}
+	return s, keys
+}
+
+// BenchmarkGetMulti reads 1000 keys, as MGET does, under a single lock
+func BenchmarkGetMulti(b *testing.B) {
+	s, keys := setupMultiKeys()
+	b.ResetTimer()
+	for range b.N {
+		s.GetMulti(0, keys)
+	}
+}
+
+// BenchmarkGetPerKey reads the same keys locking once per key
+func BenchmarkGetPerKey(b *testing.B) {
+	s, keys := setupMultiKeys()
+	b.ResetTimer()
+	for range b.N {
+		values := make([]*Value, len(keys))
+		for i, key := range keys {
+			values[i], _ = s.Get(0, key)
+		}
+	}
+}
+
+func TestSetMembers(t *testing.T) {
+	aofChan := make(chan string, 100)
+	s := NewStore(aofChan)
+
+	if added, err := s.SAdd(0, "set", "b", "a", "b"); err != nil || added != 2 {
+		t.Fatalf("Expected 2 members added, got %d (%v)", added, err)
+	}
+	if added, _ := s.SAdd(0, "set", "a", "c"); added != 1 {
+		t.Fatalf("Expected only the new member to be counted, got %d", added)
+	}
+	if members, _ := s.SMembers(0, "set"); !slices.Equal(members, []string{"a", "b", "c"}) {
+		t.Fatalf("Expected [a b c], got %v", members)
+	}
+	if ok, _ := s.SIsMember(0, "set", "b"); !ok {
+		t.Fatalf("Expected b to be a member")
+	}
+	if ok, _ := s.SIsMember(0, "missing", "b"); ok {
+		t.Fatalf("Expected no members in a missing key")
+	}
+
+	if removed, _ := s.SRem(0, "set", "a", "x"); removed != 1 {
+		t.Fatalf("Expected 1 member removed, got %d", removed)
+	}
+	if removed, _ := s.SRem(0, "set", "b", "c"); removed != 2 {
+		t.Fatalf("Expected 2 members removed, got %d", removed)
+	}
+	if s.Exists(0, "set") != 0 {
+		t.Fatalf("Expected the empty set to be deleted")
+	}
+	if card, _ := s.SCard(0, "set"); card != 0 {
+		t.Fatalf("Expected a missing set to have no members, got %d", card)
+	}
+
+	// Only the members that changed are logged
+	expected := []string{"SADD 0 set b a", "SADD 0 set c", "SREM 0 set a", "SREM 0 set b c"}
+	for _, want := range expected {
+		if record := <-aofChan; record != encodeAOFRecord(strings.Fields(want)...) {
+			t.Fatalf("Expected %q to be logged, got %q", want, record)
+		}
+	}
+	if len(aofChan) != 0 {
+		t.Fatalf("Expected nothing else to be logged, got %d records", len(aofChan))
+	}
+
+	s.Set(0, "string", "value")
+	for _, err := range []error{
+		func() error { _, err := s.SAdd(0, "string", "a"); return err }(),
+		func() error { _, err := s.SRem(0, "string", "a"); return err }(),
+		func() error { _, err := s.SMembers(0, "string"); return err }(),
+		func() error { _, err := s.SIsMember(0, "string", "a"); return err }(),
+		func() error { _, err := s.SCard(0, "string"); return err }(),
+	} {
+		if err != ErrWrongType {
+			t.Fatalf("Expected ErrWrongType on a string, got %v", err)
+		}
+	}
+}
+
+func TestZRange(t *testing.T) {
+	s := NewStore(make(chan string, 100))
+	s.ZAdd(0, "zset", ZAddOptions{},
+		ZMember{Score: 2, Member: "c"}, ZMember{Score: 1, Member: "z"},
+		ZMember{Score: 2, Member: "b"}, ZMember{Score: math.Inf(1), Member: "top"})
+
+	tests := []struct {
+		start, stop int
+		withScores  bool
+		want        []string
+	}{
+		{0, -1, false, []string{"z", "b", "c", "top"}},
+		{1, 2, true, []string{"b", "2", "c", "2"}},
+		{-1, -1, true, []string{"top", "inf"}},
+		{-100, 100, false, []string{"z", "b", "c", "top"}},
+		{2, 1, false, []string{}},
+		{4, 10, false, []string{}},
+		{-10, -5, false, []string{}},
+	}
+	for _, tt := range tests {
+		got, err := s.ZRange(0, "zset", tt.start, tt.stop, tt.withScores)
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Fatalf("ZRange %d %d %v: expected %v, got %v (%v)", tt.start, tt.stop, tt.withScores, tt.want, got, err)
+		}
+	}
+
+	if got, err := s.ZRange(0, "missing", 0, -1, false); err != nil || len(got) != 0 {
+		t.Fatalf("Expected no members for a missing key, got %v (%v)", got, err)
+	}
+	s.Set(0, "string", "value")
+	if _, err := s.ZRange(0, "string", 0, -1, false); err != ErrWrongType {
+		t.Fatalf("Expected ErrWrongType on a string, got %v", err)
+	}
+}
+
+// Test that random members are drawn uniformly, distinct with a positive
+// count and with repeats with a negative one
+func TestRandomMembersAreUniform(t *testing.T) {
+	s := NewStore(nil)
+	members := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}
+	s.SAdd(0, "set", members...)
+
+	const draws = 20000
+	counts := map[string]int{}
+	for i := 0; i < draws; i++ {
+		picked, err := s.SRandMember(0, "set", 3)
+		if err != nil || len(picked) != 3 {
+			t.Fatalf("Expected 3 members, got %v, %v", picked, err)
+		}
+		if picked[0] == picked[1] || picked[0] == picked[2] || picked[1] == picked[2] {
+			t.Fatalf("Expected distinct members, got %v", picked)
+		}
+		for _, member := range picked {
+			counts[member]++
+		}
+	}
+	// Each member is picked with probability 0.3, so 6000 times on average
+	// with a standard deviation of about 65
+	for _, member := range members {
+		if counts[member] < 5600 || counts[member] > 6400 {
+			t.Fatalf("Expected about 6000 draws of each member, got %v", counts)
+		}
+	}
+
+	if picked, _ := s.SRandMember(0, "set", 100); len(picked) != len(members) {
+		t.Fatalf("Expected every member once when count exceeds the size, got %v", picked)
+	}
+	clear(counts)
+	picked, _ := s.SRandMember(0, "set", -draws)
+	if len(picked) != draws {
+		t.Fatalf("Expected %d members with repeats, got %d", draws, len(picked))
+	}
+	for _, member := range picked {
+		counts[member]++
+	}
+	for _, member := range members {
+		if counts[member] < 1700 || counts[member] > 2300 {
+			t.Fatalf("Expected about 2000 draws of each member, got %v", counts)
+		}
+	}
+}
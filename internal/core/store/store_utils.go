@@ -1,11 +1,80 @@
 package store
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 // delKey deletes a key from the store and its expiration
 func (s *Store) delKey(dbIndex int, key string) {
+	if value, ok := s.data[dbIndex][key]; ok {
+		s.usedMemory -= value.memSize
+		value.memSize = 0
+		if s.isExpired(value) {
+			s.counters.Load().expiredKeys.Add(1)
+		}
+	}
 	delete(s.data[dbIndex], key)
+	s.keys[dbIndex].remove(key)
+	s.expires[dbIndex].remove(key)
+}
+
+// expireIfNeeded deletes key if it has expired, as Redis does when it finds
+// an expired key, and logs the deletion so a replay doesn't bring it back
+func (s *Store) expireIfNeeded(dbIndex int, key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.data[dbIndex][key]
+	if !ok || !s.isExpired(value) {
+		return false
+	}
+	s.delKey(dbIndex, key)
+	s.logAOF("DEL", dbIndex, key)
+	return true
 }
 
 // flushDb flushes the database
 func (s *Store) flushDb(dbIndex int) {
+	for _, value := range s.data[dbIndex] {
+		s.usedMemory -= value.memSize
+		value.memSize = 0
+	}
 	s.data[dbIndex] = make(map[string]*Value)
+	s.expires[dbIndex] = newExpiryIndex()
+	s.keys[dbIndex] = newScanIndex()
+}
+
+// logAOF logs a write operation on dbIndex to the AOF channel and counts it
+// as dirty. The caller holds s.mu for writing.
+func (s *Store) logAOF(command string, dbIndex int, args ...string) {
+	s.markDirty(dbIndex)
+	record := append([]string{command, strconv.Itoa(dbIndex)}, args...)
+	s.appendAOF(encodeAOFRecord(record...))
+}
+
+// SetReplaying turns the logging of writes off while the AOF is replayed,
+// so a rebuild doesn't append its records again. It is safe to call while
+// other goroutines use the store.
+func (s *Store) SetReplaying(replaying bool) {
+	s.replaying.Store(replaying)
+}
+
+// appendAOF sends an encoded record to the AOF channel, if there is one
+func (s *Store) appendAOF(record string) {
+	if s.aofChan == nil || s.replaying.Load() {
+		return
+	}
+	s.aofChan <- record
+}
+
+// encodeAOFRecord encodes an AOF record as a RESP array of bulk strings, so
+// every argument is length-prefixed and may safely contain spaces or newlines
+func encodeAOFRecord(args ...string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return b.String()
 }
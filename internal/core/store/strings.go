@@ -0,0 +1,205 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultProtoMaxBulkLen is the largest string a command may build, as in Redis
+const DefaultProtoMaxBulkLen = 512 * 1024 * 1024
+
+var ErrStringTooLong = fmt.Errorf("ERR string exceeds maximum allowed size")
+var ErrOffsetOutOfRange = fmt.Errorf("ERR offset is out of range")
+
+// SetProtoMaxBulkLen sets the largest string APPEND and SETRANGE may build
+func (s *Store) SetProtoMaxBulkLen(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.protoMaxBulkLen = n
+}
+
+// checkStringLength fails when a string would grow past protoMaxBulkLen.
+// The caller holds s.mu.
+func (s *Store) checkStringLength(n int64) error {
+	if s.protoMaxBulkLen > 0 && n > s.protoMaxBulkLen {
+		return ErrStringTooLong
+	}
+	return nil
+}
+
+// getString returns the live string value at key, or nil when there is none.
+// The caller holds s.mu.
+func (s *Store) getString(dbIndex int, key string) (*Value, error) {
+	value, ok := s.data[dbIndex][key]
+	if !ok || s.isExpired(value) {
+		return nil, nil
+	}
+	if value.Type != TypeString {
+		return nil, ErrWrongType
+	}
+	return value, nil
+}
+
+// putString stores str at key as a new value, with the expiry of current
+// when it is not nil. Readers like GET use the value they looked up after
+// releasing the lock, so a stored string is replaced rather than changed in
+// place. The caller holds s.mu.
+func (s *Store) putString(dbIndex int, key string, current *Value, str string) {
+	value := NewStringValue(str)
+	if current != nil {
+		value.ExpiresAt = current.ExpiresAt
+	}
+	s.putKey(dbIndex, key, value)
+}
+
+// Append appends value to the string at key, creating it when missing, and
+// returns the new length. The expiry of an existing key is kept.
+func (s *Store) Append(dbIndex int, key, value string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, err := s.getString(dbIndex, key)
+	if err != nil {
+		return 0, err
+	}
+	if current == nil {
+		if err := s.checkStringLength(int64(len(value))); err != nil {
+			return 0, err
+		}
+		s.putKey(dbIndex, key, NewStringValue(value))
+		s.logAOF("APPEND", dbIndex, key, value)
+		return len(value), nil
+	}
+
+	str := current.Data.(string)
+	if err := s.checkStringLength(int64(len(str)) + int64(len(value))); err != nil {
+		return 0, err
+	}
+	s.putString(dbIndex, key, current, str+value)
+	s.logAOF("APPEND", dbIndex, key, value)
+	return len(str) + len(value), nil
+}
+
+// SetRange overwrites the string at key starting at offset, padding with zero
+// bytes when offset is past its end, and returns the new length. A missing key
+// is only created when value isn't empty.
+func (s *Store) SetRange(dbIndex int, key string, offset int, value string) (int, error) {
+	if offset < 0 {
+		return 0, ErrOffsetOutOfRange
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, err := s.getString(dbIndex, key)
+	if err != nil {
+		return 0, err
+	}
+	str := ""
+	if current != nil {
+		str = current.Data.(string)
+	}
+	if len(value) == 0 {
+		return len(str), nil
+	}
+	if err := s.checkStringLength(int64(offset) + int64(len(value))); err != nil {
+		return 0, err
+	}
+
+	size := max(len(str), offset+len(value))
+	buf := make([]byte, size)
+	copy(buf, str)
+	copy(buf[offset:], value)
+	s.putString(dbIndex, key, current, string(buf))
+	s.logAOF("SETRANGE", dbIndex, key, strconv.Itoa(offset), value)
+	return size, nil
+}
+
+// GetSet sets the string at key and returns the string it replaces, nil and
+// false when the key didn't exist. The read and the write happen under the
+// same lock, and nothing is written when the key holds another type. Like
+// SET, it discards the TTL of the key and logs a SET.
+func (s *Store) GetSet(dbIndex int, key, value string) (interface{}, bool, error) {
+	old, _, err := s.SetWithOptions(dbIndex, key, value, &SetOptions{GET: true})
+	if err != nil || old == nil {
+		return nil, false, err
+	}
+	return old.Data, true, nil
+}
+
+// GetDel returns the string at key and deletes the key. The bool is false
+// when the key doesn't exist, in which case nothing is logged.
+func (s *Store) GetDel(dbIndex int, key string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.lookupRead(dbIndex, key)
+	if !ok {
+		return "", false, nil
+	}
+	if current.Type != TypeString {
+		return "", false, ErrWrongType
+	}
+	s.delKey(dbIndex, key)
+	s.logAOF("DEL", dbIndex, key)
+	return current.Data.(string), true, nil
+}
+
+// GetExOptions are the options of GETEX: an expiry as in SET, or PERSIST
+type GetExOptions struct {
+	SetOptions // only EX, PX, EXAT and PXAT
+	PERSIST    bool
+}
+
+// ParseGetExOptions parses the optional arguments of the GETEX command, which
+// accepts at most one of EX, PX, EXAT, PXAT and PERSIST
+func ParseGetExOptions(args []string) (*GetExOptions, error) {
+	options := &GetExOptions{}
+	for i := 0; i < len(args); i++ {
+		if options.PERSIST || options.hasExpiry() {
+			return nil, ErrSyntax
+		}
+		switch option := strings.ToUpper(args[i]); option {
+		case "PERSIST":
+			options.PERSIST = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if i+1 >= len(args) {
+				return nil, ErrSyntax
+			}
+			if err := options.parseExpiry("getex", option, args[i+1]); err != nil {
+				return nil, err
+			}
+			i++
+		default:
+			return nil, ErrSyntax
+		}
+	}
+	return options, nil
+}
+
+// GetEx returns the string at key, setting or removing its expiry as asked.
+// Without options it is a plain read and logs nothing; an expiry is logged as
+// an absolute PEXPIREAT, and PERSIST only when the key had a TTL to remove.
+func (s *Store) GetEx(dbIndex int, key string, opts *GetExOptions) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.lookupRead(dbIndex, key)
+	if !ok {
+		return "", false, nil
+	}
+	if current.Type != TypeString {
+		return "", false, ErrWrongType
+	}
+	if expiresAt := opts.expiresAt(s.now()); expiresAt != nil {
+		current.ExpiresAt = expiresAt
+		s.indexExpiry(dbIndex, key, current)
+		s.logAOF("PEXPIREAT", dbIndex, key, strconv.FormatInt(expiresAt.UnixMilli(), 10))
+	} else if opts.PERSIST && current.ExpiresAt != nil {
+		current.ExpiresAt = nil
+		s.indexExpiry(dbIndex, key, current)
+		s.logAOF("PERSIST", dbIndex, key)
+	}
+	return current.Data.(string), true, nil
+}
@@ -0,0 +1,81 @@
+package store
+
+import (
+	"strconv"
+	"time"
+)
+
+// Tx is a handle to a database held under the store lock by WithLock. Its
+// methods never lock, so every read and write made through it is atomic with
+// respect to other store users.
+type Tx struct {
+	store   *Store
+	dbIndex int
+	records []string
+}
+
+// WithLock runs fn with the store write lock held, giving it a Tx on dbIndex.
+// AOF records of the writes made through the Tx are sent as a group once fn
+// returns. fn must not call methods on the Store itself (they would try to
+// take the lock again and deadlock), and the Tx must not be used after fn
+// returns.
+func (s *Store) WithLock(dbIndex int, fn func(tx *Tx)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &Tx{store: s, dbIndex: dbIndex}
+	fn(tx)
+	for _, record := range tx.records {
+		s.markDirty(dbIndex)
+		s.appendAOF(record)
+	}
+}
+
+// Get returns the value stored at key
+func (tx *Tx) Get(key string) (*Value, bool) {
+	value, ok := tx.store.data[tx.dbIndex][key]
+	if !ok || tx.store.isExpired(value) {
+		return nil, false
+	}
+	return value, true
+}
+
+// Exists reports whether key exists
+func (tx *Tx) Exists(key string) bool {
+	_, ok := tx.Get(key)
+	return ok
+}
+
+// Set stores a string value at key, discarding any previous TTL
+func (tx *Tx) Set(key, value string) {
+	tx.store.putKey(tx.dbIndex, key, NewStringValue(value))
+	tx.logAOF("SET", key, value)
+}
+
+// Del deletes key and reports whether it existed
+func (tx *Tx) Del(key string) bool {
+	existed := tx.Exists(key)
+	tx.store.delKey(tx.dbIndex, key)
+	if existed {
+		tx.logAOF("DEL", key)
+	}
+	return existed
+}
+
+// Expire sets the time to live of key and reports whether the key exists
+func (tx *Tx) Expire(key string, ttl time.Duration) bool {
+	value, ok := tx.Get(key)
+	if !ok {
+		return false
+	}
+	value.SetExpiration(tx.store.now(), ttl)
+	tx.store.indexExpiry(tx.dbIndex, key, value)
+	tx.logAOF("PEXPIREAT", key, strconv.FormatInt(value.ExpiresAt.UnixMilli(), 10))
+	return true
+}
+
+// logAOF queues a record to be sent when the transaction ends
+func (tx *Tx) logAOF(command string, args ...string) {
+	record := append([]string{command, strconv.Itoa(tx.dbIndex)}, args...)
+	tx.records = append(tx.records, encodeAOFRecord(record...))
+}
@@ -2,6 +2,7 @@ package store
 
 import (
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/andrelcunha/goodiesdb/internal/protocol"
@@ -18,14 +19,57 @@ const (
 	TypeNull
 )
 
+// String returns the name of the type as reported by TYPE
+func (t ValueType) String() string {
+	switch t {
+	case TypeString:
+		return "string"
+	case TypeList:
+		return "list"
+	case TypeHash:
+		return "hash"
+	case TypeSet:
+		return "set"
+	case TypeZSet:
+		return "zset"
+	default:
+		return "none"
+	}
+}
+
 type Value struct {
 	Type      ValueType
 	Data      interface{}
 	ExpiresAt *time.Time
+	// lastAccess is when the key was last read or written, in Unix nanoseconds,
+	// for OBJECT IDLETIME. It is atomic so reads can update it under the read
+	// lock. It isn't persisted: loaded values count as accessed when they are
+	// loaded.
+	lastAccess atomic.Int64
+	// converted is set once the value outgrew its compact encoding
+	converted bool
+	// memSize is the memory accounted for the value in Store.usedMemory while
+	// it is stored
+	memSize int64
 }
 
 var ErrWrongType = fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
 var ErrNotInteger = fmt.Errorf("ERR value is not an integer or out of range")
+var ErrNotFloat = fmt.Errorf("ERR value is not a valid float")
+var ErrNaNOrInfinity = fmt.Errorf("ERR increment would produce NaN or Infinity")
+var ErrIncrOverflow = fmt.Errorf("ERR increment or decrement would overflow")
+var ErrDecrOverflow = fmt.Errorf("ERR decrement would overflow")
+var ErrSyntax = fmt.Errorf("ERR syntax error")
+var ErrValueOutOfRange = fmt.Errorf("ERR value is out of range")
+var ErrBadDataFormat = fmt.Errorf("ERR Bad data format")
+var ErrBusyKey = fmt.Errorf("BUSYKEY Target key name already exists.")
+var ErrDumpPayload = fmt.Errorf("ERR DUMP payload version or checksum are wrong")
+
+// ErrInvalidExpireTime returns the error Redis replies with when a command
+// receives a non-positive or overflowing expire time
+func ErrInvalidExpireTime(command string) error {
+	return fmt.Errorf("ERR invalid expire time in '%s' command", command)
+}
 
 /* Constructors */
 
@@ -36,7 +80,7 @@ func NewStringValue(val string) *Value {
 	}
 }
 
-func NewListValue(val []any) *Value {
+func NewListValue(val []string) *Value {
 	return &Value{
 		Type: TypeList,
 		Data: val,
@@ -64,6 +108,16 @@ func NewZSetValue(val map[string]float64) *Value {
 	}
 }
 
+// touch records that the value was accessed at now
+func (v *Value) touch(now time.Time) {
+	v.lastAccess.Store(now.UnixNano())
+}
+
+// idleTime returns how long before now the value was last accessed
+func (v *Value) idleTime(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, v.lastAccess.Load()))
+}
+
 /* Getters */
 
 func (v *Value) AsString() (string, error) {
@@ -77,11 +131,11 @@ func (v *Value) AsString() (string, error) {
 	return str, nil
 }
 
-func (v *Value) AsList() ([]any, error) {
+func (v *Value) AsList() ([]string, error) {
 	if v.Type != TypeList {
 		return nil, ErrWrongType
 	}
-	list, ok := v.Data.([]any)
+	list, ok := v.Data.([]string)
 	if !ok {
 		return nil, ErrWrongType
 	}
@@ -133,7 +187,7 @@ func (v *Value) ToRESP() (protocol.RESPValue, error) {
 		list, _ := v.AsList()
 		arr := make(protocol.Array, len(list))
 		for i, item := range list {
-			arr[i] = protocol.BulkString([]byte(fmt.Sprintf("%v", item)))
+			arr[i] = protocol.BulkString(item)
 		}
 		return arr, nil
 	default:
@@ -143,21 +197,32 @@ func (v *Value) ToRESP() (protocol.RESPValue, error) {
 
 /* Expiration */
 
-func (v *Value) IsExpired() bool {
+// IsExpiredAt reports whether the value has expired at now
+func (v *Value) IsExpiredAt(now time.Time) bool {
 	if v.ExpiresAt == nil {
 		return false
 	}
-	return time.Now().After(*v.ExpiresAt)
+	return now.After(*v.ExpiresAt)
 }
 
-func (v *Value) SetExpiration(ttl time.Duration) {
-	expiry := time.Now().Add(ttl)
+// SetExpiration makes the value expire ttl after now
+func (v *Value) SetExpiration(now time.Time, ttl time.Duration) {
+	expiry := now.Add(ttl)
 	v.ExpiresAt = &expiry
 }
 
-func (v *Value) GetTTL() time.Duration {
+// TTLMillis returns the remaining time to live at now in milliseconds, or -1
+// if the value has no expiration
+func (v *Value) TTLMillis(now time.Time) int64 {
+	if v.ExpiresAt == nil {
+		return -1
+	}
+	return v.ExpiresAt.Sub(now).Milliseconds()
+}
+
+func (v *Value) GetTTL(now time.Time) time.Duration {
 	if v.ExpiresAt == nil {
 		return -1
 	}
-	return time.Until(*v.ExpiresAt)
+	return v.ExpiresAt.Sub(now)
 }
@@ -0,0 +1,232 @@
+package store
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+)
+
+var (
+	ErrZAddNXAndXX   = fmt.Errorf("ERR XX and NX options at the same time are not compatible")
+	ErrZAddGTLTNX    = fmt.Errorf("ERR GT, LT, and/or NX options at the same time are not compatible")
+	ErrZAddIncrPairs = fmt.Errorf("ERR INCR option supports a single increment-element pair")
+	ErrScoreIsNaN    = fmt.Errorf("ERR resulting score is not a number (NaN)")
+)
+
+// ZMember is a member of a sorted set and its score
+type ZMember struct {
+	Score  float64
+	Member string
+}
+
+// ZAddOptions are the flags of ZADD. NX only adds new members and XX only
+// updates existing ones. GT and LT only update a member when its new score
+// is greater, or lower, than the current one; they never prevent adding. CH
+// counts updated members as well as added ones. Incr adds the score to the
+// current one instead of replacing it.
+type ZAddOptions struct {
+	NX, XX, GT, LT, CH, Incr bool
+}
+
+// Validate reports the flag combinations ZADD rejects, given the number of
+// score/member pairs
+func (o ZAddOptions) Validate(pairs int) error {
+	if o.NX && o.XX {
+		return ErrZAddNXAndXX
+	}
+	if (o.GT && o.LT) || (o.GT && o.NX) || (o.LT && o.NX) {
+		return ErrZAddGTLTNX
+	}
+	if o.Incr && pairs != 1 {
+		return ErrZAddIncrPairs
+	}
+	return nil
+}
+
+// ZAdd adds members to the sorted set stored at key, updating the score of
+// those already present as allowed by opts. It returns the number of members
+// added, plus the number updated with CH.
+func (s *Store) ZAdd(dbIndex int, key string, opts ZAddOptions, members ...ZMember) (int, error) {
+	if err := opts.Validate(len(members)); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	added, updated := 0, 0
+	err := s.zadd(dbIndex, key, opts, members, func(m ZMember, isNew bool) {
+		if isNew {
+			added++
+		} else {
+			updated++
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	if opts.CH {
+		return added + updated, nil
+	}
+	return added, nil
+}
+
+// ZAddIncr runs ZADD with the INCR flag and returns the new score of member.
+// The bool is false when NX, XX, GT or LT prevented the update.
+func (s *Store) ZAddIncr(dbIndex int, key string, opts ZAddOptions, member ZMember) (float64, bool, error) {
+	opts.Incr = true
+	if err := opts.Validate(1); err != nil {
+		return 0, false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	score, applied := 0.0, false
+	err := s.zadd(dbIndex, key, opts, []ZMember{member}, func(m ZMember, _ bool) {
+		score, applied = m.Score, true
+	})
+	return score, applied, err
+}
+
+// ZScore returns the score of member in the sorted set stored at key. The
+// bool is false when the key or the member doesn't exist.
+func (s *Store) ZScore(dbIndex int, key, member string) (float64, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.lookupRead(dbIndex, key)
+	if !ok {
+		return 0, false, nil
+	}
+	zset, err := value.AsZSet()
+	if err != nil {
+		return 0, false, err
+	}
+	score, ok := zset[member]
+	return score, ok, nil
+}
+
+// ZRange returns the members of the sorted set stored at key between the
+// start and stop ranks, ordered by ascending score and then lexicographically.
+// Indexes are clamped as in LRange. With withScores every member is followed
+// by its score, formatted by FormatScore.
+func (s *Store) ZRange(dbIndex int, key string, start, stop int, withScores bool) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.lookupRead(dbIndex, key)
+	if !ok {
+		return nil, nil
+	}
+	zset, err := value.AsZSet()
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(zset)
+	if start < 0 {
+		start = n + start
+	}
+	if stop < 0 {
+		stop = n + stop
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop || start >= n || stop < 0 {
+		return []string{}, nil
+	}
+
+	members := make([]ZMember, 0, n)
+	for member, score := range zset {
+		members = append(members, ZMember{Score: score, Member: member})
+	}
+	slices.SortFunc(members, func(a, b ZMember) int {
+		return cmp.Or(cmp.Compare(a.Score, b.Score), cmp.Compare(a.Member, b.Member))
+	})
+
+	result := make([]string, 0, (stop-start+1)*2)
+	for _, m := range members[start : stop+1] {
+		result = append(result, m.Member)
+		if withScores {
+			result = append(result, FormatScore(m.Score))
+		}
+	}
+	return result, nil
+}
+
+// FormatScore formats a score the way Redis replies with it
+func FormatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+// zadd applies members to the sorted set at key and calls applied for each
+// member added or whose score changed. Only the resulting scores are logged,
+// so replaying the AOF needs none of the flags. The caller holds s.mu.
+func (s *Store) zadd(dbIndex int, key string, opts ZAddOptions, members []ZMember, applied func(m ZMember, isNew bool)) error {
+	value, ok := s.data[dbIndex][key]
+	isNew := !ok || s.isExpired(value)
+	if isNew {
+		value = NewZSetValue(make(map[string]float64, len(members)))
+	}
+	zset, err := value.AsZSet()
+	if err != nil {
+		return err
+	}
+
+	// Check every score first so an error leaves the set untouched
+	scores := make([]float64, len(members))
+	for i, m := range members {
+		scores[i] = m.Score
+		if current, exists := zset[m.Member]; exists && opts.Incr {
+			scores[i] = current + m.Score
+		}
+		if math.IsNaN(scores[i]) {
+			return ErrScoreIsNaN
+		}
+	}
+
+	args := []string{key}
+	delta := 0
+	for i, m := range members {
+		score := scores[i]
+		current, exists := zset[m.Member]
+		switch {
+		case exists && opts.NX, !exists && opts.XX:
+			continue
+		case exists && opts.GT && score <= current, exists && opts.LT && score >= current:
+			continue
+		case exists && score == current:
+			// Nothing changes, but INCR still replies with the score
+			if opts.Incr {
+				applied(ZMember{Score: score, Member: m.Member}, false)
+			}
+			continue
+		}
+		if !exists {
+			delta += stringSize(m.Member) + 8
+		}
+		zset[m.Member] = score
+		args = append(args, strconv.FormatFloat(score, 'g', -1, 64), m.Member)
+		applied(ZMember{Score: score, Member: m.Member}, !exists)
+	}
+	if len(args) == 1 {
+		return nil
+	}
+	s.updateEncoding(value)
+	if isNew {
+		s.putKey(dbIndex, key, value)
+	} else {
+		s.grow(value, delta)
+	}
+	s.logAOF("ZADD", dbIndex, args...)
+	return nil
+}
@@ -0,0 +1,212 @@
+package dump
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andrelcunha/goodiesdb/internal/protocol"
+	"github.com/andrelcunha/goodiesdb/internal/protocol/resp2"
+	"github.com/andrelcunha/goodiesdb/pkg/client"
+)
+
+// batchSize is the most elements written in a single command, so the big
+// collections are split into several commands of a reasonable size
+const batchSize = 512
+
+// Export writes to w the commands that rebuild the dataset of the server c
+// is connected to, for Import to load. Each database is walked with SCAN and
+// introduced with a SELECT, and each key is written as the commands that
+// create it: SET, or a DEL followed by RPUSH, HSET, SADD or ZADD so that
+// importing into a server that has the key doesn't merge the two. A TTL is
+// written as an absolute PEXPIREAT. The keys written while the export runs
+// may or may not be included. It returns the number of keys exported.
+func Export(c *client.Client, w io.Writer) (int, error) {
+	out := bufio.NewWriter(w)
+	keys := 0
+	for db := 0; ; db++ {
+		// Databases are selected until there are no more
+		if _, err := c.Do("SELECT", strconv.Itoa(db)); err != nil {
+			var reply client.Error
+			if db > 0 && errors.As(err, &reply) && strings.Contains(err.Error(), "out of range") {
+				break
+			}
+			return keys, err
+		}
+
+		selected := false
+		it := c.ScanIterator("", 0)
+		for it.Next() {
+			commands, err := dumpKey(c, it.Key())
+			if err != nil {
+				return keys, fmt.Errorf("key %q of db %d: %w", it.Key(), db, err)
+			}
+			// The key was deleted since it was scanned
+			if commands == nil {
+				continue
+			}
+			if !selected {
+				commands = append([][]string{{"SELECT", strconv.Itoa(db)}}, commands...)
+				selected = true
+			}
+			if err := writeCommands(out, commands); err != nil {
+				return keys, err
+			}
+			keys++
+		}
+		if err := it.Err(); err != nil {
+			return keys, err
+		}
+	}
+	return keys, out.Flush()
+}
+
+// dumpKey returns the commands that create key as it is now, or none if it
+// doesn't exist
+func dumpKey(c *client.Client, key string) ([][]string, error) {
+	reply, err := c.Do("TYPE", key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Collections are deleted first, as the commands creating them add to
+	// the key rather than replace it
+	var commands [][]string
+	collection := true
+	switch reply {
+	case "none":
+		return nil, nil
+	case "string":
+		value, err := c.Do("GET", key)
+		if err != nil || value == nil {
+			return nil, err
+		}
+		commands = [][]string{{"SET", key, value.(string)}}
+		collection = false
+	case "list":
+		items, err := listItems(c, key)
+		if err != nil {
+			return nil, err
+		}
+		commands = batches("RPUSH", key, items, 1)
+	case "hash":
+		pairs, err := readAll(c, "HGETALL", key)
+		if err != nil {
+			return nil, err
+		}
+		commands = batches("HSET", key, pairs, 2)
+	case "set":
+		members, err := readAll(c, "SMEMBERS", key)
+		if err != nil {
+			return nil, err
+		}
+		commands = batches("SADD", key, members, 1)
+	case "zset":
+		pairs, err := readAll(c, "ZRANGE", key, "0", "-1", "WITHSCORES")
+		if err != nil {
+			return nil, err
+		}
+		// ZADD takes the score before the member
+		for i := 0; i < len(pairs); i += 2 {
+			pairs[i], pairs[i+1] = pairs[i+1], pairs[i]
+		}
+		commands = batches("ZADD", key, pairs, 2)
+	default:
+		return nil, fmt.Errorf("unsupported type %v", reply)
+	}
+	// A collection emptied since it was scanned is gone
+	if len(commands) == 0 {
+		return nil, nil
+	}
+	if collection {
+		commands = append([][]string{{"DEL", key}}, commands...)
+	}
+
+	reply, err = c.Do("PTTL", key)
+	if err != nil {
+		return nil, err
+	}
+	switch ttl, _ := reply.(int64); {
+	case ttl == -2:
+		return nil, nil
+	case ttl >= 0:
+		at := time.Now().Add(time.Duration(ttl) * time.Millisecond).UnixMilli()
+		commands = append(commands, []string{"PEXPIREAT", key, strconv.FormatInt(at, 10)})
+	}
+	return commands, nil
+}
+
+// listItems returns the items of the list at key, read batchSize at a time
+func listItems(c *client.Client, key string) ([]string, error) {
+	var items []string
+	for start := 0; ; start += batchSize {
+		reply, err := c.Do("LRANGE", key, strconv.Itoa(start), strconv.Itoa(start+batchSize-1))
+		if err != nil {
+			return nil, err
+		}
+		page, err := toStrings(reply)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page...)
+		if len(page) < batchSize {
+			return items, nil
+		}
+	}
+}
+
+// readAll runs a command that replies with a flat array of strings, such
+// as HGETALL, and returns them
+func readAll(c *client.Client, args ...string) ([]string, error) {
+	reply, err := c.Do(args...)
+	if err != nil {
+		return nil, err
+	}
+	return toStrings(reply)
+}
+
+// toStrings converts an array reply of strings
+func toStrings(reply any) ([]string, error) {
+	values, ok := reply.([]any)
+	if !ok && reply != nil {
+		return nil, fmt.Errorf("unexpected reply %v", reply)
+	}
+	items := make([]string, len(values))
+	for i, value := range values {
+		if items[i], ok = value.(string); !ok {
+			return nil, fmt.Errorf("unexpected element %v", value)
+		}
+	}
+	return items, nil
+}
+
+// batches splits items, made of elements of width strings each, into
+// commands of up to batchSize elements
+func batches(command, key string, items []string, width int) [][]string {
+	var commands [][]string
+	for len(items) > 0 {
+		n := min(len(items), batchSize*width)
+		commands = append(commands, append([]string{command, key}, items[:n]...))
+		items = items[n:]
+	}
+	return commands
+}
+
+// writeCommands writes commands as RESP arrays of bulk strings
+func writeCommands(out *bufio.Writer, commands [][]string) error {
+	encoder := &resp2.RESP2Protocol{}
+	for _, args := range commands {
+		command := make(protocol.Array, len(args))
+		for i, arg := range args {
+			command[i] = protocol.BulkString(arg)
+		}
+		if err := encoder.Encode(out, command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package dump
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/andrelcunha/goodiesdb/internal/testutil"
+	"github.com/andrelcunha/goodiesdb/pkg/client"
+)
+
+// Test that exporting a server and importing the dump into an empty one
+// reproduces every database, type and TTL
+func TestExportImportRoundTrip(t *testing.T) {
+	_, source := testutil.StartServer(t)
+	testutil.AssertOK(t, source, "SET", "string", "line\r\nbreak")
+	testutil.AssertOK(t, source, "SET", "empty", "")
+	testutil.Do(t, source, "INCR", "counter")
+	testutil.AssertOK(t, source, "SET", "expiring", "value", "PX", "100000")
+	// More items than fit in one command
+	items := []string{"RPUSH", "list"}
+	for i := 0; i < 2*batchSize+3; i++ {
+		items = append(items, strconv.Itoa(i))
+	}
+	testutil.Do(t, source, items...)
+	testutil.Do(t, source, "HSET", "hash", "field", "value", "blank", "")
+	testutil.Do(t, source, "SADD", "set", "a", "b", "c")
+	testutil.Do(t, source, "ZADD", "zset", "1.5", "a", "-2", "b", "inf", "c")
+	testutil.Do(t, source, "EXPIRE", "hash", "1000")
+	testutil.AssertOK(t, source, "SELECT", "3")
+	testutil.AssertOK(t, source, "SET", "string", "db 3")
+	testutil.AssertOK(t, source, "SELECT", "15")
+	testutil.Do(t, source, "SADD", "last", "db 15")
+
+	var out bytes.Buffer
+	keys, err := Export(source, &out)
+	if err != nil || keys != 10 {
+		t.Fatalf("Expected 10 keys exported, got %d (%v)", keys, err)
+	}
+
+	_, target := testutil.StartServer(t)
+	if _, failed, err := Import(target, &out, &bytes.Buffer{}); err != nil || failed != 0 {
+		t.Fatalf("Expected the dump to import cleanly, got %d errors (%v)", failed, err)
+	}
+
+	for _, db := range []string{"0", "3", "15"} {
+		testutil.AssertOK(t, source, "SELECT", db)
+		testutil.AssertOK(t, target, "SELECT", db)
+		want := keyspace(t, source)
+		if got := keyspace(t, target); !reflect.DeepEqual(got, want) {
+			t.Fatalf("db %s: expected %v after the round trip, got %v", db, want, got)
+		}
+	}
+	testutil.AssertOK(t, source, "SELECT", "0")
+	testutil.AssertOK(t, target, "SELECT", "0")
+	for _, key := range []string{"expiring", "hash"} {
+		want := testutil.Do(t, source, "PTTL", key).(int64)
+		if got := testutil.Do(t, target, "PTTL", key).(int64); got > want+1 || got < want-1000 {
+			t.Fatalf("Expected %s to keep a PTTL close to %d, got %d", key, want, got)
+		}
+	}
+	testutil.AssertReply(t, target, int64(-1), "PTTL", "string")
+}
+
+// keyspace returns the keys of the selected database with their type and
+// contents, read with a command that replies in a stable order
+func keyspace(t *testing.T, c *client.Client) map[string][]any {
+	t.Helper()
+	reads := map[string][]string{
+		"string": {"GET"},
+		"list":   {"LRANGE", "0", "-1"},
+		"hash":   {"HGETALL"},
+		"set":    {"SMEMBERS"},
+		"zset":   {"ZRANGE", "0", "-1", "WITHSCORES"},
+	}
+	contents := map[string][]any{}
+	for _, key := range testutil.Do(t, c, "KEYS", "*").([]any) {
+		key := key.(string)
+		typ := testutil.Do(t, c, "TYPE", key).(string)
+		read := reads[typ]
+		args := append([]string{read[0], key}, read[1:]...)
+		contents[key] = []any{typ, testutil.Do(t, c, args...)}
+	}
+	return contents
+}
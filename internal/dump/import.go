@@ -0,0 +1,84 @@
+// Package dump moves data in and out of a running server as streams of
+// RESP-encoded commands, the format redis-cli --pipe consumes
+package dump
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andrelcunha/goodiesdb/internal/protocol"
+	"github.com/andrelcunha/goodiesdb/internal/protocol/resp2"
+	"github.com/andrelcunha/goodiesdb/pkg/client"
+)
+
+// Import sends the commands read from r to c, one at a time, so a SELECT
+// applies to the commands after it. It returns how many were applied and how
+// many got an error reply, which are written to errOut and skipped. The error
+// is set when a malformed command or a lost connection stopped the import.
+func Import(c *client.Client, r io.Reader, errOut io.Writer) (applied, failed int, err error) {
+	reader := bufio.NewReader(r)
+	for n := 1; ; {
+		args, err := readCommand(reader)
+		if err == io.EOF {
+			return applied, failed, nil
+		}
+		if err != nil {
+			return applied, failed, fmt.Errorf("command %d: %w", n, err)
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		if _, err := c.Do(args...); err != nil {
+			var reply client.Error
+			if !errors.As(err, &reply) {
+				return applied, failed, fmt.Errorf("command %d: %w", n, err)
+			}
+			fmt.Fprintf(errOut, "command %d (%s): %v\n", n, args[0], err)
+			failed++
+		} else {
+			applied++
+		}
+		n++
+	}
+}
+
+// readCommand reads the next command: a RESP array of bulk strings, or an
+// inline command for anything that doesn't start like one. A blank line is
+// an empty command.
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	prefix, err := reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if prefix[0] != '*' {
+		// The last line of a file may lack its newline
+		line, err := reader.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return nil, err
+		}
+		return strings.Fields(line), nil
+	}
+
+	value, err := (&resp2.RESP2Protocol{}).Parse(reader)
+	if err != nil {
+		return nil, err
+	}
+	arr, ok := value.(protocol.Array)
+	if !ok {
+		return nil, fmt.Errorf("expected an array, got %T", value)
+	}
+	args := make([]string, len(arr))
+	for i, item := range arr {
+		bulk, ok := item.(protocol.BulkString)
+		if !ok {
+			return nil, fmt.Errorf("expected bulk string arguments, got %T", item)
+		}
+		args[i] = string(bulk)
+	}
+	return args, nil
+}
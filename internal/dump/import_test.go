@@ -0,0 +1,58 @@
+package dump
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/andrelcunha/goodiesdb/internal/testutil"
+	"github.com/andrelcunha/goodiesdb/pkg/client"
+)
+
+func TestImport(t *testing.T) {
+	srv, c := testutil.StartServer(t)
+	importer, err := client.Dial(srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer importer.Close()
+
+	stream := strings.Join([]string{
+		"*3\r\n$3\r\nSET\r\n$6\r\nstring\r\n$12\r\nhello\r\nworld\r\n",
+		"*4\r\n$5\r\nRPUSH\r\n$4\r\nlist\r\n$1\r\na\r\n$1\r\nb\r\n",
+		"INCR list\r\n", // rejected, a list isn't a counter
+		"\r\n",
+		"SELECT 1\n",
+		"*4\r\n$4\r\nHSET\r\n$4\r\nhash\r\n$5\r\nfield\r\n$5\r\nvalue\r\n",
+		"SET inline value", // no final newline
+	}, "")
+	var errOut bytes.Buffer
+	applied, failed, err := Import(importer, strings.NewReader(stream), &errOut)
+	if err != nil || applied != 5 || failed != 1 {
+		t.Fatalf("Expected 5 commands applied and 1 failed, got %d, %d (%v)", applied, failed, err)
+	}
+	if !strings.HasPrefix(errOut.String(), "command 3 (INCR): ERR") {
+		t.Fatalf("Expected the rejected command to be reported, got %q", errOut.String())
+	}
+
+	testutil.AssertReply(t, c, "hello\r\nworld", "GET", "string")
+	testutil.AssertReply(t, c, []any{"a", "b"}, "LRANGE", "list", "0", "-1")
+	testutil.AssertOK(t, c, "SELECT", "1")
+	testutil.AssertReply(t, c, "value", "HGET", "hash", "field")
+	testutil.AssertReply(t, c, "value", "GET", "inline")
+}
+
+func TestImportStopsOnMalformedInput(t *testing.T) {
+	srv, _ := testutil.StartServer(t)
+	importer, err := client.Dial(srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer importer.Close()
+
+	stream := "SET a 1\r\n*2\r\n:1\r\n:2\r\nSET b 2\r\n"
+	applied, _, err := Import(importer, strings.NewReader(stream), &bytes.Buffer{})
+	if err == nil || !strings.HasPrefix(err.Error(), "command 2:") || applied != 1 {
+		t.Fatalf("Expected the import to stop at command 2 after applying 1, got %d (%v)", applied, err)
+	}
+}
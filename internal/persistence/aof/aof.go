@@ -2,89 +2,272 @@ package aof
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/andrelcunha/goodiesdb/internal/core/store"
+	"github.com/andrelcunha/goodiesdb/internal/protocol"
+	"github.com/andrelcunha/goodiesdb/internal/protocol/resp2"
 )
 
-// AOFWriter writes commands to a file
-func AOFWriter(aofChan chan string, filename string) {
-	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+// AOFWriter writes commands to a file. Failing to open or write the file does
+// not stop the process: the error is sent on errChan (when not nil) and the
+// remaining commands are drained without being written, so the store never
+// blocks on a broken AOF. errChan is closed once aofChan is closed and drained.
+func AOFWriter(aofChan chan string, filename string, errChan chan<- error) {
+	NewWriter(filename).Run(aofChan, errChan)
+}
+
+// Writer appends the records of the AOF channel to the AOF file, and swaps
+// in the files written by Rewrite
+type Writer struct {
+	filename string
+	swaps    chan swapRequest
+	stopped  chan struct{} // closed once Run stops writing
+}
+
+// swapRequest asks Run to finish a rewrite written to tmpName, or to drop it
+// when tmpName is empty
+type swapRequest struct {
+	tmpName string
+	done    chan error
+}
+
+// NewWriter creates a writer for the AOF file filename
+func NewWriter(filename string) *Writer {
+	return &Writer{
+		filename: filename,
+		swaps:    make(chan swapRequest),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// Run writes the records sent to aofChan until it is closed, as AOFWriter
+// does. The records that follow store.AOFRewriteMarker are also kept in
+// memory until the rewrite they belong to is swapped in.
+func (w *Writer) Run(aofChan chan string, errChan chan<- error) {
+	// Rewrites give up once the file is no longer written
+	stop := sync.OnceFunc(func() { close(w.stopped) })
+	defer stop()
+	if errChan != nil {
+		defer close(errChan)
+	}
+	fail := func(err error) {
+		stop()
+		if errChan != nil {
+			errChan <- err
+		} else {
+			log.Printf("AOF error: %v", err)
+		}
+		drain(aofChan)
+	}
+
+	file, err := os.OpenFile(w.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
-		log.Fatalf("Failed to open AOF file: %v", err)
+		fail(fmt.Errorf("failed to open AOF file: %w", err))
+		return
 	}
-	defer file.Close()
+	defer func() { file.Close() }()
 
-	for cmd := range aofChan {
-		_, err := file.WriteString(cmd + "\n")
-		if err != nil {
-			log.Fatalf("Failed to write to AOF file: %v", err)
+	// Records arrive already RESP-encoded and CRLF-terminated
+	var rewriting bool
+	var since []string
+	write := func(cmd string) error {
+		if cmd == store.AOFRewriteMarker {
+			rewriting, since = true, nil
+			return nil
+		}
+		if rewriting {
+			since = append(since, cmd)
+		}
+		if _, err := file.WriteString(cmd); err != nil {
+			return fmt.Errorf("failed to write to AOF file: %w", err)
 		}
+		return nil
+	}
+
+	for {
+		select {
+		case cmd, ok := <-aofChan:
+			if !ok {
+				return
+			}
+			if err := write(cmd); err != nil {
+				fail(err)
+				return
+			}
+
+		case req := <-w.swaps:
+			// The records queued before the marker are covered by the
+			// rewrite: they only go to the file it replaces
+			for !rewriting {
+				cmd, ok := <-aofChan
+				if !ok {
+					req.done <- errors.New("AOF closed during the rewrite")
+					return
+				}
+				if err := write(cmd); err != nil {
+					req.done <- err
+					fail(err)
+					return
+				}
+			}
+			if req.tmpName == "" {
+				rewriting, since = false, nil
+				req.done <- nil
+				continue
+			}
+			err := w.swap(req.tmpName, since)
+			rewriting, since = false, nil
+			if err != nil {
+				req.done <- err
+				continue
+			}
+			file.Close()
+			file, err = os.OpenFile(w.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+			req.done <- err
+			if err != nil {
+				fail(fmt.Errorf("failed to reopen AOF file: %w", err))
+				return
+			}
+		}
+	}
+}
+
+// drain discards the commands sent to aofChan until it is closed
+func drain(aofChan chan string) {
+	for range aofChan {
 	}
 }
 
-// RebuildStoreFromAOF rebuilds the store from the AOF file
-func RebuildStoreFromAOF(s *store.Store, filename string) error {
+// Dispatcher applies a write command read from the AOF. args holds the
+// command name and its arguments, without the database index.
+type Dispatcher func(dbIndex int, args []string) error
+
+// RebuildStoreFromAOF rebuilds the store from the AOF file. Every record is
+// handed to dispatch, which runs it the same way as a command from a client,
+// so any write command that is logged can be replayed. The writes aren't
+// logged again while replaying.
+//
+// Each record names the database it applies to right after the command, as
+// in "SET 3 key value", so the replay never depends on an active database
+// and SELECT is never logged. SELECT records, as found in AOF files written
+// from client commands, are skipped.
+//
+// A last record cut short, as a crash in the middle of a write leaves it, is
+// dropped and the file truncated after the record before it, as Redis does
+// with aof-load-truncated, so the records appended later can be replayed.
+func RebuildStoreFromAOF(s *store.Store, filename string, dispatch Dispatcher) error {
 	file, err := os.Open(filename)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	// Create scanner to read the AOF file
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		cmd := scanner.Text()
-		parts := strings.Split(cmd, " ")
+	s.SetReplaying(true)
+	defer s.SetReplaying(false)
+
+	counter := &countingReader{r: file}
+	reader := bufio.NewReader(counter)
+	for {
+		offset := counter.n - int64(reader.Buffered())
+		parts, err := readRecord(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Only a record running into the end of the file was cut short
+			if _, peekErr := reader.Peek(1); peekErr != io.EOF {
+				return err
+			}
+			log.Printf("AOF ends with an incomplete record (%v), truncating it at byte %d", err, offset)
+			return os.Truncate(filename, offset)
+		}
 		if len(parts) == 0 {
 			continue
 		}
-
-		dbIndex, err := strconv.Atoi(parts[1])
-		if err != nil {
-			log.Printf("Invalid database index: %s", parts[1])
+		if strings.EqualFold(parts[0], "SELECT") {
+			log.Printf("Skipping %s: AOF records name their own database", strings.Join(parts, " "))
 			continue
 		}
 
-		switch parts[0] {
-
-		case "SET":
-			aofSet(parts, s, dbIndex)
-
-		case "DEL":
-			aofDel(parts, s, dbIndex)
-
-		case "SETNX":
-			aofSetNX(parts, s, dbIndex)
-
-		case "EXPIRE":
-			aofExpire(parts, s, dbIndex)
-
-		case "LPUSH":
-			aofLPush(parts, s, dbIndex)
-
-		case "RPUSH":
-			aofRPush(parts, s, dbIndex)
+		// Records start with the command and its database, except those of
+		// commands without arguments, such as FLUSHALL
+		dbIndex, args := 0, parts
+		if len(parts) > 1 {
+			dbIndex, err = strconv.Atoi(parts[1])
+			if err != nil {
+				log.Printf("Invalid database index: %s", parts[1])
+				continue
+			}
+			args = append([]string{parts[0]}, parts[2:]...)
+		}
+		if err := dispatch(dbIndex, args); err != nil {
+			log.Printf("Could not replay %s: %v", strings.Join(parts, " "), err)
+		}
+	}
 
-		case "LPOP":
-			aofLPop(parts, s, dbIndex)
+	return nil
+}
 
-		case "RPOP":
-			aofRpop(parts, s, dbIndex)
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
 
-		case "LTRIM":
-			aofLTrim(parts, s, dbIndex)
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
 
-		case "RENAME":
-			aofRename(parts, s, dbIndex)
+// readRecord reads the next AOF record. Records are RESP arrays of bulk
+// strings; files written before the RESP format are still accepted as
+// space-separated lines.
+func readRecord(reader *bufio.Reader) ([]string, error) {
+	prefix, err := reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
 
-		default:
-			log.Printf("Unknown command: %s", cmd)
+	if prefix[0] != '*' {
+		line, err := reader.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			return nil, nil
 		}
+		return strings.Split(line, " "), nil
 	}
 
-	return scanner.Err()
+	value, err := (&resp2.RESP2Protocol{}).Parse(reader)
+	if err == io.EOF {
+		// The record has started, so it was cut short
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	arr, ok := value.(protocol.Array)
+	if !ok {
+		return nil, fmt.Errorf("invalid AOF record: %v", value)
+	}
+	parts := make([]string, len(arr))
+	for i, item := range arr {
+		bulk, ok := item.(protocol.BulkString)
+		if !ok {
+			return nil, fmt.Errorf("invalid AOF record argument: %v", item)
+		}
+		parts[i] = string(bulk)
+	}
+	return parts, nil
 }
@@ -2,142 +2,200 @@ package aof
 
 import (
 	"os"
-	"strconv"
-	"strings"
+	"path/filepath"
+	"slices"
 	"testing"
-	"time"
 
 	"github.com/andrelcunha/goodiesdb/internal/core/store"
-	"github.com/andrelcunha/goodiesdb/internal/utils/slice"
 )
 
-func TestRebuildStoreFromAOF(t *testing.T) {
-	aofFilename := "test_appendonly.aof"
-	os.Remove(aofFilename)
-	aofChan := make(chan string, 100)
-
-	// Start the AOF writer
-	go AOFWriter(aofChan, aofFilename)
-
-	// Initialize the store with AOF logging
-	s := store.NewStore(aofChan)
-
-	dbIndex := 0
-
-	// Set and expire commands
-	s.Set(dbIndex, "Key1", "Value1")
-	s.Set(dbIndex, "Key2", "Value2")
-	s.Expire(dbIndex, "Key1", 3*time.Second)
-
-	// SETNX command
-	s.SetNX(dbIndex, "Key3", "Value3")   // Should succeed
-	s.SetNX(dbIndex, "Key3", "NewValue") // Should fail because Key3 already exists
-
-	// List commands
-	s.LPush(dbIndex, "List1", "Value1", "Value2", "Value3")
-	s.RPush(dbIndex, "List1", "Value4")
-	s.LPop(dbIndex, "List1", nil)
-	s.RPop(dbIndex, "List1", nil)
-
-	// List trimming commands
-	s.LTrim(dbIndex, "List1", 1, 2)
-
-	// Rename command
-	s.Rename(dbIndex, "Key2", "RenamedKey")
-
-	// Give some time for commands to be written to AOF
-	time.Sleep(1 * time.Second)
+// recorder is a Dispatcher that records the commands it is given
+type recorder struct {
+	dbs      []int
+	commands [][]string
+}
 
-	// Rebuild state from AOF
-	newAofFilename := "new_test_appendonly.aof"
-	os.Remove(newAofFilename)
-	newAofChan := make(chan string, 100)
-	go AOFWriter(newAofChan, newAofFilename)
+func (r *recorder) dispatch(dbIndex int, args []string) error {
+	r.dbs = append(r.dbs, dbIndex)
+	r.commands = append(r.commands, args)
+	return nil
+}
 
-	newStore := store.NewStore(newAofChan)
+// Test that AOF files written before the RESP format can still be replayed
+func TestRebuildFromLegacyAOF(t *testing.T) {
+	aofFilename := filepath.Join(t.TempDir(), "appendonly.aof")
+	legacy := "SET 0 Key1 Value1\nRPUSH 0 List1 a b c\nFLUSHALL\nSET 2 Key2 Value2\n"
+	if err := os.WriteFile(aofFilename, []byte(legacy), 0644); err != nil {
+		t.Fatalf("Failed to write legacy AOF: %v", err)
+	}
 
-	err := RebuildStoreFromAOF(newStore, aofFilename)
-	if err != nil {
+	r := &recorder{}
+	if err := RebuildStoreFromAOF(store.NewStore(nil), aofFilename, r.dispatch); err != nil {
 		t.Fatalf("Failed to rebuild state from AOF: %v", err)
 	}
-	// set new aofFilename
+	expected := [][]string{{"SET", "Key1", "Value1"}, {"RPUSH", "List1", "a", "b", "c"}, {"FLUSHALL"}, {"SET", "Key2", "Value2"}}
+	if !slices.EqualFunc(r.commands, expected, slices.Equal) || !slices.Equal(r.dbs, []int{0, 0, 0, 2}) {
+		t.Fatalf("Expected %q on databases [0 0 0 2], got %q on %v", expected, r.commands, r.dbs)
+	}
+}
 
-	// Verify Key2 has been renamed to RenamedKey
-	value, ok := newStore.Get(dbIndex, "RenamedKey")
-	valStr := value.Data.(string)
+// Test that a record cut short at the end of the file is dropped and
+// truncated, so the records appended after it are replayed on the next load
+func TestRebuildTruncatesIncompleteRecord(t *testing.T) {
+	aofFilename := filepath.Join(t.TempDir(), "appendonly.aof")
+	complete := "*3\r\n$3\r\nSET\r\n$1\r\n0\r\n$1\r\na\r\n"
+	for _, cut := range []string{"*4\r\n$3\r\nSET\r\n$1\r\n0\r\n$1\r\nb\r\n$5\r\nval", "*4\r\n$3", "*"} {
+		if err := os.WriteFile(aofFilename, []byte(complete+cut), 0644); err != nil {
+			t.Fatalf("Failed to write AOF: %v", err)
+		}
+		r := &recorder{}
+		if err := RebuildStoreFromAOF(store.NewStore(nil), aofFilename, r.dispatch); err != nil {
+			t.Fatalf("%q: expected the incomplete record to be dropped, got %v", cut, err)
+		}
+		if !slices.EqualFunc(r.commands, [][]string{{"SET", "a"}}, slices.Equal) {
+			t.Fatalf("%q: expected only the complete record, got %q", cut, r.commands)
+		}
+		if data, _ := os.ReadFile(aofFilename); string(data) != complete {
+			t.Fatalf("%q: expected the file to be truncated after the complete record, got %q", cut, data)
+		}
+	}
 
-	if !ok || valStr != "Value2" {
-		t.Errorf("Expected Value2 for RenamedKey, got %s", valStr)
-		t.Fail()
+	// The writer appends after the complete records
+	aofChan := make(chan string, 1)
+	errChan := make(chan error, 1)
+	go AOFWriter(aofChan, aofFilename, errChan)
+	store.NewStore(aofChan).Set(0, "c", "value")
+	close(aofChan)
+	if err := <-errChan; err != nil {
+		t.Fatalf("Unexpected AOF error: %v", err)
+	}
+	r := &recorder{}
+	if err := RebuildStoreFromAOF(store.NewStore(nil), aofFilename, r.dispatch); err != nil || len(r.commands) != 2 || r.commands[1][1] != "c" {
+		t.Fatalf("Expected the record written after the truncation to be replayed, got %q (%v)", r.commands, err)
 	}
 
-	// Verify List1 contents
-	list, _ := newStore.LRange(dbIndex, "List1", 0, -1)
-	expectedList := []string{"Value1"}
-	listStr := make([]string, len(list))
-	for i, v := range list {
-		listStr[i] = v.(string)
+	// A malformed record followed by more data is still an error
+	if err := os.WriteFile(aofFilename, []byte("*1\r\n:5\r\n"+complete), 0644); err != nil {
+		t.Fatalf("Failed to write AOF: %v", err)
 	}
-	if !slice.Equal(listStr, expectedList) {
-		t.Errorf("Expected %v, got %v", expectedList, list)
-		t.Fail()
+	if err := RebuildStoreFromAOF(store.NewStore(nil), aofFilename, (&recorder{}).dispatch); err == nil {
+		t.Fatalf("Expected a malformed record in the middle of the file to fail the load")
 	}
+}
 
-	// Wait for the key to expire
-	time.Sleep(4 * time.Second)
+// Test that every record logged by the store reaches the dispatcher with its
+// database, arguments intact, and that the replay doesn't log again
+func TestRebuildDispatchesRecords(t *testing.T) {
+	aofFilename := filepath.Join(t.TempDir(), "appendonly.aof")
+	aofChan := make(chan string, 100)
+	errChan := make(chan error, 1)
+	go AOFWriter(aofChan, aofFilename, errChan)
 
-	// Verify Key1 exists after it expires
-	if newStore.Exists(dbIndex, "Key1") > 0 {
-		t.Errorf("Expected Key1 to be expired after waiting more than 3 seconds")
-		t.Fail()
+	s := store.NewStore(aofChan)
+	s.RPush(1, "list", "two words", "line\nbreak")
+	s.Incr(0, "counter")
+	s.FlushAll()
+	close(aofChan)
+	if err := <-errChan; err != nil {
+		t.Fatalf("Unexpected AOF error: %v", err)
 	}
 
-	// Clean up the AOF file
-	os.Remove(aofFilename)
-	os.Remove(newAofFilename)
+	replayChan := make(chan string, 100)
+	newStore := store.NewStore(replayChan)
+	r := &recorder{}
+	err := RebuildStoreFromAOF(newStore, aofFilename, func(dbIndex int, args []string) error {
+		newStore.Set(dbIndex, "replayed", "value")
+		return r.dispatch(dbIndex, args)
+	})
+	if err != nil {
+		t.Fatalf("Failed to rebuild state from AOF: %v", err)
+	}
+	expected := [][]string{{"RPUSH", "list", "two words", "line\nbreak"}, {"INCR", "counter"}, {"FLUSHALL"}}
+	if !slices.EqualFunc(r.commands, expected, slices.Equal) || !slices.Equal(r.dbs, []int{1, 0, 0}) {
+		t.Fatalf("Expected %q on databases [1 0 0], got %q on %v", expected, r.commands, r.dbs)
+	}
+	if len(replayChan) != 0 {
+		t.Fatalf("Expected the replayed writes not to be logged, got %d records", len(replayChan))
+	}
 }
 
-// Test aofRename
-func TestAofRename(t *testing.T) {
-	cmd := "RENAME 0 Key1 newName"
-	parts, s, dbIndex := prepareCmdTest(cmd)
-
-	s.Set(dbIndex, "Key1", "value1")
-
-	aofRename(parts, s, dbIndex)
-	value, ok := s.Get(dbIndex, "newName")
-	valStr := value.Data.(string)
+// Test that records keep their own database and SELECT records are skipped
+func TestRebuildSkipsSelect(t *testing.T) {
+	aofFilename := filepath.Join(t.TempDir(), "appendonly.aof")
+	records := "SET 3 key value\nSELECT 7\nSET 0 other value\n"
+	if err := os.WriteFile(aofFilename, []byte(records), 0644); err != nil {
+		t.Fatalf("Failed to write AOF: %v", err)
+	}
 
-	if !ok || valStr != "value1" {
-		t.Fatalf("Expeted 'value1, got %s", valStr)
+	r := &recorder{}
+	if err := RebuildStoreFromAOF(store.NewStore(nil), aofFilename, r.dispatch); err != nil {
+		t.Fatalf("Failed to rebuild state from AOF: %v", err)
+	}
+	expected := [][]string{{"SET", "key", "value"}, {"SET", "other", "value"}}
+	if !slices.EqualFunc(r.commands, expected, slices.Equal) || !slices.Equal(r.dbs, []int{3, 0}) {
+		t.Fatalf("Expected %q on databases [3 0], got %q on %v", expected, r.commands, r.dbs)
 	}
 }
 
-// Test aofLTrim
-func TestAofLTrim(t *testing.T) {
-	cmd := "LTRIM 0 List1 1 2"
-	parts, s, dbIndex := prepareCmdTest(cmd)
+func TestAOFWriterReportsWriteErrors(t *testing.T) {
+	// Every write to /dev/full fails with ENOSPC
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("/dev/full is not available")
+	}
+	aofChan := make(chan string, 100)
+	errChan := make(chan error, 1)
+	go AOFWriter(aofChan, "/dev/full", errChan)
+
+	s := store.NewStore(aofChan)
+	for i := 0; i < 1000; i++ {
+		s.Set(0, "key", "value")
+	}
+	close(aofChan)
 
-	s.LPush(dbIndex, "List1", "Value1", "Value2", "Value3")
-	aofLTrim(parts, s, dbIndex)
-	list, _ := s.LRange(dbIndex, "List1", 0, -1)
-	expectedList := []string{"Value2", "Value1"}
-	listStr := make([]string, len(list))
-	for i, v := range list {
-		listStr[i] = v.(string)
+	err, ok := <-errChan
+	if !ok || err == nil {
+		t.Fatalf("Expected a write error")
 	}
-	if !slice.Equal(listStr, expectedList) {
-		t.Logf("Expected %v, got %v", expectedList, list)
-		t.Fail()
+	if _, ok := <-errChan; ok {
+		t.Fatalf("Expected the error channel to be closed once the writer stops")
 	}
 }
 
-func prepareCmdTest(cmd string) ([]string, *store.Store, int) {
+// Test that a rewrite replaces the records logged before it, even those
+// still queued when it is swapped in, and keeps those logged after it
+func TestRewriteKeepsLaterRecords(t *testing.T) {
+	aofFilename := filepath.Join(t.TempDir(), "appendonly.aof")
 	aofChan := make(chan string, 100)
 	s := store.NewStore(aofChan)
+	s.Incr(0, "counter")
+	s.Incr(0, "counter")
+	records := s.RewriteRecords()
+	s.Incr(0, "counter")
+
+	errChan := make(chan error, 1)
+	w := NewWriter(aofFilename)
+	go w.Run(aofChan, errChan)
+	if err := w.Rewrite(records, true); err != nil {
+		t.Fatalf("Unexpected rewrite error: %v", err)
+	}
+	s.Incr(0, "counter")
+	close(aofChan)
+	if err := <-errChan; err != nil {
+		t.Fatalf("Unexpected AOF error: %v", err)
+	}
 
-	parts := strings.Split(cmd, " ")
-
-	dbIndex, _ := strconv.Atoi(parts[1])
-	return parts, s, dbIndex
+	r := &recorder{}
+	if err := RebuildStoreFromAOF(store.NewStore(nil), aofFilename, r.dispatch); err != nil {
+		t.Fatalf("Failed to rebuild state from AOF: %v", err)
+	}
+	var names []string
+	for _, command := range r.commands {
+		names = append(names, command[0])
+	}
+	if expected := []string{"RESTORE", "INCR", "INCR"}; !slices.Equal(names, expected) {
+		t.Fatalf("Expected %v, got %q", expected, r.commands)
+	}
+	if _, err := os.Stat(aofFilename + ".rewrite"); !os.IsNotExist(err) {
+		t.Fatalf("Expected the temporary file to be renamed, got %v", err)
+	}
 }
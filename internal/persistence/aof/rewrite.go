@@ -0,0 +1,107 @@
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"os"
+)
+
+// RewriteFsyncChunk is how much of a rewrite is written between two fsyncs
+// when they are incremental
+const RewriteFsyncChunk = 4 << 20
+
+// ErrWriterStopped is returned by Rewrite when Run no longer writes the AOF
+var ErrWriterStopped = errors.New("AOF writer stopped")
+
+// Rewrite replaces the AOF with records, as returned by RewriteRecords of
+// the store logging to Run, followed by the records logged since they were
+// taken. The records are written to a temporary file next to the AOF, which
+// Run completes and renames over it. With incrementalFsync the file is
+// fsynced every RewriteFsyncChunk bytes, so a big rewrite doesn't stall the
+// disk with a single large flush at the end.
+func (w *Writer) Rewrite(records []string, incrementalFsync bool) error {
+	tmpName := w.filename + ".rewrite"
+	err := writeRecords(tmpName, records, incrementalFsync)
+	if err != nil {
+		// Run still has to drop the records it kept for the rewrite
+		os.Remove(tmpName)
+		tmpName = ""
+	}
+
+	done := make(chan error, 1)
+	select {
+	case w.swaps <- swapRequest{tmpName: tmpName, done: done}:
+	case <-w.stopped:
+		if tmpName != "" {
+			os.Remove(tmpName)
+		}
+		return ErrWriterStopped
+	}
+	if swapErr := <-done; err == nil && swapErr != nil {
+		os.Remove(tmpName)
+		err = swapErr
+	}
+	return err
+}
+
+// swap appends the records logged since the rewrite started to the file
+// written at tmpName, and renames it over the AOF
+func (w *Writer) swap(tmpName string, since []string) error {
+	file, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	for _, record := range since {
+		if _, err := writer.WriteString(record); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, w.filename)
+}
+
+// writeRecords writes records to a new file named name and fsyncs it, every
+// RewriteFsyncChunk bytes when incrementalFsync is set and at the end
+func writeRecords(name string, records []string, incrementalFsync bool) error {
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	unsynced := 0
+	for _, record := range records {
+		if _, err := writer.WriteString(record); err != nil {
+			return err
+		}
+		unsynced += len(record)
+		if incrementalFsync && unsynced >= RewriteFsyncChunk {
+			if err := writer.Flush(); err != nil {
+				return err
+			}
+			if err := file.Sync(); err != nil {
+				return err
+			}
+			unsynced = 0
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	return file.Close()
+}
@@ -7,9 +7,22 @@ import (
 	"github.com/andrelcunha/goodiesdb/internal/core/store"
 )
 
-// SaveSnapshot saves the current state of the store to a file
+// The collections are held in the Data interface of a value, so gob needs
+// their concrete types registered to encode them. Lists are []string, which
+// gob knows already.
+func init() {
+	gob.Register(map[string]any{})      // hashes
+	gob.Register(map[string]struct{}{}) // sets
+	gob.Register(map[string]float64{})  // sorted sets
+}
+
+// SaveSnapshot saves the current state of the store to a file. Once the file
+// is written, the writes it holds no longer count as dirty.
 func SaveSnapshot(s *store.Store, filename string) error {
-	data := s.GetSnapshot()
+	data, mark, err := s.GetSnapshot()
+	if err != nil {
+		return err
+	}
 
 	file, err := os.Create(filename)
 	if err != nil {
@@ -26,7 +39,14 @@ func SaveSnapshot(s *store.Store, filename string) error {
 		Data: data,
 	}
 
-	return encoder.Encode(snapshot)
+	if err := encoder.Encode(snapshot); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	s.MarkSaved(mark)
+	return nil
 }
 
 // LoadSnapshot loads the state of the store from a file
@@ -0,0 +1,52 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"strings"
+)
+
+// DefaultInlineMax is the longest inline command accepted, as in Redis
+const DefaultInlineMax = 64 * 1024
+
+// ErrInlineTooBig is returned for an inline command longer than allowed
+var ErrInlineTooBig = errors.New("too big inline request")
+
+// ParseInline reads an inline command, a line of space separated arguments
+// as typed in telnet (e.g. "PING\r\n", or "PING\n" from tools that end lines
+// with a bare newline), and returns it as an array of bulk strings. A blank
+// line is an empty array. A line longer than maxLen bytes is
+// rejected with ErrInlineTooBig as soon as it is exceeded, so a client can't
+// make the server buffer an endless line; zero disables the limit.
+func ParseInline(reader *bufio.Reader, maxLen int) (Array, error) {
+	var line []byte
+	for {
+		// Look at what has arrived so far rather than wait for the end of
+		// the line, which may never come
+		if reader.Buffered() == 0 {
+			if _, err := reader.Peek(1); err != nil {
+				return nil, err
+			}
+		}
+		buffered, _ := reader.Peek(reader.Buffered())
+		end := bytes.IndexByte(buffered, '\n')
+		if end >= 0 {
+			buffered = buffered[:end+1]
+		}
+		line = append(line, buffered...)
+		reader.Discard(len(buffered))
+		if maxLen > 0 && len(line) > maxLen {
+			return nil, ErrInlineTooBig
+		}
+		if end >= 0 {
+			break
+		}
+	}
+	fields := strings.Fields(string(line))
+	request := make(Array, len(fields))
+	for i, field := range fields {
+		request[i] = BulkString(field)
+	}
+	return request, nil
+}
@@ -1,6 +1,12 @@
 package protocol
 
-import "bufio"
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
 
 type Protocol interface {
 	Parse(reader *bufio.Reader) (RESPValue, error)
@@ -8,3 +14,41 @@ type Protocol interface {
 	EncodeNil() RESPValue
 	Version() string
 }
+
+// ErrMissingTerminator is returned when a bulk payload isn't followed by a
+// line terminator
+var ErrMissingTerminator = errors.New("expected a line terminator after the payload")
+
+// ReadLine reads a line and returns it without its terminator, which may be
+// "\r\n" or, as some tools send, a bare "\n"
+func ReadLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\n")
+	return strings.TrimSuffix(line, "\r"), nil
+}
+
+// ReadPayload reads the length bytes of a bulk payload and the "\r\n" or
+// bare "\n" that ends it
+func ReadPayload(reader *bufio.Reader, length int) ([]byte, error) {
+	if length < 0 {
+		return nil, fmt.Errorf("invalid payload length: %d", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, err
+	}
+	b, err := reader.ReadByte()
+	if err == nil && b == '\r' {
+		b, err = reader.ReadByte()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if b != '\n' {
+		return nil, ErrMissingTerminator
+	}
+	return data, nil
+}
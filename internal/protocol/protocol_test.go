@@ -0,0 +1,65 @@
+package protocol_test
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/andrelcunha/goodiesdb/internal/protocol"
+	"github.com/andrelcunha/goodiesdb/internal/protocol/resp2"
+	"github.com/andrelcunha/goodiesdb/internal/protocol/resp3"
+)
+
+func TestParseBareNewlines(t *testing.T) {
+	tests := []struct {
+		input string
+		want  protocol.RESPValue
+	}{
+		{"+OK\n", protocol.SimpleString("OK")},
+		{"+OK\r\n", protocol.SimpleString("OK")},
+		{"+\n", protocol.SimpleString("")},
+		{"-ERR oops\n", protocol.ErrorString("ERR oops")},
+		{":42\n", protocol.Integer(42)},
+	}
+	for _, p := range []protocol.Protocol{&resp2.RESP2Protocol{}, &resp3.RESP3Protocol{}} {
+		for _, test := range tests {
+			value, err := p.Parse(bufio.NewReader(strings.NewReader(test.input)))
+			if err != nil || value != test.want {
+				t.Fatalf("%s: expected %q to parse as %v, got %v (%v)", p.Version(), test.input, test.want, value, err)
+			}
+		}
+
+		// The bulk payload is followed by a bare newline, and the next frame
+		// is read whole
+		reader := bufio.NewReader(strings.NewReader("*2\n$4\nPING\n$3\nfoo\n+next\n"))
+		value, err := p.Parse(reader)
+		array, ok := value.(protocol.Array)
+		if err != nil || !ok || len(array) != 2 || string(array[0].(protocol.BulkString)) != "PING" || string(array[1].(protocol.BulkString)) != "foo" {
+			t.Fatalf("%s: expected [PING foo], got %v (%v)", p.Version(), value, err)
+		}
+		if next, err := p.Parse(reader); err != nil || next != protocol.SimpleString("next") {
+			t.Fatalf("%s: expected the next frame intact, got %v (%v)", p.Version(), next, err)
+		}
+
+		for _, invalid := range []string{"$3\nfooX\n", "$-5\n"} {
+			if _, err := p.Parse(bufio.NewReader(strings.NewReader(invalid))); err == nil {
+				t.Fatalf("%s: expected an error parsing %q", p.Version(), invalid)
+			}
+		}
+	}
+}
+
+func TestParseInlineBareNewlines(t *testing.T) {
+	reader := bufio.NewReader(strings.NewReader("\nSET key value\nPING\r\n"))
+	for _, want := range [][]string{{}, {"SET", "key", "value"}, {"PING"}} {
+		request, err := protocol.ParseInline(reader, protocol.DefaultInlineMax)
+		if err != nil || len(request) != len(want) {
+			t.Fatalf("Expected %v, got %v (%v)", want, request, err)
+		}
+		for i, arg := range want {
+			if string(request[i].(protocol.BulkString)) != arg {
+				t.Fatalf("Expected %v, got %v", want, request)
+			}
+		}
+	}
+}
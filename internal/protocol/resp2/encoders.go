@@ -41,6 +41,10 @@ func (*RESP2Protocol) encodeBulkString(value protocol.BulkString, writer *bufio.
 }
 
 func (r2 *RESP2Protocol) encodeArray(value protocol.Array, writer *bufio.Writer) error {
+	if value == nil { // Null Array
+		_, err := writer.WriteString("*-1\r\n")
+		return err
+	}
 	_, err := writer.WriteString("*" + fmt.Sprintf("%d", len(value)) + "\r\n")
 	if err != nil {
 		return err
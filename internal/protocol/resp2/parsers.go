@@ -8,19 +8,19 @@ import (
 )
 
 func (*RESP2Protocol) parseSimpleString(reader *bufio.Reader) (protocol.SimpleString, error) {
-	line, err := reader.ReadString('\n')
+	line, err := protocol.ReadLine(reader)
 	if err != nil {
 		return "", err
 	}
-	return protocol.SimpleString(line[:len(line)-2]), nil
+	return protocol.SimpleString(line), nil
 }
 
 func (*RESP2Protocol) parseErrorString(reader *bufio.Reader) (protocol.RESPValue, error) {
-	line, err := reader.ReadString('\n')
+	line, err := protocol.ReadLine(reader)
 	if err != nil {
 		return nil, err
 	}
-	return protocol.ErrorString(line[:len(line)-2]), nil
+	return protocol.ErrorString(line), nil
 }
 
 func (*RESP2Protocol) parseInteger(reader *bufio.Reader) (protocol.RESPValue, error) {
@@ -41,12 +41,11 @@ func (*RESP2Protocol) parseBulkString(reader *bufio.Reader) (protocol.RESPValue,
 	if length == -1 {
 		return protocol.BulkString(nil), nil // Null Bulk String
 	}
-	data := make([]byte, length+2)
-	_, err = reader.Read(data)
+	data, err := protocol.ReadPayload(reader, length)
 	if err != nil {
 		return nil, err
 	}
-	return protocol.BulkString(data[:length]), nil
+	return protocol.BulkString(data), nil
 }
 
 func (r2 *RESP2Protocol) parseArray(reader *bufio.Reader) (protocol.RESPValue, error) {
@@ -45,6 +45,8 @@ func (r2 *RESP2Protocol) Encode(writer *bufio.Writer, value protocol.RESPValue)
 		return r2.encodeBulkString(value, writer)
 	case protocol.Array:
 		return r2.encodeArray(value, writer)
+	case protocol.Null: // RESP2 has no null type, use the null bulk string
+		return r2.encodeBulkString(nil, writer)
 	}
 	return fmt.Errorf("encoding for type %T not implemented", value)
 }
@@ -53,6 +55,7 @@ func (r2 *RESP2Protocol) Version() string {
 	return "RESP2"
 }
 
+// EncodeNil returns the null reply, a null bulk string in RESP2
 func (r2 *RESP2Protocol) EncodeNil() protocol.RESPValue {
 	return protocol.BulkString(nil)
 }
@@ -0,0 +1,127 @@
+package resp3
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/andrelcunha/goodiesdb/internal/protocol"
+)
+
+func (*RESP3Protocol) encodeSimpleString(writer *bufio.Writer, value protocol.SimpleString) error {
+	_, err := writer.WriteString("+" + string(value) + "\r\n")
+	return err
+}
+
+func (*RESP3Protocol) encodeErrorString(writer *bufio.Writer, value protocol.ErrorString) error {
+	_, err := writer.WriteString("-" + string(value) + "\r\n")
+	return err
+}
+
+func (*RESP3Protocol) encodeInteger(writer *bufio.Writer, value protocol.Integer) error {
+	_, err := writer.WriteString(":" + fmt.Sprintf("%d", value) + "\r\n")
+	return err
+}
+
+func (r3 *RESP3Protocol) encodeBulkString(value protocol.BulkString, writer *bufio.Writer) error {
+	bs := value
+	if bs == nil {
+		return r3.encodeNull(writer)
+	}
+	_, err := writer.WriteString("$" + fmt.Sprintf("%d", len(bs)) + "\r\n")
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(bs)
+	if err != nil {
+		return err
+	}
+	_, err = writer.WriteString("\r\n")
+	return err
+}
+
+// encodeDouble encodes a double with the shortest representation that parses
+// back to the same value, and inf, -inf or nan for the special values
+func (*RESP3Protocol) encodeDouble(writer *bufio.Writer, value protocol.Double) error {
+	f := float64(value)
+	var s string
+	switch {
+	case math.IsInf(f, 1):
+		s = "inf"
+	case math.IsInf(f, -1):
+		s = "-inf"
+	case math.IsNaN(f):
+		s = "nan"
+	default:
+		s = strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	_, err := writer.WriteString("," + s + "\r\n")
+	return err
+}
+
+// encodeBigNumber encodes an integer too large for the integer type. The
+// digits are written as they are, see parseBigNumber for the accepted form.
+func (*RESP3Protocol) encodeBigNumber(writer *bufio.Writer, value protocol.BigNumber) error {
+	if !validBigNumber(string(value)) {
+		return fmt.Errorf("invalid big number: %q", value)
+	}
+	_, err := writer.WriteString("(" + string(value) + "\r\n")
+	return err
+}
+
+// encodeVerbatimString encodes the text as a bulk string prefixed with its
+// format and a colon, both counted in the length
+func (*RESP3Protocol) encodeVerbatimString(writer *bufio.Writer, value protocol.VerbatimString) error {
+	if len(value.Format) != 3 {
+		return fmt.Errorf("invalid verbatim string format: %q", value.Format)
+	}
+	payload := value.Format + ":" + value.Text
+	_, err := writer.WriteString("=" + strconv.Itoa(len(payload)) + "\r\n" + payload + "\r\n")
+	return err
+}
+
+func (*RESP3Protocol) encodeNull(writer *bufio.Writer) error {
+	_, err := writer.WriteString("_\r\n")
+	return err
+}
+
+// encodeAggregate encodes arrays and pushes, which only differ by their prefix
+func (r3 *RESP3Protocol) encodeAggregate(prefix byte, value protocol.Array, writer *bufio.Writer) error {
+	_, err := writer.WriteString(string(prefix) + fmt.Sprintf("%d", len(value)) + "\r\n")
+	if err != nil {
+		return err
+	}
+	for _, item := range value {
+		err := r3.Encode(writer, item)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeMap encodes a map with its entries ordered by key so replies are stable
+func (r3 *RESP3Protocol) encodeMap(value protocol.Map, writer *bufio.Writer) error {
+	_, err := writer.WriteString("%" + fmt.Sprintf("%d", len(value)) + "\r\n")
+	if err != nil {
+		return err
+	}
+	keys := make([]protocol.RESPValue, 0, len(value))
+	for key := range value {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+	})
+	for _, key := range keys {
+		if err := r3.Encode(writer, key); err != nil {
+			return err
+		}
+		if err := r3.Encode(writer, value[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
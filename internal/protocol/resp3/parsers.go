@@ -0,0 +1,186 @@
+package resp3
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/andrelcunha/goodiesdb/internal/protocol"
+)
+
+func (*RESP3Protocol) parseSimpleString(reader *bufio.Reader) (protocol.SimpleString, error) {
+	line, err := protocol.ReadLine(reader)
+	if err != nil {
+		return "", err
+	}
+	return protocol.SimpleString(line), nil
+}
+
+func (*RESP3Protocol) parseErrorString(reader *bufio.Reader) (protocol.RESPValue, error) {
+	line, err := protocol.ReadLine(reader)
+	if err != nil {
+		return nil, err
+	}
+	return protocol.ErrorString(line), nil
+}
+
+func (*RESP3Protocol) parseInteger(reader *bufio.Reader) (protocol.RESPValue, error) {
+	var value int64
+	_, err := fmt.Fscanf(reader, "%d\r\n", &value)
+	if err != nil {
+		return nil, err
+	}
+	return protocol.Integer(value), nil
+}
+
+func (*RESP3Protocol) parseBulkString(reader *bufio.Reader) (protocol.RESPValue, error) {
+	var length int
+	_, err := fmt.Fscanf(reader, "%d\r\n", &length)
+	if err != nil {
+		return nil, err
+	}
+	if length == -1 {
+		return protocol.BulkString(nil), nil // RESP2 style null, still accepted
+	}
+	data, err := protocol.ReadPayload(reader, length)
+	if err != nil {
+		return nil, err
+	}
+	return protocol.BulkString(data), nil
+}
+
+func (*RESP3Protocol) parseDouble(reader *bufio.Reader) (protocol.RESPValue, error) {
+	line, err := protocol.ReadLine(reader)
+	if err != nil {
+		return nil, err
+	}
+	// ParseFloat accepts inf, -inf and nan as Redis sends them
+	value, err := strconv.ParseFloat(line, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid double: %w", err)
+	}
+	return protocol.Double(value), nil
+}
+
+// parseBigNumber reads an integer of any size: an optional minus sign
+// followed by decimal digits
+func (*RESP3Protocol) parseBigNumber(reader *bufio.Reader) (protocol.RESPValue, error) {
+	value, err := protocol.ReadLine(reader)
+	if err != nil {
+		return nil, err
+	}
+	if !validBigNumber(value) {
+		return nil, fmt.Errorf("invalid big number: %q", value)
+	}
+	return protocol.BigNumber(value), nil
+}
+
+// validBigNumber reports whether s is an optional minus sign followed by at
+// least one decimal digit
+func validBigNumber(s string) bool {
+	s = strings.TrimPrefix(s, "-")
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// parseVerbatimString reads a bulk string whose first four bytes are the
+// format and a colon
+func (*RESP3Protocol) parseVerbatimString(reader *bufio.Reader) (protocol.RESPValue, error) {
+	var length int
+	if _, err := fmt.Fscanf(reader, "%d\r\n", &length); err != nil {
+		return nil, err
+	}
+	if length < 4 {
+		return nil, fmt.Errorf("invalid verbatim string length: %d", length)
+	}
+	data, err := protocol.ReadPayload(reader, length)
+	if err != nil {
+		return nil, err
+	}
+	if data[3] != ':' {
+		return nil, fmt.Errorf("invalid verbatim string format: %q", data[:4])
+	}
+	return protocol.VerbatimString{Format: string(data[:3]), Text: string(data[4:])}, nil
+}
+
+func (*RESP3Protocol) parseNull(reader *bufio.Reader) (protocol.RESPValue, error) {
+	if _, err := protocol.ReadLine(reader); err != nil {
+		return nil, err
+	}
+	return protocol.Null{}, nil
+}
+
+// parseElements reads the count and then the elements of an aggregate type
+func (r3 *RESP3Protocol) parseElements(reader *bufio.Reader, perCount int) ([]protocol.RESPValue, error) {
+	var count int
+	_, err := fmt.Fscanf(reader, "%d\r\n", &count)
+	if err != nil {
+		return nil, err
+	}
+	if count == -1 {
+		return nil, nil // RESP2 style null array
+	}
+	elements := make([]protocol.RESPValue, count*perCount)
+	for i := range elements {
+		value, err := r3.Parse(reader)
+		if err != nil {
+			return nil, err
+		}
+		elements[i] = value
+	}
+	return elements, nil
+}
+
+func (r3 *RESP3Protocol) parseArray(reader *bufio.Reader) (protocol.RESPValue, error) {
+	elements, err := r3.parseElements(reader, 1)
+	if err != nil {
+		return nil, err
+	}
+	return protocol.Array(elements), nil
+}
+
+func (r3 *RESP3Protocol) parsePush(reader *bufio.Reader) (protocol.RESPValue, error) {
+	elements, err := r3.parseElements(reader, 1)
+	if err != nil {
+		return nil, err
+	}
+	return protocol.Push(elements), nil
+}
+
+func (r3 *RESP3Protocol) parseSet(reader *bufio.Reader) (protocol.RESPValue, error) {
+	elements, err := r3.parseElements(reader, 1)
+	if err != nil {
+		return nil, err
+	}
+	return protocol.Set(elements), nil
+}
+
+// parseMap reads a map. Bulk string keys are turned into BulkKeys since byte
+// slices can't be map keys.
+func (r3 *RESP3Protocol) parseMap(reader *bufio.Reader) (protocol.RESPValue, error) {
+	elements, err := r3.parseElements(reader, 2)
+	if err != nil {
+		return nil, err
+	}
+	m := make(protocol.Map, len(elements)/2)
+	for i := 0; i < len(elements); i += 2 {
+		key := elements[i]
+		if bulk, ok := key.(protocol.BulkString); ok {
+			key = protocol.BulkKey(bulk)
+		}
+		switch key.(type) {
+		case protocol.Array, protocol.Push, protocol.Map:
+			return nil, fmt.Errorf("unsupported RESP3 map key type %T", key)
+		}
+		m[key] = elements[i+1]
+	}
+	return m, nil
+}
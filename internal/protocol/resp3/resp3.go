@@ -0,0 +1,92 @@
+package resp3
+
+import (
+	"bufio"
+	"fmt"
+
+	"github.com/andrelcunha/goodiesdb/internal/protocol"
+)
+
+// Implement the protocol.Protocol interface for RESP3 here
+
+type RESP3Protocol struct{}
+
+func (r3 *RESP3Protocol) Parse(reader *bufio.Reader) (protocol.RESPValue, error) {
+	prefix, err := reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch prefix {
+	case '+': // Simple String
+		return r3.parseSimpleString(reader)
+	case '-': // Simple Error
+		return r3.parseErrorString(reader)
+	case ':': // Integer
+		return r3.parseInteger(reader)
+	case '$': // Bulk String
+		return r3.parseBulkString(reader)
+	case '*': // Array
+		return r3.parseArray(reader)
+	case '_': // Null
+		return r3.parseNull(reader)
+	case ',': // Double
+		return r3.parseDouble(reader)
+	case '(': // Big Number
+		return r3.parseBigNumber(reader)
+	case '=': // Verbatim String
+		return r3.parseVerbatimString(reader)
+	case '%': // Map
+		return r3.parseMap(reader)
+	case '~': // Set
+		return r3.parseSet(reader)
+	case '>': // Push
+		return r3.parsePush(reader)
+	default:
+		return nil, fmt.Errorf("unknown RESP3 prefix: %c", prefix)
+	}
+}
+
+func (r3 *RESP3Protocol) Encode(writer *bufio.Writer, value protocol.RESPValue) error {
+	switch value := value.(type) {
+	case protocol.SimpleString:
+		return r3.encodeSimpleString(writer, value)
+	case protocol.ErrorString:
+		return r3.encodeErrorString(writer, value)
+	case protocol.Integer:
+		return r3.encodeInteger(writer, value)
+	case protocol.BulkString:
+		return r3.encodeBulkString(value, writer)
+	case protocol.BulkKey:
+		return r3.encodeBulkString(protocol.BulkString(value), writer)
+	case protocol.Array:
+		if value == nil {
+			return r3.encodeNull(writer)
+		}
+		return r3.encodeAggregate('*', value, writer)
+	case protocol.Push:
+		return r3.encodeAggregate('>', protocol.Array(value), writer)
+	case protocol.Set:
+		return r3.encodeAggregate('~', protocol.Array(value), writer)
+	case protocol.Map:
+		return r3.encodeMap(value, writer)
+	case protocol.Null:
+		return r3.encodeNull(writer)
+	case protocol.Double:
+		return r3.encodeDouble(writer, value)
+	case protocol.BigNumber:
+		return r3.encodeBigNumber(writer, value)
+	case protocol.VerbatimString:
+		return r3.encodeVerbatimString(writer, value)
+	}
+	return fmt.Errorf("encoding for type %T not implemented", value)
+}
+
+func (r3 *RESP3Protocol) Version() string {
+	return "RESP3"
+}
+
+// EncodeNil returns the null reply, RESP3's dedicated null type
+func (r3 *RESP3Protocol) EncodeNil() protocol.RESPValue {
+	return protocol.Null{}
+}
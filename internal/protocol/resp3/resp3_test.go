@@ -0,0 +1,78 @@
+package resp3
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/andrelcunha/goodiesdb/internal/protocol"
+)
+
+// roundTrip encodes value and checks the wire form before parsing it back
+func roundTrip(t *testing.T, value protocol.RESPValue, wire string) protocol.RESPValue {
+	t.Helper()
+	r3 := &RESP3Protocol{}
+	var buf bytes.Buffer
+	writer := bufio.NewWriter(&buf)
+	if err := r3.Encode(writer, value); err != nil {
+		t.Fatalf("Unexpected error encoding %v: %v", value, err)
+	}
+	writer.Flush()
+	if buf.String() != wire {
+		t.Fatalf("Expected %q, got %q", wire, buf.String())
+	}
+	parsed, err := r3.Parse(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatalf("Unexpected error parsing %q: %v", wire, err)
+	}
+	return parsed
+}
+
+func TestBigNumberRoundTrip(t *testing.T) {
+	for _, digits := range []string{"3492890328409238509324850943850943825024385", "-12345678901234567890123", "0"} {
+		if parsed := roundTrip(t, protocol.BigNumber(digits), "("+digits+"\r\n"); parsed != protocol.BigNumber(digits) {
+			t.Fatalf("Expected %s, got %v", digits, parsed)
+		}
+	}
+
+	r3 := &RESP3Protocol{}
+	for _, invalid := range []string{"(\r\n", "(-\r\n", "(12a\r\n", "(1.5\r\n"} {
+		if _, err := r3.Parse(bufio.NewReader(strings.NewReader(invalid))); err == nil {
+			t.Fatalf("Expected an error parsing %q", invalid)
+		}
+	}
+	if err := r3.Encode(bufio.NewWriter(&bytes.Buffer{}), protocol.BigNumber("1e3")); err == nil {
+		t.Fatalf("Expected an error encoding an invalid big number")
+	}
+}
+
+func TestVerbatimStringRoundTrip(t *testing.T) {
+	value := protocol.VerbatimString{Format: "txt", Text: "Some string\r\nwith lines"}
+	if parsed := roundTrip(t, value, "=27\r\ntxt:Some string\r\nwith lines\r\n"); parsed != value {
+		t.Fatalf("Expected %v, got %v", value, parsed)
+	}
+	empty := protocol.VerbatimString{Format: "mkd"}
+	if parsed := roundTrip(t, empty, "=4\r\nmkd:\r\n"); parsed != empty {
+		t.Fatalf("Expected %v, got %v", empty, parsed)
+	}
+
+	r3 := &RESP3Protocol{}
+	for _, invalid := range []string{"=3\r\ntxt\r\n", "=5\r\ntxt-a\r\n"} {
+		if _, err := r3.Parse(bufio.NewReader(strings.NewReader(invalid))); err == nil {
+			t.Fatalf("Expected an error parsing %q", invalid)
+		}
+	}
+	if err := r3.Encode(bufio.NewWriter(&bytes.Buffer{}), protocol.VerbatimString{Format: "text"}); err == nil {
+		t.Fatalf("Expected an error encoding a four character format")
+	}
+}
+
+func TestSetRoundTrip(t *testing.T) {
+	value := protocol.Set{protocol.BulkString("a"), protocol.Integer(1)}
+	parsed := roundTrip(t, value, "~2\r\n$1\r\na\r\n:1\r\n")
+	set, ok := parsed.(protocol.Set)
+	if !ok || len(set) != 2 || string(set[0].(protocol.BulkString)) != "a" || set[1] != protocol.Integer(1) {
+		t.Fatalf("Expected %v, got %v", value, parsed)
+	}
+}
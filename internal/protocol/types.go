@@ -11,9 +11,21 @@ type Array []RESPValue
 
 // RESP3 types
 type Map map[RESPValue]RESPValue
+
+// BulkKey is a bulk string that can be used as a Map key, which BulkString,
+// being a byte slice, can't. It is encoded as a bulk string.
+type BulkKey string
 type Set []RESPValue
 type Boolean bool
 type Double float64
 type BigNumber string
+
+// VerbatimString is preformatted text, such as INFO's, with the three
+// character Format telling how to display it: "txt" for plain text or "mkd"
+// for markdown
+type VerbatimString struct {
+	Format string
+	Text   string
+}
 type Null struct{}
 type Push []RESPValue
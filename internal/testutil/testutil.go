@@ -0,0 +1,97 @@
+// Package testutil runs a real server for end-to-end tests and provides
+// helpers to check its replies
+package testutil
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/andrelcunha/goodiesdb/internal/core/server"
+	"github.com/andrelcunha/goodiesdb/pkg/client"
+)
+
+// StartServer starts a server on an ephemeral port with a temporary data
+// directory and returns it with a connected client. Persistence is off unless
+// configure turns it on. Both are closed when the test ends.
+func StartServer(t *testing.T, configure ...func(*server.Config)) (*server.Server, *client.Client) {
+	t.Helper()
+	config := server.NewConfig()
+	config.Host = "127.0.0.1"
+	config.DataDir = t.TempDir()
+	config.UseRDB = false
+	config.UseAOF = false
+	for _, fn := range configure {
+		fn(config)
+	}
+
+	ln, err := net.Listen("tcp", config.Host+":0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	srv := server.NewServer(config)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.Serve(ln)
+	}()
+
+	c, err := client.Dial(ln.Addr().String())
+	if err != nil {
+		srv.Shutdown()
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+		srv.Shutdown()
+		<-done
+	})
+	return srv, c
+}
+
+// Do runs a command and fails the test on an error, including error replies
+func Do(t *testing.T, c *client.Client, args ...string) any {
+	t.Helper()
+	reply, err := c.Do(args...)
+	if err != nil {
+		t.Fatalf("%s: %v", strings.Join(args, " "), err)
+	}
+	return reply
+}
+
+// AssertReply runs a command and checks its reply against want, using the
+// types client.Do returns
+func AssertReply(t *testing.T, c *client.Client, want any, args ...string) {
+	t.Helper()
+	if got := Do(t, c, args...); !reflect.DeepEqual(got, want) {
+		t.Fatalf("%s: expected %#v, got %#v", strings.Join(args, " "), want, got)
+	}
+}
+
+// AssertOK runs a command and checks that it replies OK
+func AssertOK(t *testing.T, c *client.Client, args ...string) {
+	t.Helper()
+	AssertReply(t, c, "OK", args...)
+}
+
+// AssertNil runs a command and checks that it replies with a null
+func AssertNil(t *testing.T, c *client.Client, args ...string) {
+	t.Helper()
+	AssertReply(t, c, nil, args...)
+}
+
+// AssertError runs a command and checks that it replies with an error
+// starting with prefix, e.g. "WRONGTYPE"
+func AssertError(t *testing.T, c *client.Client, prefix string, args ...string) {
+	t.Helper()
+	reply, err := c.Do(args...)
+	var replyErr client.Error
+	if !errors.As(err, &replyErr) {
+		t.Fatalf("%s: expected an error reply, got %#v (err %v)", strings.Join(args, " "), reply, err)
+	}
+	if !strings.HasPrefix(string(replyErr), prefix) {
+		t.Fatalf("%s: expected an error starting with %q, got %q", strings.Join(args, " "), prefix, replyErr)
+	}
+}
@@ -0,0 +1,294 @@
+package testutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andrelcunha/goodiesdb/internal/core/server"
+	"github.com/andrelcunha/goodiesdb/pkg/client"
+)
+
+func TestSetGet(t *testing.T) {
+	_, c := StartServer(t)
+
+	AssertNil(t, c, "GET", "key")
+	AssertOK(t, c, "SET", "key", "value")
+	AssertReply(t, c, "value", "GET", "key")
+	AssertError(t, c, "ERR", "INCR", "key")
+}
+
+func TestExpireTTL(t *testing.T) {
+	_, c := StartServer(t)
+
+	AssertReply(t, c, int64(-2), "TTL", "key")
+	AssertOK(t, c, "SET", "key", "value")
+	AssertReply(t, c, int64(-1), "TTL", "key")
+	AssertReply(t, c, int64(1), "EXPIRE", "key", "100")
+	if ttl := Do(t, c, "TTL", "key").(int64); ttl < 99 || ttl > 100 {
+		t.Fatalf("Expected a TTL close to 100, got %d", ttl)
+	}
+}
+
+// Test that expired keys are deleted in the background, without being read,
+// unless active expiry is turned off
+func TestActiveExpire(t *testing.T) {
+	expiredKeys := func(c *client.Client) string {
+		info := Do(t, c, "INFO", "stats").(string)
+		return regexp.MustCompile(`expired_keys:(\d+)`).FindStringSubmatch(info)[1]
+	}
+
+	_, c := StartServer(t)
+	AssertOK(t, c, "SET", "key", "value", "PX", "10")
+	deadline := time.Now().Add(5 * time.Second)
+	for expiredKeys(c) != "1" {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the key to be expired in the background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, c = StartServer(t, func(config *server.Config) { config.ActiveExpire = false })
+	AssertOK(t, c, "SET", "key", "value", "PX", "10")
+	time.Sleep(300 * time.Millisecond)
+	if n := expiredKeys(c); n != "0" {
+		t.Fatalf("Expected no key expired in the background with active expiry off, got %s", n)
+	}
+}
+
+func TestConfigGetReportsBoundAddress(t *testing.T) {
+	srv, c := StartServer(t, func(config *server.Config) {
+		config.Port = "0"
+		config.DataDir = "."
+	})
+
+	reply := Do(t, c, "CONFIG", "GET", "port", "bind", "dir").([]any)
+	got := map[string]any{}
+	for i := 0; i+1 < len(reply); i += 2 {
+		got[reply[i].(string)] = reply[i+1]
+	}
+	_, port, _ := net.SplitHostPort(srv.Addr().String())
+	if got["port"] != port || port == "0" {
+		t.Fatalf("Expected the bound port %s, got %v", port, got["port"])
+	}
+	if got["bind"] != "127.0.0.1" {
+		t.Fatalf("Expected to be bound to 127.0.0.1, got %v", got["bind"])
+	}
+	if dir, _ := got["dir"].(string); !filepath.IsAbs(dir) {
+		t.Fatalf("Expected an absolute data directory, got %v", got["dir"])
+	}
+	AssertReply(t, c, []any{"maxmemory", "0", "maxmemory-clients", "0", "maxmemory-policy", "noeviction"}, "CONFIG", "GET", "maxmemory*")
+	AssertReply(t, c, []any{}, "CONFIG", "GET", "missing")
+}
+
+func TestPool(t *testing.T) {
+	srv, c := StartServer(t)
+	pool := client.NewPool(srv.Addr().String(), 4, time.Minute)
+	defer pool.Close()
+
+	const workers, rounds = 32, 50
+	var checkedOut, maxCheckedOut atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				conn, err := pool.Get(context.Background())
+				if err != nil {
+					t.Errorf("Get: %v", err)
+					return
+				}
+				n := checkedOut.Add(1)
+				for m := maxCheckedOut.Load(); n > m && !maxCheckedOut.CompareAndSwap(m, n); m = maxCheckedOut.Load() {
+				}
+
+				key, value := fmt.Sprintf("key:%d", w), fmt.Sprint(i)
+				conn.Do("INCR", "counter")
+				conn.Do("SET", key, value)
+				if reply, err := conn.Do("GET", key); reply != value {
+					t.Errorf("Expected GET %s to return %s, got %v (%v)", key, value, reply, err)
+				}
+				checkedOut.Add(-1)
+				pool.Put(conn)
+			}
+		}()
+	}
+	wg.Wait()
+
+	AssertReply(t, c, fmt.Sprint(workers*rounds), "GET", "counter")
+	if n := maxCheckedOut.Load(); n > 4 {
+		t.Fatalf("Expected at most 4 connections checked out at once, got %d", n)
+	}
+	// The pool's connections and the test's own
+	var clients int
+	info := Do(t, c, "INFO", "server").(string)
+	if match := regexp.MustCompile(`connected_clients:(\d+)`).FindStringSubmatch(info); match != nil {
+		clients, _ = strconv.Atoi(match[1])
+	}
+	if clients < 2 || clients > 5 {
+		t.Fatalf("Expected between 2 and 5 connected clients, got %d", clients)
+	}
+}
+
+func TestPoolReplacesBrokenConnections(t *testing.T) {
+	srv, _ := StartServer(t)
+	pool := client.NewPool(srv.Addr().String(), 1, 0)
+	defer pool.Close()
+	ctx := context.Background()
+
+	// A connection that failed a command is discarded on Put
+	conn, _ := pool.Get(ctx)
+	conn.Close()
+	if _, err := conn.Do("PING"); err == nil {
+		t.Fatalf("Expected PING on a closed connection to fail")
+	}
+	pool.Put(conn)
+	conn, err := pool.Get(ctx)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if reply, err := conn.Do("PING"); reply != "PONG" {
+		t.Fatalf("Expected a working connection, got %v (%v)", reply, err)
+	}
+
+	// One that broke while idle fails the health check on Get
+	conn.Close()
+	pool.Put(conn)
+	conn, err = pool.Get(ctx)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if reply, err := conn.Do("PING"); reply != "PONG" {
+		t.Fatalf("Expected a working connection, got %v (%v)", reply, err)
+	}
+
+	// With every connection checked out, Get waits for ctx
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := pool.Get(timeout); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected Get to wait until the deadline, got %v", err)
+	}
+	pool.Put(conn)
+
+	pool.Close()
+	if _, err := pool.Get(ctx); !errors.Is(err, client.ErrPoolClosed) {
+		t.Fatalf("Expected ErrPoolClosed, got %v", err)
+	}
+}
+
+func TestScanIterator(t *testing.T) {
+	srv, c := StartServer(t)
+	const keys = 500
+	for i := 0; i < keys; i++ {
+		AssertOK(t, c, "SET", fmt.Sprintf("key:%d", i), "value")
+	}
+	AssertOK(t, c, "SET", "other", "value")
+
+	visited := make(map[string]int)
+	it := c.ScanIterator("key:*", 17)
+	for it.Next() {
+		visited[it.Key()]++
+		if it.Value() != "" {
+			t.Fatalf("Expected SCAN items to have no value, got %q", it.Value())
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(visited) != keys {
+		t.Fatalf("Expected %d keys to be visited, got %d", keys, len(visited))
+	}
+	for key, n := range visited {
+		if n != 1 {
+			t.Fatalf("Expected %s to be visited once, got %d", key, n)
+		}
+	}
+
+	// Without a pattern or COUNT every key is visited
+	n := 0
+	for it := c.ScanIterator("", 0); it.Next(); {
+		n++
+	}
+	if n != keys+1 {
+		t.Fatalf("Expected %d keys, got %d", keys+1, n)
+	}
+
+	// Errors end the iteration
+	closed, err := client.Dial(srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	closed.Close()
+	it = closed.ScanIterator("", 0)
+	if it.Next() || it.Err() == nil {
+		t.Fatalf("Expected the iteration to fail on a closed connection, got %v", it.Err())
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	srv, publisher := StartServer(t)
+	receive := func(ps *client.PubSub) client.Message {
+		t.Helper()
+		select {
+		case msg := <-ps.Channel():
+			return msg
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected a message")
+			return client.Message{}
+		}
+	}
+
+	for _, proto := range []string{"2", "3"} {
+		conn, err := client.Dial(srv.Addr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		Do(t, conn, "HELLO", proto)
+		ps, err := conn.Subscribe("news")
+		if err != nil {
+			t.Fatalf("RESP%s: Subscribe: %v", proto, err)
+		}
+		if _, err := conn.Do("PING"); err != client.ErrSubscribed {
+			t.Fatalf("RESP%s: expected the subscribed client to refuse commands, got %v", proto, err)
+		}
+
+		AssertReply(t, publisher, int64(1), "PUBLISH", "news", "hello")
+		if msg := receive(ps); msg != (client.Message{Channel: "news", Payload: "hello"}) {
+			t.Fatalf("RESP%s: expected the published message, got %+v", proto, msg)
+		}
+
+		// The pattern is confirmed in the background, so publish until
+		// it receives too
+		if err := ps.PSubscribe("n*"); err != nil {
+			t.Fatalf("RESP%s: PSubscribe: %v", proto, err)
+		}
+		for Do(t, publisher, "PUBLISH", "news", "again") != int64(2) {
+			receive(ps)
+		}
+		msg := receive(ps)
+		for msg.Pattern == "" {
+			msg = receive(ps)
+		}
+		if msg != (client.Message{Pattern: "n*", Channel: "news", Payload: "again"}) {
+			t.Fatalf("RESP%s: expected the message through the pattern, got %+v", proto, msg)
+		}
+
+		if err := ps.Close(); err != nil {
+			t.Fatalf("RESP%s: Close: %v", proto, err)
+		}
+		for range ps.Channel() {
+		}
+		if err := ps.Err(); err != nil {
+			t.Fatalf("RESP%s: expected no error after Close, got %v", proto, err)
+		}
+	}
+}
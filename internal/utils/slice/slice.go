@@ -13,7 +13,7 @@ func Equal(a, b []string) bool {
 	return true
 }
 
-func Reverse(slice []any) {
+func Reverse[T any](slice []T) {
 	for i, j := 0, len(slice)-1; i < j; i, j = i+1, j-1 {
 		slice[i], slice[j] = slice[j], slice[i]
 	}
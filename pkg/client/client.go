@@ -0,0 +1,164 @@
+// Package client is a minimal GoodiesDB client. It sends commands as RESP
+// arrays of bulk strings and converts the replies to plain Go values.
+package client
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/andrelcunha/goodiesdb/internal/protocol"
+	"github.com/andrelcunha/goodiesdb/internal/protocol/resp2"
+	"github.com/andrelcunha/goodiesdb/internal/protocol/resp3"
+)
+
+// Error is an error reply sent by the server, e.g. "ERR syntax error"
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+// Client is a connection to a server. It is safe for concurrent use, but
+// commands are sent one at a time.
+type Client struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+	// broken is set once a command fails to be sent or its reply to be
+	// read, as the connection may be out of step with the server
+	broken bool
+	// subscribed is set once a PubSub owns the connection
+	subscribed bool
+}
+
+// Dial connects to the server listening on addr
+func Dial(addr string) (*Client, error) {
+	return dialContext(context.Background(), addr)
+}
+
+func dialContext(ctx context.Context, addr string) (*Client, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, reader: bufio.NewReader(conn), writer: bufio.NewWriter(conn)}, nil
+}
+
+// Do sends a command and returns its reply. Simple, bulk and verbatim strings
+// are returned as string, integers as int64, doubles as float64, big numbers
+// as their decimal string, arrays, sets and pushes as []any, maps as
+// map[string]any and nulls as nil. An error reply is returned as an Error.
+func (c *Client) Do(args ...string) (any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.subscribed {
+		return nil, ErrSubscribed
+	}
+	if err := c.send(args); err != nil {
+		return nil, err
+	}
+	reply, err := c.receive()
+	if err != nil {
+		c.broken = true
+		return nil, err
+	}
+	if e, ok := reply.(protocol.ErrorString); ok {
+		return nil, Error(e)
+	}
+	return convert(reply), nil
+}
+
+// send writes a command to the server. The caller holds c.mu.
+func (c *Client) send(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("client: empty command")
+	}
+	command := make(protocol.Array, len(args))
+	for i, arg := range args {
+		command[i] = protocol.BulkString(arg)
+	}
+	if err := (&resp2.RESP2Protocol{}).Encode(c.writer, command); err != nil {
+		c.broken = true
+		return err
+	}
+	if err := c.writer.Flush(); err != nil {
+		c.broken = true
+		return err
+	}
+	return nil
+}
+
+// receive reads the next frame sent by the server. Only one goroutine may
+// read at a time: the caller of Do, holding c.mu, or the PubSub receiver.
+func (c *Client) receive() (protocol.RESPValue, error) {
+	// The RESP3 parser reads RESP2 replies too
+	return (&resp3.RESP3Protocol{}).Parse(c.reader)
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// isBroken reports whether a command failed on the connection, or it was
+// handed to a PubSub, so it can't run commands anymore
+func (c *Client) isBroken() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.broken || c.subscribed
+}
+
+// convert turns a parsed reply into plain Go values
+func convert(value protocol.RESPValue) any {
+	switch v := value.(type) {
+	case protocol.SimpleString:
+		return string(v)
+	case protocol.BulkString:
+		if v == nil {
+			return nil
+		}
+		return string(v)
+	case protocol.BulkKey:
+		return string(v)
+	case protocol.Integer:
+		return int64(v)
+	case protocol.Double:
+		return float64(v)
+	case protocol.BigNumber:
+		return string(v)
+	case protocol.VerbatimString:
+		return v.Text
+	case protocol.ErrorString:
+		return Error(v)
+	case protocol.Array:
+		if v == nil {
+			return nil
+		}
+		return convertAll(v)
+	case protocol.Push:
+		return convertAll(v)
+	case protocol.Set:
+		return convertAll(v)
+	case protocol.Map:
+		m := make(map[string]any, len(v))
+		for key, val := range v {
+			m[fmt.Sprint(convert(key))] = convert(val)
+		}
+		return m
+	case protocol.Null:
+		return nil
+	default:
+		return value
+	}
+}
+
+func convertAll(values []protocol.RESPValue) []any {
+	out := make([]any, len(values))
+	for i, v := range values {
+		out[i] = convert(v)
+	}
+	return out
+}
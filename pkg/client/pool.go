@@ -0,0 +1,121 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrPoolClosed is returned by Get once the pool is closed
+var ErrPoolClosed = errors.New("client: pool closed")
+
+// Pool is a bounded set of reusable connections to a server. Get checks a
+// connection out and Put returns it, so concurrent callers share at most
+// maxSize connections instead of opening one per request. It is safe for
+// concurrent use.
+type Pool struct {
+	addr      string
+	idleCheck time.Duration
+	// slots holds a token for every connection checked out, bounding them
+	// to its capacity. Idle connections are only dialed by Get, so the
+	// connections open never exceed it either.
+	slots chan struct{}
+
+	mu     sync.Mutex
+	idle   []idleClient // the most recently returned last
+	closed bool
+}
+
+// idleClient is a connection waiting in the pool, with the time it was
+// returned
+type idleClient struct {
+	client *Client
+	since  time.Time
+}
+
+// NewPool creates a pool of at most maxSize connections to the server
+// listening on addr. Connections that stayed idle for idleCheck or longer
+// are checked with PING when they are checked out. No connection is opened
+// until one is needed.
+func NewPool(addr string, maxSize int, idleCheck time.Duration) *Pool {
+	return &Pool{
+		addr:      addr,
+		idleCheck: idleCheck,
+		slots:     make(chan struct{}, max(maxSize, 1)),
+	}
+}
+
+// Get checks a connection out of the pool, reusing an idle one when there
+// is one and dialing otherwise. When maxSize connections are checked out it
+// waits for one to be returned, or for ctx to be done. The connection must
+// be given back with Put.
+func (p *Pool) Get(ctx context.Context) (*Client, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			<-p.slots
+			return nil, ErrPoolClosed
+		}
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		idle := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+
+		if time.Since(idle.since) < p.idleCheck {
+			return idle.client, nil
+		}
+		// The server may have dropped the connection while it was idle
+		if _, err := idle.client.Do("PING"); err == nil {
+			return idle.client, nil
+		}
+		idle.client.Close()
+	}
+
+	c, err := dialContext(ctx, p.addr)
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return c, nil
+}
+
+// Put returns a connection checked out with Get. Broken connections, those
+// whose last command failed to reach the server or to read its reply, are
+// closed instead of being reused.
+func (p *Pool) Put(c *Client) {
+	defer func() { <-p.slots }()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || c.isBroken() {
+		c.Close()
+		return
+	}
+	p.idle = append(p.idle, idleClient{client: c, since: time.Now()})
+}
+
+// Close closes the idle connections and makes Get fail. Connections still
+// checked out are closed when they are returned.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	var errs []error
+	for _, idle := range p.idle {
+		errs = append(errs, idle.client.Close())
+	}
+	p.idle = nil
+	return errors.Join(errs...)
+}
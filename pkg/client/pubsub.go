@@ -0,0 +1,198 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/andrelcunha/goodiesdb/internal/protocol"
+)
+
+// ErrSubscribed is returned by Do once a PubSub owns the connection
+var ErrSubscribed = errors.New("client: connection is used by a PubSub")
+
+// Message is a message published on a channel a PubSub is subscribed to
+type Message struct {
+	Channel string
+	// Pattern is the pattern that matched Channel for messages received
+	// through PSUBSCRIBE, and "" otherwise
+	Pattern string
+	Payload string
+}
+
+// PubSub receives the messages of the channels and patterns its connection
+// is subscribed to. It owns the connection: the Client it was created from
+// no longer runs commands, and closing the PubSub closes it. Frames are read
+// in a background goroutine, as RESP2 arrays or RESP3 pushes, and messages
+// are delivered on Channel. It is safe for concurrent use.
+type PubSub struct {
+	c        *Client
+	messages chan Message
+	closing  chan struct{}
+	done     chan struct{}
+	once     sync.Once
+
+	mu  sync.Mutex
+	err error
+}
+
+// Subscribe subscribes the connection to channels and returns the PubSub
+// that receives their messages
+func (c *Client) Subscribe(channels ...string) (*PubSub, error) {
+	return c.newPubSub("SUBSCRIBE", channels)
+}
+
+// PSubscribe subscribes the connection to the channels matching the
+// glob-style patterns and returns the PubSub that receives their messages
+func (c *Client) PSubscribe(patterns ...string) (*PubSub, error) {
+	return c.newPubSub("PSUBSCRIBE", patterns)
+}
+
+func (c *Client) newPubSub(command string, names []string) (*PubSub, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("client: %s needs at least one name", command)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.subscribed {
+		return nil, ErrSubscribed
+	}
+	if err := c.send(append([]string{command}, names...)); err != nil {
+		return nil, err
+	}
+	// Errors such as NOPERM come instead of the first confirmation. The
+	// other confirmations are read with the messages.
+	reply, err := c.receive()
+	if err != nil {
+		c.broken = true
+		return nil, err
+	}
+	if e, ok := reply.(protocol.ErrorString); ok {
+		return nil, Error(e)
+	}
+
+	c.subscribed = true
+	ps := &PubSub{
+		c:        c,
+		messages: make(chan Message, 100),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go ps.receive()
+	return ps, nil
+}
+
+// Channel returns the channel messages are delivered on. It is closed when
+// the PubSub is closed or the connection fails; Err tells which.
+func (ps *PubSub) Channel() <-chan Message {
+	return ps.messages
+}
+
+// Subscribe subscribes to more channels. Like the other subscription
+// changes, it only sends the command: the confirmations are read in the
+// background.
+func (ps *PubSub) Subscribe(channels ...string) error {
+	return ps.send("SUBSCRIBE", channels, true)
+}
+
+// PSubscribe subscribes to more patterns
+func (ps *PubSub) PSubscribe(patterns ...string) error {
+	return ps.send("PSUBSCRIBE", patterns, true)
+}
+
+// Unsubscribe leaves the channels, or every channel when none is given
+func (ps *PubSub) Unsubscribe(channels ...string) error {
+	return ps.send("UNSUBSCRIBE", channels, false)
+}
+
+// PUnsubscribe leaves the patterns, or every pattern when none is given
+func (ps *PubSub) PUnsubscribe(patterns ...string) error {
+	return ps.send("PUNSUBSCRIBE", patterns, false)
+}
+
+// Err returns the error that stopped the PubSub, if the connection failed
+func (ps *PubSub) Err() error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.err
+}
+
+// Close closes the connection and waits for the background goroutine to
+// stop, which closes Channel
+func (ps *PubSub) Close() error {
+	var err error
+	ps.once.Do(func() {
+		close(ps.closing)
+		err = ps.c.Close()
+		<-ps.done
+	})
+	return err
+}
+
+func (ps *PubSub) send(command string, names []string, required bool) error {
+	if required && len(names) == 0 {
+		return fmt.Errorf("client: %s needs at least one name", command)
+	}
+	ps.c.mu.Lock()
+	defer ps.c.mu.Unlock()
+	return ps.c.send(append([]string{command}, names...))
+}
+
+// receive delivers the messages read from the connection until it is closed
+func (ps *PubSub) receive() {
+	defer close(ps.done)
+	defer close(ps.messages)
+	for {
+		frame, err := ps.c.receive()
+		if err != nil {
+			select {
+			case <-ps.closing:
+			default:
+				ps.mu.Lock()
+				ps.err = err
+				ps.mu.Unlock()
+			}
+			return
+		}
+		// Subscription confirmations and other replies are skipped
+		msg, ok := parseMessage(frame)
+		if !ok {
+			continue
+		}
+		select {
+		case ps.messages <- msg:
+		case <-ps.closing:
+			return
+		}
+	}
+}
+
+// parseMessage decodes a message or pmessage frame
+func parseMessage(frame protocol.RESPValue) (Message, bool) {
+	var items []protocol.RESPValue
+	switch v := frame.(type) {
+	case protocol.Array:
+		items = v
+	case protocol.Push:
+		items = v
+	default:
+		return Message{}, false
+	}
+	fields := make([]string, len(items))
+	for i, item := range items {
+		bulk, ok := item.(protocol.BulkString)
+		if !ok {
+			return Message{}, false
+		}
+		fields[i] = string(bulk)
+	}
+
+	switch {
+	case len(fields) == 3 && strings.EqualFold(fields[0], "message"):
+		return Message{Channel: fields[1], Payload: fields[2]}, true
+	case len(fields) == 4 && strings.EqualFold(fields[0], "pmessage"):
+		return Message{Pattern: fields[1], Channel: fields[2], Payload: fields[3]}, true
+	}
+	return Message{}, false
+}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Iterator walks the replies of SCAN and its HSCAN, SSCAN and ZSCAN variants,
+// sending the next call with the returned cursor whenever a page runs out,
+// until the server returns cursor 0:
+//
+//	it := c.ScanIterator("user:*", 100)
+//	for it.Next() {
+//		key := it.Key()
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type Iterator struct {
+	c      *Client
+	prefix []string // the command and its key, if any, before the cursor
+	match  string
+	count  int
+	// width is the number of elements of each item: 1 for keys and set
+	// members, 2 for field/value and member/score pairs
+	width int
+
+	cursor  string
+	page    []string
+	current []string
+	err     error
+}
+
+// ScanIterator iterates over the keys of the current database matching the
+// glob-style pattern match. An empty match matches every key. count is the
+// COUNT hint of each call, zero to use the server's default.
+func (c *Client) ScanIterator(match string, count int) *Iterator {
+	return c.newIterator([]string{"SCAN"}, match, count, 1)
+}
+
+// HScanIterator iterates over the fields of the hash at key. Key returns the
+// field and Value its value.
+func (c *Client) HScanIterator(key, match string, count int) *Iterator {
+	return c.newIterator([]string{"HSCAN", key}, match, count, 2)
+}
+
+// SScanIterator iterates over the members of the set at key, returned by Key
+func (c *Client) SScanIterator(key, match string, count int) *Iterator {
+	return c.newIterator([]string{"SSCAN", key}, match, count, 1)
+}
+
+// ZScanIterator iterates over the members of the sorted set at key. Key
+// returns the member and Value its score.
+func (c *Client) ZScanIterator(key, match string, count int) *Iterator {
+	return c.newIterator([]string{"ZSCAN", key}, match, count, 2)
+}
+
+func (c *Client) newIterator(prefix []string, match string, count, width int) *Iterator {
+	return &Iterator{c: c, prefix: prefix, match: match, count: count, width: width}
+}
+
+// Next advances to the next item, fetching pages as needed. It returns false
+// once the iteration is over or failed; Err tells which.
+func (it *Iterator) Next() bool {
+	for len(it.page) == 0 {
+		// The first call starts with an empty cursor, later ones stop once
+		// the server returns 0
+		if it.err != nil || it.cursor == "0" {
+			it.current = nil
+			return false
+		}
+		it.err = it.fetch()
+	}
+	it.current, it.page = it.page[:it.width], it.page[it.width:]
+	return true
+}
+
+// Key returns the key, field or member of the current item
+func (it *Iterator) Key() string {
+	if it.current == nil {
+		return ""
+	}
+	return it.current[0]
+}
+
+// Value returns the value of the current HSCAN field or the score of the
+// current ZSCAN member, and "" for SCAN and SSCAN
+func (it *Iterator) Value() string {
+	if len(it.current) < 2 {
+		return ""
+	}
+	return it.current[1]
+}
+
+// Err returns the error that ended the iteration, if any
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// fetch sends the next call and keeps the page it returns
+func (it *Iterator) fetch() error {
+	cursor := it.cursor
+	if cursor == "" {
+		cursor = "0"
+	}
+	args := append(append([]string{}, it.prefix...), cursor)
+	if it.match != "" {
+		args = append(args, "MATCH", it.match)
+	}
+	if it.count > 0 {
+		args = append(args, "COUNT", strconv.Itoa(it.count))
+	}
+	reply, err := it.c.Do(args...)
+	if err != nil {
+		return err
+	}
+
+	// The reply is [cursor, [elements...]]
+	parts, ok := reply.([]any)
+	if !ok || len(parts) != 2 {
+		return fmt.Errorf("client: unexpected %s reply %v", it.prefix[0], reply)
+	}
+	next, ok := parts[0].(string)
+	elements, ok2 := parts[1].([]any)
+	if !ok || !ok2 || next == "" || len(elements)%it.width != 0 {
+		return fmt.Errorf("client: unexpected %s reply %v", it.prefix[0], reply)
+	}
+	page := make([]string, len(elements))
+	for i, element := range elements {
+		if page[i], ok = element.(string); !ok {
+			return fmt.Errorf("client: unexpected %s element %v", it.prefix[0], element)
+		}
+	}
+	it.cursor, it.page = next, page
+	return nil
+}